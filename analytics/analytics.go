@@ -0,0 +1,122 @@
+// Package analytics records who accesses shares, keeping single events for
+// a while and daily aggregates of them afterwards.
+package analytics
+
+import (
+	"net"
+	"time"
+)
+
+// Kinds of access events.
+const (
+	EventView     = "view"
+	EventDownload = "download"
+	EventPresign  = "presign"
+	EventCatalog  = "catalog"
+)
+
+// maxUserAgent is the number of bytes of user agents that are recorded.
+const maxUserAgent = 256
+
+// Event is an access of a share.
+type Event struct {
+	ID   uint   `json:"id" storm:"id,increment"`
+	Hash string `json:"hash" storm:"index"`
+	// Time is a unix timestamp.
+	Time int64  `json:"time" storm:"index"`
+	Kind string `json:"kind"`
+	// Bytes is the size of the response, if the server sent the data.
+	Bytes     int64  `json:"bytes,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// NewEvent creates an event of kind for the share hash, accessed now from
+// ip, which is anonymized, by userAgent.
+func NewEvent(hash, kind string, bytes int64, ip, userAgent string) *Event {
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	return &Event{
+		Hash:      hash,
+		Time:      time.Now().Unix(),
+		Kind:      kind,
+		Bytes:     bytes,
+		IP:        AnonymizeIP(ip),
+		UserAgent: userAgent,
+	}
+}
+
+// AnonymizeIP masks the host part of an IP address, keeping the first 24
+// bits of IPv4 and the first 48 bits of IPv6 addresses. Anything else is
+// dropped.
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return parsed.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// Counts sums up access events.
+type Counts struct {
+	Views          int64 `json:"views"`
+	Downloads      int64 `json:"downloads"`
+	Presigns       int64 `json:"presigns"`
+	CatalogQueries int64 `json:"catalogQueries"`
+	Bytes          int64 `json:"bytes"`
+}
+
+// Add counts an event.
+func (c *Counts) Add(e *Event) {
+	switch e.Kind {
+	case EventView:
+		c.Views++
+	case EventDownload:
+		c.Downloads++
+	case EventPresign:
+		c.Presigns++
+	case EventCatalog:
+		c.CatalogQueries++
+	}
+	c.Bytes += e.Bytes
+}
+
+// Merge adds up other counts.
+func (c *Counts) Merge(other Counts) {
+	c.Views += other.Views
+	c.Downloads += other.Downloads
+	c.Presigns += other.Presigns
+	c.CatalogQueries += other.CatalogQueries
+	c.Bytes += other.Bytes
+}
+
+// Day aggregates the events of a share on a day in UTC.
+type Day struct {
+	ID   string `json:"-" storm:"id"`
+	Hash string `json:"hash" storm:"index"`
+	// Date is formatted as 2006-01-02.
+	Date string `json:"date"`
+	Counts
+}
+
+func dayID(hash, date string) string {
+	return hash + "/" + date
+}
+
+func dateOf(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.DateOnly)
+}
+
+// Stats are the statistics of a share: its totals, the counts per day and
+// the single events that have not been aggregated yet, latest first.
+type Stats struct {
+	Hash   string   `json:"hash"`
+	Totals Counts   `json:"totals"`
+	Days   []*Day   `json:"days"`
+	Events []*Event `json:"events"`
+}
@@ -0,0 +1,234 @@
+package analytics
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const (
+	// compactInterval is how often the expired events are folded into daily
+	// aggregates.
+	compactInterval = time.Hour
+	// maxStatsEvents is the number of single events returned with the stats.
+	maxStatsEvents = 100
+	// queueSize bounds the recorded events waiting to be written, beyond
+	// which events are dropped rather than holding up requests.
+	queueSize = 4096
+	// flushInterval and maxBatch bound how long and how many recorded
+	// events wait to be written together.
+	flushInterval = time.Second
+	maxBatch      = 256
+	// maxFoldEvents bounds the events folded into their days at once.
+	maxFoldEvents = 1000
+)
+
+// ErrQueueFull is returned for events dropped because too many are waiting
+// to be written.
+var ErrQueueFull = errors.New("too many access events waiting to be written")
+
+// StorageBackend is the interface to implement for an analytics storage.
+type StorageBackend interface {
+	SaveEvents(events []*Event) error
+	FindEvents(hash string) ([]*Event, error)
+	EventsBefore(unix int64) ([]*Event, error)
+	GetDay(id string) (*Day, error)
+	FindDays(hash string) ([]*Day, error)
+	// Fold saves the days and deletes the events aggregated into them at
+	// once.
+	Fold(days []*Day, events []*Event) error
+}
+
+// Storage is an analytics storage. Recorded events are written in batches
+// by a background writer, which also compacts them.
+type Storage struct {
+	back StorageBackend
+
+	start      sync.Once
+	queue      chan *Event
+	flushes    chan chan struct{}
+	retention  atomic.Int64
+	compacting sync.Mutex
+}
+
+// NewStorage creates an analytics storage from a backend.
+func NewStorage(back StorageBackend) *Storage {
+	return &Storage{
+		back:    back,
+		queue:   make(chan *Event, queueSize),
+		flushes: make(chan chan struct{}),
+	}
+}
+
+// Record queues an event to be written, or returns ErrQueueFull if it is
+// dropped. Events older than retention are folded into daily aggregates
+// once per compactInterval.
+func (s *Storage) Record(e *Event, retention time.Duration) error {
+	s.retention.Store(int64(retention))
+	s.start.Do(func() { go s.write() })
+
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Flush waits until the events recorded so far are written.
+func (s *Storage) Flush() {
+	s.start.Do(func() { go s.write() })
+
+	done := make(chan struct{})
+	s.flushes <- done
+	<-done
+}
+
+// write saves the queued events in batches and compacts the expired ones,
+// right away and then once per compactInterval.
+func (s *Storage) write() {
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	compact := time.NewTimer(0)
+	defer compact.Stop()
+
+	var batch []*Event
+	save := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.back.SaveEvents(batch); err != nil {
+			log.Printf("failed to save %d access events: %v", len(batch), err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case e := <-s.queue:
+			if batch = append(batch, e); len(batch) >= maxBatch {
+				save()
+			}
+		case <-flush.C:
+			save()
+		case done := <-s.flushes:
+			for queued := len(s.queue); queued > 0; queued-- {
+				batch = append(batch, <-s.queue)
+			}
+			save()
+			close(done)
+		case <-compact.C:
+			save()
+			if retention := time.Duration(s.retention.Load()); retention > 0 {
+				if err := s.Compact(time.Now().Add(-retention)); err != nil {
+					log.Printf("failed to compact access events: %v", err)
+				}
+			}
+			compact.Reset(compactInterval)
+		}
+	}
+}
+
+// Compact folds the events before a time into the aggregates of their days,
+// at most maxFoldEvents at once.
+func (s *Storage) Compact(before time.Time) error {
+	s.compacting.Lock()
+	defer s.compacting.Unlock()
+
+	events, err := s.back.EventsBefore(before.Unix())
+	if errors.Is(err, fbErrors.ErrNotExist) || len(events) == 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for len(events) > 0 {
+		n := min(len(events), maxFoldEvents)
+		if err := s.fold(events[:n]); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// fold adds events to the aggregates of their days and deletes them.
+func (s *Storage) fold(events []*Event) error {
+	var err error
+	days := map[string]*Day{}
+	for _, e := range events {
+		date := dateOf(e.Time)
+		id := dayID(e.Hash, date)
+		day, ok := days[id]
+		if !ok {
+			day, err = s.back.GetDay(id)
+			if errors.Is(err, fbErrors.ErrNotExist) {
+				day = &Day{ID: id, Hash: e.Hash, Date: date}
+			} else if err != nil {
+				return err
+			}
+			days[id] = day
+		}
+		day.Add(e)
+	}
+
+	folded := make([]*Day, 0, len(days))
+	for _, day := range days {
+		folded = append(folded, day)
+	}
+	return s.back.Fold(folded, events)
+}
+
+// Stats returns the statistics of a share, counting the events that have
+// not been aggregated yet into their days.
+func (s *Storage) Stats(hash string) (*Stats, error) {
+	days, err := s.back.FindDays(hash)
+	if err != nil && !errors.Is(err, fbErrors.ErrNotExist) {
+		return nil, err
+	}
+	events, err := s.back.FindEvents(hash)
+	if err != nil && !errors.Is(err, fbErrors.ErrNotExist) {
+		return nil, err
+	}
+
+	byDate := map[string]*Day{}
+	for _, day := range days {
+		byDate[day.Date] = day
+	}
+	for _, e := range events {
+		date := dateOf(e.Time)
+		day, ok := byDate[date]
+		if !ok {
+			day = &Day{ID: dayID(hash, date), Hash: hash, Date: date}
+			byDate[date] = day
+		}
+		day.Add(e)
+	}
+
+	stats := &Stats{Hash: hash, Days: make([]*Day, 0, len(byDate))}
+	for _, day := range byDate {
+		stats.Totals.Merge(day.Counts)
+		stats.Days = append(stats.Days, day)
+	}
+	sort.Slice(stats.Days, func(i, j int) bool {
+		return stats.Days[i].Date < stats.Days[j].Date
+	})
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if len(events) > maxStatsEvents {
+		events = events[:maxStatsEvents]
+	}
+	stats.Events = events
+	if stats.Events == nil {
+		stats.Events = []*Event{}
+	}
+
+	return stats, nil
+}
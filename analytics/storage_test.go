@@ -0,0 +1,175 @@
+package analytics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+type memoryBackend struct {
+	mu     sync.Mutex
+	events map[uint]*Event
+	days   map[string]*Day
+	nextID uint
+}
+
+func (m *memoryBackend) SaveEvents(events []*Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range events {
+		m.nextID++
+		e.ID = m.nextID
+		m.events[e.ID] = e
+	}
+	return nil
+}
+
+func (m *memoryBackend) FindEvents(hash string) ([]*Event, error) {
+	return m.selectEvents(func(e *Event) bool { return e.Hash == hash })
+}
+
+func (m *memoryBackend) EventsBefore(unix int64) ([]*Event, error) {
+	return m.selectEvents(func(e *Event) bool { return e.Time < unix })
+}
+
+func (m *memoryBackend) selectEvents(match func(e *Event) bool) ([]*Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var v []*Event
+	for _, e := range m.events {
+		if match(e) {
+			v = append(v, e)
+		}
+	}
+	if len(v) == 0 {
+		return nil, fbErrors.ErrNotExist
+	}
+	return v, nil
+}
+
+func (m *memoryBackend) GetDay(id string) (*Day, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	day, ok := m.days[id]
+	if !ok {
+		return nil, fbErrors.ErrNotExist
+	}
+	copied := *day
+	return &copied, nil
+}
+
+func (m *memoryBackend) FindDays(hash string) ([]*Day, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var v []*Day
+	for _, day := range m.days {
+		if day.Hash == hash {
+			copied := *day
+			v = append(v, &copied)
+		}
+	}
+	return v, nil
+}
+
+func (m *memoryBackend) Fold(days []*Day, events []*Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, day := range days {
+		m.days[day.ID] = day
+	}
+	for _, e := range events {
+		delete(m.events, e.ID)
+	}
+	return nil
+}
+
+func TestStorage(t *testing.T) {
+	back := &memoryBackend{events: map[uint]*Event{}, days: map[string]*Day{}}
+	s := NewStorage(back)
+
+	now := time.Now()
+	twoDaysAgo := now.Add(-48 * time.Hour)
+	for _, e := range []*Event{
+		{Hash: "h", Time: twoDaysAgo.Unix(), Kind: EventDownload, Bytes: 100},
+		{Hash: "h", Time: twoDaysAgo.Unix(), Kind: EventView, Bytes: 10},
+		{Hash: "other", Time: twoDaysAgo.Unix(), Kind: EventView},
+		{Hash: "h", Time: now.Unix(), Kind: EventPresign},
+		{Hash: "h", Time: now.Unix(), Kind: EventCatalog, Bytes: 5},
+	} {
+		if err := back.SaveEvents([]*Event{e}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := s.Stats("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Compact(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(back.events) != 2 || len(back.days) != 2 {
+		t.Errorf("expected old events to be folded, got %d events and %d days", len(back.events), len(back.days))
+	}
+
+	// late events of a folded day are added to its aggregate
+	if err := back.SaveEvents([]*Event{{Hash: "h", Time: twoDaysAgo.Unix(), Kind: EventDownload, Bytes: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Compact(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := s.Stats("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Counts{Views: 1, Downloads: 2, Presigns: 1, CatalogQueries: 1, Bytes: 116}
+	if stats.Totals != want {
+		t.Errorf("expected totals %+v, got %+v", want, stats.Totals)
+	}
+	if before.Totals.Bytes != 115 || before.Totals.Downloads != 1 {
+		t.Errorf("unexpected totals before compaction %+v", before.Totals)
+	}
+	if len(stats.Days) != 2 || stats.Days[0].Date != twoDaysAgo.UTC().Format(time.DateOnly) || stats.Days[0].Downloads != 2 {
+		t.Errorf("unexpected days %+v", stats.Days)
+	}
+	if len(stats.Events) != 2 || stats.Events[0].Kind != EventCatalog {
+		t.Errorf("expected the latest events first, got %+v", stats.Events)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	back := &memoryBackend{events: map[uint]*Event{}, days: map[string]*Day{}}
+	s := NewStorage(back)
+
+	for i := 0; i < 3; i++ {
+		if err := s.Record(NewEvent("h", EventView, 0, "", ""), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Flush()
+
+	stats, err := s.Stats("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Totals.Views != 3 {
+		t.Errorf("expected 3 recorded views, got %+v", stats.Totals)
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	for ip, want := range map[string]string{
+		"203.0.113.77":            "203.0.113.0",
+		"2001:db8:85a3:8d3::7334": "2001:db8:85a3::",
+		"::ffff:203.0.113.77":     "203.0.113.0",
+		"unknown":                 "",
+	} {
+		if got := AnonymizeIP(ip); got != want {
+			t.Errorf("%s: expected %q, got %q", ip, want, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package attest signs the manifests of shares and verifies downloaded
+// packages against them.
+package attest
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
+)
+
+// Names of the manifest, its signature and the public key verifying it, as
+// published in the root of a share and looked for next to a downloaded
+// package.
+const (
+	ManifestName  = "manifest.json"
+	SignatureName = "manifest.sig"
+	PublicKeyName = "manifest.pem"
+)
+
+// ManifestVersion is the version of the manifest format.
+const ManifestVersion = 1
+
+// Manifest lists every file of a package with its SHA-256 checksum.
+type Manifest struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	// Source is the URL of the share the manifest was published by.
+	Source string  `json:"source,omitempty"`
+	Files  []*File `json:"files"`
+}
+
+// File is a file of a manifest, by its slash separated path in the package.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Marshal encodes the manifest with its files sorted by path, which are the
+// bytes its signature is made of.
+func (m *Manifest) Marshal() ([]byte, error) {
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Sign signs the encoded manifest and returns the base64 encoded signature.
+func Sign(key ed25519.PrivateKey, manifest []byte) []byte {
+	sig := ed25519.Sign(key, manifest)
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// CheckSignature checks a base64 encoded signature of the encoded manifest.
+func CheckSignature(pub ed25519.PublicKey, manifest, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if !ed25519.Verify(pub, manifest, sig) {
+		return errors.New("the signature does not match the manifest")
+	}
+	return nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 Ed25519 private key, as
+// created by "openssl genpkey -algorithm ed25519".
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found: %w", fbErrors.ErrInvalidOption)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an Ed25519 key: %w", key, fbErrors.ErrInvalidOption)
+	}
+	return private, nil
+}
+
+// MarshalPublicKey encodes a public key as PEM encoded PKIX.
+func MarshalPublicKey(pub ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX Ed25519 public key.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found: %w", fbErrors.ErrInvalidOption)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an Ed25519 key: %w", key, fbErrors.ErrInvalidOption)
+	}
+	return pub, nil
+}
+
+// Problem is a file of a package that doesn't match its manifest.
+type Problem struct {
+	Path   string
+	Reason string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Reason
+}
+
+// Verify checks the files at root in afs against the manifest and returns
+// the files that are missing, differ or are not listed. The manifest, its
+// signature and public key are ignored in the root.
+func Verify(afs afero.Fs, root string, m *Manifest) ([]Problem, error) {
+	problems := []Problem{}
+	listed := map[string]bool{}
+
+	for _, f := range m.Files {
+		listed[f.Path] = true
+
+		name := path.Join(root, f.Path)
+		info, err := afs.Stat(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, Problem{f.Path, "missing"})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			problems = append(problems, Problem{f.Path, "is a directory"})
+			continue
+		}
+		if info.Size() != f.Size {
+			problems = append(problems, Problem{f.Path, fmt.Sprintf("size %d instead of %d", info.Size(), f.Size)})
+			continue
+		}
+
+		fi := &files.FileInfo{Fs: afs, Path: name}
+		if err := fi.Checksum("sha256"); err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(fi.Checksums["sha256"], f.SHA256) {
+			problems = append(problems, Problem{f.Path, "checksum mismatch"})
+		}
+	}
+
+	err := afero.Walk(afs, root, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+		switch rel {
+		case ManifestName, SignatureName, PublicKeyName:
+			return nil
+		}
+		if !listed[rel] {
+			problems = append(problems, Problem{rel, "not in the manifest"})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return problems, nil
+}
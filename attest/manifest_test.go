@@ -0,0 +1,96 @@
+package attest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestSignature(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil || !parsed.Equal(key) {
+		t.Fatalf("unexpected private key: %v", err)
+	}
+
+	encoded, err := MarshalPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedPub, err := ParsePublicKey(encoded)
+	if err != nil || !parsedPub.Equal(pub) {
+		t.Fatalf("unexpected public key: %v", err)
+	}
+
+	m := &Manifest{Version: ManifestVersion, Name: "survey", Files: []*File{{Path: "b", Size: 1}, {Path: "a", Size: 2}}}
+	out, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Files[0].Path != "a" {
+		t.Errorf("expected files sorted by path, got %v", m.Files)
+	}
+
+	sig := Sign(key, out)
+	if err := CheckSignature(parsedPub, out, sig); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	out[len(out)-2] = ' '
+	if err := CheckSignature(parsedPub, out, sig); err == nil {
+		t.Error("expected a modified manifest to fail")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	for name, content := range map[string]string{
+		"/plots.csv":       "id,x\n1,2\n",
+		"/scans/a.tif":     "tiff",
+		"/scans/b.tif":     "changed",
+		"/extra.txt":       "extra",
+		"/" + ManifestName: "{}",
+	} {
+		if err := afero.WriteFile(afs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checksum := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	m := &Manifest{Version: ManifestVersion, Files: []*File{
+		{Path: "plots.csv", Size: 9, SHA256: checksum("id,x\n1,2\n")},
+		{Path: "scans/a.tif", Size: 4, SHA256: checksum("tiff")},
+		{Path: "scans/b.tif", Size: 7, SHA256: checksum("tagged!")},
+		{Path: "scans/c.tif", Size: 1, SHA256: checksum("c")},
+	}}
+
+	problems, err := Verify(afs, "/", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, problem := range problems {
+		got = append(got, problem.String())
+	}
+	want := []string{"scans/b.tif: checksum mismatch", "scans/c.tif: missing", "extra.txt: not in the manifest"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
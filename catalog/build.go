@@ -0,0 +1,420 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
+	"github.com/versioneer-tech/package-r/rules"
+)
+
+const (
+	// DefaultCatalogName is the file name of a built catalog if the settings
+	// don't name one.
+	DefaultCatalogName = "catalog.parquet"
+	// DefaultAssetKey is the asset holding the file an item was derived from.
+	DefaultAssetKey = "data"
+
+	stacVersion = "1.0.0"
+	sidecarExt  = ".json"
+)
+
+// BuildOptions configure how Build derives items from a directory.
+type BuildOptions struct {
+	// Output is the path of the catalog in the file system, by default
+	// DefaultCatalogName inside the directory.
+	Output string
+	// Collection of the items, by default the name of the directory.
+	Collection string
+	// AssetKey is the asset that points at the file of an item, by default
+	// DefaultAssetKey. Shares of the catalog use it as their filter field.
+	AssetKey string
+	// AssetsBaseURL prefixes the asset hrefs, which are otherwise the paths
+	// of the files relative to the directory.
+	AssetsBaseURL string
+	// Checker, if set, skips the files and directories it denies, and the
+	// sidecars it denies aren't applied.
+	Checker rules.Checker
+}
+
+// allowed reports whether the checker of the options allows name.
+func (o BuildOptions) allowed(name string) bool {
+	return o.Checker == nil || o.Checker.Check(name)
+}
+
+// buildItem is a STAC item in the columns of a built catalog.
+type buildItem struct {
+	ID         string                 `json:"id"`
+	Collection string                 `json:"collection"`
+	Geometry   interface{}            `json:"geometry"`
+	BBox       []float64              `json:"bbox"`
+	Datetime   string                 `json:"datetime,omitempty"`
+	Properties map[string]interface{} `json:"properties"`
+	Assets     map[string]interface{} `json:"assets"`
+	Extensions []string               `json:"stac_extensions"`
+}
+
+// Build walks the directory root of fs and writes a stac-geoparquet catalog
+// with one item per file to opts.Output. Items are derived from the file
+// metadata and, if present, a sidecar "<file>.json" holding a partial STAC
+// item whose fields take precedence. It returns the number of items written.
+func Build(ctx context.Context, fs afero.Fs, root string, opts BuildOptions) (int, error) {
+	root = path.Clean("/" + root)
+
+	info, err := fs.Stat(root)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return 0, fmt.Errorf("%s is not a directory: %w", root, fbErrors.ErrInvalidRequestParams)
+	}
+
+	if opts.Output == "" {
+		opts.Output = path.Join(root, DefaultCatalogName)
+	}
+	opts.Output = path.Clean("/" + opts.Output)
+	if opts.Collection == "" {
+		opts.Collection = path.Base(root)
+		if opts.Collection == "/" {
+			opts.Collection = "catalog"
+		}
+	}
+	if opts.AssetKey == "" {
+		opts.AssetKey = DefaultAssetKey
+	}
+	if !assetKeyPattern.MatchString(opts.AssetKey) {
+		return 0, fmt.Errorf("invalid asset key %q: %w", opts.AssetKey, fbErrors.ErrInvalidRequestParams)
+	}
+
+	parquet, err := os.CreateTemp("", "catalog-*.parquet")
+	if err != nil {
+		return 0, err
+	}
+	parquet.Close()
+	defer os.Remove(parquet.Name())
+
+	count := 0
+	err = writeItems(ctx, parquet.Name(), func(add func(item *buildItem) error) error {
+		return afero.Walk(fs, root, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			name = filepath.ToSlash(name)
+			if name != root && (strings.HasPrefix(info.Name(), ".") || !opts.allowed(name)) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || name == opts.Output || isSidecar(fs, name) {
+				return nil
+			}
+
+			item, err := deriveItem(fs, root, name, info, opts)
+			if err != nil {
+				return err
+			}
+			count++
+			return add(item)
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	src, err := os.Open(parquet.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	if err := writeFile(fs, opts.Output, src); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// isSidecar reports whether name is the sidecar JSON of another file.
+func isSidecar(fs afero.Fs, name string) bool {
+	if !strings.HasSuffix(name, sidecarExt) {
+		return false
+	}
+	info, err := fs.Stat(strings.TrimSuffix(name, sidecarExt))
+	return err == nil && !info.IsDir()
+}
+
+func deriveItem(fs afero.Fs, root, name string, info os.FileInfo, opts BuildOptions) (*buildItem, error) {
+	rel := strings.TrimPrefix(name, root)
+	rel = "/" + strings.TrimPrefix(rel, "/")
+	baseURL := strings.TrimSuffix(opts.AssetsBaseURL, "/")
+
+	asset := map[string]interface{}{
+		"href":      baseURL + rel,
+		"title":     info.Name(),
+		"roles":     []string{"data"},
+		"file:size": info.Size(),
+	}
+	if mediaType := mediaTypeOf(name); mediaType != "" {
+		asset["type"] = mediaType
+	}
+
+	item := &buildItem{
+		ID:         strings.TrimPrefix(rel, "/"),
+		Collection: opts.Collection,
+		Datetime:   info.ModTime().UTC().Format(time.RFC3339Nano),
+		Properties: map[string]interface{}{"title": info.Name()},
+		Assets:     map[string]interface{}{opts.AssetKey: asset},
+		Extensions: []string{"https://stac-extensions.github.io/file/v2.1.0/schema.json"},
+	}
+
+	if opts.allowed(name + sidecarExt) {
+		if err := applySidecar(fs, name, item, baseURL+path.Dir(rel)); err != nil {
+			return nil, err
+		}
+	}
+
+	if item.BBox == nil && item.Geometry != nil {
+		item.BBox = geometryBounds(item.Geometry)
+	}
+
+	return item, nil
+}
+
+// applySidecar merges the sidecar of name, if there is one, into item.
+// Relative asset hrefs of the sidecar are resolved against dirURL.
+//
+//nolint:gocyclo
+func applySidecar(fs afero.Fs, name string, item *buildItem, dirURL string) error {
+	data, err := afero.ReadFile(fs, name+sidecarExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var sidecar struct {
+		ID             string                 `json:"id"`
+		Collection     string                 `json:"collection"`
+		Geometry       interface{}            `json:"geometry"`
+		BBox           []float64              `json:"bbox"`
+		Properties     map[string]interface{} `json:"properties"`
+		Assets         map[string]interface{} `json:"assets"`
+		StacExtensions []string               `json:"stac_extensions"`
+	}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return fmt.Errorf("invalid sidecar %s: %w", name+sidecarExt, err)
+	}
+
+	if sidecar.ID != "" {
+		item.ID = sidecar.ID
+	}
+	if sidecar.Collection != "" {
+		item.Collection = sidecar.Collection
+	}
+	if sidecar.Geometry != nil {
+		item.Geometry = sidecar.Geometry
+	}
+	if bbox := flatBBox(sidecar.BBox); bbox != nil {
+		item.BBox = bbox
+	}
+
+	for key, value := range sidecar.Properties {
+		if key != "datetime" {
+			item.Properties[key] = value
+			continue
+		}
+		if s, ok := value.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return fmt.Errorf("invalid datetime in sidecar %s: %w", name+sidecarExt, err)
+			}
+			item.Datetime = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	for key, value := range sidecar.Assets {
+		asset, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if href, ok := asset["href"].(string); ok && !strings.Contains(href, "://") && !strings.HasPrefix(href, "/") {
+			asset["href"] = strings.TrimSuffix(dirURL, "/") + "/" + path.Clean(href)
+		}
+		if existing, ok := item.Assets[key].(map[string]interface{}); ok {
+			for k, v := range asset {
+				existing[k] = v
+			}
+			continue
+		}
+		item.Assets[key] = asset
+	}
+
+	for _, ext := range sidecar.StacExtensions {
+		if !slices.Contains(item.Extensions, ext) {
+			item.Extensions = append(item.Extensions, ext)
+		}
+	}
+
+	return nil
+}
+
+var buildMediaTypes = map[string]string{
+	".tif":     "image/tiff; application=geotiff",
+	".tiff":    "image/tiff; application=geotiff",
+	".geojson": "application/geo+json",
+	".parquet": "application/vnd.apache.parquet",
+	".nc":      "application/netcdf",
+	".h5":      "application/x-hdf5",
+	".zarr":    "application/vnd+zarr",
+}
+
+func mediaTypeOf(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if mediaType, ok := buildMediaTypes[ext]; ok {
+		return mediaType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// flatBBox returns the 2D part of a STAC bbox, or nil if it is invalid.
+func flatBBox(bbox []float64) []float64 {
+	switch len(bbox) {
+	case 4:
+		return bbox
+	case 6:
+		return []float64{bbox[0], bbox[1], bbox[3], bbox[4]}
+	default:
+		return nil
+	}
+}
+
+// geometryBounds returns the bbox of the coordinates of a GeoJSON geometry,
+// or nil if it has none.
+func geometryBounds(geometry interface{}) []float64 {
+	bbox := []float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			walk(v["coordinates"])
+			walk(v["geometries"])
+		case []interface{}:
+			if len(v) >= 2 {
+				x, xOk := v[0].(float64)
+				y, yOk := v[1].(float64)
+				if xOk && yOk {
+					bbox[0], bbox[1] = math.Min(bbox[0], x), math.Min(bbox[1], y)
+					bbox[2], bbox[3] = math.Max(bbox[2], x), math.Max(bbox[3], y)
+					return
+				}
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(geometry)
+
+	if math.IsInf(bbox[0], 1) {
+		return nil
+	}
+	return bbox
+}
+
+// writeItems writes the items passed to add by emit as a catalog to the
+// local file dst.
+func writeItems(ctx context.Context, dst string, emit func(add func(item *buildItem) error) error) error {
+	ndjson, err := os.CreateTemp("", "catalog-*.ndjson")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(ndjson.Name())
+	defer ndjson.Close()
+
+	enc := json.NewEncoder(ndjson)
+	if err := emit(func(item *buildItem) error { return enc.Encode(item) }); err != nil {
+		return err
+	}
+	if err := ndjson.Close(); err != nil {
+		return err
+	}
+
+	return writeParquet(ctx, ndjson.Name(), dst)
+}
+
+// writeParquet converts the items in the NDJSON file src to a catalog at
+// dst. Both are local temporary files.
+func writeParquet(ctx context.Context, src, dst string) error {
+	conn, err := GetDuckDBConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+
+	_, err = conn.ExecContext(ctx, `COPY (
+SELECT
+	'Feature' AS type,
+	'`+stacVersion+`' AS stac_version,
+	stac_extensions,
+	id,
+	collection,
+	CASE WHEN json_type(geometry) = 'OBJECT' THEN CAST(geometry AS VARCHAR) END AS geometry,
+	CASE WHEN len(bbox) = 4 THEN {'xmin': bbox[1], 'ymin': bbox[2], 'xmax': bbox[3], 'ymax': bbox[4]} END AS bbox,
+	CAST(datetime AS TIMESTAMPTZ) AS datetime,
+	CAST(properties AS VARCHAR) AS properties,
+	CAST(assets AS VARCHAR) AS assets
+FROM read_json(`+quote(src)+`, format = 'newline_delimited', columns = {
+	id: 'VARCHAR',
+	collection: 'VARCHAR',
+	geometry: 'JSON',
+	bbox: 'DOUBLE[]',
+	datetime: 'VARCHAR',
+	properties: 'JSON',
+	assets: 'JSON',
+	stac_extensions: 'VARCHAR[]'
+})
+ORDER BY collection, id
+) TO `+quote(dst)+` (FORMAT parquet)`)
+	if err != nil {
+		return fmt.Errorf("writing catalog failed: %w", err)
+	}
+	return nil
+}
+
+func writeFile(fs afero.Fs, name string, r io.Reader) error {
+	if err := fs.MkdirAll(path.Dir(name), files.PermDir); err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, files.PermFile)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
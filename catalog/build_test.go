@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	fs := afero.NewBasePathFs(afero.NewOsFs(), dir)
+
+	files := map[string]string{
+		"/pkg/a.tif":           "a",
+		"/pkg/sub/b.nc":        "bb",
+		"/pkg/.hidden/c.tif":   "c",
+		"/pkg/.d.tif":          "d",
+		"/pkg/notes.json":      "{}",
+		"/other/outside.tif":   "o",
+		"/pkg/sub/b.nc.json":   `{"id": "b", "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [2, 0], [2, 1], [0, 0]]]}, "properties": {"datetime": "2024-06-01T00:00:00Z", "platform": "x"}, "assets": {"meta": {"href": "b.xml"}}}`,
+		"/pkg/catalog.parquet": "stale",
+	}
+	for name, content := range files {
+		if err := fs.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := afero.WriteFile(fs, name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := Build(context.Background(), fs, "/pkg", BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got %d items, want 3", count)
+	}
+
+	src := Source{
+		Path:        filepath.Join(dir, "pkg", DefaultCatalogName),
+		FilterField: DefaultAssetKey,
+		RequestPath: "/",
+		AssetsURL:   "http://localhost/api/public/share/h",
+	}
+	result, err := SearchItems(context.Background(), src, Search{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(result.Items); !slices.Equal(got, []string{"a.tif", "b", "notes.json"}) {
+		t.Fatalf("got items %v", got)
+	}
+
+	b := result.Items[1]
+	if b["collection"] != "pkg" {
+		t.Errorf("unexpected collection %v", b["collection"])
+	}
+	if !slices.Equal(b["bbox"].([]float64), []float64{0, 0, 2, 1}) {
+		t.Errorf("unexpected bbox %v", b["bbox"])
+	}
+	props := b["properties"].(map[string]interface{})
+	if props["datetime"] != "2024-06-01T00:00:00Z" || props["platform"] != "x" || props["title"] != "b.nc" {
+		t.Errorf("unexpected properties %v", props)
+	}
+	assets := b["assets"].(map[string]interface{})
+	data := assets[DefaultAssetKey].(map[string]interface{})
+	if data["href"] != "http://localhost/api/public/share/h/sub/b.nc?presign&followRedirect" || data["type"] != "application/netcdf" {
+		t.Errorf("unexpected data asset %v", data)
+	}
+	if meta := assets["meta"].(map[string]interface{}); meta["href"] != "http://localhost/api/public/share/h/sub/b.xml?presign&followRedirect" {
+		t.Errorf("unexpected meta asset %v", meta)
+	}
+
+	result, err = SearchItems(context.Background(), Source{Path: src.Path, FilterField: DefaultAssetKey, RequestPath: "/sub"}, Search{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(result.Items); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("got items %v in sub directory", got)
+	}
+
+	if _, err := Build(context.Background(), fs, "/pkg/a.tif", BuildOptions{}); err == nil {
+		t.Error("expected building a file to fail")
+	}
+
+	count, err = Build(context.Background(), fs, "/pkg", BuildOptions{Checker: denyPrefix("/pkg/sub")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d items with /pkg/sub denied, want 2", count)
+	}
+}
+
+// denyPrefix is a rules checker denying the paths below it.
+type denyPrefix string
+
+func (p denyPrefix) Check(name string) bool {
+	return name != string(p) && !strings.HasPrefix(name, string(p)+"/")
+}
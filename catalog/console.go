@@ -0,0 +1,415 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcboeker/go-duckdb/v2"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const (
+	// DefaultQueryRows is the number of rows a query returns unless limited.
+	DefaultQueryRows = 1000
+	// MaxQueryRows bounds the number of rows a query returns or exports.
+	MaxQueryRows = 100000
+	// QueryTimeout bounds the time a query runs.
+	QueryTimeout = 30 * time.Second
+
+	queryMemoryLimit = "1GB"
+)
+
+// Export formats of query results.
+const (
+	FormatCSV     = "csv"
+	FormatJSON    = "json"
+	FormatParquet = "parquet"
+)
+
+// queryTableFunctions are the table functions a query may call. Their first
+// argument is a file reference.
+var queryTableFunctions = map[string]bool{
+	"read_parquet":          true,
+	"parquet_scan":          true,
+	"parquet_metadata":      true,
+	"parquet_schema":        true,
+	"parquet_file_metadata": true,
+	"parquet_kv_metadata":   true,
+	"read_csv":              true,
+	"read_csv_auto":         true,
+	"read_json":             true,
+	"read_json_auto":        true,
+	"read_ndjson":           true,
+	"read_ndjson_auto":      true,
+}
+
+// QueryOptions configure how a query of a user is run.
+type QueryOptions struct {
+	// Root is the local directory the query may read from.
+	Root string
+	// Resolve maps a file reference of the query, possibly a glob, to its
+	// local path below Root. It fails if the reference or any file it
+	// matches must not be read.
+	Resolve func(ref string) (string, error)
+	// Limit is the maximum number of rows, by default DefaultQueryRows.
+	Limit int
+}
+
+// QueryResult holds the rows of a query.
+type QueryResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"`
+}
+
+func (opts QueryOptions) limit() (int, error) {
+	switch {
+	case opts.Limit == 0:
+		return DefaultQueryRows, nil
+	case opts.Limit < 0 || opts.Limit > MaxQueryRows:
+		return 0, fmt.Errorf("limit must be between 1 and %d: %w", MaxQueryRows, fbErrors.ErrInvalidRequestParams)
+	default:
+		return opts.Limit, nil
+	}
+}
+
+// RunQuery runs the read-only query on the files below opts.Root.
+func RunQuery(ctx context.Context, query string, opts QueryOptions) (*QueryResult, error) {
+	limit, err := opts.limit()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	db, err := sandbox(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	query, err = rewriteQuery(ctx, db, query, opts.Resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	// one more row than requested tells whether the result was truncated
+	rows, err := db.QueryContext(ctx, `SELECT * FROM (`+query+`) LIMIT ?`, limit+1)
+	if err != nil {
+		return nil, queryError(ctx, err, opts.Root)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("reading columns failed: %w", err)
+	}
+
+	result := &QueryResult{Columns: cols, Rows: [][]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) == limit {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		for i := range values {
+			values[i] = jsonValue(values[i])
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err, opts.Root)
+	}
+
+	return result, nil
+}
+
+// ExportQuery runs the read-only query on the files below opts.Root and
+// writes its result in format to w.
+func ExportQuery(ctx context.Context, query, format string, opts QueryOptions, w io.Writer) error {
+	limit, err := opts.limit()
+	if err != nil {
+		return err
+	}
+
+	var options string
+	switch format {
+	case FormatCSV:
+		options = "FORMAT csv, HEADER true"
+	case FormatJSON:
+		options = "FORMAT json"
+	case FormatParquet:
+		options = "FORMAT parquet"
+	default:
+		return fmt.Errorf("unsupported format %q: %w", format, fbErrors.ErrInvalidRequestParams)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeout)
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "query-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sandbox(opts.Root, dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query, err = rewriteQuery(ctx, db, query, opts.Resolve)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(dir, "result."+format)
+	_, err = db.ExecContext(ctx, `COPY (SELECT * FROM (`+query+`) LIMIT `+fmt.Sprint(limit)+`) TO `+quoteLiteral(out)+` (`+options+`)`)
+	if err != nil {
+		return queryError(ctx, err, opts.Root, dir)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// sandbox opens a database of its own for a query that may only access the
+// given directories, with extensions and remote files disabled and the
+// configuration locked.
+func sandbox(dirs ...string) (*sql.DB, error) {
+	allowed := make([]string, len(dirs))
+	for i, dir := range dirs {
+		allowed[i] = quoteLiteral(strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator))
+	}
+
+	settings := []string{
+		// the temp directory, relative to the working directory, would be
+		// allowed as well, so queries may not spill to disk
+		"SET temp_directory = ''",
+		"SET allowed_directories = [" + strings.Join(allowed, ", ") + "]",
+		"SET enable_external_access = false",
+		"SET autoinstall_known_extensions = false",
+		"SET autoload_known_extensions = false",
+		"SET memory_limit = '" + queryMemoryLimit + "'",
+		"SET lock_configuration = true",
+	}
+
+	connector, err := duckdb.NewConnector("", func(execer driver.ExecerContext) error {
+		for _, setting := range settings {
+			if _, err := execer.ExecContext(context.Background(), setting, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("duckdb connector error: %w", err)
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// queryError marks errors of running a query, which the user wrote, as
+// invalid requests, including running out of time. The local directories
+// the query ran in are stripped from the message, so that it names files
+// by their paths in the scope of the user.
+func queryError(ctx context.Context, err error, dirs ...string) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("query exceeded %s: %w", QueryTimeout, fbErrors.ErrInvalidRequestParams)
+	case ctx.Err() != nil:
+		return ctx.Err()
+	}
+
+	msg := err.Error()
+	for _, dir := range dirs {
+		if dir = strings.TrimSuffix(dir, string(filepath.Separator)); dir != "" {
+			msg = strings.ReplaceAll(msg, dir, "")
+		}
+	}
+	return fmt.Errorf("query failed: %s: %w", msg, fbErrors.ErrInvalidRequestParams)
+}
+
+// rewriteQuery checks that query is a single SELECT statement reading only
+// files through the allowed table functions or file references, and
+// returns it with the references replaced by resolve.
+func rewriteQuery(ctx context.Context, db *sql.DB, query string, resolve func(ref string) (string, error)) (string, error) {
+	var serialized string
+	err := db.QueryRowContext(ctx, `SELECT json_serialize_sql(?::VARCHAR)::VARCHAR`, query).Scan(&serialized)
+	if err != nil {
+		return "", queryError(ctx, err)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(serialized))
+	dec.UseNumber()
+	var tree struct {
+		Error        bool          `json:"error"`
+		ErrorMessage string        `json:"error_message"`
+		Statements   []interface{} `json:"statements"`
+	}
+	if err := dec.Decode(&tree); err != nil {
+		return "", fmt.Errorf("parsing query failed: %w", err)
+	}
+	if tree.Error {
+		return "", fmt.Errorf("%s: %w", tree.ErrorMessage, fbErrors.ErrInvalidRequestParams)
+	}
+	if len(tree.Statements) != 1 {
+		return "", fmt.Errorf("expected a single statement: %w", fbErrors.ErrInvalidRequestParams)
+	}
+
+	rw := &queryRewriter{resolve: resolve, ctes: map[string]bool{}}
+	rw.collectCTEs(tree.Statements)
+	if err := rw.walk(tree.Statements); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(map[string]interface{}{"error": false, "statements": tree.Statements}); err != nil {
+		return "", err
+	}
+
+	var rewritten string
+	err = db.QueryRowContext(ctx, `SELECT json_deserialize_sql(?::JSON)`, buf.String()).Scan(&rewritten)
+	if err != nil {
+		return "", queryError(ctx, err)
+	}
+	return rewritten, nil
+}
+
+type queryRewriter struct {
+	resolve func(ref string) (string, error)
+	// ctes are the names of common table expressions, which are referenced
+	// like tables
+	ctes map[string]bool
+}
+
+func (rw *queryRewriter) collectCTEs(node interface{}) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if cteMap, ok := n["cte_map"].(map[string]interface{}); ok {
+			entries, _ := cteMap["map"].([]interface{})
+			for _, entry := range entries {
+				if entry, ok := entry.(map[string]interface{}); ok {
+					if key, ok := entry["key"].(string); ok {
+						rw.ctes[strings.ToLower(key)] = true
+					}
+				}
+			}
+		}
+		for _, v := range n {
+			rw.collectCTEs(v)
+		}
+	case []interface{}:
+		for _, v := range n {
+			rw.collectCTEs(v)
+		}
+	}
+}
+
+//nolint:gocyclo
+func (rw *queryRewriter) walk(node interface{}) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		switch n["type"] {
+		case "BASE_TABLE":
+			name, _ := n["table_name"].(string)
+			if n["schema_name"] != "" || n["catalog_name"] != "" {
+				return fmt.Errorf("unknown table %q: %w", name, fbErrors.ErrInvalidRequestParams)
+			}
+			if !rw.ctes[strings.ToLower(name)] {
+				resolved, err := rw.resolve(name)
+				if err != nil {
+					return err
+				}
+				n["table_name"] = resolved
+			}
+		case "TABLE_FUNCTION":
+			fn, _ := n["function"].(map[string]interface{})
+			name, _ := fn["function_name"].(string)
+			if !queryTableFunctions[strings.ToLower(name)] {
+				return fmt.Errorf("unsupported table function %q: %w", name, fbErrors.ErrInvalidRequestParams)
+			}
+			children, _ := fn["children"].([]interface{})
+			if len(children) == 0 {
+				return fmt.Errorf("%s needs a file: %w", name, fbErrors.ErrInvalidRequestParams)
+			}
+			if err := rw.files(children[0]); err != nil {
+				return err
+			}
+		}
+		for _, v := range n {
+			if err := rw.walk(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range n {
+			if err := rw.walk(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// files resolves the file argument of a table function, a string or a list
+// of strings.
+func (rw *queryRewriter) files(node interface{}) error {
+	n, _ := node.(map[string]interface{})
+
+	if n["class"] == "FUNCTION" && n["function_name"] == "list_value" {
+		children, _ := n["children"].([]interface{})
+		for _, child := range children {
+			if err := rw.files(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if n["class"] == "CONSTANT" {
+		if value, ok := n["value"].(map[string]interface{}); ok {
+			if ref, ok := value["value"].(string); ok {
+				resolved, err := rw.resolve(ref)
+				if err != nil {
+					return err
+				}
+				value["value"] = resolved
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("files must be given as string literals: %w", fbErrors.ErrInvalidRequestParams)
+}
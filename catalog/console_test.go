@@ -0,0 +1,152 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+func TestRunQuery(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFixtureAt(t, filepath.Join(root, "data", "items.parquet"), fixtureItems)
+	if err := os.WriteFile(filepath.Join(root, "names.csv"), []byte("id,name\na,first\nb,second\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret.csv"), []byte("x\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := QueryOptions{
+		Root: root,
+		Resolve: func(ref string) (string, error) {
+			ref = path.Clean("/" + ref)
+			if strings.HasPrefix(ref, "/secret") {
+				return "", fbErrors.ErrPermissionDenied
+			}
+			return filepath.Join(root, ref), nil
+		},
+	}
+
+	testCases := map[string]struct {
+		query string
+		want  string
+	}{
+		"file reference": {
+			query: `SELECT count(*) AS n FROM 'data/*.parquet'`,
+			want:  "[[4]]",
+		},
+		"table functions and cte": {
+			query: `WITH c1 AS (SELECT id FROM read_parquet(['/data/items.parquet']) WHERE collection = 'c1')
+				SELECT c1.id, n.name FROM c1 JOIN read_csv('names.csv', header = true) n USING (id) ORDER BY id`,
+			want: "[[a first] [b second]]",
+		},
+		"subquery": {
+			query: `SELECT id FROM 'data/items.parquet' WHERE id IN (SELECT id FROM 'names.csv') ORDER BY id`,
+			want:  "[[a] [b]]",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := RunQuery(context.Background(), tc.query, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(result.Rows); got != tc.want || result.Truncated {
+				t.Errorf("got rows %s truncated %v, want %s", got, result.Truncated, tc.want)
+			}
+		})
+	}
+
+	limited := opts
+	limited.Limit = 2
+	result, err := RunQuery(context.Background(), `SELECT id FROM 'data/items.parquet' ORDER BY id`, limited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Columns, result.Rows) != "[id] [[a] [b]]" || !result.Truncated {
+		t.Errorf("unexpected limited result %+v", result)
+	}
+
+	rejected := map[string]error{
+		"denied file":          fbErrors.ErrPermissionDenied,
+		"write":                fbErrors.ErrInvalidRequestParams,
+		"two statements":       fbErrors.ErrInvalidRequestParams,
+		"other table function": fbErrors.ErrInvalidRequestParams,
+		"computed file":        fbErrors.ErrInvalidRequestParams,
+		"schema table":         fbErrors.ErrInvalidRequestParams,
+		"syntax":               fbErrors.ErrInvalidRequestParams,
+	}
+	queries := map[string]string{
+		"denied file":          `SELECT * FROM 'secret.csv'`,
+		"write":                `COPY (SELECT 1) TO 'out.csv'`,
+		"two statements":       `SELECT 1; SELECT 2`,
+		"other table function": `SELECT * FROM read_text('/etc/passwd')`,
+		"computed file":        `SELECT * FROM read_csv('sec' || 'ret.csv')`,
+		"schema table":         `SELECT * FROM information_schema.tables`,
+		"syntax":               `SELEC 1`,
+	}
+	for name, want := range rejected {
+		if _, err := RunQuery(context.Background(), queries[name], opts); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, err)
+		}
+	}
+
+	_, err = RunQuery(context.Background(), `SELECT * FROM 'data/missing.parquet'`, opts)
+	if err == nil || strings.Contains(err.Error(), root) || !strings.Contains(err.Error(), "/data/missing.parquet") {
+		t.Errorf("expected an error naming the file in the scope, got %v", err)
+	}
+
+	// DuckDB allows its temp directory, relative to the working directory
+	t.Chdir(t.TempDir())
+	if err := os.MkdirAll(".tmp", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(".tmp", "x.csv"), []byte("x\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	raw := QueryOptions{Root: root, Resolve: func(ref string) (string, error) { return ref, nil }}
+	if _, err := RunQuery(context.Background(), `SELECT * FROM read_csv('.tmp/x.csv')`, raw); err == nil {
+		t.Error("expected the temp directory to be outside of the sandbox")
+	}
+
+	escaping := opts
+	escaping.Resolve = func(string) (string, error) { return "/etc/passwd", nil }
+	if _, err := RunQuery(context.Background(), `SELECT * FROM read_csv('x')`, escaping); err == nil {
+		t.Error("expected reading outside of the root to fail")
+	}
+}
+
+func TestExportQuery(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "names.csv"), []byte("id,name\na,first\nb,second\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := QueryOptions{
+		Root:    root,
+		Resolve: func(ref string) (string, error) { return filepath.Join(root, path.Clean("/"+ref)), nil },
+	}
+
+	var buf bytes.Buffer
+	if err := ExportQuery(context.Background(), `SELECT name FROM 'names.csv' ORDER BY id`, FormatCSV, opts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "name\nfirst\nsecond\n" {
+		t.Errorf("unexpected export %q", buf.String())
+	}
+
+	if err := ExportQuery(context.Background(), `SELECT 1`, "xlsx", opts, &buf); !errors.Is(err, fbErrors.ErrInvalidRequestParams) {
+		t.Errorf("expected invalid request params, got %v", err)
+	}
+}
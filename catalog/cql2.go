@@ -0,0 +1,534 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const (
+	CQL2Text = "cql2-text"
+	CQL2JSON = "cql2-json"
+)
+
+// propertyPattern whitelists the property names of filters. Names end up as
+// quoted identifiers or in JSON paths.
+var propertyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]{0,63}$`)
+
+// parseFilter parses filter in lang, guessing CQL2-text for strings and
+// CQL2-JSON otherwise if lang is empty.
+func parseFilter(filter interface{}, lang string) (interface{}, error) {
+	text, isText := filter.(string)
+
+	switch lang {
+	case "":
+		if isText {
+			return parseCQL2Text(text)
+		}
+		return filter, nil
+	case CQL2Text:
+		if !isText {
+			return nil, fmt.Errorf("cql2-text filter must be a string: %w", fbErrors.ErrInvalidRequestParams)
+		}
+		return parseCQL2Text(text)
+	case CQL2JSON:
+		if !isText {
+			return filter, nil
+		}
+		var node interface{}
+		if err := json.Unmarshal([]byte(text), &node); err != nil {
+			return nil, fmt.Errorf("invalid cql2-json filter: %w", fbErrors.ErrInvalidRequestParams)
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("unsupported filter-lang %q: %w", lang, fbErrors.ErrInvalidRequestParams)
+	}
+}
+
+// cql2Translator translates CQL2-JSON nodes into DuckDB SQL. Literals are
+// always passed as bound arguments, in the order they appear in the SQL.
+type cql2Translator struct {
+	schema         *schema
+	collection     string
+	collectionArgs []interface{}
+	args           []interface{}
+	// spatial is set once the SQL needs the spatial extension
+	spatial bool
+}
+
+func unsupported(format string, args ...interface{}) error {
+	return fmt.Errorf("cql2: "+format+": %w", append(args, fbErrors.ErrInvalidRequestParams)...)
+}
+
+func cql2Op(node interface{}) (string, []interface{}, bool) {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return "", nil, false
+	}
+	name, ok := m["op"].(string)
+	if !ok {
+		return "", nil, false
+	}
+	args, _ := m["args"].([]interface{})
+	return strings.ToLower(name), args, true
+}
+
+func (t *cql2Translator) arity(name string, args []interface{}, n int) error {
+	if len(args) != n {
+		return unsupported("%s takes %d arguments, got %d", name, n, len(args))
+	}
+	return nil
+}
+
+var comparisons = map[string]string{
+	"=":  "=",
+	"<>": "<>",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// predicate translates a boolean expression.
+//
+//nolint:gocyclo
+func (t *cql2Translator) predicate(node interface{}) (string, error) {
+	if b, ok := node.(bool); ok {
+		if b {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	}
+
+	name, args, ok := cql2Op(node)
+	if !ok {
+		return "", unsupported("expected a predicate, got %v", node)
+	}
+
+	switch name {
+	case "and", "or":
+		if len(args) < 2 {
+			return "", unsupported("%s takes at least 2 arguments", name)
+		}
+		parts := make([]string, 0, len(args))
+		for _, arg := range args {
+			part, err := t.predicate(arg)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(name)+" ") + ")", nil
+
+	case "not":
+		if err := t.arity(name, args, 1); err != nil {
+			return "", err
+		}
+		part, err := t.predicate(args[0])
+		if err != nil {
+			return "", err
+		}
+		return "(NOT " + part + ")", nil
+
+	case "=", "<>", "<", "<=", ">", ">=":
+		if err := t.arity(name, args, 2); err != nil {
+			return "", err
+		}
+		kind := literalKind(args[0], args[1])
+		left, err := t.scalar(args[0], kind)
+		if err != nil {
+			return "", err
+		}
+		right, err := t.scalar(args[1], kind)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + comparisons[name] + " " + right + ")", nil
+
+	case "like":
+		if err := t.arity(name, args, 2); err != nil {
+			return "", err
+		}
+		if _, ok := args[1].(string); !ok {
+			return "", unsupported("like needs a string pattern")
+		}
+		left, err := t.scalar(args[0], "string")
+		if err != nil {
+			return "", err
+		}
+		right, err := t.scalar(args[1], "string")
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " LIKE " + right + ` ESCAPE '\')`, nil
+
+	case "in":
+		if err := t.arity(name, args, 2); err != nil {
+			return "", err
+		}
+		list, ok := args[1].([]interface{})
+		if !ok || len(list) == 0 {
+			return "", unsupported("in needs a non-empty list")
+		}
+		kind := literalKind(list...)
+		left, err := t.scalar(args[0], kind)
+		if err != nil {
+			return "", err
+		}
+		values := make([]string, 0, len(list))
+		for _, v := range list {
+			value, err := t.scalar(v, kind)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, value)
+		}
+		return "(" + left + " IN (" + strings.Join(values, ", ") + "))", nil
+
+	case "between":
+		if err := t.arity(name, args, 3); err != nil {
+			return "", err
+		}
+		kind := literalKind(args[1], args[2])
+		parts := make([]string, 0, 3)
+		for _, arg := range args {
+			part, err := t.scalar(arg, kind)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		return "(" + parts[0] + " BETWEEN " + parts[1] + " AND " + parts[2] + ")", nil
+
+	case "isnull":
+		if err := t.arity(name, args, 1); err != nil {
+			return "", err
+		}
+		value, err := t.scalar(args[0], "")
+		if err != nil {
+			return "", err
+		}
+		return "(" + value + " IS NULL)", nil
+
+	case "s_intersects", "s_disjoint", "s_within", "s_contains", "s_equals":
+		if err := t.arity(name, args, 2); err != nil {
+			return "", err
+		}
+		left, err := t.geometry(args[0])
+		if err != nil {
+			return "", err
+		}
+		right, err := t.geometry(args[1])
+		if err != nil {
+			return "", err
+		}
+		fn := map[string]string{
+			"s_intersects": "ST_Intersects",
+			"s_disjoint":   "ST_Disjoint",
+			"s_within":     "ST_Within",
+			"s_contains":   "ST_Contains",
+			"s_equals":     "ST_Equals",
+		}[name]
+		return fn + "(" + left + ", " + right + ")", nil
+
+	case "t_intersects", "t_before", "t_after":
+		if err := t.arity(name, args, 2); err != nil {
+			return "", err
+		}
+		return t.temporal(name, args[0], args[1])
+
+	default:
+		return "", unsupported("unsupported operator %q", name)
+	}
+}
+
+// literalKind returns the type of the first literal in nodes, which decides
+// how properties stored as JSON are cast for comparison.
+func literalKind(nodes ...interface{}) string {
+	for _, node := range nodes {
+		switch v := node.(type) {
+		case string:
+			return "string"
+		case float64:
+			return "number"
+		case bool:
+			return "bool"
+		case map[string]interface{}:
+			if _, ok := v["timestamp"]; ok {
+				return "timestamp"
+			}
+			if _, ok := v["date"]; ok {
+				return "timestamp"
+			}
+		}
+	}
+	return ""
+}
+
+// scalar translates a property or literal.
+func (t *cql2Translator) scalar(node interface{}, kind string) (string, error) {
+	switch v := node.(type) {
+	case string, float64, bool:
+		t.args = append(t.args, v)
+		return "?", nil
+	case map[string]interface{}:
+		if name, ok := v["property"].(string); ok {
+			return t.property(name, kind)
+		}
+		if v["timestamp"] != nil || v["date"] != nil {
+			instant, err := parseInstant(v)
+			if err != nil {
+				return "", err
+			}
+			t.args = append(t.args, instant)
+			return "?", nil
+		}
+		if _, _, ok := cql2Op(v); ok {
+			return "", unsupported("functions and arithmetic are not supported")
+		}
+	}
+	return "", unsupported("unsupported value %v", node)
+}
+
+// property translates a property reference. Properties are columns of the
+// catalog or keys of its properties column.
+func (t *cql2Translator) property(name, kind string) (string, error) {
+	if !propertyPattern.MatchString(name) {
+		return "", unsupported("invalid property %q", name)
+	}
+
+	switch {
+	case name == "collection":
+		t.args = append(t.args, t.collectionArgs...)
+		return t.collection, nil
+	case name == "datetime" && t.schema.datetime != "":
+		return t.schema.datetime, nil
+	case name == "geometry":
+		return "", unsupported("geometry can only be used in spatial operators")
+	}
+
+	if _, ok := t.schema.columns[name]; ok {
+		return `"` + name + `"`, nil
+	}
+
+	if !t.schema.hasProperties {
+		return "", unsupported("unknown property %q", name)
+	}
+
+	t.args = append(t.args, `$."`+name+`"`)
+	expr := "json_extract_string(CAST(properties AS JSON), ?)"
+	switch kind {
+	case "number":
+		return "TRY_CAST(" + expr + " AS DOUBLE)", nil
+	case "bool":
+		return "TRY_CAST(" + expr + " AS BOOLEAN)", nil
+	case "timestamp":
+		return "TRY_CAST(" + expr + " AS TIMESTAMPTZ)", nil
+	default:
+		return expr, nil
+	}
+}
+
+// geometry translates the geometry property or a geometry literal.
+func (t *cql2Translator) geometry(node interface{}) (string, error) {
+	v, ok := node.(map[string]interface{})
+	if !ok {
+		return "", unsupported("expected a geometry, got %v", node)
+	}
+
+	t.spatial = true
+
+	if name, ok := v["property"].(string); ok {
+		if name != "geometry" {
+			return "", unsupported("spatial operators need the geometry property, got %q", name)
+		}
+		if t.schema.geometry == "" {
+			return "", unsupported("catalog has no geometry")
+		}
+		return t.schema.geometry, nil
+	}
+
+	if bbox, ok := v["bbox"].([]interface{}); ok {
+		coords := make([]float64, 0, len(bbox))
+		for _, c := range bbox {
+			f, ok := c.(float64)
+			if !ok {
+				return "", unsupported("invalid bbox %v", bbox)
+			}
+			coords = append(coords, f)
+		}
+		switch len(coords) {
+		case 4:
+		case 6:
+			coords = []float64{coords[0], coords[1], coords[3], coords[4]}
+		default:
+			return "", unsupported("bbox needs 4 or 6 values")
+		}
+		for _, c := range coords {
+			t.args = append(t.args, c)
+		}
+		return "ST_MakeEnvelope(?, ?, ?, ?)", nil
+	}
+
+	if wkt, ok := v["wkt"].(string); ok {
+		t.args = append(t.args, wkt)
+		return "ST_GeomFromText(?)", nil
+	}
+
+	if _, ok := v["type"].(string); ok {
+		geojson, err := json.Marshal(v)
+		if err != nil {
+			return "", unsupported("invalid geometry")
+		}
+		t.args = append(t.args, string(geojson))
+		return "ST_GeomFromGeoJSON(?)", nil
+	}
+
+	return "", unsupported("expected a geometry, got %v", node)
+}
+
+// temporal translates temporal operators between an instant property and a
+// temporal literal.
+func (t *cql2Translator) temporal(name string, left, right interface{}) (string, error) {
+	ref, _ := left.(map[string]interface{})
+	prop, ok := ref["property"].(string)
+	if !ok {
+		return "", unsupported("%s needs a property as first argument", name)
+	}
+
+	start, end, err := parseTemporal(right)
+	if err != nil {
+		return "", err
+	}
+
+	conds := []string{}
+	add := func(cmp string, instant *time.Time) error {
+		expr, err := t.property(prop, "timestamp")
+		if err != nil {
+			return err
+		}
+		conds = append(conds, expr+" "+cmp+" ?")
+		t.args = append(t.args, *instant)
+		return nil
+	}
+
+	switch name {
+	case "t_intersects":
+		if start != nil {
+			if err := add(">=", start); err != nil {
+				return "", err
+			}
+		}
+		if end != nil {
+			if err := add("<=", end); err != nil {
+				return "", err
+			}
+		}
+	case "t_before":
+		if start == nil {
+			return "", unsupported("t_before needs a bounded start")
+		}
+		if err := add("<", start); err != nil {
+			return "", err
+		}
+	case "t_after":
+		if end == nil {
+			return "", unsupported("t_after needs a bounded end")
+		}
+		if err := add(">", end); err != nil {
+			return "", err
+		}
+	}
+
+	if len(conds) == 0 {
+		return "TRUE", nil
+	}
+	return "(" + strings.Join(conds, " AND ") + ")", nil
+}
+
+// parseInstant parses a {"timestamp": ...} or {"date": ...} literal.
+func parseInstant(v map[string]interface{}) (time.Time, error) {
+	if s, ok := v["timestamp"].(string); ok {
+		instant, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, unsupported("invalid timestamp %q", s)
+		}
+		return instant, nil
+	}
+	if s, ok := v["date"].(string); ok {
+		instant, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return time.Time{}, unsupported("invalid date %q", s)
+		}
+		return instant, nil
+	}
+	return time.Time{}, unsupported("invalid instant %v", v)
+}
+
+// parseTemporal returns the bounds of a temporal literal, nil if open. A date
+// covers the whole day.
+func parseTemporal(node interface{}) (start, end *time.Time, err error) {
+	v, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, nil, unsupported("expected a temporal literal, got %v", node)
+	}
+
+	if interval, ok := v["interval"].([]interface{}); ok {
+		if len(interval) != 2 {
+			return nil, nil, unsupported("interval needs 2 values")
+		}
+		bounds := [2]*time.Time{}
+		for i, bound := range interval {
+			switch b := bound.(type) {
+			case string:
+				if b == ".." {
+					continue
+				}
+				instant, err := time.Parse(time.RFC3339, b)
+				if err != nil {
+					if instant, err = time.Parse(time.DateOnly, b); err != nil {
+						return nil, nil, unsupported("invalid interval bound %q", b)
+					}
+					if i == 1 {
+						instant = instant.Add(24*time.Hour - time.Nanosecond)
+					}
+				}
+				bounds[i] = &instant
+			case map[string]interface{}:
+				instant, err := parseInstant(b)
+				if err != nil {
+					return nil, nil, err
+				}
+				bounds[i] = &instant
+			default:
+				return nil, nil, unsupported("invalid interval bound %v", bound)
+			}
+		}
+		return bounds[0], bounds[1], nil
+	}
+
+	instant, err := parseInstant(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, isDate := v["date"]; isDate {
+		last := instant.Add(24*time.Hour - time.Nanosecond)
+		return &instant, &last, nil
+	}
+	return &instant, &instant, nil
+}
+
+// loadSpatial loads the DuckDB spatial extension, which InstallSpatial
+// installs beforehand, as requests must not download extensions.
+func loadSpatial(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, "LOAD spatial"); err != nil {
+		return fmt.Errorf("spatial extension unavailable: %w", err)
+	}
+	return nil
+}
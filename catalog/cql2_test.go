@@ -0,0 +1,245 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+func testSchema() *schema {
+	return &schema{
+		collection:    "collection",
+		datetime:      "TRY_CAST(datetime AS TIMESTAMPTZ)",
+		bbox:          [4]string{"bbox.xmin", "bbox.ymin", "bbox.xmax", "bbox.ymax"},
+		geometry:      "ST_GeomFromWKB(geometry)",
+		columns:       map[string]string{"id": "VARCHAR", "collection": "VARCHAR", "eo:cloud_cover": "DOUBLE"},
+		hasProperties: true,
+	}
+}
+
+func TestCQL2Translation(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		filter   interface{}
+		lang     string
+		wantSQL  string
+		wantArgs []interface{}
+		spatial  bool
+	}{
+		"comparison on column": {
+			filter:   `"eo:cloud_cover" < 10`,
+			wantSQL:  `("eo:cloud_cover" < ?)`,
+			wantArgs: []interface{}{10.0},
+		},
+		"comparison on json property": {
+			filter:   "platform = 'sentinel-2a' AND gsd >= 10",
+			wantSQL:  `((json_extract_string(CAST(properties AS JSON), ?) = ?) AND (TRY_CAST(json_extract_string(CAST(properties AS JSON), ?) AS DOUBLE) >= ?))`,
+			wantArgs: []interface{}{`$."platform"`, "sentinel-2a", `$."gsd"`, 10.0},
+		},
+		"collection": {
+			filter:   "collection IN ('c1', 'c2')",
+			wantSQL:  `(COALESCE(collection, ?) IN (?, ?))`,
+			wantArgs: []interface{}{"h", "c1", "c2"},
+		},
+		"not like": {
+			filter:   `id NOT LIKE 'a\_%'`,
+			wantSQL:  `(NOT ("id" LIKE ? ESCAPE '\'))`,
+			wantArgs: []interface{}{`a\_%`},
+		},
+		"between and null": {
+			filter:   `"eo:cloud_cover" BETWEEN 0 AND 5 OR id IS NOT NULL`,
+			wantSQL:  `(("eo:cloud_cover" BETWEEN ? AND ?) OR (NOT ("id" IS NULL)))`,
+			wantArgs: []interface{}{0.0, 5.0},
+		},
+		"temporal interval": {
+			filter:   "T_INTERSECTS(datetime, INTERVAL('2024-01-01T00:00:00Z', '..'))",
+			wantSQL:  `(TRY_CAST(datetime AS TIMESTAMPTZ) >= ?)`,
+			wantArgs: []interface{}{day},
+		},
+		"temporal date": {
+			filter:   "T_INTERSECTS(datetime, DATE('2024-01-01'))",
+			wantSQL:  `(TRY_CAST(datetime AS TIMESTAMPTZ) >= ? AND TRY_CAST(datetime AS TIMESTAMPTZ) <= ?)`,
+			wantArgs: []interface{}{day, day.Add(24*time.Hour - time.Nanosecond)},
+		},
+		"timestamp comparison": {
+			filter:   "datetime > TIMESTAMP('2024-01-01T00:00:00Z')",
+			wantSQL:  `(TRY_CAST(datetime AS TIMESTAMPTZ) > ?)`,
+			wantArgs: []interface{}{day},
+		},
+		"spatial wkt": {
+			filter:   "S_INTERSECTS(geometry, POLYGON((0 0, 1 0, 1 1, 0 0)))",
+			wantSQL:  `ST_Intersects(ST_GeomFromWKB(geometry), ST_GeomFromText(?))`,
+			wantArgs: []interface{}{"POLYGON((0 0, 1 0, 1 1, 0 0))"},
+			spatial:  true,
+		},
+		"spatial bbox": {
+			filter:   "S_INTERSECTS(geometry, BBOX(-10, -5.5, 10, 5.5))",
+			wantSQL:  `ST_Intersects(ST_GeomFromWKB(geometry), ST_MakeEnvelope(?, ?, ?, ?))`,
+			wantArgs: []interface{}{-10.0, -5.5, 10.0, 5.5},
+			spatial:  true,
+		},
+		"cql2-json": {
+			filter: map[string]interface{}{"op": "and", "args": []interface{}{
+				map[string]interface{}{"op": "s_intersects", "args": []interface{}{
+					map[string]interface{}{"property": "geometry"},
+					map[string]interface{}{"type": "Point", "coordinates": []interface{}{1.0, 2.0}},
+				}},
+				map[string]interface{}{"op": "t_intersects", "args": []interface{}{
+					map[string]interface{}{"property": "datetime"},
+					map[string]interface{}{"interval": []interface{}{"..", "2024-01-01T00:00:00Z"}},
+				}},
+			}},
+			wantSQL:  `(ST_Intersects(ST_GeomFromWKB(geometry), ST_GeomFromGeoJSON(?)) AND (TRY_CAST(datetime AS TIMESTAMPTZ) <= ?))`,
+			wantArgs: []interface{}{`{"coordinates":[1,2],"type":"Point"}`, day},
+			spatial:  true,
+		},
+		"cql2-json as text": {
+			filter:   `{"op": "=", "args": [{"property": "id"}, "a"]}`,
+			lang:     CQL2JSON,
+			wantSQL:  `("id" = ?)`,
+			wantArgs: []interface{}{"a"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			node, err := parseFilter(tc.filter, tc.lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := &cql2Translator{schema: testSchema(), collection: "COALESCE(collection, ?)", collectionArgs: []interface{}{"h"}}
+			got, err := tr.predicate(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.wantSQL {
+				t.Errorf("got SQL\n%s\nwant\n%s", got, tc.wantSQL)
+			}
+			if fmt.Sprint(tr.args) != fmt.Sprint(tc.wantArgs) {
+				t.Errorf("got args %v, want %v", tr.args, tc.wantArgs)
+			}
+			if tr.spatial != tc.spatial {
+				t.Errorf("got spatial %v, want %v", tr.spatial, tc.spatial)
+			}
+		})
+	}
+}
+
+func TestCQL2Errors(t *testing.T) {
+	filters := map[string]interface{}{
+		"syntax":                 "id = ",
+		"unterminated string":    "id = 'a",
+		"unsupported function":   "CASEI(id) = 'a'",
+		"unsupported operator":   map[string]interface{}{"op": "a_contains", "args": []interface{}{map[string]interface{}{"property": "id"}, []interface{}{"a"}}},
+		"arithmetic":             "gsd + 1 > 2",
+		"hostile property":       `"id"" = '' OR 1=1 --" = 'a'`,
+		"property only":          "id",
+		"geometry comparison":    "geometry = 'POINT(0 0)'",
+		"spatial on property":    "S_INTERSECTS(id, BBOX(0, 0, 1, 1))",
+		"invalid timestamp":      "datetime > TIMESTAMP('yesterday')",
+		"temporal needs literal": "T_INTERSECTS(datetime, 'x')",
+		"wrong arity":            map[string]interface{}{"op": "=", "args": []interface{}{"a"}},
+		"invalid json":           `{"op": `,
+	}
+
+	for name, filter := range filters {
+		t.Run(name, func(t *testing.T) {
+			lang := ""
+			if name == "invalid json" {
+				lang = CQL2JSON
+			}
+			node, err := parseFilter(filter, lang)
+			if err == nil {
+				tr := &cql2Translator{schema: testSchema(), collection: "collection"}
+				_, err = tr.predicate(node)
+			}
+			if !errors.Is(err, fbErrors.ErrInvalidRequestParams) {
+				t.Errorf("expected invalid request params, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSearchItemsFilter(t *testing.T) {
+	src := Source{
+		Path:        writeFixture(t),
+		FilterField: "visual",
+		BaseURL:     "s3://bucket/share",
+		RequestPath: "/",
+	}
+
+	testCases := map[string]struct {
+		filter interface{}
+		want   []string
+	}{
+		"in":         {filter: "id IN ('a', 'c', 'd')", want: []string{"a", "c"}},
+		"and not":    {filter: "collection = 'c1' AND NOT id = 'b'", want: []string{"a"}},
+		"like":       {filter: "id LIKE 'b%'", want: []string{"b"}},
+		"or":         {filter: "id = 'a' OR collection = 'c2'", want: []string{"a", "c"}},
+		"temporal":   {filter: "T_INTERSECTS(datetime, INTERVAL('2024-03-01T00:00:00Z', '..'))", want: []string{"b", "c"}},
+		"date":       {filter: "T_INTERSECTS(datetime, DATE('2024-06-01'))", want: []string{"b"}},
+		"before":     {filter: "T_BEFORE(datetime, TIMESTAMP('2024-06-01T00:00:00Z'))", want: []string{"a"}},
+		"comparison": {filter: "datetime >= TIMESTAMP('2024-06-01T00:00:00Z')", want: []string{"b", "c"}},
+		"cql2-json": {
+			filter: `{"op": "in", "args": [{"property": "id"}, ["b", "c"]]}`,
+			want:   []string{"b", "c"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			search := Search{Filter: tc.filter}
+			if name == "cql2-json" {
+				var node interface{}
+				if err := json.Unmarshal([]byte(tc.filter.(string)), &node); err != nil {
+					t.Fatal(err)
+				}
+				search.Filter = node
+			}
+			result, err := SearchItems(context.Background(), src, search)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(result.Items); !slices.Equal(got, tc.want) {
+				t.Errorf("got items %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchItemsSpatialFilter(t *testing.T) {
+	if err := InstallSpatial(context.Background()); err != nil {
+		t.Skipf("skipping spatial filters: %v", err)
+	}
+	conn, err := GetDuckDBConn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadSpatial(context.Background(), conn)
+	conn.Close()
+	if err != nil {
+		t.Skipf("skipping spatial filters: %v", err)
+	}
+
+	src := Source{
+		Path:        writeFixture(t),
+		FilterField: "visual",
+		BaseURL:     "s3://bucket/share",
+		RequestPath: "/",
+	}
+
+	result, err := SearchItems(context.Background(), src, Search{Filter: "S_INTERSECTS(geometry, BBOX(1.5, 1.5, 3, 3))"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(result.Items); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("got items %v", got)
+	}
+}
@@ -0,0 +1,431 @@
+package catalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+// parseCQL2Text parses a CQL2-text expression into the node tree of the
+// equivalent CQL2-JSON expression. Geometry literals are kept as WKT in
+// {"wkt": ...} nodes.
+func parseCQL2Text(text string) (interface{}, error) {
+	tokens, err := lexCQL2(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &cql2Parser{text: text, tokens: tokens}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.value)
+	}
+	return node, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+	end   int
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == ':' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+//nolint:gocyclo
+func lexCQL2(text string) ([]token, error) {
+	runes := []rune(text)
+	tokens := []token{}
+
+	// positions are kept in bytes to slice the text
+	offset := func(i int) int { return len(string(runes[:i])) }
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			i++
+			tokens = append(tokens, token{kind: tokLParen, value: "("})
+		case r == ')':
+			i++
+			tokens = append(tokens, token{kind: tokRParen, value: ")"})
+		case r == ',':
+			i++
+			tokens = append(tokens, token{kind: tokComma, value: ","})
+		case r == '=':
+			i++
+			tokens = append(tokens, token{kind: tokOp, value: "="})
+		case r == '<' || r == '>':
+			i++
+			op := string(r)
+			if i < len(runes) && (runes[i] == '=' || (r == '<' && runes[i] == '>')) {
+				op += string(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokOp, value: op})
+		case r == '\'' || r == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated %c at %d: %w", r, offset(start), fbErrors.ErrInvalidRequestParams)
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						b.WriteRune(r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			kind := tokString
+			if r == '"' {
+				kind = tokQuotedIdent
+			}
+			tokens = append(tokens, token{kind: kind, value: b.String()})
+		case unicode.IsDigit(r) || ((r == '-' || r == '+' || r == '.') && i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.')):
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '-' || runes[i] == '+') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, value: string(runes[start:i])})
+		case isIdentStart(r):
+			for i < len(runes) && isIdentPart(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, value: string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d: %w", r, offset(start), fbErrors.ErrInvalidRequestParams)
+		}
+
+		tokens[len(tokens)-1].pos = offset(start)
+		tokens[len(tokens)-1].end = offset(i)
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(text), end: len(text)}), nil
+}
+
+type cql2Parser struct {
+	text   string
+	tokens []token
+	pos    int
+}
+
+func (p *cql2Parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *cql2Parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *cql2Parser) errorf(tok token, format string, args ...interface{}) error {
+	return fmt.Errorf("cql2 at %d: %s: %w", tok.pos, fmt.Sprintf(format, args...), fbErrors.ErrInvalidRequestParams)
+}
+
+// keyword reports whether the next token is the unquoted keyword kw and
+// consumes it if so.
+func (p *cql2Parser) keyword(kw string) bool {
+	if tok := p.peek(); tok.kind == tokIdent && strings.EqualFold(tok.value, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *cql2Parser) expect(kind tokenKind, what string) (token, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, p.errorf(tok, "expected %s, got %q", what, tok.value)
+	}
+	return tok, nil
+}
+
+func op(name string, args ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"op": name, "args": args}
+}
+
+func (p *cql2Parser) or() (interface{}, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = op("or", left, right)
+	}
+	return left, nil
+}
+
+func (p *cql2Parser) and() (interface{}, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = op("and", left, right)
+	}
+	return left, nil
+}
+
+func (p *cql2Parser) not() (interface{}, error) {
+	if p.keyword("NOT") {
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return op("not", node), nil
+	}
+	return p.predicate()
+}
+
+//nolint:gocyclo
+func (p *cql2Parser) predicate() (interface{}, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	left, err := p.scalar()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind == tokOp {
+		p.next()
+		right, err := p.scalar()
+		if err != nil {
+			return nil, err
+		}
+		return op(tok.value, left, right), nil
+	}
+
+	negate := p.keyword("NOT")
+	var node interface{}
+
+	switch {
+	case p.keyword("LIKE"):
+		pattern, err := p.scalar()
+		if err != nil {
+			return nil, err
+		}
+		node = op("like", left, pattern)
+	case p.keyword("IN"):
+		if _, err := p.expect(tokLParen, "("); err != nil {
+			return nil, err
+		}
+		list, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		node = op("in", left, list)
+	case p.keyword("BETWEEN"):
+		low, err := p.scalar()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, p.errorf(p.peek(), "expected AND")
+		}
+		high, err := p.scalar()
+		if err != nil {
+			return nil, err
+		}
+		node = op("between", left, low, high)
+	case !negate && p.keyword("IS"):
+		negate = p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, p.errorf(p.peek(), "expected NULL")
+		}
+		node = op("isNull", left)
+	case negate:
+		return nil, p.errorf(p.peek(), "expected LIKE, IN or BETWEEN")
+	default:
+		// functions and booleans are predicates on their own
+		if node, ok := left.(map[string]interface{}); ok && node["op"] != nil {
+			return left, nil
+		}
+		if _, ok := left.(bool); ok {
+			return left, nil
+		}
+		return nil, p.errorf(p.peek(), "expected a comparison")
+	}
+
+	if negate {
+		return op("not", node), nil
+	}
+	return node, nil
+}
+
+// list parses the scalars of a list up to the closing parenthesis.
+func (p *cql2Parser) list() ([]interface{}, error) {
+	values := []interface{}{}
+	if p.peek().kind == tokRParen {
+		p.next()
+		return values, nil
+	}
+	for {
+		value, err := p.scalar()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		tok := p.next()
+		switch tok.kind {
+		case tokComma:
+			continue
+		case tokRParen:
+			return values, nil
+		default:
+			return nil, p.errorf(tok, "expected , or ), got %q", tok.value)
+		}
+	}
+}
+
+var wktTypes = map[string]bool{
+	"POINT":              true,
+	"LINESTRING":         true,
+	"POLYGON":            true,
+	"MULTIPOINT":         true,
+	"MULTILINESTRING":    true,
+	"MULTIPOLYGON":       true,
+	"GEOMETRYCOLLECTION": true,
+}
+
+//nolint:gocyclo
+func (p *cql2Parser) scalar() (interface{}, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokString:
+		return tok.value, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.errorf(tok, "invalid number %q", tok.value)
+		}
+		return f, nil
+	case tokQuotedIdent:
+		return map[string]interface{}{"property": tok.value}, nil
+	case tokIdent:
+	default:
+		return nil, p.errorf(tok, "unexpected %q", tok.value)
+	}
+
+	name := strings.ToUpper(tok.value)
+	switch name {
+	case "TRUE", "FALSE":
+		return name == "TRUE", nil
+	}
+
+	if wktTypes[name] {
+		return p.wkt(tok)
+	}
+
+	if p.peek().kind != tokLParen {
+		return map[string]interface{}{"property": tok.value}, nil
+	}
+	p.next()
+	args, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "TIMESTAMP", "DATE":
+		if len(args) != 1 {
+			return nil, p.errorf(tok, "%s takes one argument", name)
+		}
+		return map[string]interface{}{strings.ToLower(name): args[0]}, nil
+	case "INTERVAL":
+		if len(args) != 2 {
+			return nil, p.errorf(tok, "INTERVAL takes two arguments")
+		}
+		return map[string]interface{}{"interval": args}, nil
+	case "BBOX":
+		return map[string]interface{}{"bbox": args}, nil
+	default:
+		return op(strings.ToLower(tok.value), args...), nil
+	}
+}
+
+// wkt returns the literal geometry starting at tok as WKT.
+func (p *cql2Parser) wkt(tok token) (interface{}, error) {
+	// dimension markers such as POINT Z are part of the literal
+	if next := p.peek(); next.kind == tokIdent && (strings.EqualFold(next.value, "Z") || strings.EqualFold(next.value, "M") || strings.EqualFold(next.value, "ZM")) {
+		p.next()
+	}
+
+	if _, err := p.expect(tokLParen, "("); err != nil {
+		return nil, err
+	}
+	for depth := 1; depth > 0; {
+		next := p.next()
+		switch next.kind {
+		case tokLParen:
+			depth++
+		case tokRParen:
+			depth--
+		case tokEOF:
+			return nil, p.errorf(next, "unterminated geometry")
+		}
+	}
+
+	end := p.tokens[p.pos-1].end
+	return map[string]interface{}{"wkt": p.text[tok.pos:end]}, nil
+}
@@ -20,7 +20,8 @@ var (
 func InitDuckDB() {
 	dbOnce.Do(func() {
 		log.Println("Initializing global DuckDB connection")
-		connector, err := duckdb.NewConnector("duckdb", nil)
+		// the database is in memory, as catalogs are read from their files
+		connector, err := duckdb.NewConnector("", nil)
 		if err != nil {
 			dbErr = fmt.Errorf("duckdb connector error: %w", err)
 			return
@@ -29,6 +30,22 @@ func InitDuckDB() {
 	})
 }
 
+// InstallSpatial installs the DuckDB spatial extension that spatial filters
+// of catalog searches load. It is downloaded unless it is installed already,
+// so this is done at startup rather than on requests.
+func InstallSpatial(ctx context.Context) error {
+	conn, err := GetDuckDBConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "INSTALL spatial"); err != nil {
+		return fmt.Errorf("installing the spatial extension failed: %w", err)
+	}
+	return nil
+}
+
 func GetDuckDBConn(ctx context.Context) (*sql.Conn, error) {
 	if db == nil {
 		InitDuckDB()
@@ -0,0 +1,308 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+	"unicode/utf8"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const (
+	// DefaultSampleRows is the number of rows an inspection samples.
+	DefaultSampleRows = 10
+	// MaxSampleRows bounds the number of rows an inspection samples.
+	MaxSampleRows = 100
+)
+
+// ParquetInfo describes a Parquet file from its footer, without reading its
+// data beyond the sampled rows.
+type ParquetInfo struct {
+	NumRows       int64                    `json:"numRows"`
+	NumRowGroups  int64                    `json:"numRowGroups"`
+	CreatedBy     string                   `json:"createdBy,omitempty"`
+	FormatVersion int64                    `json:"formatVersion"`
+	Schema        []ParquetField           `json:"schema"`
+	RowGroups     []ParquetRowGroup        `json:"rowGroups"`
+	Columns       []ParquetColumn          `json:"columns"`
+	Geo           interface{}              `json:"geo,omitempty"`
+	Sample        []map[string]interface{} `json:"sample"`
+}
+
+// ParquetField is an element of the schema of a Parquet file.
+type ParquetField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type,omitempty"`
+	LogicalType string `json:"logicalType,omitempty"`
+	Repetition  string `json:"repetition,omitempty"`
+	NumChildren int64  `json:"numChildren,omitempty"`
+}
+
+// ParquetRowGroup is a row group of a Parquet file.
+type ParquetRowGroup struct {
+	ID             int64 `json:"id"`
+	NumRows        int64 `json:"numRows"`
+	Bytes          int64 `json:"bytes"`
+	CompressedSize int64 `json:"compressedSize"`
+}
+
+// ParquetColumn holds the statistics of a column across all row groups.
+type ParquetColumn struct {
+	Path             string  `json:"path"`
+	Type             string  `json:"type"`
+	Compression      string  `json:"compression"`
+	NullCount        *int64  `json:"nullCount,omitempty"`
+	Min              *string `json:"min,omitempty"`
+	Max              *string `json:"max,omitempty"`
+	CompressedSize   int64   `json:"compressedSize"`
+	UncompressedSize int64   `json:"uncompressedSize"`
+}
+
+// InspectParquet describes the Parquet file at location, a local path or a
+// URL DuckDB reads with ranged requests, along with its first sampleRows
+// rows.
+func InspectParquet(ctx context.Context, location string, sampleRows int) (*ParquetInfo, error) {
+	if sampleRows < 0 || sampleRows > MaxSampleRows {
+		return nil, fmt.Errorf("sample rows must be between 0 and %d: %w", MaxSampleRows, fbErrors.ErrInvalidRequestParams)
+	}
+
+	conn, err := GetDuckDBConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	info := &ParquetInfo{}
+	var createdBy sql.NullString
+	err = conn.QueryRowContext(ctx, `SELECT num_rows, num_row_groups, created_by, format_version FROM parquet_file_metadata(?)`, location).
+		Scan(&info.NumRows, &info.NumRowGroups, &createdBy, &info.FormatVersion)
+	if err != nil {
+		return nil, fmt.Errorf("reading parquet metadata failed: %w", err)
+	}
+	info.CreatedBy = createdBy.String
+
+	if info.Schema, err = parquetSchema(ctx, conn, location); err != nil {
+		return nil, err
+	}
+	if info.RowGroups, err = parquetRowGroups(ctx, conn, location); err != nil {
+		return nil, err
+	}
+	if info.Columns, err = parquetColumns(ctx, conn, location); err != nil {
+		return nil, err
+	}
+	if info.Geo, err = parquetGeo(ctx, conn, location); err != nil {
+		return nil, err
+	}
+	if info.Sample, err = parquetSample(ctx, conn, location, sampleRows); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func parquetSchema(ctx context.Context, conn *sql.Conn, location string) ([]ParquetField, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT name, type, logical_type, repetition_type, num_children FROM parquet_schema(?)`, location)
+	if err != nil {
+		return nil, fmt.Errorf("reading parquet schema failed: %w", err)
+	}
+	defer rows.Close()
+
+	fields := []ParquetField{}
+	for rows.Next() {
+		var (
+			field                        ParquetField
+			typ, logicalType, repetition sql.NullString
+			numChildren                  sql.NullInt64
+		)
+		if err := rows.Scan(&field.Name, &typ, &logicalType, &repetition, &numChildren); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		field.Type, field.LogicalType, field.Repetition = typ.String, logicalType.String, repetition.String
+		field.NumChildren = numChildren.Int64
+		fields = append(fields, field)
+	}
+	return fields, rows.Err()
+}
+
+func parquetRowGroups(ctx context.Context, conn *sql.Conn, location string) ([]ParquetRowGroup, error) {
+	rows, err := conn.QueryContext(ctx, `
+SELECT row_group_id, any_value(row_group_num_rows), any_value(row_group_bytes), sum(total_compressed_size)::BIGINT
+FROM parquet_metadata(?)
+GROUP BY row_group_id
+ORDER BY row_group_id`, location)
+	if err != nil {
+		return nil, fmt.Errorf("reading parquet row groups failed: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []ParquetRowGroup{}
+	for rows.Next() {
+		var group ParquetRowGroup
+		if err := rows.Scan(&group.ID, &group.NumRows, &group.Bytes, &group.CompressedSize); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// parquetColumns aggregates the statistics of the row groups per column.
+// Bounds of numeric columns are compared as numbers, all others as strings.
+func parquetColumns(ctx context.Context, conn *sql.Conn, location string) ([]ParquetColumn, error) {
+	rows, err := conn.QueryContext(ctx, `
+SELECT
+	path_in_schema,
+	any_value(type) AS t,
+	any_value(compression),
+	sum(stats_null_count)::BIGINT,
+	CASE
+		WHEN t IN ('INT32', 'INT64') THEN min(TRY_CAST(stats_min_value AS HUGEINT))::VARCHAR
+		WHEN t IN ('FLOAT', 'DOUBLE') THEN min(TRY_CAST(stats_min_value AS DOUBLE))::VARCHAR
+		ELSE min(stats_min_value)
+	END,
+	CASE
+		WHEN t IN ('INT32', 'INT64') THEN max(TRY_CAST(stats_max_value AS HUGEINT))::VARCHAR
+		WHEN t IN ('FLOAT', 'DOUBLE') THEN max(TRY_CAST(stats_max_value AS DOUBLE))::VARCHAR
+		ELSE max(stats_max_value)
+	END,
+	sum(total_compressed_size)::BIGINT,
+	sum(total_uncompressed_size)::BIGINT
+FROM parquet_metadata(?)
+GROUP BY column_id, path_in_schema
+ORDER BY column_id`, location)
+	if err != nil {
+		return nil, fmt.Errorf("reading parquet statistics failed: %w", err)
+	}
+	defer rows.Close()
+
+	columns := []ParquetColumn{}
+	for rows.Next() {
+		var (
+			column      ParquetColumn
+			compression sql.NullString
+			nullCount   sql.NullInt64
+			minValue    sql.NullString
+			maxValue    sql.NullString
+		)
+		err := rows.Scan(&column.Path, &column.Type, &compression, &nullCount, &minValue, &maxValue,
+			&column.CompressedSize, &column.UncompressedSize)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		column.Compression = compression.String
+		if nullCount.Valid {
+			column.NullCount = &nullCount.Int64
+		}
+		if minValue.Valid {
+			column.Min = &minValue.String
+		}
+		if maxValue.Valid {
+			column.Max = &maxValue.String
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// parquetGeo returns the GeoParquet metadata of the file, if any.
+func parquetGeo(ctx context.Context, conn *sql.Conn, location string) (interface{}, error) {
+	var value []byte
+	err := conn.QueryRowContext(ctx, `SELECT value FROM parquet_kv_metadata(?) WHERE key = 'geo'::BLOB`, location).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading parquet key value metadata failed: %w", err)
+	}
+
+	var geo interface{}
+	if err := json.Unmarshal(value, &geo); err != nil {
+		return string(value), nil
+	}
+	return geo, nil
+}
+
+func parquetSample(ctx context.Context, conn *sql.Conn, location string, n int) ([]map[string]interface{}, error) {
+	sample := []map[string]interface{}{}
+	if n == 0 {
+		return sample, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT * FROM read_parquet(?) LIMIT ?`, location, n)
+	if err != nil {
+		return nil, fmt.Errorf("reading parquet rows failed: %w", err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("reading columns failed: %w", err)
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			row[col] = jsonValue(values[i])
+		}
+		sample = append(sample, row)
+	}
+	return sample, rows.Err()
+}
+
+// jsonValue converts a value scanned from DuckDB into one encoding/json can
+// marshal. Binary values such as WKB geometries are base64 encoded.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *big.Int:
+		return v.String()
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = jsonValue(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = jsonValue(e)
+		}
+		return out
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Sprint(v)
+		}
+		return v
+	case float32:
+		return jsonValue(float64(v))
+	case nil, bool, string, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return v
+	default:
+		if m, ok := v.(json.Marshaler); ok {
+			return m
+		}
+		return fmt.Sprint(v)
+	}
+}
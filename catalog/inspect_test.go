@@ -0,0 +1,47 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+func TestInspectParquet(t *testing.T) {
+	path := writeFixture(t)
+
+	info, err := InspectParquet(context.Background(), path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.NumRows != 4 || info.NumRowGroups != 1 || len(info.RowGroups) != 1 || info.RowGroups[0].NumRows != 4 {
+		t.Errorf("unexpected metadata %+v", info)
+	}
+	if info.Geo != nil {
+		t.Errorf("unexpected geo metadata %v", info.Geo)
+	}
+	if len(info.Sample) != 2 || info.Sample[0]["id"] != "a" || info.Sample[0]["datetime"] != "2024-01-01T00:00:00Z" {
+		t.Errorf("unexpected sample %v", info.Sample)
+	}
+
+	columns := map[string]ParquetColumn{}
+	for _, column := range info.Columns {
+		columns[column.Path] = column
+	}
+	if id := columns["id"]; id.Min == nil || *id.Min != "a" || *id.Max != "d" || id.NullCount == nil || *id.NullCount != 0 {
+		t.Errorf("unexpected id statistics %+v", id)
+	}
+	if xmax := columns["bbox, xmax"]; xmax.Min == nil || *xmax.Min != "1.0" || *xmax.Max != "11.0" {
+		t.Errorf("unexpected xmax statistics %+v in %v", xmax, info.Columns)
+	}
+
+	if _, err := InspectParquet(context.Background(), path, MaxSampleRows+1); !errors.Is(err, fbErrors.ErrInvalidRequestParams) {
+		t.Errorf("expected invalid request params, got %v", err)
+	}
+	if _, err := InspectParquet(context.Background(), filepath.Join(t.TempDir(), "missing.parquet"), 0); err == nil {
+		t.Error("expected inspecting a missing file to fail")
+	}
+}
@@ -1,11 +1,14 @@
 package catalog
 
 import (
-	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"regexp"
 	"strings"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
 )
 
 func tryParseJSON(val interface{}) interface{} {
@@ -38,7 +41,7 @@ func isZeroBBox(bbox []float64) bool {
 	return len(bbox) == 4 && bbox[0] == 0 && bbox[1] == 0 && bbox[2] == 0 && bbox[3] == 0
 }
 
-func rewriteAssetHrefs(entry map[string]interface{}, baseURL, presignedURL string) {
+func rewriteAssetHrefs(entry map[string]interface{}, baseURL, presignedURL string, mounts []Mount) {
 	assetsRaw, ok := entry["assets"]
 	if !ok {
 		return
@@ -57,40 +60,77 @@ func rewriteAssetHrefs(entry map[string]interface{}, baseURL, presignedURL strin
 		if !ok || !strings.HasPrefix(href, baseURL) {
 			continue
 		}
-		relativePath := strings.TrimPrefix(href, baseURL)
+		relativePath := strings.TrimLeft(strings.TrimPrefix(href, baseURL), "/")
+		if mounts != nil {
+			if relativePath, ok = mountedPath(relativePath, mounts); !ok {
+				continue
+			}
+		}
 		newHref := strings.TrimRight(presignedURL, "/") + "/" + relativePath + "?presign&followRedirect"
 		asset["href"] = newHref
 	}
 }
 
-//nolint:gocyclo
-func QueryCatalogParquet(ctx context.Context, catalogPath, filterField, baseURL, requestPath, assetsURL string) (map[string]interface{}, error) {
-	query := fmt.Sprintf(`
-SELECT *
-FROM read_parquet('%s')
-WHERE COALESCE((CAST(assets AS JSON)->'$.%s'->>'href'), '') LIKE '%s%%%%'
-`, catalogPath, filterField, baseURL+requestPath)
+// mountedPath returns the path in a shared package of the path of an asset
+// below the base URL.
+func mountedPath(p string, mounts []Mount) (string, bool) {
+	for _, m := range mounts {
+		mountPath := strings.Trim(m.Path, "/")
+		if p == mountPath {
+			return m.Name, true
+		}
+		if rest, ok := strings.CutPrefix(p, mountPath+"/"); ok {
+			return m.Name + "/" + rest, true
+		}
+	}
+	return "", false
+}
 
-	log.Printf("Query: %s", query)
+// assetKeyPattern whitelists the asset keys usable as filter field. Asset keys
+// end up in a JSON path, which can not be passed as bound parameter piecewise.
+var assetKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
 
-	conn, err := GetDuckDBConn(ctx)
-	if err != nil {
-		return nil, err
+// assetHrefPath returns the JSON path of the href of the asset key.
+func assetHrefPath(key string) (string, error) {
+	if !assetKeyPattern.MatchString(key) {
+		return "", fmt.Errorf("invalid filter field %q: %w", key, fbErrors.ErrInvalidRequestParams)
 	}
-	defer conn.Close()
+	return `$."` + key + `".href`, nil
+}
 
-	rows, err := conn.QueryContext(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
-	}
-	defer rows.Close()
+// itemFields are the top-level fields of a STAC item. Any other column of a
+// catalog is a flattened item property, as in stac-geoparquet.
+var itemFields = map[string]bool{
+	"type":            true,
+	"stac_version":    true,
+	"stac_extensions": true,
+	"id":              true,
+	"geometry":        true,
+	"bbox":            true,
+	"properties":      true,
+	"links":           true,
+	"assets":          true,
+	"collection":      true,
+	"href":            true,
+	"repository":      true,
+}
 
+func scanItems(rows *sql.Rows, baseURL, assetsURL string, mounts []Mount) ([]map[string]interface{}, error) {
+	results := make([]map[string]interface{}, 0, 100)
+	err := eachItem(rows, baseURL, assetsURL, mounts, func(item map[string]interface{}) error {
+		results = append(results, item)
+		return nil
+	})
+	return results, err
+}
+
+// eachItem passes the rows as STAC items to fn, stopping at the first error.
+func eachItem(rows *sql.Rows, baseURL, assetsURL string, mounts []Mount, fn func(item map[string]interface{}) error) error {
 	cols, err := rows.Columns()
 	if err != nil {
-		return nil, fmt.Errorf("reading columns failed: %w", err)
+		return fmt.Errorf("reading columns failed: %w", err)
 	}
 
-	results := make([]map[string]interface{}, 0, 100)
 	for rows.Next() {
 		values := make([]interface{}, len(cols))
 		ptrs := make([]interface{}, len(cols))
@@ -98,7 +138,7 @@ WHERE COALESCE((CAST(assets AS JSON)->'$.%s'->>'href'), '') LIKE '%s%%%%'
 			ptrs[i] = &values[i]
 		}
 		if err := rows.Scan(ptrs...); err != nil {
-			return nil, fmt.Errorf("scan failed: %w", err)
+			return fmt.Errorf("scan failed: %w", err)
 		}
 
 		entry := make(map[string]interface{}, len(cols))
@@ -106,95 +146,111 @@ WHERE COALESCE((CAST(assets AS JSON)->'$.%s'->>'href'), '') LIKE '%s%%%%'
 			entry[col] = tryParseJSON(values[i])
 		}
 
-		if _, ok := entry["type"]; !ok {
-			entry["type"] = "Feature"
-		}
+		normalizeItem(entry, baseURL, assetsURL, mounts)
 
-		if bbox, ok := entry["bbox"].(map[string]interface{}); ok {
-			xmin, xminOk := bbox["xmin"].(float64)
-			ymin, yminOk := bbox["ymin"].(float64)
-			xmax, xmaxOk := bbox["xmax"].(float64)
-			ymax, ymaxOk := bbox["ymax"].(float64)
-			if xminOk && yminOk && xmaxOk && ymaxOk {
-				entry["bbox"] = []float64{xmin, ymin, xmax, ymax}
-			}
+		if err := fn(entry); err != nil {
+			return err
 		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return nil
+}
+
+// normalizeItem turns a catalog row into a STAC item.
+//
+//nolint:gocyclo
+func normalizeItem(entry map[string]interface{}, baseURL, assetsURL string, mounts []Mount) {
+	if _, ok := entry["type"]; !ok {
+		entry["type"] = "Feature"
+	}
 
-		if bbox, ok := entry["bbox"].([]float64); ok && isZeroBBox(bbox) {
-			delete(entry, "bbox")
+	if bbox, ok := entry["bbox"].(map[string]interface{}); ok {
+		xmin, xminOk := bbox["xmin"].(float64)
+		ymin, yminOk := bbox["ymin"].(float64)
+		xmax, xmaxOk := bbox["xmax"].(float64)
+		ymax, ymaxOk := bbox["ymax"].(float64)
+		if xminOk && yminOk && xmaxOk && ymaxOk {
+			entry["bbox"] = []float64{xmin, ymin, xmax, ymax}
 		}
+	}
 
-		if geomStr, ok := entry["geometry"].(string); ok && (len(geomStr) > 0 && geomStr[0] == '{') {
-			var geomObj map[string]interface{}
-			if err := json.Unmarshal([]byte(geomStr), &geomObj); err == nil {
-				entry["geometry"] = geomObj
-			}
+	if bbox, ok := entry["bbox"].([]float64); ok && isZeroBBox(bbox) {
+		delete(entry, "bbox")
+	}
+
+	if geomStr, ok := entry["geometry"].(string); ok && (len(geomStr) > 0 && geomStr[0] == '{') {
+		var geomObj map[string]interface{}
+		if err := json.Unmarshal([]byte(geomStr), &geomObj); err == nil {
+			entry["geometry"] = geomObj
 		}
+	}
 
-		geom, hasGeom := entry["geometry"].(map[string]interface{})
-		replaceGeom := false
-
-		if hasGeom {
-			coords, ok := geom["coordinates"].([]interface{})
-			if ok && len(coords) > 0 {
-				if poly, ok := coords[0].([]interface{}); ok {
-					allZero := true
-					for _, pt := range poly {
-						if pair, ok := pt.([]interface{}); ok && len(pair) == 2 {
-							if pair[0] != float64(0) || pair[1] != float64(0) {
-								allZero = false
-								break
-							}
+	geom, hasGeom := entry["geometry"].(map[string]interface{})
+	replaceGeom := false
+
+	if hasGeom {
+		coords, ok := geom["coordinates"].([]interface{})
+		if ok && len(coords) > 0 {
+			if poly, ok := coords[0].([]interface{}); ok {
+				allZero := true
+				for _, pt := range poly {
+					if pair, ok := pt.([]interface{}); ok && len(pair) == 2 {
+						if pair[0] != float64(0) || pair[1] != float64(0) {
+							allZero = false
+							break
 						}
 					}
-					replaceGeom = allZero
 				}
-			} else {
-				replaceGeom = true
+				replaceGeom = allZero
 			}
 		} else {
 			replaceGeom = true
 		}
+	} else {
+		replaceGeom = true
+	}
 
-		if replaceGeom {
-			if bbox, ok := entry["bbox"].([]float64); ok && len(bbox) == 4 {
-				entry["geometry"] = bboxToPolygon(bbox)
-			} else {
-				delete(entry, "geometry")
-			}
+	if replaceGeom {
+		if bbox, ok := entry["bbox"].([]float64); ok && len(bbox) == 4 {
+			entry["geometry"] = bboxToPolygon(bbox)
+		} else {
+			delete(entry, "geometry")
 		}
+	}
+
+	if _, ok := entry["properties"]; !ok {
+		entry["properties"] = map[string]interface{}{}
+	}
 
-		if _, ok := entry["properties"]; !ok {
-			entry["properties"] = map[string]interface{}{}
+	if repo, ok := entry["repository"]; ok {
+		if props, ok := entry["properties"].(map[string]interface{}); ok {
+			props["repository"] = repo
 		}
+		delete(entry, "repository")
+	}
 
-		if repo, ok := entry["repository"]; ok {
-			if props, ok := entry["properties"].(map[string]interface{}); ok {
-				props["repository"] = repo
+	if props, ok := entry["properties"].(map[string]interface{}); ok {
+		for key, value := range entry {
+			if !itemFields[key] {
+				props[key] = value
+				delete(entry, key)
+			}
+		}
+		for key, value := range props {
+			if t, ok := value.(time.Time); ok {
+				props[key] = t.UTC().Format(time.RFC3339)
 			}
-			delete(entry, "repository")
 		}
-
-		delete(entry, "links")
-
-		delete(entry, "href")
-
-		rewriteAssetHrefs(entry, baseURL, assetsURL)
-
-		results = append(results, entry)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
-	}
+	delete(entry, "links")
 
-	log.Printf("Total STAC features: %d", len(results))
+	delete(entry, "href")
+
+	rewriteAssetHrefs(entry, baseURL, assetsURL, mounts)
 
-	if len(results) == 1 {
-		return results[0], nil
-	}
-	return map[string]interface{}{
-		"type":     "FeatureCollection",
-		"features": results,
-	}, nil
 }
@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const hostileItems = `
+SELECT * FROM (VALUES
+	('quote', 's3://bucket/share/it''s/a.tif'),
+	('percent', 's3://bucket/share/100%/b.tif'),
+	('digits', 's3://bucket/share/1000/c.tif'),
+	('underscore', 's3://bucket/share/a_b/d.tif'),
+	('letter', 's3://bucket/share/axb/e.tif'),
+	('backslash', 's3://bucket/share/back\slash/f.tif'),
+	('other', 's3://bucket/other/g.tif')
+) t(id, href)`
+
+func hostileFixture(t *testing.T) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "it's a %_\\ catalog")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	items := `SELECT id, 'c' AS collection, 0 AS xmin, 0 AS ymin, 1 AS xmax, 1 AS ymax,
+	TIMESTAMPTZ '2024-01-01 00:00:00+00' AS datetime, href FROM (` + hostileItems + `)`
+	return writeFixtureAt(t, filepath.Join(dir, "catalog.parquet"), items)
+}
+
+func queryIDs(t *testing.T, catalogPath, filterField, requestPath string) ([]string, error) {
+	t.Helper()
+
+	result, err := SearchItems(context.Background(), Source{
+		Path:        catalogPath,
+		FilterField: filterField,
+		BaseURL:     "s3://bucket/share",
+		RequestPath: requestPath,
+		AssetsURL:   "http://localhost/api/public/share/h",
+	}, Search{Limit: MaxLimit})
+	if err != nil {
+		return nil, err
+	}
+
+	items := result.Items
+	got := ids(items)
+	sort.Strings(got)
+	return got, nil
+}
+
+func TestSearchItemsHostilePaths(t *testing.T) {
+	catalogPath := hostileFixture(t)
+
+	testCases := map[string]struct {
+		requestPath string
+		want        []string
+	}{
+		"quote":                     {requestPath: "/it's", want: []string{"quote"}},
+		"percent is no wildcard":    {requestPath: "/100%", want: []string{"percent"}},
+		"underscore is no wildcard": {requestPath: "/a_b", want: []string{"underscore"}},
+		"backslash":                 {requestPath: `/back\slash`, want: []string{"backslash"}},
+		"trailing backslash":        {requestPath: `/back\`, want: []string{"backslash"}},
+		"injected condition":        {requestPath: "/' OR 1=1 --", want: []string{}},
+		"injected wildcard":         {requestPath: "/%", want: []string{}},
+		"injected statement":        {requestPath: "/'); DROP TABLE x; --", want: []string{}},
+		"whole share":               {requestPath: "/", want: []string{"backslash", "digits", "letter", "percent", "quote", "underscore"}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := queryIDs(t, catalogPath, "visual", tc.requestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchItemsHostileFilterField(t *testing.T) {
+	catalogPath := hostileFixture(t)
+
+	for _, field := range []string{"", `visual".href`, "visual' OR 1=1 --", "$.visual", "visual[0]", "vis ual"} {
+		if _, err := queryIDs(t, catalogPath, field, "/"); !errors.Is(err, fbErrors.ErrInvalidRequestParams) {
+			t.Errorf("filter field %q: expected invalid request params, got %v", field, err)
+		}
+	}
+
+	for _, field := range []string{"visual", "cog:visual", "data-0", "eo_bands.1"} {
+		if _, err := queryIDs(t, catalogPath, field, "/"); err != nil {
+			t.Errorf("filter field %q: %v", field, err)
+		}
+	}
+}
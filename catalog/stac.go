@@ -0,0 +1,596 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const (
+	// DefaultLimit is the page size of item searches without a limit.
+	DefaultLimit = 10
+	// MaxLimit caps the page size of item searches.
+	MaxLimit = 1000
+)
+
+// Source is the part of a GeoParquet catalog visible through a share.
+type Source struct {
+	// Path of the GeoParquet file.
+	Path string
+	// FilterField is the asset whose href decides if an item is visible.
+	FilterField string
+	// BaseURL is the href prefix of the shared assets.
+	BaseURL string
+	// RequestPath is the path within the share, appended to BaseURL.
+	RequestPath string
+	// AssetsURL replaces BaseURL in asset hrefs.
+	AssetsURL string
+	// Collection is the id of the collection of items without one.
+	Collection string
+	// Mounts are set for shared packages, whose items are visible under
+	// their names rather than their paths below BaseURL.
+	Mounts []Mount
+}
+
+// Mount maps the path of the item of a package below BaseURL, e.g.
+// "raw/2023/a.tif", to its name in the share, e.g. "a.tif".
+type Mount struct {
+	Path string
+	Name string
+}
+
+// Search is a STAC item search. Zero values do not constrain the results.
+type Search struct {
+	IDs         []string  `json:"ids,omitempty"`
+	Collections []string  `json:"collections,omitempty"`
+	BBox        []float64 `json:"bbox,omitempty"`
+	Datetime    string    `json:"datetime,omitempty"`
+	Limit       int       `json:"limit,omitempty"`
+	Token       string    `json:"token,omitempty"`
+	// Filter is a CQL2 expression in FilterLang, CQL2-text if it is a string
+	// and CQL2-JSON otherwise by default.
+	Filter     interface{} `json:"filter,omitempty"`
+	FilterLang string      `json:"filter-lang,omitempty"`
+}
+
+// SearchResult is a page of items matching a Search.
+type SearchResult struct {
+	Items   []map[string]interface{}
+	Matched int64
+	// Next is the token of the next page, empty on the last page.
+	Next string
+}
+
+// CollectionInfo describes the items of a catalog sharing a collection.
+type CollectionInfo struct {
+	ID    string
+	Count int64
+	// BBox is the spatial extent as [xmin, ymin, xmax, ymax], nil if unknown.
+	BBox  []float64
+	Start *time.Time
+	End   *time.Time
+}
+
+// schema holds the SQL expressions of the STAC fields found in a catalog.
+type schema struct {
+	collection string
+	datetime   string
+	bbox       [4]string
+	// geometry needs the spatial extension
+	geometry      string
+	columns       map[string]string
+	hasProperties bool
+}
+
+func describe(ctx context.Context, conn *sql.Conn, path string) (*schema, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT column_name, column_type FROM (DESCRIBE SELECT * FROM read_parquet(?))`, path)
+	if err != nil {
+		return nil, fmt.Errorf("describe failed: %w", err)
+	}
+	defer rows.Close()
+
+	s := &schema{columns: map[string]string{}}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, fmt.Errorf("describe failed: %w", err)
+		}
+		s.columns[name] = typ
+		switch name {
+		case "collection":
+			s.collection = "collection"
+		case "datetime":
+			s.datetime = "TRY_CAST(datetime AS TIMESTAMPTZ)"
+		case "properties":
+			s.hasProperties = true
+		case "geometry":
+			switch typ {
+			case "BLOB":
+				s.geometry = "ST_GeomFromWKB(geometry)"
+			case "GEOMETRY":
+				s.geometry = "geometry"
+			case "VARCHAR", "JSON":
+				s.geometry = "ST_GeomFromGeoJSON(geometry)"
+			}
+		case "bbox":
+			switch {
+			case strings.HasPrefix(typ, "STRUCT"):
+				s.bbox = [4]string{"bbox.xmin", "bbox.ymin", "bbox.xmax", "bbox.ymax"}
+			case strings.HasSuffix(typ, "[]"):
+				s.bbox = [4]string{"bbox[1]", "bbox[2]", "bbox[3]", "bbox[4]"}
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("describe failed: %w", err)
+	}
+
+	if s.geometry == "" && s.bbox[0] != "" {
+		s.geometry = fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s)", s.bbox[0], s.bbox[1], s.bbox[2], s.bbox[3])
+	}
+	if s.datetime == "" && s.hasProperties {
+		s.datetime = "TRY_CAST(json_extract_string(CAST(properties AS JSON), '$.datetime') AS TIMESTAMPTZ)"
+	}
+
+	return s, nil
+}
+
+// where collects SQL conditions and their arguments.
+type where struct {
+	conds []string
+	args  []interface{}
+}
+
+func (w *where) add(cond string, args ...interface{}) {
+	w.conds = append(w.conds, cond)
+	w.args = append(w.args, args...)
+}
+
+func (w *where) in(expr string, exprArgs []interface{}, values []string) {
+	w.args = append(w.args, exprArgs...)
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = "?"
+		w.args = append(w.args, v)
+	}
+	w.conds = append(w.conds, expr+" IN ("+strings.Join(placeholders, ", ")+")")
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// scope limits a query to the items visible through src. The href prefix is
+// matched literally, so "%" and "_" in paths are no wildcards.
+func (src Source) scope() (*where, error) {
+	path, err := assetHrefPath(src.FilterField)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &where{}
+	if src.Mounts == nil {
+		w.add("starts_with(COALESCE(json_extract_string(CAST(assets AS JSON), ?), ''), ?)",
+			path, src.BaseURL+src.RequestPath)
+		return w, nil
+	}
+
+	// only the items below the requested mounts are visible
+	conds := []string{}
+	args := []interface{}{}
+	for _, prefix := range src.mountPrefixes() {
+		conds = append(conds, "starts_with(COALESCE(json_extract_string(CAST(assets AS JSON), ?), ''), ?)",
+			"COALESCE(json_extract_string(CAST(assets AS JSON), ?), '') = ?")
+		args = append(args, path, prefix+"/", path, prefix)
+	}
+	if len(conds) == 0 {
+		conds = append(conds, "false")
+	}
+	w.add("("+strings.Join(conds, " OR ")+")", args...)
+	return w, nil
+}
+
+// mountPrefixes returns the href prefixes of the mounts visible at the
+// requested path.
+func (src Source) mountPrefixes() []string {
+	base := strings.TrimSuffix(src.BaseURL, "/") + "/"
+	name, rest, _ := strings.Cut(strings.Trim(src.RequestPath, "/"), "/")
+
+	prefixes := []string{}
+	for _, m := range src.Mounts {
+		switch {
+		case name == "":
+			prefixes = append(prefixes, base+strings.Trim(m.Path, "/"))
+		case name == m.Name:
+			prefixes = append(prefixes, strings.TrimSuffix(base+strings.Trim(m.Path, "/")+"/"+rest, "/"))
+		}
+	}
+	return prefixes
+}
+
+func (s *schema) collectionExpr(fallback string) (string, []interface{}) {
+	if s.collection != "" {
+		return "COALESCE(" + s.collection + ", ?)", []interface{}{fallback}
+	}
+	return "?", []interface{}{fallback}
+}
+
+// ParseDatetime parses a RFC 3339 datetime or an interval of two datetimes
+// separated by "/", where ".." or an empty value leaves the interval open.
+func ParseDatetime(value string) (start, end *time.Time, err error) {
+	if value == "" {
+		return nil, nil, nil
+	}
+
+	parse := func(v string) (*time.Time, error) {
+		if v == "" || v == ".." {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid datetime %q: %w", v, fbErrors.ErrInvalidRequestParams)
+		}
+		return &t, nil
+	}
+
+	first, second, isInterval := strings.Cut(value, "/")
+	if start, err = parse(first); err != nil {
+		return nil, nil, err
+	}
+	if !isInterval {
+		if start == nil {
+			return nil, nil, fmt.Errorf("invalid datetime %q: %w", value, fbErrors.ErrInvalidRequestParams)
+		}
+		return start, start, nil
+	}
+	if end, err = parse(second); err != nil {
+		return nil, nil, err
+	}
+	if start == nil && end == nil {
+		return nil, nil, fmt.Errorf("invalid datetime %q: %w", value, fbErrors.ErrInvalidRequestParams)
+	}
+	if start != nil && end != nil && end.Before(*start) {
+		return nil, nil, fmt.Errorf("datetime interval %q ends before it starts: %w", value, fbErrors.ErrInvalidRequestParams)
+	}
+
+	return start, end, nil
+}
+
+// cursor is the position after the last item of a page.
+type cursor struct {
+	Collection string
+	ID         string
+}
+
+func (c cursor) token() string {
+	b, _ := json.Marshal([]string{c.Collection, c.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseToken(token string) (*cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var values []string
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(b, &values)
+	}
+	if err != nil || len(values) != 2 {
+		return nil, fmt.Errorf("invalid token %q: %w", token, fbErrors.ErrInvalidRequestParams)
+	}
+
+	return &cursor{Collection: values[0], ID: values[1]}, nil
+}
+
+func (q Search) validate() error {
+	if len(q.BBox) != 0 && len(q.BBox) != 4 {
+		return fmt.Errorf("bbox needs 4 values: %w", fbErrors.ErrInvalidRequestParams)
+	}
+	if len(q.BBox) == 4 && (q.BBox[1] > q.BBox[3]) {
+		return fmt.Errorf("bbox ymin above ymax: %w", fbErrors.ErrInvalidRequestParams)
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("negative limit: %w", fbErrors.ErrInvalidRequestParams)
+	}
+	return nil
+}
+
+// searchQuery is the SQL of an item search, ordered by collection and id.
+type searchQuery struct {
+	src   Source
+	where *where
+	// collection is the expression of the collection of an item and
+	// collectionArgs are its arguments
+	collection     string
+	collectionArgs []interface{}
+	// hasCollection is set if the catalog has a collection column
+	hasCollection bool
+}
+
+func newSearchQuery(ctx context.Context, conn *sql.Conn, src Source, q Search) (*searchQuery, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+
+	start, end, err := ParseDatetime(q.Datetime)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err = src.resolve(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := describe(ctx, conn, src.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := src.scope()
+	if err != nil {
+		return nil, err
+	}
+	collection, collectionArgs := s.collectionExpr(src.Collection)
+
+	if len(q.IDs) > 0 {
+		w.in("id", nil, q.IDs)
+	}
+	if len(q.Collections) > 0 {
+		w.in(collection, collectionArgs, q.Collections)
+	}
+	if len(q.BBox) == 4 {
+		if s.bbox[0] == "" {
+			return nil, fmt.Errorf("catalog has no bbox column: %w", fbErrors.ErrInvalidRequestParams)
+		}
+		xmin, ymin, xmax, ymax := q.BBox[0], q.BBox[1], q.BBox[2], q.BBox[3]
+		if xmin <= xmax {
+			w.add(s.bbox[0]+" <= ? AND "+s.bbox[2]+" >= ?", xmax, xmin)
+		} else {
+			// crosses the antimeridian
+			w.add("("+s.bbox[0]+" <= ? OR "+s.bbox[2]+" >= ?)", xmax, xmin)
+		}
+		w.add(s.bbox[1]+" <= ? AND "+s.bbox[3]+" >= ?", ymax, ymin)
+	}
+	if start != nil || end != nil {
+		if s.datetime == "" {
+			return nil, fmt.Errorf("catalog has no datetime column: %w", fbErrors.ErrInvalidRequestParams)
+		}
+		if start != nil {
+			w.add(s.datetime+" >= ?", *start)
+		}
+		if end != nil {
+			w.add(s.datetime+" <= ?", *end)
+		}
+	}
+
+	if q.Filter != nil {
+		node, err := parseFilter(q.Filter, q.FilterLang)
+		if err != nil {
+			return nil, err
+		}
+		t := &cql2Translator{schema: s, collection: collection, collectionArgs: collectionArgs}
+		cond, err := t.predicate(node)
+		if err != nil {
+			return nil, err
+		}
+		if t.spatial {
+			if err := loadSpatial(ctx, conn); err != nil {
+				return nil, err
+			}
+		}
+		w.add(cond, t.args...)
+	}
+
+	return &searchQuery{
+		src:            src,
+		where:          w,
+		collection:     collection,
+		collectionArgs: collectionArgs,
+		hasCollection:  s.collection != "",
+	}, nil
+}
+
+func (sq *searchQuery) count(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var matched int64
+	args := append([]interface{}{sq.src.Path}, sq.where.args...)
+	err := conn.QueryRowContext(ctx, `SELECT count(*) FROM read_parquet(?) `+sq.where.String(), args...).Scan(&matched)
+	if err != nil {
+		return 0, fmt.Errorf("count failed: %w", err)
+	}
+	return matched, nil
+}
+
+// items queries up to limit items after the cursor, all if limit is 0.
+func (sq *searchQuery) items(ctx context.Context, conn *sql.Conn, after *cursor, limit int) (*sql.Rows, error) {
+	expr, exprArgs := sq.collection, sq.collectionArgs
+
+	w := &where{conds: slices.Clone(sq.where.conds), args: slices.Clone(sq.where.args)}
+	if after != nil {
+		w.add("("+expr+", id) > (?, ?)", append(slices.Clone(exprArgs), after.Collection, after.ID)...)
+	}
+
+	selection := `SELECT *, ` + expr + ` AS collection`
+	if sq.hasCollection {
+		selection = `SELECT * REPLACE (` + expr + ` AS collection)`
+	}
+
+	query := selection + ` FROM read_parquet(?) ` + w.String() + ` ORDER BY collection, id`
+	args := append(append(slices.Clone(exprArgs), sq.src.Path), w.args...)
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return rows, nil
+}
+
+// SearchItems returns a page of the items of src matching q, ordered by
+// collection and id. Pages are addressed by keyset tokens, so deep pages of
+// large catalogs cost no more than the first one.
+func SearchItems(ctx context.Context, src Source, q Search) (*SearchResult, error) {
+	after, err := parseToken(q.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	conn, err := GetDuckDBConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	sq, err := newSearchQuery(ctx, conn, src, q)
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err := sq.count(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// one more item than requested tells whether there is a next page
+	rows, err := sq.items(ctx, conn, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items, err := scanItems(rows, src.BaseURL, src.AssetsURL, src.Mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Items: items, Matched: matched}
+	if len(items) > limit {
+		result.Items = items[:limit]
+		last := result.Items[limit-1]
+		result.Next = cursor{Collection: fmt.Sprint(last["collection"]), ID: fmt.Sprint(last["id"])}.token()
+	}
+
+	return result, nil
+}
+
+// StreamItems passes the items of src matching q to fn as they are read,
+// without holding them in memory. Unlike SearchItems, all items are passed
+// unless q has a limit.
+func StreamItems(ctx context.Context, src Source, q Search, fn func(item map[string]interface{}) error) error {
+	after, err := parseToken(q.Token)
+	if err != nil {
+		return err
+	}
+
+	conn, err := GetDuckDBConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sq, err := newSearchQuery(ctx, conn, src, q)
+	if err != nil {
+		return err
+	}
+
+	rows, err := sq.items(ctx, conn, after, q.Limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	return eachItem(rows, src.BaseURL, src.AssetsURL, src.Mounts, fn)
+}
+
+// Collections returns the collections of the items of src with their extents.
+func Collections(ctx context.Context, src Source) ([]CollectionInfo, error) {
+	conn, err := GetDuckDBConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	src, err = src.resolve(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := describe(ctx, conn, src.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := src.scope()
+	if err != nil {
+		return nil, err
+	}
+	collection, collectionArgs := s.collectionExpr(src.Collection)
+
+	bbox := "NULL, NULL, NULL, NULL"
+	if s.bbox[0] != "" {
+		bbox = fmt.Sprintf("min(%s)::DOUBLE, min(%s)::DOUBLE, max(%s)::DOUBLE, max(%s)::DOUBLE",
+			s.bbox[0], s.bbox[1], s.bbox[2], s.bbox[3])
+	}
+	datetime := "NULL::TIMESTAMPTZ, NULL::TIMESTAMPTZ"
+	if s.datetime != "" {
+		datetime = fmt.Sprintf("min(%s), max(%s)", s.datetime, s.datetime)
+	}
+
+	query := `SELECT ` + collection + ` AS c, count(*), ` + bbox + `, ` + datetime +
+		` FROM read_parquet(?) ` + w.String() + ` GROUP BY c ORDER BY c`
+	args := append(append(collectionArgs, src.Path), w.args...)
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	collections := []CollectionInfo{}
+	for rows.Next() {
+		var (
+			info                   CollectionInfo
+			xmin, ymin, xmax, ymax sql.NullFloat64
+			start, end             sql.NullTime
+		)
+		if err := rows.Scan(&info.ID, &info.Count, &xmin, &ymin, &xmax, &ymax, &start, &end); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		if xmin.Valid && ymin.Valid && xmax.Valid && ymax.Valid {
+			info.BBox = []float64{xmin.Float64, ymin.Float64, xmax.Float64, ymax.Float64}
+		}
+		if start.Valid {
+			info.Start = &start.Time
+		}
+		if end.Valid {
+			info.End = &end.Time
+		}
+		collections = append(collections, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return collections, nil
+}
@@ -0,0 +1,296 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const fixtureItems = `
+SELECT * FROM (VALUES
+	('a', 'c1', 0.0, 0.0, 1.0, 1.0, TIMESTAMPTZ '2024-01-01 00:00:00+00', 's3://bucket/share/a.tif'),
+	('b', 'c1', 10.0, 10.0, 11.0, 11.0, TIMESTAMPTZ '2024-06-01 00:00:00+00', 's3://bucket/share/sub/b.tif'),
+	('c', 'c2', 0.5, 0.5, 2.0, 2.0, TIMESTAMPTZ '2025-01-01 00:00:00+00', 's3://bucket/share/c.tif'),
+	('d', 'c1', 0.0, 0.0, 1.0, 1.0, TIMESTAMPTZ '2024-01-01 00:00:00+00', 's3://bucket/other/d.tif')
+) t(id, collection, xmin, ymin, xmax, ymax, datetime, href)`
+
+// writeFixture writes a stac-geoparquet like catalog of fixtureItems and
+// returns its path.
+func writeFixture(t *testing.T) string {
+	t.Helper()
+	return writeFixtureAt(t, filepath.Join(t.TempDir(), "catalog.parquet"), fixtureItems)
+}
+
+// writeFixtureAt writes a catalog of items, a query of id, collection, bbox
+// bounds, datetime and visual asset href, to path.
+func writeFixtureAt(t *testing.T, path, items string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	conn, err := GetDuckDBConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `COPY (
+SELECT
+	id,
+	collection,
+	{'xmin': xmin::DOUBLE, 'ymin': ymin::DOUBLE, 'xmax': xmax::DOUBLE, 'ymax': ymax::DOUBLE} AS bbox,
+	datetime,
+	json_object('visual', json_object('href', href))::VARCHAR AS assets
+FROM (`+items+`)
+) TO '`+strings.ReplaceAll(path, "'", "''")+`' (FORMAT parquet)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func ids(items []map[string]interface{}) []string {
+	result := []string{}
+	for _, item := range items {
+		result = append(result, item["id"].(string))
+	}
+	return result
+}
+
+func TestSearchItems(t *testing.T) {
+	src := Source{
+		Path:        writeFixture(t),
+		FilterField: "visual",
+		BaseURL:     "s3://bucket/share",
+		RequestPath: "/",
+		AssetsURL:   "http://localhost/api/public/share/h",
+		Collection:  "h",
+	}
+
+	testCases := map[string]struct {
+		search  Search
+		want    []string
+		matched int64
+	}{
+		"all items of the share":      {want: []string{"a", "b", "c"}, matched: 3},
+		"by ids":                      {search: Search{IDs: []string{"c", "d"}}, want: []string{"c"}, matched: 1},
+		"by collection":               {search: Search{Collections: []string{"c2"}}, want: []string{"c"}, matched: 1},
+		"by bbox":                     {search: Search{BBox: []float64{0.9, 0.9, 5, 5}}, want: []string{"a", "c"}, matched: 2},
+		"by bbox across antimeridian": {search: Search{BBox: []float64{170, -90, 10.5, 90}}, want: []string{"a", "b", "c"}, matched: 3},
+		"by datetime":                 {search: Search{Datetime: "2024-06-01T00:00:00Z"}, want: []string{"b"}, matched: 1},
+		"by open interval":            {search: Search{Datetime: "2024-03-01T00:00:00Z/.."}, want: []string{"b", "c"}, matched: 2},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, err := SearchItems(context.Background(), src, tc.search)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(result.Items); !slices.Equal(got, tc.want) {
+				t.Errorf("got items %v, want %v", got, tc.want)
+			}
+			if result.Matched != tc.matched || result.Next != "" {
+				t.Errorf("got matched %d next %q, want %d", result.Matched, result.Next, tc.matched)
+			}
+		})
+	}
+
+	result, err := SearchItems(context.Background(), src, Search{IDs: []string{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := result.Items[0]
+	if item["collection"] != "c1" {
+		t.Errorf("unexpected collection %v", item["collection"])
+	}
+	if props := item["properties"].(map[string]interface{}); props["datetime"] != "2024-06-01T00:00:00Z" {
+		t.Errorf("unexpected properties %v", props)
+	}
+	href := item["assets"].(map[string]interface{})["visual"].(map[string]interface{})["href"]
+	if href != "http://localhost/api/public/share/h/sub/b.tif?presign&followRedirect" {
+		t.Errorf("unexpected asset href %v", href)
+	}
+
+	for _, search := range []Search{{BBox: []float64{1, 2, 3}}, {Token: "x"}, {Datetime: "yesterday"}, {Limit: -1}} {
+		if _, err := SearchItems(context.Background(), src, search); !errors.Is(err, fbErrors.ErrInvalidRequestParams) {
+			t.Errorf("search %+v: expected invalid request params, got %v", search, err)
+		}
+	}
+}
+
+func TestSearchItemsMounts(t *testing.T) {
+	src := Source{
+		Path:        writeFixture(t),
+		FilterField: "visual",
+		BaseURL:     "s3://bucket",
+		AssetsURL:   "http://localhost/api/public/share/h",
+		Collection:  "h",
+		Mounts:      []Mount{{Path: "/share/sub", Name: "sub"}, {Path: "/other/d.tif", Name: "d.tif"}, {Path: "/share/c", Name: "c"}},
+	}
+
+	testCases := map[string]struct {
+		requestPath string
+		want        []string
+	}{
+		"all items of the package": {requestPath: "/", want: []string{"b", "d"}},
+		"an item":                  {requestPath: "/d.tif", want: []string{"d"}},
+		"below an item":            {requestPath: "/sub/b.tif", want: []string{"b"}},
+		"not an item":              {requestPath: "/a.tif", want: []string{}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			src := src
+			src.RequestPath = tc.requestPath
+			result, err := SearchItems(context.Background(), src, Search{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(result.Items); !slices.Equal(got, tc.want) {
+				t.Errorf("got items %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	src.RequestPath = "/"
+	result, err := SearchItems(context.Background(), src, Search{IDs: []string{"b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	href := result.Items[0]["assets"].(map[string]interface{})["visual"].(map[string]interface{})["href"]
+	if href != "http://localhost/api/public/share/h/sub/b.tif?presign&followRedirect" {
+		t.Errorf("unexpected asset href %v", href)
+	}
+}
+
+func TestSearchItemsPaging(t *testing.T) {
+	src := Source{
+		Path:        writeFixture(t),
+		FilterField: "visual",
+		BaseURL:     "s3://bucket/share",
+		RequestPath: "/",
+	}
+
+	pages := [][]string{}
+	search := Search{Limit: 2}
+	for {
+		result, err := SearchItems(context.Background(), src, search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Matched != 3 {
+			t.Fatalf("unexpected matched %d", result.Matched)
+		}
+		pages = append(pages, ids(result.Items))
+		if result.Next == "" {
+			break
+		}
+		search.Token = result.Next
+	}
+
+	if len(pages) != 2 || !slices.Equal(pages[0], []string{"a", "b"}) || !slices.Equal(pages[1], []string{"c"}) {
+		t.Errorf("unexpected pages %v", pages)
+	}
+}
+
+func TestStreamItems(t *testing.T) {
+	src := Source{
+		Path:        writeFixture(t),
+		FilterField: "visual",
+		BaseURL:     "s3://bucket/share",
+		RequestPath: "/",
+	}
+
+	streamed := []map[string]interface{}{}
+	err := StreamItems(context.Background(), src, Search{}, func(item map[string]interface{}) error {
+		streamed = append(streamed, item)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(streamed); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("got items %v", got)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = StreamItems(context.Background(), src, Search{}, func(map[string]interface{}) error {
+		count++
+		return stop
+	})
+	if !errors.Is(err, stop) || count != 1 {
+		t.Errorf("expected streaming to stop at the first error, got %v after %d items", err, count)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	src := Source{
+		Path:        writeFixture(t),
+		FilterField: "visual",
+		BaseURL:     "s3://bucket/share",
+		RequestPath: "/",
+		Collection:  "h",
+	}
+
+	collections, err := Collections(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collections) != 2 {
+		t.Fatalf("unexpected collections %+v", collections)
+	}
+
+	c1 := collections[0]
+	if c1.ID != "c1" || c1.Count != 2 || !slices.Equal(c1.BBox, []float64{0, 0, 11, 11}) {
+		t.Errorf("unexpected collection %+v", c1)
+	}
+	if !c1.Start.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !c1.End.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected interval %v %v", c1.Start, c1.End)
+	}
+}
+
+func TestParseDatetime(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		value      string
+		start, end *time.Time
+		wantErr    bool
+	}{
+		"empty":            {},
+		"instant":          {value: "2024-01-01T00:00:00Z", start: &day, end: &day},
+		"open start":       {value: "../2024-01-01T00:00:00Z", end: &day},
+		"open end":         {value: "2024-01-01T00:00:00Z/", start: &day},
+		"fully open":       {value: "../..", wantErr: true},
+		"reversed":         {value: "2024-01-02T00:00:00Z/2024-01-01T00:00:00Z", wantErr: true},
+		"invalid datetime": {value: "2024-01-01", wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			start, end, err := ParseDatetime(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			equal := func(a, b *time.Time) bool {
+				return (a == nil && b == nil) || (a != nil && b != nil && a.Equal(*b))
+			}
+			if !equal(start, tc.start) || !equal(end, tc.end) {
+				t.Errorf("got %v %v, want %v %v", start, end, tc.start, tc.end)
+			}
+		})
+	}
+}
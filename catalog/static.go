@@ -0,0 +1,323 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StaticCatalogTTL is how long a static STAC catalog is served from its
+	// converted copy before it is read again.
+	StaticCatalogTTL = 5 * time.Minute
+
+	// maxStaticDocuments bounds the JSON documents read from a static catalog.
+	maxStaticDocuments = 100000
+	// maxStaticCatalogs bounds the converted copies kept at once.
+	maxStaticCatalogs = 64
+)
+
+// staticCatalog is the converted copy of a static catalog. Its mutex is held
+// while the catalog is read, so that readers of other catalogs don't wait.
+type staticCatalog struct {
+	mu     sync.Mutex
+	path   string
+	loaded time.Time
+	used   time.Time
+}
+
+var (
+	// staticMu guards staticCatalogs, not the catalogs themselves.
+	staticMu       sync.Mutex
+	staticCatalogs = map[string]*staticCatalog{}
+)
+
+// isStaticCatalog reports whether the catalog at location is a static STAC
+// tree, i.e. its root catalog.json, collection.json or an item collection,
+// rather than a GeoParquet file.
+func isStaticCatalog(location string) bool {
+	if u, err := url.Parse(location); err == nil && u.Scheme != "" {
+		location = u.Path
+	}
+	return strings.EqualFold(path.Ext(location), ".json")
+}
+
+// resolve returns src reading from a GeoParquet file. Static STAC catalogs
+// are converted to one on first use and whenever StaticCatalogTTL passed.
+func (src Source) resolve(ctx context.Context, conn *sql.Conn) (Source, error) {
+	if !isStaticCatalog(src.Path) {
+		return src, nil
+	}
+
+	staticMu.Lock()
+	evictStaticCatalogs(src.Path)
+	c, ok := staticCatalogs[src.Path]
+	if !ok {
+		c = &staticCatalog{}
+		staticCatalogs[src.Path] = c
+	}
+	c.used = time.Now()
+	staticMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.path != "" && time.Since(c.loaded) < StaticCatalogTTL {
+		src.Path = c.path
+		return src, nil
+	}
+
+	f, err := os.CreateTemp("", "static-catalog-*.parquet")
+	if err != nil {
+		return src, err
+	}
+	f.Close()
+
+	loaded := time.Now()
+	err = writeItems(ctx, f.Name(), func(add func(item *buildItem) error) error {
+		return walkStatic(ctx, conn, src.Path, add)
+	})
+	if err != nil {
+		os.Remove(f.Name())
+		return src, err
+	}
+
+	if c.path != "" {
+		os.Remove(c.path)
+	}
+	c.path, c.loaded = f.Name(), loaded
+
+	src.Path = f.Name()
+	return src, nil
+}
+
+// evictStaticCatalogs removes the copies of the catalogs other than keep that
+// weren't used within StaticCatalogTTL, and the least recently used ones
+// beyond maxStaticCatalogs. Copies being read are left alone. It is called
+// with staticMu held.
+func evictStaticCatalogs(keep string) {
+	evict := func(location string, c *staticCatalog) {
+		if !c.mu.TryLock() {
+			return
+		}
+		defer c.mu.Unlock()
+		if c.path != "" {
+			os.Remove(c.path)
+		}
+		delete(staticCatalogs, location)
+	}
+
+	var oldest string
+	for location, c := range staticCatalogs {
+		if location == keep {
+			continue
+		}
+		if time.Since(c.used) >= StaticCatalogTTL {
+			evict(location, c)
+			continue
+		}
+		if oldest == "" || c.used.Before(staticCatalogs[oldest].used) {
+			oldest = location
+		}
+	}
+	if len(staticCatalogs) >= maxStaticCatalogs && oldest != "" {
+		evict(oldest, staticCatalogs[oldest])
+	}
+}
+
+type stacDocument struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Collection string                 `json:"collection"`
+	Geometry   interface{}            `json:"geometry"`
+	BBox       []float64              `json:"bbox"`
+	Properties map[string]interface{} `json:"properties"`
+	Assets     map[string]interface{} `json:"assets"`
+	Extensions []string               `json:"stac_extensions"`
+	Links      []struct {
+		Rel  string `json:"rel"`
+		Href string `json:"href"`
+	} `json:"links"`
+	Features []json.RawMessage `json:"features"`
+}
+
+// walkStatic passes the items of the static catalog at root to add. The
+// tree is read level by level, each level with a single read_text so remote
+// catalogs don't cost a round trip per document. Only links to documents
+// below the directory of root are followed.
+//
+//nolint:gocyclo
+func walkStatic(ctx context.Context, conn *sql.Conn, root string, add func(item *buildItem) error) error {
+	// pending maps the documents of the next level to the collection their
+	// items belong to unless they name one
+	pending := map[string]string{root: ""}
+	seen := map[string]bool{root: true}
+
+	for len(pending) > 0 {
+		docs, err := readDocuments(ctx, conn, pending)
+		if err != nil {
+			return err
+		}
+
+		next := map[string]string{}
+		for location, doc := range docs {
+			collection := pending[location]
+
+			switch doc.Type {
+			case "Feature":
+				if err := add(staticItem(location, collection, doc)); err != nil {
+					return err
+				}
+				continue
+			case "FeatureCollection":
+				for _, raw := range doc.Features {
+					var feature stacDocument
+					if err := json.Unmarshal(raw, &feature); err != nil {
+						return fmt.Errorf("invalid item in %s: %w", location, err)
+					}
+					if err := add(staticItem(location, collection, &feature)); err != nil {
+						return err
+					}
+				}
+				continue
+			case "Collection":
+				collection = doc.ID
+			}
+
+			for _, link := range doc.Links {
+				if link.Rel != "child" && link.Rel != "item" {
+					continue
+				}
+				href := resolveHref(location, link.Href)
+				if seen[href] || !withinStatic(root, href) {
+					continue
+				}
+				if len(seen) >= maxStaticDocuments {
+					return fmt.Errorf("static catalog %s has more than %d documents", root, maxStaticDocuments)
+				}
+				seen[href] = true
+				next[href] = collection
+			}
+		}
+		pending = next
+	}
+
+	return nil
+}
+
+func readDocuments(ctx context.Context, conn *sql.Conn, locations map[string]string) (map[string]*stacDocument, error) {
+	list := make([]string, 0, len(locations))
+	for location := range locations {
+		list = append(list, location)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT filename, content FROM read_text(?)`, list)
+	if err != nil {
+		return nil, fmt.Errorf("reading static catalog failed: %w", err)
+	}
+	defer rows.Close()
+
+	docs := make(map[string]*stacDocument, len(list))
+	for rows.Next() {
+		var location, content string
+		if err := rows.Scan(&location, &content); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		var doc stacDocument
+		if err := json.Unmarshal([]byte(content), &doc); err != nil {
+			return nil, fmt.Errorf("invalid STAC document %s: %w", location, err)
+		}
+		docs[location] = &doc
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading static catalog failed: %w", err)
+	}
+
+	return docs, nil
+}
+
+// staticItem turns the item doc read from location into the columns of a
+// catalog, with asset hrefs made absolute.
+func staticItem(location, collection string, doc *stacDocument) *buildItem {
+	item := &buildItem{
+		ID:         doc.ID,
+		Collection: doc.Collection,
+		Geometry:   doc.Geometry,
+		BBox:       flatBBox(doc.BBox),
+		Properties: doc.Properties,
+		Assets:     doc.Assets,
+		Extensions: doc.Extensions,
+	}
+	if item.Collection == "" {
+		item.Collection = collection
+	}
+	if item.Properties == nil {
+		item.Properties = map[string]interface{}{}
+	}
+	if datetime, ok := item.Properties["datetime"].(string); ok {
+		item.Datetime = datetime
+		delete(item.Properties, "datetime")
+	}
+	if item.BBox == nil && item.Geometry != nil {
+		item.BBox = geometryBounds(item.Geometry)
+	}
+
+	for _, value := range item.Assets {
+		if asset, ok := value.(map[string]interface{}); ok {
+			if href, ok := asset["href"].(string); ok {
+				asset["href"] = resolveHref(location, href)
+			}
+		}
+	}
+
+	return item
+}
+
+// resolveHref resolves href relative to the document at base, which is a
+// URL or a local path.
+func resolveHref(base, href string) string {
+	ref, err := url.Parse(href)
+	if err != nil || ref.Scheme != "" {
+		return href
+	}
+
+	baseURL, err := url.Parse(base)
+	if err != nil || baseURL.Scheme == "" {
+		if strings.HasPrefix(href, "/") {
+			return path.Clean(href)
+		}
+		return path.Join(path.Dir(base), href)
+	}
+
+	return baseURL.ResolveReference(ref).String()
+}
+
+// withinStatic reports whether the document at href is below the directory
+// of the static catalog at root, on the same host if root is a URL.
+func withinStatic(root, href string) bool {
+	hrefURL, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+
+	rootURL, err := url.Parse(root)
+	if err != nil || rootURL.Scheme == "" {
+		return hrefURL.Scheme == "" && below(path.Dir(root), href)
+	}
+	return hrefURL.Scheme == rootURL.Scheme && hrefURL.Host == rootURL.Host &&
+		hrefURL.User.String() == rootURL.User.String() &&
+		below(path.Dir(rootURL.Path), hrefURL.Path)
+}
+
+// below reports whether p is inside the directory dir.
+func below(dir, p string) bool {
+	p = path.Clean(p)
+	return strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
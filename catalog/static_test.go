@@ -0,0 +1,162 @@
+package catalog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStaticCatalog(t *testing.T) {
+	dir := t.TempDir()
+	docs := map[string]string{
+		"catalog.json": `{"type": "Catalog", "id": "root", "links": [
+			{"rel": "self", "href": "./catalog.json"},
+			{"rel": "child", "href": "./c1/collection.json"},
+			{"rel": "item", "href": "./x.json"}
+		]}`,
+		"c1/collection.json": `{"type": "Collection", "id": "c1", "links": [
+			{"rel": "root", "href": "../catalog.json"},
+			{"rel": "item", "href": "a/a.json"},
+			{"rel": "item", "href": "b/b.json"}
+		]}`,
+		"c1/a/a.json": `{"type": "Feature", "id": "a", "bbox": [0, 0, 1, 1],
+			"properties": {"datetime": "2024-01-01T00:00:00Z", "platform": "p"},
+			"assets": {"visual": {"href": "./a.tif"}}}`,
+		"c1/b/b.json": `{"type": "Feature", "id": "b",
+			"geometry": {"type": "Point", "coordinates": [10, 10]},
+			"properties": {"datetime": "2024-06-01T00:00:00Z"},
+			"assets": {"visual": {"href": "s3://bucket/elsewhere/b.tif"}}}`,
+		"x.json": `{"type": "Feature", "id": "x", "collection": "c2",
+			"properties": {"datetime": "2025-01-01T00:00:00Z"},
+			"assets": {"visual": {"href": "/data/x.tif"}}}`,
+	}
+	for name, content := range docs {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := Source{
+		Path:        filepath.Join(dir, "catalog.json"),
+		FilterField: "visual",
+		BaseURL:     dir,
+		RequestPath: "/",
+		AssetsURL:   "http://localhost/api/public/share/h",
+	}
+
+	result, err := SearchItems(context.Background(), src, Search{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(result.Items); !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("got items %v", got)
+	}
+
+	a := result.Items[0]
+	if a["collection"] != "c1" {
+		t.Errorf("unexpected collection %v", a["collection"])
+	}
+	if props := a["properties"].(map[string]interface{}); props["datetime"] != "2024-01-01T00:00:00Z" || props["platform"] != "p" {
+		t.Errorf("unexpected properties %v", props)
+	}
+	href := a["assets"].(map[string]interface{})["visual"].(map[string]interface{})["href"]
+	if href != "http://localhost/api/public/share/h/c1/a/a.tif?presign&followRedirect" {
+		t.Errorf("unexpected asset href %v", href)
+	}
+
+	src.BaseURL = "/data"
+	collections, err := Collections(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collections) != 1 || collections[0].ID != "c2" {
+		t.Errorf("unexpected collections %+v", collections)
+	}
+
+	all := Source{Path: src.Path, FilterField: "visual", BaseURL: "s3://", RequestPath: "", Collection: "h"}
+	result, err = SearchItems(context.Background(), all, Search{Filter: "id = 'b'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Items) != 1 || !slices.Equal(result.Items[0]["bbox"].([]float64), []float64{10, 10, 10, 10}) {
+		t.Errorf("unexpected items %v", result.Items)
+	}
+}
+
+func TestResolveHref(t *testing.T) {
+	testCases := []struct{ base, href, want string }{
+		{"/data/c/catalog.json", "./item.json", "/data/c/item.json"},
+		{"/data/c/catalog.json", "../x/item.json", "/data/x/item.json"},
+		{"/data/c/catalog.json", "/abs/item.json", "/abs/item.json"},
+		{"s3://bucket/c/catalog.json", "./sub/item.json", "s3://bucket/c/sub/item.json"},
+		{"https://host/c/catalog.json", "https://other/item.json", "https://other/item.json"},
+	}
+
+	for _, tc := range testCases {
+		if got := resolveHref(tc.base, tc.href); got != tc.want {
+			t.Errorf("resolveHref(%q, %q) = %q, want %q", tc.base, tc.href, got, tc.want)
+		}
+	}
+}
+
+func TestStaticCatalogScope(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside.json")
+	docs := map[string]string{
+		"pkg/catalog.json": `{"type": "Catalog", "id": "root", "links": [
+			{"rel": "item", "href": "./in.json"},
+			{"rel": "item", "href": "../outside.json"},
+			{"rel": "item", "href": "` + filepath.ToSlash(outside) + `"}
+		]}`,
+		"pkg/in.json":  `{"type": "Feature", "id": "in", "properties": {}, "assets": {"data": {"href": "in.tif"}}}`,
+		"outside.json": `{"type": "Feature", "id": "outside", "properties": {}, "assets": {"data": {"href": "o.tif"}}}`,
+	}
+	for name, content := range docs {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := Source{Path: filepath.Join(dir, "pkg", "catalog.json"), FilterField: "data"}
+	result, err := SearchItems(context.Background(), src, Search{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(result.Items); !slices.Equal(got, []string{"in"}) {
+		t.Errorf("got items %v, want only those below the catalog", got)
+	}
+}
+
+func TestWithinStatic(t *testing.T) {
+	testCases := []struct {
+		root, href string
+		want       bool
+	}{
+		{"/data/c/catalog.json", "/data/c/sub/item.json", true},
+		{"/data/c/catalog.json", "/data/c/../x/item.json", false},
+		{"/data/c/catalog.json", "/data/cx/item.json", false},
+		{"/data/c/catalog.json", "https://host/data/c/item.json", false},
+		{"https://host/c/catalog.json", "https://host/c/sub/item.json", true},
+		{"https://host/c/catalog.json", "https://host/other/item.json", false},
+		{"https://host/c/catalog.json", "https://other/c/item.json", false},
+		{"https://host/c/catalog.json", "http://host/c/item.json", false},
+		{"https://host/c/catalog.json", "/c/item.json", false},
+		{"s3://bucket/catalog.json", "s3://bucket/a/item.json", true},
+	}
+
+	for _, tc := range testCases {
+		if got := withinStatic(tc.root, tc.href); got != tc.want {
+			t.Errorf("withinStatic(%q, %q) = %v, want %v", tc.root, tc.href, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+}
+
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Catalog management utility",
+	Long:  `Catalog management utility.`,
+	Args:  cobra.NoArgs,
+}
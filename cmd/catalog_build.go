@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/versioneer-tech/package-r/catalog"
+)
+
+func init() {
+	catalogCmd.AddCommand(catalogBuildCmd)
+
+	catalogBuildCmd.Flags().String("user", "", "id or username whose file system the path is in (defaults to the server root)")
+	catalogBuildCmd.Flags().StringP("output", "o", "", "path of the catalog (defaults to the catalog default name inside the path)")
+	catalogBuildCmd.Flags().String("collection", "", "collection of the items (defaults to the name of the path)")
+	catalogBuildCmd.Flags().String("asset-key", catalog.DefaultAssetKey, "asset pointing at the file of each item, to be used as the share filter field")
+	catalogBuildCmd.Flags().String("assets-base-url", "", "prefix of the asset hrefs, to be used as the share assets base URL")
+}
+
+var catalogBuildCmd = &cobra.Command{
+	Use:   "build <path>",
+	Short: "Build a stac-geoparquet catalog of a directory",
+	Long: `Build a stac-geoparquet catalog of a directory. Every file becomes
+an item derived from its metadata and, if present, a sidecar
+"<file>.json" holding a partial STAC item.`,
+	Args: cobra.ExactArgs(1),
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		flags := cmd.Flags()
+
+		set, err := d.store.Settings.Get()
+		checkErr(err)
+		server, err := d.store.Settings.GetServer()
+		checkErr(err)
+
+		var fs afero.Fs = afero.NewBasePathFs(afero.NewOsFs(), server.Root)
+		if user := mustGetString(flags, "user"); user != "" {
+			username, id := parseUsernameOrID(user)
+			if username != "" {
+				u, err := d.store.Users.Get(server.Root, username)
+				checkErr(err)
+				fs = u.Fs
+			} else {
+				u, err := d.store.Users.Get(server.Root, id)
+				checkErr(err)
+				fs = u.Fs
+			}
+		}
+
+		output := mustGetString(flags, "output")
+		if output == "" && set.Catalog.DefaultName != "" {
+			output = path.Join("/", args[0], set.Catalog.DefaultName)
+		}
+
+		count, err := catalog.Build(cmd.Context(), fs, args[0], catalog.BuildOptions{
+			Output:        output,
+			Collection:    mustGetString(flags, "collection"),
+			AssetKey:      mustGetString(flags, "asset-key"),
+			AssetsBaseURL: mustGetString(flags, "assets-base-url"),
+		})
+		checkErr(err)
+		fmt.Printf("Catalogued %d items\n", count)
+	}, pythonConfig{}),
+}
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	nerrors "errors"
 	"fmt"
@@ -11,6 +13,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
+	"github.com/versioneer-tech/package-r/attest"
 	"github.com/versioneer-tech/package-r/auth"
 	"github.com/versioneer-tech/package-r/errors"
 	"github.com/versioneer-tech/package-r/settings"
@@ -54,6 +57,23 @@ func addConfigFlags(flags *pflag.FlagSet) {
 	flags.String("catalog.baseurl", "", "(optional) base url of catalog")
 	flags.String("catalog.defaultName", "", "(optional) default catalog name")
 	flags.String("catalog.previewURL", "", "(optional) preview URL")
+	flags.String("presign.expiry", "", "(optional) lifetime of presigned URLs (e.g. 12h, defaults to 168h)")
+	flags.Bool("analytics.disabled", false, "disable recording accesses of shares")
+	flags.String("analytics.retention", "", "(optional) lifetime of single share access events before they are aggregated per day (defaults to 168h)")
+	flags.String("signing.key", "", "(optional) path to a PEM encoded Ed25519 private key signing the manifests of shares")
+}
+
+// readSigningKey reads the seed of the PEM encoded Ed25519 private key at
+// name, or returns nil if name is empty.
+func readSigningKey(name string) []byte {
+	if name == "" {
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	checkErr(err)
+	key, err := attest.ParsePrivateKey(data)
+	checkErr(err)
+	return key.Seed()
 }
 
 func getAuthentication(flags *pflag.FlagSet, defaults ...interface{}) (settings.AuthMethod, auth.Auther) {
@@ -160,6 +180,19 @@ func printSettings(ser *settings.Server, set *settings.Settings, auther auth.Aut
 	fmt.Fprintf(w, "\tDisable used disk percentage graph:\t%t\n", set.Branding.DisableUsedPercentage)
 	fmt.Fprintf(w, "\tColor:\t%s\n", set.Branding.Color)
 	fmt.Fprintf(w, "\tTheme:\t%s\n", set.Branding.Theme)
+	fmt.Fprintln(w, "\nPresign:")
+	fmt.Fprintf(w, "\tExpiry:\t%s\n", set.Presign.Expiry)
+	fmt.Fprintln(w, "\nAnalytics:")
+	fmt.Fprintf(w, "\tDisabled:\t%t\n", set.Analytics.Disabled)
+	fmt.Fprintf(w, "\tRetention:\t%s\n", set.Analytics.Retention)
+	fmt.Fprintln(w, "\nSigning:")
+	if key, err := set.Signing.PrivateKey(); err != nil {
+		fmt.Fprintf(w, "\tPublic key:\t%s\n", err)
+	} else if key != nil {
+		fmt.Fprintf(w, "\tPublic key:\t%s\n", base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	} else {
+		fmt.Fprintln(w, "\tPublic key:\t")
+	}
 	fmt.Fprintln(w, "\nServer:")
 	fmt.Fprintf(w, "\tLog:\t%s\n", ser.Log)
 	fmt.Fprintf(w, "\tPort:\t%s\n", ser.Port)
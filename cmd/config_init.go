@@ -42,6 +42,13 @@ override the options.`,
 				Theme:                 mustGetString(flags, "branding.theme"),
 				Files:                 mustGetString(flags, "branding.files"),
 			},
+			Analytics: settings.Analytics{
+				Disabled:  mustGetBool(flags, "analytics.disabled"),
+				Retention: mustGetString(flags, "analytics.retention"),
+			},
+			Signing: settings.Signing{
+				Key: readSigningKey(mustGetString(flags, "signing.key")),
+			},
 		}
 
 		ser := &settings.Server{
@@ -71,6 +71,14 @@ you want to change. Other options will remain unchanged.`,
 				set.Catalog.DefaultName = mustGetString(flags, flag.Name)
 			case "catalog.previewURL":
 				set.Catalog.PreviewURL = mustGetString(flags, flag.Name)
+			case "presign.expiry":
+				set.Presign.Expiry = mustGetString(flags, flag.Name)
+			case "analytics.disabled":
+				set.Analytics.Disabled = mustGetBool(flags, flag.Name)
+			case "analytics.retention":
+				set.Analytics.Retention = mustGetString(flags, flag.Name)
+			case "signing.key":
+				set.Signing.Key = readSigningKey(mustGetString(flags, flag.Name))
 			}
 		})
 
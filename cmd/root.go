@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
@@ -22,6 +23,7 @@ import (
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/versioneer-tech/package-r/auth"
+	"github.com/versioneer-tech/package-r/catalog"
 	"github.com/versioneer-tech/package-r/diskcache"
 	"github.com/versioneer-tech/package-r/frontend"
 	fbhttp "github.com/versioneer-tech/package-r/http"
@@ -138,6 +140,14 @@ user created with the credentials from options "username" and "password".`,
 		server := getRunParams(cmd.Flags(), d.store)
 		setupLog(server.Log)
 
+		// spatial filters of catalog searches need the extension, which is
+		// installed in the background so that startup doesn't wait for it
+		go func() {
+			if err := catalog.InstallSpatial(context.Background()); err != nil {
+				log.Printf("spatial catalog filters are unavailable: %v", err)
+			}
+		}()
+
 		root, err := filepath.Abs(server.Root)
 		checkErr(err)
 		server.Root = root
@@ -13,6 +13,9 @@ import (
 
 func init() {
 	sharesCmd.AddCommand(sharesAddCmd)
+
+	sharesAddCmd.Flags().Bool("snapshot", false, "freeze presigned URLs to the object versions current at creation")
+	sharesAddCmd.Flags().String("license", "", "SPDX identifier or URL of the license of the shared data")
 }
 
 var sharesAddCmd = &cobra.Command{
@@ -20,7 +23,7 @@ var sharesAddCmd = &cobra.Command{
 	Short: "Create a new default share",
 	Long:  `Create a new default share and add it to the database.`,
 	Args:  cobra.ExactArgs(3),
-	Run: python(func(_ *cobra.Command, args []string, d pythonData) {
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
 		username, id := parseUsernameOrID(args[0])
 
 		var (
@@ -61,6 +64,8 @@ var sharesAddCmd = &cobra.Command{
 		link, err := share.NewLink(share.CreateBody{
 			Hash:        args[1],
 			Description: "default share",
+			Snapshot:    mustGetBool(cmd.Flags(), "snapshot"),
+			License:     mustGetString(cmd.Flags(), "license"),
 		}, share.LinkOptions{
 			Path:   args[2],
 			UserID: owner.ID,
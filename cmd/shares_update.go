@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/versioneer-tech/package-r/share"
+)
+
+func init() {
+	sharesCmd.AddCommand(sharesUpdateCmd)
+
+	flags := sharesUpdateCmd.Flags()
+	flags.String("description", "", "description of the share")
+	flags.String("license", "", "SPDX identifier or URL of the license of the shared data")
+	flags.String("password", "", "password of the share, removed if empty")
+	flags.String("expires", "", "expiry of the share, in units from now, permanent if empty")
+	flags.String("unit", "hours", "unit of the expiry (seconds, minutes, hours or days)")
+	flags.String("catalog-name", "", "name of the catalog of the share, relative to the catalog base url")
+	flags.String("filters-field", "", "asset field filtering the items of the catalog")
+	flags.String("assets-base-url", "", "prefix of the asset hrefs of the catalog")
+	flags.Bool("snapshot", false, "freeze presigned URLs to the object versions current now, or unfreeze them if false")
+}
+
+var sharesUpdateCmd = &cobra.Command{
+	Use:   "update <hash>",
+	Short: "Update a share",
+	Long: `Update a share. Only the options given are changed, so that
+e.g. --expires 7 --unit days extends a share by a week from now and
+--password "" removes its password. The change is recorded in the
+history of the share.`,
+	Args: cobra.ExactArgs(1),
+	Run: python(func(cmd *cobra.Command, args []string, d pythonData) {
+		flags := cmd.Flags()
+
+		link, err := d.store.Share.GetByHash(args[0])
+		checkErr(err)
+
+		set, err := d.store.Settings.Get()
+		checkErr(err)
+
+		body := share.UpdateBody{Unit: mustGetString(flags, "unit")}
+		for flag, field := range map[string]**string{
+			"description":     &body.Description,
+			"license":         &body.License,
+			"password":        &body.Password,
+			"expires":         &body.Expires,
+			"catalog-name":    &body.CatalogName,
+			"filters-field":   &body.FiltersField,
+			"assets-base-url": &body.AssetsBaseURL,
+		} {
+			if flags.Changed(flag) {
+				value := mustGetString(flags, flag)
+				*field = &value
+			}
+		}
+		if flags.Changed("snapshot") {
+			snapshot := mustGetBool(flags, "snapshot")
+			body.Snapshot = &snapshot
+		}
+
+		updated, err := link.Update(body, share.LinkOptions{CatalogBaseURL: set.Catalog.BaseURL}, 0)
+		checkErr(err)
+		checkErr(d.store.Share.Save(updated))
+		printShares([]*share.Link{updated})
+	}, pythonConfig{}),
+}
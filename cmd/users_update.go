@@ -48,6 +48,7 @@ options you want to change.`,
 			Perm:        user.Perm,
 			Sorting:     user.Sorting,
 			Commands:    user.Commands,
+			Envs:        user.Envs,
 		}
 		getUserDefaults(flags, &defaults, false)
 		user.Scope = defaults.Scope
@@ -57,6 +58,7 @@ options you want to change.`,
 		user.Perm = defaults.Perm
 		user.Commands = defaults.Commands
 		user.Sorting = defaults.Sorting
+		user.Envs = defaults.Envs
 		user.LockPassword = mustGetBool(flags, "lockPassword")
 
 		if newUsername != "" {
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/zipfs"
+	"github.com/spf13/cobra"
+
+	"github.com/versioneer-tech/package-r/attest"
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().String("manifest", "", "path of the manifest (defaults to "+attest.ManifestName+" in or next to the package)")
+	verifyCmd.Flags().String("signature", "", "path of the signature (defaults to "+attest.SignatureName+" in or next to the package)")
+	verifyCmd.Flags().String("public-key", "", "path of the trusted public key, obtained apart from the package")
+	_ = verifyCmd.MarkFlagRequired("public-key")
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <package>",
+	Short: "Verify a downloaded package against its signed manifest",
+	Long: `Verify a downloaded package, an extracted folder or a zip archive,
+against the signed manifest of its share. The signature of the manifest
+is checked with the trusted public key given with --public-key, as a key
+shipped with the package could be replaced along with it. Then the size
+and SHA-256 checksum of every file are checked, and files missing in the
+package or in the manifest fail the verification. No network access is
+needed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+
+		info, err := os.Stat(args[0])
+		checkErr(err)
+
+		var afs afero.Fs
+		dir := args[0]
+		if info.IsDir() {
+			afs = afero.NewBasePathFs(afero.NewOsFs(), args[0])
+		} else {
+			zr, err := zip.OpenReader(args[0])
+			checkErr(err)
+			defer zr.Close()
+			afs = zipfs.New(&zr.Reader)
+			dir = filepath.Dir(args[0])
+		}
+
+		read := func(flag, name string) []byte {
+			p := mustGetString(flags, flag)
+			if p == "" {
+				p = filepath.Join(dir, name)
+			}
+			data, err := os.ReadFile(p)
+			checkErr(err)
+			return data
+		}
+
+		manifest := read("manifest", attest.ManifestName)
+		pubPEM, err := os.ReadFile(mustGetString(flags, "public-key"))
+		checkErr(err)
+		pub, err := attest.ParsePublicKey(pubPEM)
+		checkErr(err)
+		checkErr(attest.CheckSignature(pub, manifest, read("signature", attest.SignatureName)))
+
+		m := &attest.Manifest{}
+		checkErr(json.Unmarshal(manifest, m))
+		if m.Version != attest.ManifestVersion {
+			checkErr(fmt.Errorf("unsupported manifest version %d", m.Version))
+		}
+
+		problems, err := attest.Verify(afs, "/", m)
+		checkErr(err)
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+			os.Exit(1)
+		}
+
+		source := m.Name
+		if m.Source != "" {
+			source += " from " + m.Source
+		}
+		fmt.Printf("Verified %d files of %s signed by %s\n", len(m.Files), source, base64.StdEncoding.EncodeToString(pub))
+	},
+}
@@ -54,6 +54,9 @@ type FileInfo struct {
 	Token        string            `json:"token,omitempty"`
 	currentDir   []os.FileInfo     `json:"-"`
 	Resolution   *ImageResolution  `json:"resolution,omitempty"`
+	Raster       *RasterInfo       `json:"raster,omitempty"`
+	Zarr         *ZarrInfo         `json:"zarr,omitempty"`
+	NetCDF       *NetCDFInfo       `json:"netcdf,omitempty"`
 	PresignedURL string            `json:"presignedURL,omitempty"`
 	PreviewURL   string            `json:"previewURL,omitempty"`
 }
@@ -90,13 +93,17 @@ func NewFileInfo(opts *FileOptions) (*FileInfo, error) {
 
 	if opts.Expand {
 		if file.IsDir {
+			if ZarrVersion(file.Fs, file.Path) != 0 {
+				file.readZarr(opts.Checker)
+				return file, nil
+			}
 			if err := file.readListing(opts.Checker, opts.ReadHeader); err != nil {
 				return nil, err
 			}
 			return file, nil
 		}
 
-		err = file.detectType(opts.Modify, opts.Content, true)
+		err = file.detectType(opts.Modify, opts.Content, true, true)
 		if err != nil {
 			return nil, err
 		}
@@ -254,8 +261,12 @@ func IsMimeText(mimetype string) bool {
 	}
 }
 
+// detectType sets the type of the file. The headers of rasters and netcdf
+// files are only parsed if readMetadata is set, which listings don't, as
+// they would have to read them for every item.
+//
 //nolint:goconst
-func (i *FileInfo) detectType(modify, saveContent, readHeader bool) error {
+func (i *FileInfo) detectType(modify, saveContent, readHeader, readMetadata bool) error {
 	if IsNamedPipe(i.Mode) {
 		i.Type = "blob"
 		return nil
@@ -286,6 +297,15 @@ func (i *FileInfo) detectType(modify, saveContent, readHeader bool) error {
 		return nil
 	case strings.HasSuffix(mimetype, "tiff"):
 		i.Type = "tiff"
+		if !readMetadata {
+			return nil
+		}
+		raster, err := ReadRaster(i.Fs, i.Path)
+		if err != nil {
+			log.Printf("Error reading raster header of %s: %v", i.Path, err)
+		} else {
+			i.Raster = raster
+		}
 		return nil
 	case strings.HasPrefix(mimetype, "image"):
 		i.Type = "image"
@@ -299,6 +319,18 @@ func (i *FileInfo) detectType(modify, saveContent, readHeader bool) error {
 	case strings.HasSuffix(mimetype, "pdf"):
 		i.Type = "pdf"
 		return nil
+	case strings.HasSuffix(mimetype, "netcdf") || strings.HasSuffix(mimetype, "hdf5"):
+		i.Type = "netcdf"
+		if !readMetadata {
+			return nil
+		}
+		netcdf, err := ReadNetCDF(i.Fs, i.Path)
+		if err != nil {
+			log.Printf("Error reading netcdf header of %s: %v", i.Path, err)
+		} else {
+			i.NetCDF = netcdf
+		}
+		return nil
 	case strings.HasSuffix(mimetype, "parquet"):
 		i.Type = "parquet"
 		return nil
@@ -492,13 +524,17 @@ func (i *FileInfo) readListing(checker rules.Checker, readHeader bool) error {
 
 		if file.IsDir {
 			listing.NumDirs++
+
+			if looksLikeZarrStore(file.Name) && ZarrVersion(file.Fs, file.Path) != 0 {
+				file.Type = "zarr"
+			}
 		} else {
 			listing.NumFiles++
 
 			if isInvalidLink {
 				file.Type = "invalid_link"
 			} else {
-				err := file.detectType(true, false, readHeader)
+				err := file.detectType(true, false, readHeader, false)
 				if err != nil {
 					return err
 				}
@@ -511,3 +547,42 @@ func (i *FileInfo) readListing(checker rules.Checker, readHeader bool) error {
 	i.Listing = listing
 	return nil
 }
+
+// readZarr presents the Zarr store at the path as a single dataset with a
+// summary of its arrays, listing only the metadata documents of its root
+// instead of the chunks.
+func (i *FileInfo) readZarr(checker rules.Checker) {
+	i.Type = "zarr"
+
+	zarr, err := ReadZarr(i.Fs, i.Path, checker)
+	if err != nil {
+		log.Printf("Error reading zarr metadata of %s: %v", i.Path, err)
+	} else {
+		i.Zarr = zarr
+	}
+
+	listing := &Listing{Items: []*FileInfo{}}
+	for _, name := range []string{zarrConsolidatedV2, zarrGroupV2, zarrArrayV2, zarrAttrsV2, zarrJSONV3} {
+		fPath := path.Join(i.Path, name)
+		if !checker.Check(fPath) {
+			continue
+		}
+		info, err := i.Fs.Stat(fPath)
+		if err != nil {
+			continue
+		}
+		listing.Items = append(listing.Items, &FileInfo{
+			Fs:        i.Fs,
+			Path:      fPath,
+			Name:      name,
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			Mode:      info.Mode(),
+			Extension: filepath.Ext(name),
+			Type:      "textImmutable",
+		})
+		listing.NumFiles++
+	}
+
+	i.Listing = listing
+}
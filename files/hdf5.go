@@ -0,0 +1,1470 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"path"
+	"sort"
+	"strings"
+)
+
+var hdf5Signature = []byte("\x89HDF\r\n\x1a\n")
+
+// Types of the object header messages read.
+const (
+	h5MsgDataspace     = 0x01
+	h5MsgLinkInfo      = 0x02
+	h5MsgDatatype      = 0x03
+	h5MsgLink          = 0x06
+	h5MsgLayout        = 0x08
+	h5MsgFilterLine    = 0x0b
+	h5MsgAttribute     = 0x0c
+	h5MsgContinuation  = 0x10
+	h5MsgSymbolTable   = 0x11
+	h5MsgAttributeInfo = 0x15
+)
+
+// h5HiddenAttributes are the attributes netCDF-4 uses to store its data
+// model in HDF5, which aren't attributes of the netCDF file.
+var h5HiddenAttributes = map[string]bool{
+	"CLASS":               true,
+	"NAME":                true,
+	"REFERENCE_LIST":      true,
+	"DIMENSION_LIST":      true,
+	"_Netcdf4Dimid":       true,
+	"_Netcdf4Coordinates": true,
+	"_NCProperties":       true,
+	"_nc3_strict":         true,
+	"_IsNetcdf4":          true,
+	"_SuperblockVersion":  true,
+}
+
+// h5PureDimension starts the NAME of dimension scales that are no variable.
+const h5PureDimension = "This is a netCDF dimension but not a netCDF variable"
+
+// h5Filters names the filters of the HDF5 filter pipeline.
+var h5Filters = map[uint16]string{
+	1:     "deflate",
+	2:     "shuffle",
+	3:     "fletcher32",
+	4:     "szip",
+	5:     "nbit",
+	6:     "scaleoffset",
+	307:   "bzip2",
+	32001: "blosc",
+	32004: "lz4",
+	32015: "zstd",
+}
+
+// Bounds of the structures read from HDF5 files, which could otherwise
+// refer back to themselves.
+const (
+	// h5MaxShared is the longest chain of shared messages followed.
+	h5MaxShared = 8
+	// h5MaxNodes is the most nodes read from a B-tree.
+	h5MaxNodes = 100000
+	// h5MaxDepth is the deepest version 2 B-tree read.
+	h5MaxDepth = 16
+)
+
+// h5Reader reads the objects of an HDF5 file, whose addresses are relative
+// to the base address of its superblock.
+type h5Reader struct {
+	r           *blockReader
+	base        uint64
+	sizeOffsets int
+	sizeLengths int
+	heaps       map[uint64][]byte
+	// sharing holds the object headers whose shared messages are being
+	// resolved.
+	sharing map[uint64]bool
+}
+
+// h5Buf decodes a little-endian structure, keeping the first error.
+type h5Buf struct {
+	h   *h5Reader
+	b   []byte
+	pos int
+	err error
+}
+
+func (c *h5Buf) bytes(n int) []byte {
+	if c.err == nil && (n < 0 || c.pos+n > len(c.b)) {
+		c.err = errors.New("truncated hdf5 structure")
+	}
+	if c.err != nil {
+		return make([]byte, max(0, min(n, 8)))
+	}
+	b := c.b[c.pos : c.pos+n]
+	c.pos += n
+	return b
+}
+
+func (c *h5Buf) skip(n int)  { c.bytes(n) }
+func (c *h5Buf) u8() uint8   { return c.bytes(1)[0] }
+func (c *h5Buf) u16() uint16 { return binary.LittleEndian.Uint16(c.bytes(2)) }
+func (c *h5Buf) u32() uint32 { return binary.LittleEndian.Uint32(c.bytes(4)) }
+
+// uint reads an unsigned integer of n bytes.
+func (c *h5Buf) uint(n int) uint64 {
+	var v uint64
+	for i, b := range c.bytes(n) {
+		if i < 8 {
+			v |= uint64(b) << (8 * i)
+		}
+	}
+	return v
+}
+
+func (c *h5Buf) addr() uint64   { return c.uint(c.h.sizeOffsets) }
+func (c *h5Buf) length() uint64 { return c.uint(c.h.sizeLengths) }
+
+func (h *h5Reader) buf(b []byte) *h5Buf {
+	return &h5Buf{h: h, b: b}
+}
+
+func (h *h5Reader) undefined(addr uint64) bool {
+	return addr == ^uint64(0)>>(64-8*h.sizeOffsets)
+}
+
+// read reads n bytes at the address addr.
+func (h *h5Reader) read(addr, n uint64) ([]byte, error) {
+	if h.undefined(addr) {
+		return nil, errors.New("undefined hdf5 address")
+	}
+	off := addr + h.base
+	if n > uint64(h.r.size) || off > uint64(h.r.size)-n {
+		return nil, fmt.Errorf("hdf5 address %d out of range", addr)
+	}
+	b := make([]byte, n)
+	if _, err := h.r.ReadAt(b, int64(off)); err != nil { //nolint:gosec
+		return nil, err
+	}
+	return b, nil
+}
+
+// signed reads n bytes at addr and checks that they start with signature.
+func (h *h5Reader) signed(addr, n uint64, signature string) (*h5Buf, error) {
+	b, err := h.read(addr, n)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[:len(signature)]) != signature {
+		return nil, fmt.Errorf("expected %s at hdf5 address %d", signature, addr)
+	}
+	c := h.buf(b)
+	c.skip(len(signature))
+	return c, nil
+}
+
+// readAvailable reads up to n bytes at addr, fewer at the end of the file.
+func (h *h5Reader) readAvailable(addr, n uint64) ([]byte, error) {
+	if addr+h.base < uint64(h.r.size) {
+		n = min(n, uint64(h.r.size)-addr-h.base)
+	}
+	return h.read(addr, n)
+}
+
+type h5Message struct {
+	typ  uint16
+	data []byte
+}
+
+// messages reads the messages of the object header at addr, following
+// continuation blocks.
+//
+//nolint:gocyclo
+func (h *h5Reader) messages(addr uint64) ([]h5Message, error) {
+	type block struct{ addr, size uint64 }
+	var (
+		msgs    []h5Message
+		blocks  []block
+		version int
+		flags   uint8
+	)
+
+	prefix, err := h.readAvailable(addr, 64)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(prefix, []byte("OHDR")) {
+		version = 2
+		c := h.buf(prefix)
+		c.skip(4)
+		if c.u8() != 2 {
+			return nil, errors.New("unknown hdf5 object header version")
+		}
+		flags = c.u8()
+		if flags&0x20 != 0 {
+			c.skip(16)
+		}
+		if flags&0x10 != 0 {
+			c.skip(4)
+		}
+		size := c.uint(1 << (flags & 3))
+		blocks = append(blocks, block{addr + uint64(c.pos), size}) //nolint:gosec
+		if c.err != nil {
+			return nil, c.err
+		}
+	} else {
+		version = 1
+		c := h.buf(prefix)
+		if c.u8() != 1 {
+			return nil, errors.New("unknown hdf5 object header version")
+		}
+		c.skip(7)
+		size := uint64(c.u32())
+		blocks = append(blocks, block{addr + 16, size})
+		if c.err != nil {
+			return nil, c.err
+		}
+	}
+
+	for i := 0; i < len(blocks); i++ {
+		if i > 1000 {
+			return nil, errors.New("too many hdf5 object header continuations")
+		}
+		b, err := h.read(blocks[i].addr, blocks[i].size)
+		if err != nil {
+			return nil, err
+		}
+		c := h.buf(b)
+		end := len(b)
+		if version == 2 {
+			if i > 0 {
+				if !bytes.HasPrefix(b, []byte("OCHK")) {
+					return nil, errors.New("expected hdf5 object header continuation")
+				}
+				c.skip(4)
+				end -= 4 // checksum
+			}
+		}
+
+		for c.err == nil {
+			var (
+				typ      uint16
+				size     int
+				msgFlags uint8
+			)
+			if version == 1 {
+				if end-c.pos < 8 {
+					break
+				}
+				typ, size, msgFlags = c.u16(), int(c.u16()), c.u8()
+				c.skip(3)
+			} else {
+				header := 4
+				if flags&0x04 != 0 {
+					header += 2
+				}
+				if end-c.pos < header {
+					break
+				}
+				typ, size, msgFlags = uint16(c.u8()), int(c.u16()), c.u8()
+				if flags&0x04 != 0 {
+					c.skip(2)
+				}
+			}
+			data := c.bytes(size)
+			if version == 1 {
+				c.skip((8 - size%8) % 8)
+			}
+			if c.err != nil {
+				return nil, c.err
+			}
+
+			if msgFlags&0x02 != 0 {
+				// shared messages refer to the header of a committed type
+				shared, err := h.shared(typ, data)
+				if err != nil {
+					return nil, err
+				}
+				data = shared
+			}
+
+			switch typ {
+			case h5MsgContinuation:
+				cc := h.buf(data)
+				blocks = append(blocks, block{cc.addr(), cc.length()})
+				if cc.err != nil {
+					return nil, cc.err
+				}
+			case 0:
+			default:
+				msgs = append(msgs, h5Message{typ, data})
+			}
+		}
+	}
+	return msgs, nil
+}
+
+// shared resolves a shared message of type typ stored in another object
+// header.
+func (h *h5Reader) shared(typ uint16, data []byte) ([]byte, error) {
+	c := h.buf(data)
+	version := c.u8()
+	kind := c.u8()
+	switch {
+	case version == 1:
+		c.skip(6)
+	case version == 3 && kind != 2:
+		return nil, errors.New("unsupported hdf5 shared message heap")
+	}
+	addr := c.addr()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if h.sharing[addr] {
+		return nil, errors.New("cyclic hdf5 shared message")
+	}
+	if len(h.sharing) >= h5MaxShared {
+		return nil, errors.New("too deeply shared hdf5 message")
+	}
+
+	h.sharing[addr] = true
+	msgs, err := h.messages(addr)
+	delete(h.sharing, addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		if msg.typ == typ {
+			return msg.data, nil
+		}
+	}
+	return nil, fmt.Errorf("shared hdf5 message %d not found", typ)
+}
+
+func findMessage(msgs []h5Message, typ uint16) []byte {
+	for _, msg := range msgs {
+		if msg.typ == typ {
+			return msg.data
+		}
+	}
+	return nil
+}
+
+// h5Type is a datatype of an HDF5 file.
+type h5Type struct {
+	class  uint8
+	size   int
+	order  binary.ByteOrder
+	kind   byte // i, u or f for numbers
+	name   string
+	dtype  string
+	vlen   bool
+	string bool
+	base   *h5Type
+}
+
+//nolint:gocyclo
+func (h *h5Reader) datatype(c *h5Buf) *h5Type {
+	classVersion := c.u8()
+	bitField := c.bytes(3)
+	t := &h5Type{class: classVersion & 0x0f, size: int(c.u32()), order: binary.LittleEndian}
+	if bitField[0]&1 != 0 {
+		t.order = binary.BigEndian
+	}
+	endian := "<"
+	if t.order == binary.BigEndian {
+		endian = ">"
+	}
+	if t.size == 1 {
+		endian = "|"
+	}
+
+	switch t.class {
+	case 0: // fixed-point
+		c.skip(4)
+		t.kind = 'u'
+		if bitField[0]&0x08 != 0 {
+			t.kind = 'i'
+		}
+		t.name = map[byte]map[int]string{
+			'i': {1: "byte", 2: "short", 4: "int", 8: "int64"},
+			'u': {1: "ubyte", 2: "ushort", 4: "uint", 8: "uint64"},
+		}[t.kind][t.size]
+		if t.name != "" {
+			t.dtype = fmt.Sprintf("%s%c%d", endian, t.kind, t.size)
+		}
+	case 1: // floating-point
+		c.skip(12)
+		t.kind = 'f'
+		t.name = map[int]string{4: "float", 8: "double"}[t.size]
+		if t.name != "" {
+			t.dtype = fmt.Sprintf("%sf%d", endian, t.size)
+		}
+	case 3:
+		t.string = true
+		t.name = "string"
+		if t.size == 1 {
+			t.name = "char"
+		}
+		t.dtype = fmt.Sprintf("|S%d", t.size)
+	case 9:
+		t.vlen = true
+		t.string = bitField[0]&0x0f == 1
+		t.base = h.datatype(c)
+		t.name = "vlen"
+		if t.string {
+			t.name = "string"
+		}
+	case 8: // enumeration, stored as its base type
+		t.base = h.datatype(c)
+		t.name = "enum"
+		t.kind = t.base.kind
+		t.dtype = t.base.dtype
+	default:
+		t.name = map[uint8]string{2: "time", 4: "bitfield", 5: "opaque", 6: "compound", 7: "reference", 10: "array"}[t.class]
+	}
+	return t
+}
+
+// dataspace returns the dimensions of a dataspace and whether the first
+// one is unlimited.
+func (h *h5Reader) dataspace(c *h5Buf) ([]int64, bool) {
+	version := c.u8()
+	rank := int(c.u8())
+	flags := c.u8()
+	if version == 1 {
+		c.skip(5)
+	} else if c.u8() == 2 { // null dataspace
+		return nil, false
+	}
+
+	dims := make([]int64, rank)
+	for i := range dims {
+		dims[i] = int64(c.length()) //nolint:gosec
+	}
+	unlimited := false
+	if flags&1 != 0 {
+		for i := 0; i < rank; i++ {
+			if maxDim := c.length(); i == 0 && maxDim == ^uint64(0)>>(64-8*h.sizeLengths) {
+				unlimited = true
+			}
+		}
+	}
+	return dims, unlimited
+}
+
+// h5Attribute is an attribute, with the addresses of DIMENSION_LIST kept
+// apart.
+type h5Attribute struct {
+	name  string
+	value interface{}
+	refs  [][]uint64
+}
+
+func (h *h5Reader) attribute(data []byte) (*h5Attribute, error) {
+	c := h.buf(data)
+	version := c.u8()
+	flags := c.u8()
+	nameSize, typeSize, spaceSize := int(c.u16()), int(c.u16()), int(c.u16())
+	if version == 3 {
+		c.skip(1) // encoding
+	}
+	pad := func(n int) int {
+		if version == 1 {
+			return (n + 7) &^ 7
+		}
+		return n
+	}
+
+	nameBytes := c.bytes(pad(nameSize))
+	name := strings.TrimRight(string(nameBytes[:min(nameSize, len(nameBytes))]), "\x00")
+	typeData := c.bytes(pad(typeSize))
+	dims, _ := h.dataspace(h.buf(c.bytes(pad(spaceSize))))
+	if c.err != nil {
+		return nil, c.err
+	}
+	if version > 1 && flags&0x01 != 0 {
+		shared, err := h.shared(h5MsgDatatype, typeData)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		typeData = shared
+	}
+	t := h.datatype(h.buf(typeData))
+	raw := c.b[c.pos:]
+
+	n := int(product(dims))
+	attr := &h5Attribute{name: name}
+	switch {
+	case t.kind != 0 && t.dtype != "":
+		attr.value = attributeValue(decodeNumbers(raw, t.order, t.kind, t.size, n))
+	case t.string && !t.vlen:
+		values := make([]interface{}, 0, n)
+		for i := 0; i < n && (i+1)*t.size <= len(raw); i++ {
+			values = append(values, strings.TrimRight(string(raw[i*t.size:(i+1)*t.size]), "\x00 "))
+		}
+		attr.value = attributeValue(values)
+	case t.vlen:
+		elem := 4 + h.sizeOffsets + 4
+		values := make([]interface{}, 0, n)
+		for i := 0; i < n && (i+1)*elem <= len(raw); i++ {
+			e := h.buf(raw[i*elem : (i+1)*elem])
+			count, collection, index := e.u32(), e.addr(), e.u32()
+			obj, err := h.globalHeapObject(collection, index)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %s: %w", name, err)
+			}
+			switch {
+			case t.string:
+				values = append(values, strings.TrimRight(string(obj), "\x00"))
+			case t.base.class == 7:
+				refs := make([]uint64, 0, count)
+				r := h.buf(obj)
+				for j := uint32(0); j < count && r.err == nil; j++ {
+					refs = append(refs, r.addr())
+				}
+				attr.refs = append(attr.refs, refs)
+			}
+		}
+		if t.string {
+			attr.value = attributeValue(values)
+		}
+	}
+	return attr, nil
+}
+
+// globalHeapObject returns the object index of the global heap collection.
+func (h *h5Reader) globalHeapObject(collection uint64, index uint32) ([]byte, error) {
+	heap, ok := h.heaps[collection]
+	if !ok {
+		c, err := h.signed(collection, 8+uint64(h.sizeLengths), "GCOL")
+		if err != nil {
+			return nil, err
+		}
+		c.skip(4)
+		size := c.length()
+		if c.err != nil {
+			return nil, c.err
+		}
+		if heap, err = h.read(collection, size); err != nil {
+			return nil, err
+		}
+		h.heaps[collection] = heap
+	}
+
+	c := h.buf(heap)
+	c.skip(8 + h.sizeLengths)
+	for c.err == nil {
+		id := c.u16()
+		c.skip(6)
+		size := int(c.length()) //nolint:gosec
+		if id == 0 {
+			break
+		}
+		data := c.bytes(size)
+		c.skip((8 - size%8) % 8)
+		if uint32(id) == index && c.err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("global heap object %d not found", index)
+}
+
+// btreeV1 visits the keys and children of the leaves of the version 1
+// B-tree at addr, with children keyed by keySize bytes.
+func (h *h5Reader) btreeV1(addr uint64, keySize int, visit func(key []byte, child uint64) error) error {
+	nodes := 0
+	var walk func(addr uint64, want int) error
+	walk = func(addr uint64, want int) error {
+		if nodes++; nodes > h5MaxNodes {
+			return errors.New("too many hdf5 b-tree nodes")
+		}
+		header := 8 + 2*h.sizeOffsets
+		c, err := h.signed(addr, uint64(header), "TREE") //nolint:gosec
+		if err != nil {
+			return err
+		}
+		c.skip(1)
+		level := int(c.u8())
+		entries := int(c.u16())
+		// the level of a child is one below its parent's
+		if want >= 0 && level != want {
+			return errors.New("invalid hdf5 b-tree level")
+		}
+
+		b, err := h.read(addr+uint64(header), uint64(entries*(keySize+h.sizeOffsets)+keySize)) //nolint:gosec
+		if err != nil {
+			return err
+		}
+		c = h.buf(b)
+		for i := 0; i < entries; i++ {
+			key := c.bytes(keySize)
+			child := c.addr()
+			if c.err != nil {
+				return c.err
+			}
+			if level > 0 {
+				err = walk(child, level-1)
+			} else {
+				err = visit(key, child)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(addr, -1)
+}
+
+// btreeV2 visits the records of the version 2 B-tree at addr.
+func (h *h5Reader) btreeV2(addr uint64, visit func(record []byte) error) error {
+	c, err := h.signed(addr, uint64(16+h.sizeOffsets+2+h.sizeLengths+4), "BTHD") //nolint:gosec
+	if err != nil {
+		return err
+	}
+	c.skip(2)
+	nodeSize := int(c.u32())
+	recordSize := int(c.u16())
+	depth := int(c.u16())
+	c.skip(2)
+	root := c.addr()
+	rootRecords := int(c.u16())
+	if c.err != nil {
+		return c.err
+	}
+	if h.undefined(root) || rootRecords == 0 {
+		return nil
+	}
+	if recordSize == 0 || nodeSize <= 10 || depth > h5MaxDepth {
+		return errors.New("invalid hdf5 b-tree header")
+	}
+
+	// the widths of the record counts of child pointers depend on the most
+	// records the nodes of each depth can hold
+	limitSize := func(n int) int { return (bits.Len64(uint64(n))-1)/8 + 1 } //nolint:gosec
+	maxRecords := make([]int, depth+1)
+	cumRecords := make([]int, depth+1)
+	cumSizes := make([]int, depth+1)
+	maxRecords[0] = (nodeSize - 10) / recordSize
+	cumRecords[0] = maxRecords[0]
+	recordsSize := limitSize(maxRecords[0])
+	pointerSize := func(d int) int {
+		size := h.sizeOffsets + recordsSize
+		if d > 1 {
+			size += cumSizes[d-1]
+		}
+		return size
+	}
+	for d := 1; d <= depth; d++ {
+		maxRecords[d] = (nodeSize - (10 + pointerSize(d))) / (recordSize + pointerSize(d))
+		if maxRecords[d] <= 0 || maxRecords[d] > math.MaxInt32 || cumRecords[d-1] > math.MaxInt32 {
+			return errors.New("invalid hdf5 b-tree header")
+		}
+		cumRecords[d] = (maxRecords[d]+1)*cumRecords[d-1] + maxRecords[d]
+		cumSizes[d] = limitSize(cumRecords[d])
+	}
+
+	nodes := 0
+	var walk func(addr uint64, records, d int) error
+	walk = func(addr uint64, records, d int) error {
+		if nodes++; nodes > h5MaxNodes {
+			return errors.New("too many hdf5 b-tree nodes")
+		}
+		if records > maxRecords[d] {
+			return errors.New("too many hdf5 b-tree records")
+		}
+		signature := "BTLF"
+		if d > 0 {
+			signature = "BTIN"
+		}
+		c, err := h.signed(addr, uint64(nodeSize), signature) //nolint:gosec
+		if err != nil {
+			return err
+		}
+		c.skip(2)
+		recs := make([][]byte, records)
+		for i := range recs {
+			recs[i] = c.bytes(recordSize)
+		}
+		for _, rec := range recs {
+			if err := visit(rec); err != nil {
+				return err
+			}
+		}
+		if d == 0 {
+			return c.err
+		}
+		for i := 0; i <= records && c.err == nil; i++ {
+			child := c.addr()
+			n := int(c.uint(recordsSize)) //nolint:gosec
+			if d > 1 {
+				c.skip(cumSizes[d-1])
+			}
+			if c.err == nil {
+				if err := walk(child, n, d-1); err != nil {
+					return err
+				}
+			}
+		}
+		return c.err
+	}
+	return walk(root, rootRecords, depth)
+}
+
+// h5FractalHeap reads the objects of a fractal heap, as far as they are
+// managed or tiny.
+type h5FractalHeap struct {
+	h              *h5Reader
+	idLength       int
+	maxHeapBits    int
+	tableWidth     int
+	startBlockSize uint64
+	maxDirectSize  uint64
+	maxManaged     uint32
+	root           uint64
+	rootRows       int
+	checksummed    bool
+}
+
+func (h *h5Reader) fractalHeap(addr uint64) (*h5FractalHeap, error) {
+	c, err := h.signed(addr, uint64(22+12*h.sizeLengths+3*h.sizeOffsets), "FRHP") //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	heap := &h5FractalHeap{h: h}
+	c.skip(1)
+	heap.idLength = int(c.u16())
+	if c.u16() != 0 {
+		return nil, errors.New("filtered fractal heaps are not supported")
+	}
+	heap.checksummed = c.u8()&0x02 != 0
+	heap.maxManaged = c.u32()
+	c.skip(h.sizeLengths + h.sizeOffsets + h.sizeLengths + h.sizeOffsets + 8*h.sizeLengths)
+	heap.tableWidth = int(c.u16())
+	heap.startBlockSize = c.length()
+	heap.maxDirectSize = c.length()
+	heap.maxHeapBits = int(c.u16())
+	c.skip(2)
+	heap.root = c.addr()
+	heap.rootRows = int(c.u16())
+	if c.err != nil {
+		return nil, c.err
+	}
+	if heap.tableWidth == 0 || heap.startBlockSize == 0 {
+		return nil, errors.New("invalid fractal heap")
+	}
+	return heap, nil
+}
+
+func (f *h5FractalHeap) blockSize(row int) uint64 {
+	if row == 0 {
+		return f.startBlockSize
+	}
+	return f.startBlockSize << (row - 1)
+}
+
+func (f *h5FractalHeap) maxDirectRows() int {
+	return bits.Len64(f.maxDirectSize) - bits.Len64(f.startBlockSize) + 2
+}
+
+// object returns the object of the heap ID id.
+func (f *h5FractalHeap) object(id []byte) ([]byte, error) {
+	switch id[0] >> 4 & 0x03 {
+	case 0:
+		offsetSize := (f.maxHeapBits + 7) / 8
+		lengthSize := min((bits.Len64(f.maxDirectSize-1)+7)/8, (bits.Len32(f.maxManaged)-1)/8+1)
+		c := f.h.buf(id[1:])
+		offset, length := c.uint(offsetSize), c.uint(lengthSize)
+		if c.err != nil {
+			return nil, c.err
+		}
+		return f.managed(offset, length)
+	case 2:
+		length := int(id[0]&0x0f) + 1
+		if length+1 > len(id) {
+			return nil, errors.New("invalid tiny heap object")
+		}
+		return id[1 : 1+length], nil
+	default:
+		return nil, errors.New("huge heap objects are not supported")
+	}
+}
+
+// managed reads length bytes at offset of the heap address space, locating
+// the direct block holding them from the root.
+func (f *h5FractalHeap) managed(offset, length uint64) ([]byte, error) {
+	if f.rootRows == 0 {
+		return f.h.read(f.root+offset, length)
+	}
+
+	block, rows, start := f.root, f.rootRows, uint64(0)
+	for depth := 0; depth < 64; depth++ {
+		direct := min(rows, f.maxDirectRows())
+		entry := 5 + f.h.sizeOffsets + (f.maxHeapBits+7)/8
+		entries := rows * f.tableWidth
+		c, err := f.h.signed(block, uint64(entry+entries*f.h.sizeOffsets), "FHIB") //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		c.skip(entry - 4)
+
+		found := false
+		for row := 0; row < rows && !found; row++ {
+			size := f.blockSize(row)
+			for col := 0; col < f.tableWidth; col++ {
+				child := c.addr()
+				if offset >= start+size {
+					start += size
+					continue
+				}
+				if row < direct {
+					return f.h.read(child+offset-start, length)
+				}
+				// an indirect block spans the rows that fit its size
+				block = child
+				rows = bits.Len64(size) - bits.Len64(f.startBlockSize*uint64(f.tableWidth)) + 1 //nolint:gosec
+				found = true
+				break
+			}
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		if !found {
+			return nil, fmt.Errorf("heap offset %d out of range", offset)
+		}
+	}
+	return nil, errors.New("fractal heap too deep")
+}
+
+// denseObjects returns the objects of a fractal heap indexed by a version 2
+// B-tree of links or attributes.
+func (h *h5Reader) denseObjects(heapAddr, btreeAddr uint64) ([][]byte, error) {
+	heap, err := h.fractalHeap(heapAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects [][]byte
+	err = h.btreeV2(btreeAddr, func(record []byte) error {
+		var id []byte
+		switch {
+		case len(record) == 11: // link names: hash, heap ID
+			id = record[4:]
+		case len(record) == 15: // link creation order: order, heap ID
+			id = record[8:]
+		case len(record) >= 13 && record[8]&0x01 == 0: // attributes: heap ID, flags, ...
+			id = record[:8]
+		default:
+			return nil
+		}
+		obj, err := heap.object(id)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, obj)
+		return nil
+	})
+	return objects, err
+}
+
+type h5Link struct {
+	name string
+	addr uint64
+}
+
+// links returns the hard links of the group with the messages msgs.
+func (h *h5Reader) links(msgs []h5Message) ([]h5Link, error) {
+	var links []h5Link
+
+	if data := findMessage(msgs, h5MsgSymbolTable); data != nil {
+		c := h.buf(data)
+		btree, heapAddr := c.addr(), c.addr()
+		if c.err != nil {
+			return nil, c.err
+		}
+		hc, err := h.signed(heapAddr, uint64(8+2*h.sizeLengths+h.sizeOffsets), "HEAP") //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		hc.skip(4)
+		size := hc.length()
+		hc.length()
+		names, err := h.read(hc.addr(), size)
+		if err != nil {
+			return nil, err
+		}
+
+		err = h.btreeV1(btree, h.sizeLengths, func(_ []byte, child uint64) error {
+			entrySize := 2*h.sizeOffsets + 24
+			sc, err := h.signed(child, 8, "SNOD")
+			if err != nil {
+				return err
+			}
+			sc.skip(2)
+			n := int(sc.u16())
+			b, err := h.read(child+8, uint64(n*entrySize)) //nolint:gosec
+			if err != nil {
+				return err
+			}
+			ec := h.buf(b)
+			for i := 0; i < n; i++ {
+				nameOffset, addr := ec.addr(), ec.addr()
+				ec.skip(24)
+				if nameOffset >= uint64(len(names)) {
+					return errors.New("invalid hdf5 link name")
+				}
+				name, _, _ := strings.Cut(string(names[nameOffset:]), "\x00")
+				links = append(links, h5Link{name, addr})
+			}
+			return ec.err
+		})
+		return links, err
+	}
+
+	objects := [][]byte{}
+	for _, msg := range msgs {
+		if msg.typ == h5MsgLink {
+			objects = append(objects, msg.data)
+		}
+	}
+	if data := findMessage(msgs, h5MsgLinkInfo); data != nil {
+		c := h.buf(data)
+		c.skip(1)
+		if c.u8()&0x01 != 0 {
+			c.skip(8)
+		}
+		heapAddr, btree := c.addr(), c.addr()
+		if c.err != nil {
+			return nil, c.err
+		}
+		if !h.undefined(heapAddr) {
+			dense, err := h.denseObjects(heapAddr, btree)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, dense...)
+		}
+	}
+
+	for _, obj := range objects {
+		c := h.buf(obj)
+		c.skip(1)
+		flags := c.u8()
+		linkType := uint8(0)
+		if flags&0x08 != 0 {
+			linkType = c.u8()
+		}
+		if flags&0x04 != 0 {
+			c.skip(8)
+		}
+		if flags&0x10 != 0 {
+			c.skip(1)
+		}
+		name := string(c.bytes(int(c.uint(1 << (flags & 3))))) //nolint:gosec
+		if linkType == 0 {
+			addr := c.addr()
+			if c.err != nil {
+				return nil, c.err
+			}
+			links = append(links, h5Link{name, addr})
+		}
+	}
+	return links, nil
+}
+
+// attributes returns the attributes of the object with the messages msgs.
+func (h *h5Reader) attributes(msgs []h5Message) ([]*h5Attribute, error) {
+	var objects [][]byte
+	for _, msg := range msgs {
+		if msg.typ == h5MsgAttribute {
+			objects = append(objects, msg.data)
+		}
+	}
+	if data := findMessage(msgs, h5MsgAttributeInfo); data != nil {
+		c := h.buf(data)
+		c.skip(1)
+		if c.u8()&0x01 != 0 {
+			c.skip(2)
+		}
+		heapAddr, btree := c.addr(), c.addr()
+		if c.err != nil {
+			return nil, c.err
+		}
+		if !h.undefined(heapAddr) {
+			dense, err := h.denseObjects(heapAddr, btree)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, dense...)
+		}
+	}
+
+	attrs := make([]*h5Attribute, 0, len(objects))
+	for _, obj := range objects {
+		attr, err := h.attribute(obj)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// h5Dataset is a dataset found walking the groups.
+type h5Dataset struct {
+	*ncVariable
+	addr      uint64
+	unlimited bool
+	scale     bool
+	pure      bool
+	refs      [][]uint64
+}
+
+// readHDF5 walks the groups of an HDF5 file, and so netCDF-4, and reads
+// the dimensions from the dimension scales netCDF-4 writes.
+//
+//nolint:gocyclo
+func readHDF5(r *blockReader) (*ncFile, error) {
+	h := &h5Reader{r: r, heaps: map[uint64][]byte{}, sharing: map[uint64]bool{}}
+
+	// the superblock is at 0 or a power of two from 512 past a user block
+	var sb uint64
+	for sig := make([]byte, 8); ; sb = max(512, sb*2) {
+		if _, err := r.ReadAt(sig, int64(sb)); err != nil { //nolint:gosec
+			return nil, errors.New("not a netcdf or hdf5 file")
+		}
+		if bytes.Equal(sig, hdf5Signature) {
+			break
+		}
+	}
+
+	head := make([]byte, min(256, r.size-int64(sb)))     //nolint:gosec
+	if _, err := r.ReadAt(head, int64(sb)); err != nil { //nolint:gosec
+		return nil, err
+	}
+	c := h.buf(head)
+	c.skip(8)
+	version := c.u8()
+	var root uint64
+	switch version {
+	case 0, 1:
+		c.skip(4)
+		h.sizeOffsets, h.sizeLengths = int(c.u8()), int(c.u8())
+		c.skip(1 + 4 + 4)
+		if version == 1 {
+			c.skip(4)
+		}
+		h.base = c.addr()
+		c.skip(3 * h.sizeOffsets)
+		c.addr() // link name offset of the root group
+		root = c.addr()
+	case 2, 3:
+		h.sizeOffsets, h.sizeLengths = int(c.u8()), int(c.u8())
+		c.skip(1)
+		h.base = c.addr()
+		c.skip(2 * h.sizeOffsets)
+		root = c.addr()
+	default:
+		return nil, fmt.Errorf("unknown hdf5 superblock version %d", version)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if h.sizeOffsets < 2 || h.sizeOffsets > 8 || h.sizeLengths < 2 || h.sizeLengths > 8 {
+		return nil, errors.New("invalid hdf5 superblock")
+	}
+
+	f := &ncFile{info: NetCDFInfo{Format: "HDF5"}}
+	var datasets []*h5Dataset
+	netcdf4 := false
+
+	attributeMap := func(attrs []*h5Attribute) map[string]interface{} {
+		m := map[string]interface{}{}
+		for _, attr := range attrs {
+			if attr.name == "_NCProperties" {
+				netcdf4 = true
+			}
+			if !h5HiddenAttributes[attr.name] && attr.value != nil {
+				m[attr.name] = attr.value
+			}
+		}
+		if len(m) == 0 {
+			return nil
+		}
+		return m
+	}
+
+	seen := map[uint64]bool{}
+	var walk func(addr uint64, group string) error
+	walk = func(addr uint64, group string) error {
+		if seen[addr] || len(seen) > 100000 {
+			return nil
+		}
+		seen[addr] = true
+
+		msgs, err := h.messages(addr)
+		if err != nil {
+			return err
+		}
+		attrs, err := h.attributes(msgs)
+		if err != nil {
+			return err
+		}
+
+		if findMessage(msgs, h5MsgLayout) != nil {
+			d, err := h.dataset(addr, group, msgs, attrs)
+			if err != nil {
+				return fmt.Errorf("%s: %w", group, err)
+			}
+			d.Attributes = attributeMap(attrs)
+			datasets = append(datasets, d)
+			return nil
+		}
+
+		if group == "" {
+			f.info.Attributes = attributeMap(attrs)
+		}
+		links, err := h.links(msgs)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			if err := walk(link.addr, path.Join(group, link.name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(root, ""); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+
+	// dimension scales are the dimensions, the other datasets refer to
+	// them by address
+	dims := map[uint64]string{}
+	for _, d := range datasets {
+		if !d.scale || len(d.Shape) != 1 {
+			continue
+		}
+		netcdf4 = true
+		dims[d.addr] = d.Name
+		f.info.Dimensions = append(f.info.Dimensions, NetCDFDimension{Name: d.Name, Size: d.Shape[0], Unlimited: d.unlimited})
+	}
+
+	// datasets without dimension scales get phony dimensions by size, the
+	// way netCDF-4 names them
+	phony := map[int64]string{}
+	for _, d := range datasets {
+		d.Dimensions = []string{}
+		for i, size := range d.Shape {
+			switch {
+			case i < len(d.refs) && len(d.refs[i]) > 0 && dims[d.refs[i][0]] != "":
+				d.Dimensions = append(d.Dimensions, dims[d.refs[i][0]])
+			case d.scale && len(d.Shape) == 1:
+				d.Dimensions = append(d.Dimensions, d.Name)
+			default:
+				name, ok := phony[size]
+				if !ok {
+					name = fmt.Sprintf("phony_dim_%d", len(phony))
+					phony[size] = name
+					f.info.Dimensions = append(f.info.Dimensions, NetCDFDimension{Name: name, Size: size})
+				}
+				d.Dimensions = append(d.Dimensions, name)
+			}
+		}
+		if !d.pure {
+			f.variables = append(f.variables, d.ncVariable)
+		}
+	}
+
+	if netcdf4 {
+		f.info.Format = "netCDF-4"
+	}
+	return f, nil
+}
+
+// dataset reads the shape, type, layout and filters of a dataset.
+//
+//nolint:gocyclo
+func (h *h5Reader) dataset(addr uint64, name string, msgs []h5Message, attrs []*h5Attribute) (*h5Dataset, error) {
+	d := &h5Dataset{ncVariable: &ncVariable{}, addr: addr}
+	d.Name = name
+
+	space := findMessage(msgs, h5MsgDataspace)
+	typeData := findMessage(msgs, h5MsgDatatype)
+	if space == nil || typeData == nil {
+		return nil, errors.New("dataset without dataspace or datatype")
+	}
+	sc := h.buf(space)
+	d.Shape, d.unlimited = h.dataspace(sc)
+	if d.Shape == nil {
+		d.Shape = []int64{}
+	}
+	t := h.datatype(h.buf(typeData))
+	if sc.err != nil {
+		return nil, sc.err
+	}
+	d.DataType = t.name
+	d.dtype = t.dtype
+
+	for _, attr := range attrs {
+		switch attr.name {
+		case "CLASS":
+			d.scale = attr.value == "DIMENSION_SCALE"
+		case "NAME":
+			if s, ok := attr.value.(string); ok && strings.HasPrefix(s, h5PureDimension) {
+				d.pure = true
+			}
+		case "DIMENSION_LIST":
+			d.refs = attr.refs
+		}
+	}
+
+	if data := findMessage(msgs, h5MsgFilterLine); data != nil {
+		if err := h.filters(d, t, data); err != nil {
+			return nil, err
+		}
+	}
+
+	c := h.buf(findMessage(msgs, h5MsgLayout))
+	version := c.u8()
+	if version < 3 {
+		d.unsupported = fmt.Sprintf("layout version %d", version)
+		return d, nil
+	}
+	rank := len(d.Shape)
+	elemSize := int64(t.size)
+
+	switch c.u8() {
+	case 0: // compact
+		data := c.bytes(int(c.u16()))
+		d.chunkShape = d.Shape
+		d.chunks = func() ([]ncChunk, error) {
+			return []ncChunk{{index: make([]int64, rank), data: data}}, nil
+		}
+	case 1: // contiguous
+		dataAddr, size := c.addr(), c.length()
+		d.chunkShape = d.Shape
+		d.chunks = func() ([]ncChunk, error) {
+			if h.undefined(dataAddr) || size == 0 {
+				return nil, nil
+			}
+			return []ncChunk{{index: make([]int64, rank), offset: int64(dataAddr + h.base), size: int64(size)}}, nil //nolint:gosec
+		}
+	case 2: // chunked
+		var flags uint8
+		if version == 4 {
+			flags = c.u8()
+		}
+		dimensionality := int(c.u8())
+		var btree uint64
+		if version == 3 {
+			btree = c.addr()
+		}
+		dimSize := 4
+		if version == 4 {
+			dimSize = int(c.u8())
+		}
+		if dimensionality != rank+1 {
+			return nil, errors.New("chunk rank does not match dataspace")
+		}
+		d.Chunks = make([]int64, rank)
+		for i := range d.Chunks {
+			d.Chunks[i] = int64(c.uint(dimSize)) //nolint:gosec
+			if d.Chunks[i] == 0 {
+				return nil, errors.New("invalid chunk size")
+			}
+		}
+		c.uint(dimSize) // element size
+		d.chunkShape = d.Chunks
+		chunkBytes := product(d.Chunks) * elemSize
+
+		if version == 3 {
+			keySize := 8 + 8*dimensionality
+			d.chunks = func() ([]ncChunk, error) {
+				if h.undefined(btree) {
+					return nil, nil
+				}
+				var chunks []ncChunk
+				err := h.btreeV1(btree, keySize, func(key []byte, child uint64) error {
+					kc := h.buf(key)
+					size := kc.u32()
+					kc.skip(4)
+					index := make([]int64, rank)
+					for i := range index {
+						index[i] = int64(kc.uint(8)) / d.Chunks[i] //nolint:gosec
+					}
+					chunks = append(chunks, ncChunk{index: index, offset: int64(child + h.base), size: int64(size)}) //nolint:gosec
+					return kc.err
+				})
+				return chunks, err
+			}
+			break
+		}
+
+		switch indexType := c.u8(); indexType {
+		case 1: // single chunk
+			size := chunkBytes
+			if flags&0x02 != 0 {
+				size = int64(c.length()) //nolint:gosec
+				c.skip(4)
+			}
+			dataAddr := c.addr()
+			d.chunks = func() ([]ncChunk, error) {
+				if h.undefined(dataAddr) {
+					return nil, nil
+				}
+				return []ncChunk{{index: make([]int64, rank), offset: int64(dataAddr + h.base), size: size}}, nil //nolint:gosec
+			}
+		case 2: // implicit, stored contiguously in order
+			dataAddr := c.addr()
+			d.chunks = func() ([]ncChunk, error) {
+				if h.undefined(dataAddr) {
+					return nil, nil
+				}
+				indices, err := gridIndices(d.Shape, d.Chunks)
+				if err != nil {
+					return nil, err
+				}
+				var chunks []ncChunk
+				for i, index := range indices {
+					chunks = append(chunks, ncChunk{index: index, offset: int64(dataAddr+h.base) + int64(i)*chunkBytes, size: chunkBytes}) //nolint:gosec
+				}
+				return chunks, nil
+			}
+		case 3: // fixed array
+			c.skip(1)
+			indexAddr := c.addr()
+			d.chunks = func() ([]ncChunk, error) {
+				return h.fixedArrayChunks(indexAddr, d.Shape, d.Chunks, chunkBytes)
+			}
+		default:
+			d.unsupported = fmt.Sprintf("chunk index %d", indexType)
+		}
+	default:
+		d.unsupported = "virtual layout"
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return d, nil
+}
+
+// filters reads the filter pipeline of a dataset into the names of its
+// filters and the equivalent numcodecs configurations.
+func (h *h5Reader) filters(d *h5Dataset, t *h5Type, data []byte) error {
+	c := h.buf(data)
+	version := c.u8()
+	n := int(c.u8())
+	if version == 1 {
+		c.skip(6)
+	}
+
+	for i := 0; i < n && c.err == nil; i++ {
+		id := c.u16()
+		nameLength := 0
+		if version == 1 || id >= 256 {
+			nameLength = int(c.u16())
+		}
+		c.skip(2) // flags
+		nValues := int(c.u16())
+		if version == 1 {
+			nameLength = (nameLength + 7) &^ 7
+		}
+		c.skip(nameLength)
+		values := make([]uint32, nValues)
+		for j := range values {
+			values[j] = c.u32()
+		}
+		if version == 1 && nValues%2 == 1 {
+			c.skip(4)
+		}
+
+		name, ok := h5Filters[id]
+		if !ok {
+			name = fmt.Sprintf("filter %d", id)
+		}
+		d.Filters = append(d.Filters, name)
+
+		var codec map[string]interface{}
+		switch id {
+		case 1:
+			codec = map[string]interface{}{"id": "zlib", "level": valueOr(values, 0, 6)}
+		case 2:
+			codec = map[string]interface{}{"id": "shuffle", "elementsize": t.size}
+		case 3:
+			codec = map[string]interface{}{"id": "fletcher32"}
+		case 307:
+			codec = map[string]interface{}{"id": "bz2", "level": valueOr(values, 0, 9)}
+		case 32015:
+			codec = map[string]interface{}{"id": "zstd", "level": valueOr(values, 0, 3)}
+		default:
+			d.unsupported = "filter " + name
+		}
+		if codec != nil {
+			d.filters = append(d.filters, codec)
+		}
+	}
+	return c.err
+}
+
+func valueOr(values []uint32, i int, fallback uint32) uint32 {
+	if i < len(values) {
+		return values[i]
+	}
+	return fallback
+}
+
+// fixedArrayChunks reads the chunk addresses of a fixed array index, which
+// lists the chunks in row-major order.
+func (h *h5Reader) fixedArrayChunks(addr uint64, shape, chunkShape []int64, chunkBytes int64) ([]ncChunk, error) {
+	c, err := h.signed(addr, uint64(12+h.sizeLengths+h.sizeOffsets), "FAHD") //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	c.skip(1)
+	filtered := c.u8() == 1
+	entrySize := int(c.u8())
+	pageBits := c.u8()
+	entries := c.length()
+	dataAddr := c.addr()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if entries > 1<<pageBits {
+		return nil, errors.New("paged chunk indexes are not supported")
+	}
+	if h.undefined(dataAddr) {
+		return nil, nil
+	}
+
+	header := 6 + h.sizeOffsets
+	b, err := h.read(dataAddr, uint64(header)+entries*uint64(entrySize)) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	ec := h.buf(b[header:])
+
+	indices, err := gridIndices(shape, chunkShape)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []ncChunk
+	for _, index := range indices {
+		if ec.err != nil {
+			break
+		}
+		chunkAddr := ec.addr()
+		size := chunkBytes
+		if filtered {
+			size = int64(ec.uint(entrySize - h.sizeOffsets - 4)) //nolint:gosec
+			ec.skip(4)
+		}
+		if !h.undefined(chunkAddr) {
+			chunks = append(chunks, ncChunk{index: index, offset: int64(chunkAddr + h.base), size: size}) //nolint:gosec
+		}
+	}
+	return chunks, ec.err
+}
@@ -176,7 +176,10 @@ var types = map[string]string{
 	".gz":        "application/x-compressed",
 	".gzip":      "application/x-gzip",
 	".h":         "text/x-h",
+	".h5":        "application/x-hdf5",
 	".hdf":       "application/x-hdf",
+	".hdf5":      "application/x-hdf5",
+	".he5":       "application/x-hdf5",
 	".help":      "application/x-helpfile",
 	".hgl":       "application/vndhp-hpgl",
 	".hh":        "text/x-h",
@@ -304,6 +307,7 @@ var types = map[string]string{
 	".nap":       "image/naplps",
 	".naplps":    "image/naplps",
 	".nc":        "application/x-netcdf",
+	".nc4":       "application/x-netcdf",
 	".ncm":       "application/vndnokiaconfiguration-message",
 	".nif":       "image/x-niff",
 	".niff":      "image/x-niff",
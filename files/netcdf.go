@@ -0,0 +1,648 @@
+package files
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// NetCDFInfo describes a NetCDF or HDF5 file from its header.
+type NetCDFInfo struct {
+	// Format is classic, 64-bit offset, 64-bit data, netCDF-4 or HDF5.
+	Format      string                 `json:"format"`
+	Conventions string                 `json:"conventions,omitempty"`
+	Dimensions  []NetCDFDimension      `json:"dimensions"`
+	Variables   []NetCDFVariable       `json:"variables"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// NetCDFDimension is a dimension of a NetCDF file. The names of dimensions
+// and variables of groups are prefixed with the path of their group.
+type NetCDFDimension struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Unlimited bool   `json:"unlimited,omitempty"`
+}
+
+// NetCDFVariable is a variable of a NetCDF file or a dataset of an HDF5 file.
+type NetCDFVariable struct {
+	Name       string                 `json:"name"`
+	Dimensions []string               `json:"dimensions"`
+	Shape      []int64                `json:"shape"`
+	DataType   string                 `json:"dataType"`
+	Chunks     []int64                `json:"chunks,omitempty"`
+	Filters    []string               `json:"filters,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// KerchunkReferences maps the keys of a Zarr store to the byte ranges of a
+// file, in the kerchunk reference format version 1, so that the file can be
+// opened lazily with fsspec and xarray.
+type KerchunkReferences struct {
+	Version   int                    `json:"version"`
+	Templates map[string]string      `json:"templates"`
+	Refs      map[string]interface{} `json:"refs"`
+}
+
+// maxReferences bounds the number of chunks referenced in a file, which
+// malformed headers could otherwise make arbitrarily large.
+const maxReferences = 1 << 20
+
+var errTooManyChunks = fmt.Errorf("more than %d chunks", maxReferences)
+
+// ncFile is a parsed header along with what it takes to reference the data
+// of its variables.
+type ncFile struct {
+	info      NetCDFInfo
+	variables []*ncVariable
+}
+
+type ncVariable struct {
+	NetCDFVariable
+	// dtype is the numpy type of the values, empty if they can't be
+	// referenced.
+	dtype      string
+	chunkShape []int64
+	// filters are the numcodecs configurations of the filters.
+	filters []map[string]interface{}
+	// unsupported tells why the data can't be referenced.
+	unsupported string
+	chunks      func() ([]ncChunk, error)
+}
+
+// ncChunk is a chunk of a variable by its index in the chunk grid, either a
+// byte range of the file or inline data.
+type ncChunk struct {
+	index  []int64
+	offset int64
+	size   int64
+	data   []byte
+}
+
+// ReadNetCDF parses the header of the NetCDF or HDF5 file at filePath with
+// ranged reads.
+func ReadNetCDF(fSys afero.Fs, filePath string) (*NetCDFInfo, error) {
+	file, err := fSys.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := readNetCDF(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &f.info, nil
+}
+
+// NetCDFReferences returns kerchunk references to the variables of the
+// NetCDF or HDF5 file at filePath, to be fetched from url. Variables whose
+// type, compression or chunk index can't be referenced are left out.
+func NetCDFReferences(fSys afero.Fs, filePath, url string) (*KerchunkReferences, error) {
+	file, err := fSys.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := readNetCDF(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	return f.references(url)
+}
+
+func readNetCDF(r io.ReaderAt, size int64) (*ncFile, error) {
+	br := &blockReader{r: r, size: size, blocks: map[int64][]byte{}}
+
+	magic := make([]byte, 4)
+	if _, err := br.ReadAt(magic, 0); err != nil {
+		return nil, errors.New("not a netcdf or hdf5 file")
+	}
+
+	var (
+		f   *ncFile
+		err error
+	)
+	if string(magic[:3]) == "CDF" {
+		f, err = readCDF(br, magic[3])
+	} else {
+		f, err = readHDF5(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if conventions, ok := f.info.Attributes["Conventions"].(string); ok {
+		f.info.Conventions = conventions
+	}
+	for _, v := range f.variables {
+		f.info.Variables = append(f.info.Variables, v.NetCDFVariable)
+	}
+	if f.info.Dimensions == nil {
+		f.info.Dimensions = []NetCDFDimension{}
+	}
+	if f.info.Variables == nil {
+		f.info.Variables = []NetCDFVariable{}
+	}
+	return f, nil
+}
+
+func (f *ncFile) references(url string) (*KerchunkReferences, error) {
+	refs := map[string]interface{}{}
+	addJSON := func(key string, v interface{}) error {
+		var buf strings.Builder
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		refs[key] = strings.TrimSuffix(buf.String(), "\n")
+		return nil
+	}
+
+	if err := addJSON(".zgroup", map[string]int{"zarr_format": 2}); err != nil {
+		return nil, err
+	}
+	if err := addJSON(".zattrs", nonNil(f.info.Attributes)); err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, v := range f.variables {
+		if v.dtype == "" || v.unsupported != "" {
+			continue
+		}
+		chunks, err := v.chunks()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Name, err)
+		}
+		if total += len(chunks); total > maxReferences {
+			return nil, errTooManyChunks
+		}
+
+		// every group of the variable is a group of the store as well
+		for dir := path.Dir(v.Name); dir != "."; dir = path.Dir(dir) {
+			if err := addJSON(dir+"/.zgroup", map[string]int{"zarr_format": 2}); err != nil {
+				return nil, err
+			}
+		}
+
+		attrs := map[string]interface{}{"_ARRAY_DIMENSIONS": v.Dimensions}
+		for name, value := range v.Attributes {
+			if name != "_FillValue" {
+				attrs[name] = value
+			}
+		}
+		fill := v.Attributes["_FillValue"]
+		if strings.Contains(v.dtype, "S") {
+			fill = nil
+		}
+
+		chunkShape := make([]int64, len(v.chunkShape))
+		for i, size := range v.chunkShape {
+			chunkShape[i] = max(size, 1)
+		}
+		zarray := map[string]interface{}{
+			"zarr_format": 2,
+			"shape":       v.Shape,
+			"chunks":      chunkShape,
+			"dtype":       v.dtype,
+			"compressor":  nil,
+			"filters":     v.filters,
+			"fill_value":  fill,
+			"order":       "C",
+		}
+		if err := addJSON(v.Name+"/.zarray", zarray); err != nil {
+			return nil, err
+		}
+		if err := addJSON(v.Name+"/.zattrs", attrs); err != nil {
+			return nil, err
+		}
+
+		for _, chunk := range chunks {
+			key := "0"
+			if len(chunk.index) > 0 {
+				parts := make([]string, len(chunk.index))
+				for i, index := range chunk.index {
+					parts[i] = strconv.FormatInt(index, 10)
+				}
+				key = strings.Join(parts, ".")
+			}
+			if chunk.data != nil {
+				refs[v.Name+"/"+key] = "base64:" + base64.StdEncoding.EncodeToString(chunk.data)
+			} else {
+				refs[v.Name+"/"+key] = []interface{}{"{{u}}", chunk.offset, chunk.size}
+			}
+		}
+	}
+
+	return &KerchunkReferences{Version: 1, Templates: map[string]string{"u": url}, Refs: refs}, nil
+}
+
+func nonNil(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
+
+func product(values []int64) int64 {
+	p := int64(1)
+	for _, v := range values {
+		p *= v
+	}
+	return p
+}
+
+// gridIndices returns the indices of all chunks of shape in row-major order,
+// or errTooManyChunks if there are more than maxReferences.
+func gridIndices(shape, chunks []int64) ([][]int64, error) {
+	grid := make([]int64, len(shape))
+	n := int64(1)
+	for i := range shape {
+		grid[i] = (shape[i] + chunks[i] - 1) / chunks[i]
+		if grid[i] > 0 && n > maxReferences/grid[i] {
+			return nil, errTooManyChunks
+		}
+		n *= grid[i]
+	}
+
+	indices := make([][]int64, 0, n)
+	for i := int64(0); i < n; i++ {
+		index := make([]int64, len(grid))
+		rest := i
+		for d := len(grid) - 1; d >= 0; d-- {
+			index[d] = rest % grid[d]
+			rest /= grid[d]
+		}
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+// decodeNumbers decodes the n numbers of size bytes in b, which are signed
+// or unsigned integers or floats as of kind i, u or f.
+func decodeNumbers(b []byte, order binary.ByteOrder, kind byte, size, n int) []interface{} {
+	values := make([]interface{}, 0, n)
+	for i := 0; i < n && (i+1)*size <= len(b); i++ {
+		v := b[i*size : (i+1)*size]
+		var bits uint64
+		switch size {
+		case 1:
+			bits = uint64(v[0])
+		case 2:
+			bits = uint64(order.Uint16(v))
+		case 4:
+			bits = uint64(order.Uint32(v))
+		case 8:
+			bits = order.Uint64(v)
+		default:
+			return nil
+		}
+
+		switch {
+		case kind == 'f' && size == 4:
+			values = append(values, jsonFloat(float64(math.Float32frombits(uint32(bits)))))
+		case kind == 'f' && size == 8:
+			values = append(values, jsonFloat(math.Float64frombits(bits)))
+		case kind == 'i':
+			shift := 64 - 8*size
+			values = append(values, int64(bits<<shift)>>shift) //nolint:gosec
+		default:
+			values = append(values, bits)
+		}
+	}
+	return values
+}
+
+// jsonFloat keeps floats that JSON can't represent as strings, the way Zarr
+// writes fill values.
+func jsonFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	default:
+		return f
+	}
+}
+
+// attributeValue unwraps single values.
+func attributeValue(values []interface{}) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// cdfType is a type of the classic formats.
+type cdfType struct {
+	name  string
+	size  uint64
+	kind  byte
+	dtype string
+}
+
+var cdfTypes = map[uint32]cdfType{
+	1:  {"byte", 1, 'i', "|i1"},
+	2:  {"char", 1, 'S', "|S1"},
+	3:  {"short", 2, 'i', ">i2"},
+	4:  {"int", 4, 'i', ">i4"},
+	5:  {"float", 4, 'f', ">f4"},
+	6:  {"double", 8, 'f', ">f8"},
+	7:  {"ubyte", 1, 'u', "|u1"},
+	8:  {"ushort", 2, 'u', ">u2"},
+	9:  {"uint", 4, 'u', ">u4"},
+	10: {"int64", 8, 'i', ">i8"},
+	11: {"uint64", 8, 'u', ">u8"},
+}
+
+// Tags of the lists of the classic header.
+const (
+	cdfDimensionTag = 0x0a
+	cdfVariableTag  = 0x0b
+	cdfAttributeTag = 0x0c
+)
+
+// cdfReader reads a classic header sequentially, keeping the first error.
+type cdfReader struct {
+	r       *blockReader
+	off     int64
+	version byte
+	err     error
+}
+
+func (c *cdfReader) read(n uint64) []byte {
+	if c.err == nil && n > uint64(c.r.size-c.off) {
+		c.err = fmt.Errorf("unexpected end of header at %d", c.off)
+	}
+	if c.err != nil {
+		return make([]byte, min(n, 8))
+	}
+
+	buf := make([]byte, n)
+	if _, err := c.r.ReadAt(buf, c.off); err != nil {
+		c.err = err
+	}
+	c.off += int64(n)
+	return buf
+}
+
+func (c *cdfReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(c.read(4))
+}
+
+// count reads a non-negative number, which is 64 bits wide in the 64-bit
+// data format.
+func (c *cdfReader) count() uint64 {
+	if c.version == 5 {
+		return binary.BigEndian.Uint64(c.read(8))
+	}
+	return uint64(c.uint32())
+}
+
+func (c *cdfReader) offset() uint64 {
+	if c.version == 1 {
+		return uint64(c.uint32())
+	}
+	return binary.BigEndian.Uint64(c.read(8))
+}
+
+func (c *cdfReader) name() string {
+	n := c.count()
+	b := c.read((n + 3) &^ 3)
+	if uint64(len(b)) < n {
+		return ""
+	}
+	return string(b[:n])
+}
+
+// list reads the tag and length of a list, which is absent if both are 0.
+func (c *cdfReader) list(tag uint32) uint64 {
+	actual, n := c.uint32(), c.count()
+	switch {
+	case c.err != nil:
+		return 0
+	case actual == 0 && n == 0:
+		return 0
+	case actual != tag:
+		c.err = fmt.Errorf("unexpected tag %d at %d", actual, c.off)
+		return 0
+	case n > uint64(c.r.size-c.off)/4:
+		c.err = fmt.Errorf("invalid list length %d at %d", n, c.off)
+		return 0
+	}
+	return n
+}
+
+func (c *cdfReader) attributes() map[string]interface{} {
+	n := c.list(cdfAttributeTag)
+	if n == 0 {
+		return nil
+	}
+
+	attrs := map[string]interface{}{}
+	for i := uint64(0); i < n && c.err == nil; i++ {
+		name := c.name()
+		typ, ok := cdfTypes[c.uint32()]
+		count := c.count()
+		if !ok {
+			c.err = fmt.Errorf("attribute %s has an unknown type", name)
+			break
+		}
+		if count > uint64(c.r.size) {
+			c.err = fmt.Errorf("attribute %s has an invalid length", name)
+			break
+		}
+		data := c.read((count*typ.size + 3) &^ 3)
+		if c.err != nil {
+			break
+		}
+
+		if typ.kind == 'S' {
+			attrs[name] = strings.TrimRight(string(data[:count]), "\x00")
+		} else {
+			attrs[name] = attributeValue(decodeNumbers(data, binary.BigEndian, typ.kind, int(typ.size), int(count))) //nolint:gosec
+		}
+	}
+	return attrs
+}
+
+// readCDF parses the header of the classic, 64-bit offset and 64-bit data
+// formats, whose variables are each stored contiguously or, along the
+// unlimited dimension, interleaved by record.
+//
+//nolint:gocyclo
+func readCDF(r *blockReader, version byte) (*ncFile, error) {
+	c := &cdfReader{r: r, off: 4, version: version}
+	f := &ncFile{}
+	switch version {
+	case 1:
+		f.info.Format = "classic"
+	case 2:
+		f.info.Format = "64-bit offset"
+	case 5:
+		f.info.Format = "64-bit data"
+	default:
+		return nil, fmt.Errorf("unknown netcdf version %d", version)
+	}
+
+	numRecs := c.count()
+	streaming := (version == 5 && numRecs == math.MaxUint64) || (version != 5 && numRecs == math.MaxUint32)
+
+	n := c.list(cdfDimensionTag)
+	unlimited := -1
+	for i := uint64(0); i < n && c.err == nil; i++ {
+		dim := NetCDFDimension{Name: c.name(), Size: int64(c.count())} //nolint:gosec
+		if dim.Size == 0 {
+			dim.Unlimited = true
+			unlimited = int(i) //nolint:gosec
+		}
+		f.info.Dimensions = append(f.info.Dimensions, dim)
+	}
+
+	f.info.Attributes = c.attributes()
+
+	type cdfVariable struct {
+		*ncVariable
+		typ    cdfType
+		begin  int64
+		record bool
+	}
+	var vars []cdfVariable
+	n = c.list(cdfVariableTag)
+	for i := uint64(0); i < n && c.err == nil; i++ {
+		v := cdfVariable{ncVariable: &ncVariable{}}
+		v.Name = c.name()
+		ndims := c.count()
+		if ndims > 1024 {
+			return nil, fmt.Errorf("variable %s has too many dimensions", v.Name)
+		}
+		ids := make([]uint64, ndims)
+		for j := range ids {
+			ids[j] = c.count()
+		}
+		v.Attributes = c.attributes()
+		typ, ok := cdfTypes[c.uint32()]
+		// the size of the variable is padded and not needed
+		c.count()
+		v.begin = int64(c.offset()) //nolint:gosec
+		if c.err != nil {
+			break
+		}
+		if !ok {
+			return nil, fmt.Errorf("variable %s has an unknown type", v.Name)
+		}
+
+		v.typ = typ
+		v.DataType = typ.name
+		v.dtype = typ.dtype
+		v.Dimensions = []string{}
+		v.Shape = []int64{}
+		for j, id := range ids {
+			if id >= uint64(len(f.info.Dimensions)) {
+				return nil, fmt.Errorf("variable %s has an unknown dimension", v.Name)
+			}
+			v.Dimensions = append(v.Dimensions, f.info.Dimensions[id].Name)
+			v.Shape = append(v.Shape, f.info.Dimensions[id].Size)
+			if int(id) == unlimited { //nolint:gosec
+				if j != 0 {
+					return nil, fmt.Errorf("variable %s has the unlimited dimension %s other than first", v.Name, v.Dimensions[j])
+				}
+				v.record = true
+			}
+		}
+		vars = append(vars, v)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	// records hold the values of all record variables for one index of the
+	// unlimited dimension, each padded to 4 bytes unless there is only one
+	var recSize, recBegin int64 = 0, -1
+	var records []cdfVariable
+	for _, v := range vars {
+		if v.record {
+			records = append(records, v)
+		}
+	}
+	for _, v := range records {
+		size := product(v.Shape[1:]) * int64(v.typ.size) //nolint:gosec
+		if len(records) > 1 {
+			size = (size + 3) &^ 3
+		}
+		recSize += size
+		if recBegin < 0 || v.begin < recBegin {
+			recBegin = v.begin
+		}
+	}
+	if streaming && recSize > 0 {
+		numRecs = uint64((r.size - recBegin) / recSize) //nolint:gosec
+	} else if streaming {
+		numRecs = 0
+	}
+	if unlimited >= 0 {
+		f.info.Dimensions[unlimited].Size = int64(numRecs) //nolint:gosec
+	}
+
+	for _, v := range vars {
+		v := v
+		size := int64(v.typ.size) //nolint:gosec
+		if v.record {
+			v.Shape[0] = int64(numRecs) //nolint:gosec
+			v.chunkShape = append([]int64{1}, v.Shape[1:]...)
+			v.chunks = func() ([]ncChunk, error) {
+				if v.Shape[0] > maxReferences {
+					return nil, errTooManyChunks
+				}
+				if recSize > 0 && v.Shape[0] > (r.size-recBegin)/recSize {
+					return nil, fmt.Errorf("%d records of %d bytes exceed the file", v.Shape[0], recSize)
+				}
+				chunks := make([]ncChunk, 0, v.Shape[0])
+				length := product(v.Shape[1:]) * size
+				for rec := int64(0); rec < v.Shape[0]; rec++ {
+					index := make([]int64, len(v.Shape))
+					index[0] = rec
+					chunks = append(chunks, ncChunk{index: index, offset: v.begin + rec*recSize, size: length})
+				}
+				return chunks, nil
+			}
+		} else {
+			v.chunkShape = v.Shape
+			v.chunks = func() ([]ncChunk, error) {
+				length := product(v.Shape) * size
+				if length == 0 {
+					return nil, nil
+				}
+				return []ncChunk{{index: make([]int64, len(v.Shape)), offset: v.begin, size: length}}, nil
+			}
+		}
+		f.variables = append(f.variables, v.ncVariable)
+	}
+
+	return f, nil
+}
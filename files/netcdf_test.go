@@ -0,0 +1,410 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// le writes little-endian values to a buffer.
+type le struct{ bytes.Buffer }
+
+func (w *le) put(values ...interface{}) *le {
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			w.WriteString(v)
+		case []byte:
+			w.Write(v)
+		default:
+			_ = binary.Write(w, binary.LittleEndian, v)
+		}
+	}
+	return w
+}
+
+func (w *le) pad(align int) *le {
+	for w.Len()%align != 0 {
+		w.WriteByte(0)
+	}
+	return w
+}
+
+const h5Undefined = ^uint64(0)
+
+// h5File builds an HDF5 file with 8 byte offsets and lengths, appending
+// objects after the version 0 superblock.
+type h5File struct{ le }
+
+func newH5File() *h5File {
+	f := &h5File{}
+	f.Write(make([]byte, 96))
+	return f
+}
+
+func (f *h5File) alloc(data []byte) uint64 {
+	f.pad(8)
+	addr := uint64(f.Len()) //nolint:gosec
+	f.Write(data)
+	return addr
+}
+
+type h5TestMessage struct {
+	typ  uint16
+	data []byte
+}
+
+// objectHeader writes a version 1 object header, or version 2 if v2.
+func (f *h5File) objectHeader(v2 bool, msgs ...h5TestMessage) uint64 {
+	var body le
+	for _, msg := range msgs {
+		if v2 {
+			body.put(uint8(msg.typ), uint16(len(msg.data)), uint8(0), msg.data) //nolint:gosec
+		} else {
+			size := (len(msg.data) + 7) &^ 7
+			body.put(msg.typ, uint16(size), uint8(0), []byte{0, 0, 0}, msg.data).pad(8) //nolint:gosec
+		}
+	}
+
+	var header le
+	if v2 {
+		header.put("OHDR", uint8(2), uint8(0x02), uint32(body.Len()), body.Bytes(), uint32(0)) //nolint:gosec
+	} else {
+		header.put(uint8(1), uint8(0), uint16(len(msgs)), uint32(1), uint32(body.Len()), uint32(0), body.Bytes()) //nolint:gosec
+	}
+	return f.alloc(header.Bytes())
+}
+
+func h5Space(maxDims []uint64, dims ...uint64) []byte {
+	var w le
+	flags := uint8(0)
+	if maxDims != nil {
+		flags = 1
+	}
+	w.put(uint8(1), uint8(len(dims)), flags, uint8(0), uint32(0), dims) //nolint:gosec
+	if maxDims != nil {
+		w.put(maxDims)
+	}
+	return w.Bytes()
+}
+
+func h5Float(size uint32) []byte {
+	return new(le).put(uint8(0x11), []byte{0x20, 0x3f, 0}, size, make([]byte, 12)).Bytes()
+}
+
+func h5String(size uint32) []byte {
+	return new(le).put(uint8(0x13), []byte{0, 0, 0}, size).Bytes()
+}
+
+func h5AttributeMessage(version uint8, name string, typ, space, data []byte) []byte {
+	var w le
+	w.put(version, uint8(0), uint16(len(name)+1), uint16(len(typ)), uint16(len(space))) //nolint:gosec
+	if version == 1 {
+		w.put(name, uint8(0)).pad(8).put(typ).pad(8).put(space).pad(8)
+	} else {
+		w.put(uint8(0), name, uint8(0), typ, space)
+	}
+	return w.put(data).Bytes()
+}
+
+func h5StringAttribute(name, value string) h5TestMessage {
+	return h5TestMessage{0x0c, h5AttributeMessage(1, name, h5String(uint32(len(value))), h5Space(nil), []byte(value))} //nolint:gosec
+}
+
+// encodeHDF5 writes a netCDF-4 file with the dimensions time and x, where
+// time is a coordinate variable, and the chunked and compressed variable
+// temp(time, x).
+func encodeHDF5(t *testing.T) ([]byte, map[string]uint64) {
+	t.Helper()
+	f := newH5File()
+	offsets := map[string]uint64{}
+
+	// time: contiguous, in a version 2 object header
+	offsets["time"] = f.alloc(new(le).put([]float64{0, 1, 2}).Bytes())
+	timeHeader := f.objectHeader(true,
+		h5TestMessage{0x01, h5Space([]uint64{h5Undefined}, 3)},
+		h5TestMessage{0x03, h5Float(8)},
+		h5TestMessage{0x08, new(le).put(uint8(3), uint8(1), offsets["time"], uint64(24)).Bytes()},
+		h5StringAttribute("CLASS", "DIMENSION_SCALE"),
+		h5StringAttribute("NAME", "time"),
+		h5StringAttribute("units", "days since 2000-01-01"),
+	)
+
+	// x: a dimension without variable
+	xHeader := f.objectHeader(false,
+		h5TestMessage{0x01, h5Space(nil, 4)},
+		h5TestMessage{0x03, h5Float(4)},
+		h5TestMessage{0x08, new(le).put(uint8(3), uint8(1), h5Undefined, uint64(0)).Bytes()},
+		h5StringAttribute("CLASS", "DIMENSION_SCALE"),
+		h5StringAttribute("NAME", h5PureDimension+".         4"),
+	)
+
+	// temp: two chunks indexed by a version 1 B-tree
+	offsets["chunk0"] = f.alloc([]byte("first compressed chunk"))
+	offsets["chunk1"] = f.alloc([]byte("second"))
+	var tree le
+	tree.put("TREE", uint8(1), uint8(0), uint16(2), h5Undefined, h5Undefined)
+	tree.put(uint32(22), uint32(0), []uint64{0, 0, 0}, offsets["chunk0"])
+	tree.put(uint32(6), uint32(0), []uint64{2, 0, 0}, offsets["chunk1"])
+	tree.put(uint32(0), uint32(0), []uint64{4, 0, 0})
+	chunkTree := f.alloc(tree.Bytes())
+	offsets["chunkTree"] = chunkTree
+
+	// the dimensions of temp refer to time and x through the global heap
+	var heap le
+	heap.put(uint16(1), uint16(1), uint32(0), uint64(8), timeHeader)
+	heap.put(uint16(2), uint16(1), uint32(0), uint64(8), xHeader)
+	heap.put(uint16(0), uint16(0), uint32(0), uint64(0))
+	collection := f.alloc(new(le).put("GCOL", uint8(1), []byte{0, 0, 0}, uint64(16+heap.Len()), heap.Bytes()).Bytes())
+	refType := new(le).put(uint8(0x19), []byte{0, 0, 0}, uint32(16), uint8(0x17), []byte{0, 0, 0}, uint32(8)).Bytes()
+	dimList := new(le).put(uint32(1), collection, uint32(1), uint32(1), collection, uint32(2)).Bytes()
+
+	// long_name of temp is stored densely, in a fractal heap indexed by a
+	// version 2 B-tree
+	longName := h5AttributeMessage(3, "long_name", h5String(11), h5Space(nil), []byte("temperature"))
+	var frhp le
+	frhp.put("FRHP", uint8(0), uint16(8), uint16(0), uint8(0), uint32(4096), make([]byte, 10*8+2*8))
+	frhp.put(uint16(4), uint64(512), uint64(65536), uint16(32), uint16(0), uint64(0), uint16(0), uint32(0))
+	heapHeader := f.alloc(frhp.Bytes())
+	direct := f.alloc(new(le).put("FHDB", uint8(0), heapHeader, uint32(0), longName).Bytes())
+	// the address of the root block follows the table parameters
+	binary.LittleEndian.PutUint64(f.Bytes()[heapHeader+132:], direct)
+	heapID := new(le).put(uint8(0), uint32(17), uint16(len(longName))).pad(8).Bytes() //nolint:gosec
+	leaf := f.alloc(new(le).put("BTLF", uint8(0), uint8(8), heapID, uint8(0), uint32(0), uint32(0), make([]byte, 512-6-17)).Bytes())
+	btree := f.alloc(new(le).put("BTHD", uint8(0), uint8(8), uint32(512), uint16(17), uint16(0), uint8(100), uint8(40), leaf, uint16(1), uint64(1), uint32(0)).Bytes())
+	offsets["attributeTree"] = btree
+
+	var filters le
+	filters.put(uint8(1), uint8(2), make([]byte, 6))
+	filters.put(uint16(2), uint16(0), uint16(0), uint16(1), uint32(4), uint32(0))
+	filters.put(uint16(1), uint16(0), uint16(0), uint16(1), uint32(4), uint32(0))
+	tempHeader := f.objectHeader(false,
+		h5TestMessage{0x01, h5Space(nil, 3, 4)},
+		h5TestMessage{0x03, h5Float(4)},
+		h5TestMessage{0x08, new(le).put(uint8(3), uint8(2), uint8(3), chunkTree, uint32(2), uint32(4), uint32(4)).Bytes()},
+		h5TestMessage{0x0b, filters.Bytes()},
+		h5TestMessage{0x0c, h5AttributeMessage(1, "_FillValue", h5Float(4), h5Space(nil), new(le).put(float32(math.NaN())).Bytes())},
+		h5TestMessage{0x0c, h5AttributeMessage(1, "DIMENSION_LIST", refType, h5Space(nil, 2), dimList)},
+		h5TestMessage{0x15, new(le).put(uint8(0), uint8(0), heapHeader, btree).Bytes()},
+	)
+
+	// the root group lists the variables in a symbol table
+	names := new(le).put(uint64(0), "temp", uint8(0)).pad(8)
+	timeName := uint64(names.Len()) //nolint:gosec
+	names.put("time", uint8(0)).pad(8)
+	xName := uint64(names.Len()) //nolint:gosec
+	names.put("x", uint8(0)).pad(8)
+	namesAddr := f.alloc(names.Bytes())
+	localHeap := f.alloc(new(le).put("HEAP", uint8(0), []byte{0, 0, 0}, uint64(names.Len()), h5Undefined, namesAddr).Bytes()) //nolint:gosec
+	var snod le
+	snod.put("SNOD", uint8(1), uint8(0), uint16(3))
+	for _, entry := range [][2]uint64{{8, tempHeader}, {timeName, timeHeader}, {xName, xHeader}} {
+		snod.put(entry[0], entry[1], uint32(0), uint32(0), make([]byte, 16))
+	}
+	symbols := f.alloc(snod.Bytes())
+	groupTree := f.alloc(new(le).put("TREE", uint8(0), uint8(0), uint16(1), h5Undefined, h5Undefined, uint64(0), symbols, xName).Bytes())
+	root := f.objectHeader(false,
+		h5TestMessage{0x11, new(le).put(groupTree, localHeap).Bytes()},
+		h5StringAttribute("Conventions", "CF-1.8"),
+		h5StringAttribute("_NCProperties", "version=2,netcdf=4.9.2"),
+	)
+
+	out := f.Bytes()
+	var sb le
+	sb.put(hdf5Signature, []byte{0, 0, 0, 0, 0, 8, 8, 0}, uint16(4), uint16(16), uint32(0))
+	sb.put(uint64(0), h5Undefined, uint64(len(out)), h5Undefined, uint64(0), root, uint32(1), uint32(0), groupTree, localHeap)
+	copy(out, sb.Bytes())
+	return out, offsets
+}
+
+func TestReadHDF5(t *testing.T) {
+	data, offsets := encodeHDF5(t)
+	f, err := readNetCDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := json.Marshal(f.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"format":"netCDF-4","conventions":"CF-1.8",` +
+		`"dimensions":[{"name":"time","size":3,"unlimited":true},{"name":"x","size":4}],` +
+		`"variables":[` +
+		`{"name":"temp","dimensions":["time","x"],"shape":[3,4],"dataType":"float","chunks":[2,4],"filters":["shuffle","deflate"],"attributes":{"_FillValue":"NaN","long_name":"temperature"}},` +
+		`{"name":"time","dimensions":["time"],"shape":[3],"dataType":"double","attributes":{"units":"days since 2000-01-01"}}],` +
+		`"attributes":{"Conventions":"CF-1.8"}}`
+	if string(info) != want {
+		t.Errorf("got  %s\nwant %s", info, want)
+	}
+
+	refs, err := f.references("https://example.com/data.nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"temp/.zarray": `{"chunks":[2,4],"compressor":null,"dtype":"<f4","fill_value":"NaN","filters":[{"elementsize":4,"id":"shuffle"},{"id":"zlib","level":4}],"order":"C","shape":[3,4],"zarr_format":2}`,
+		"temp/.zattrs": `{"_ARRAY_DIMENSIONS":["time","x"],"long_name":"temperature"}`,
+		"temp/0.0":     fmt.Sprint([]interface{}{"{{u}}", int64(offsets["chunk0"]), int64(22)}),
+		"temp/1.0":     fmt.Sprint([]interface{}{"{{u}}", int64(offsets["chunk1"]), int64(6)}),
+		"time/0":       fmt.Sprint([]interface{}{"{{u}}", int64(offsets["time"]), int64(24)}),
+	} {
+		if got := fmt.Sprint(refs.Refs[key]); got != want {
+			t.Errorf("%s: got %s, want %s", key, got, want)
+		}
+	}
+	if _, ok := refs.Refs["x/.zarray"]; ok {
+		t.Error("expected no array for a dimension without variable")
+	}
+}
+
+func TestReadHDF5Cyclic(t *testing.T) {
+	// a datatype message shared with the object header it is part of
+	f := newH5File()
+	self := uint64(f.Len()) //nolint:gosec
+	shared := new(le).put(uint8(1), uint8(0), make([]byte, 6), self).Bytes()
+	f.alloc(new(le).put(uint8(1), uint8(0), uint16(1), uint32(1), uint32(8+len(shared)), uint32(0),
+		uint16(h5MsgDatatype), uint16(len(shared)), uint8(0x02), []byte{0, 0, 0}, shared).Bytes()) //nolint:gosec
+	data := f.Bytes()
+	var sb le
+	sb.put(hdf5Signature, []byte{0, 0, 0, 0, 0, 8, 8, 0}, uint16(4), uint16(16), uint32(0))
+	sb.put(uint64(0), h5Undefined, uint64(len(data)), h5Undefined, uint64(0), self, uint32(0), uint32(0), make([]byte, 16))
+	copy(data, sb.Bytes())
+	if _, err := readNetCDF(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("expected an error for a cyclic shared message")
+	}
+
+	// a chunk tree listing itself as its child
+	data, offsets := encodeHDF5(t)
+	data[offsets["chunkTree"]+5] = 1
+	binary.LittleEndian.PutUint64(data[offsets["chunkTree"]+24+32:], offsets["chunkTree"])
+	nc, err := readNetCDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.references("u"); err == nil {
+		t.Error("expected an error for a cyclic chunk tree")
+	}
+
+	// attribute trees without room for records
+	for field, value := range map[int]uint32{6: 10, 10: 0} {
+		data, offsets := encodeHDF5(t)
+		tree := int(offsets["attributeTree"]) //nolint:gosec
+		if field == 6 {
+			binary.LittleEndian.PutUint32(data[tree+field:], value)
+		} else {
+			binary.LittleEndian.PutUint16(data[tree+field:], uint16(value)) //nolint:gosec
+		}
+		if _, err := readNetCDF(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("expected an error for an attribute tree with %d at %d", value, field)
+		}
+	}
+}
+
+// encodeCDF writes a classic file with the record variables temp(time, x)
+// and flag(time) and the fixed variable x(x).
+func encodeCDF() []byte {
+	var w bytes.Buffer
+	put := func(values ...interface{}) {
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				_ = binary.Write(&w, binary.BigEndian, uint32(len(s))) //nolint:gosec
+				w.WriteString(s)
+				for w.Len()%4 != 0 {
+					w.WriteByte(0)
+				}
+				continue
+			}
+			_ = binary.Write(&w, binary.BigEndian, v)
+		}
+	}
+
+	put([]byte("CDF\x01"), uint32(2))
+	put(uint32(cdfDimensionTag), uint32(2), "time", uint32(0), "x", uint32(4))
+	put(uint32(cdfAttributeTag), uint32(1), "Conventions", uint32(2), "CF-1.8")
+	put(uint32(cdfVariableTag), uint32(3))
+	var begins []int
+	put("x", uint32(1), uint32(1), uint32(0), uint32(0), uint32(5), uint32(16))
+	begins = append(begins, w.Len())
+	put(uint32(0))
+	put("temp", uint32(2), uint32(0), uint32(1),
+		uint32(cdfAttributeTag), uint32(2), "units", uint32(2), "K", "_FillValue", uint32(5), uint32(1), float32(-999),
+		uint32(5), uint32(16))
+	begins = append(begins, w.Len())
+	put(uint32(0))
+	put("flag", uint32(1), uint32(0), uint32(0), uint32(0), uint32(1), uint32(4))
+	begins = append(begins, w.Len())
+	put(uint32(0))
+	header := w.Len()
+
+	out := w.Bytes()
+	// x at the end of the header, then the records of temp and flag
+	for i, begin := range []int{header, header + 16, header + 32} {
+		binary.BigEndian.PutUint32(out[begins[i]:], uint32(begin)) //nolint:gosec
+	}
+	return append(out, make([]byte, 16+2*20)...)
+}
+
+func TestReadCDF(t *testing.T) {
+	data := encodeCDF()
+	f, err := readNetCDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := json.Marshal(f.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"format":"classic","conventions":"CF-1.8",` +
+		`"dimensions":[{"name":"time","size":2,"unlimited":true},{"name":"x","size":4}],` +
+		`"variables":[` +
+		`{"name":"x","dimensions":["x"],"shape":[4],"dataType":"float"},` +
+		`{"name":"temp","dimensions":["time","x"],"shape":[2,4],"dataType":"float","attributes":{"_FillValue":-999,"units":"K"}},` +
+		`{"name":"flag","dimensions":["time"],"shape":[2],"dataType":"byte"}],` +
+		`"attributes":{"Conventions":"CF-1.8"}}`
+	if string(info) != want {
+		t.Errorf("got  %s\nwant %s", info, want)
+	}
+
+	refs, err := f.references("u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := int64(len(data) - 16 - 40)
+	for key, want := range map[string]string{
+		"x/0":          fmt.Sprint([]interface{}{"{{u}}", header, int64(16)}),
+		"temp/0.0":     fmt.Sprint([]interface{}{"{{u}}", header + 16, int64(16)}),
+		"temp/1.0":     fmt.Sprint([]interface{}{"{{u}}", header + 36, int64(16)}),
+		"flag/1":       fmt.Sprint([]interface{}{"{{u}}", header + 52, int64(1)}),
+		"temp/.zarray": `{"chunks":[1,4],"compressor":null,"dtype":">f4","fill_value":-999,"filters":null,"order":"C","shape":[2,4],"zarr_format":2}`,
+	} {
+		if got := fmt.Sprint(refs.Refs[key]); got != want {
+			t.Errorf("%s: got %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestReferencesBounded(t *testing.T) {
+	data := encodeCDF()
+	// claim more records than the file holds
+	binary.BigEndian.PutUint32(data[4:], 200_000_000)
+	f, err := readNetCDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.references("u"); err == nil {
+		t.Error("expected an error for records beyond the end of the file")
+	}
+
+	if _, err := gridIndices([]int64{1 << 40, 1 << 40}, []int64{1, 1}); !errors.Is(err, errTooManyChunks) {
+		t.Errorf("expected too many chunks, got %v", err)
+	}
+	if indices, err := gridIndices([]int64{4, 3}, []int64{2, 2}); err != nil || len(indices) != 4 {
+		t.Errorf("unexpected indices %v %v", indices, err)
+	}
+}
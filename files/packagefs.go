@@ -0,0 +1,254 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// PackageFs is a read-only afero.Fs presenting the items of a package, files
+// or directories anywhere in a base file system, as the entries of its root,
+// named after the last elements of their paths.
+type PackageFs struct {
+	base  afero.Fs
+	name  string
+	items []string
+}
+
+// NewPackageFs creates a PackageFs named name of the items at paths in base,
+// whose last elements must be unique.
+func NewPackageFs(base afero.Fs, name string, paths []string) *PackageFs {
+	items := make([]string, len(paths))
+	for i, p := range paths {
+		items[i] = path.Clean("/" + p)
+	}
+	return &PackageFs{base: base, name: name, items: items}
+}
+
+// resolve returns the path in the base file system of name, or "" for the
+// root.
+func (p *PackageFs) resolve(op, name string) (string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", nil
+	}
+
+	first, rest, _ := strings.Cut(name[1:], "/")
+	for _, item := range p.items {
+		if path.Base(item) == first {
+			return path.Join(item, rest), nil
+		}
+	}
+	return "", &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// RealPath returns the real path of name in the base file system.
+func (p *PackageFs) RealPath(name string) (string, error) {
+	resolved, err := p.resolve("realpath", name)
+	if err != nil {
+		return "", err
+	}
+	if realPathFs, ok := p.base.(interface {
+		RealPath(name string) (string, error)
+	}); ok {
+		return realPathFs.RealPath(resolved)
+	}
+	return resolved, nil
+}
+
+func (p *PackageFs) Name() string {
+	return "PackageFs"
+}
+
+func (p *PackageFs) Open(name string) (afero.File, error) {
+	resolved, err := p.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == "" {
+		return &packageRoot{fs: p}, nil
+	}
+	f, err := p.base.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return &packageFile{File: f, name: path.Clean("/" + name)}, nil
+}
+
+func (p *PackageFs) OpenFile(name string, flag int, _ os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	return p.Open(name)
+}
+
+func (p *PackageFs) Stat(name string) (os.FileInfo, error) {
+	resolved, err := p.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == "" {
+		return p.rootInfo(), nil
+	}
+	return p.base.Stat(resolved)
+}
+
+func (p *PackageFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	resolved, err := p.resolve("lstat", name)
+	if err != nil {
+		return nil, false, err
+	}
+	if resolved == "" {
+		return p.rootInfo(), false, nil
+	}
+	if lstater, ok := p.base.(afero.Lstater); ok {
+		return lstater.LstatIfPossible(resolved)
+	}
+	info, err := p.base.Stat(resolved)
+	return info, false, err
+}
+
+// rootInfo describes the root, modified when its latest item was.
+func (p *PackageFs) rootInfo() os.FileInfo {
+	info := packageRootInfo{name: p.name}
+	for _, item := range p.items {
+		if stat, err := p.base.Stat(item); err == nil && stat.ModTime().After(info.modTime) {
+			info.modTime = stat.ModTime()
+		}
+	}
+	return info
+}
+
+func (p *PackageFs) Create(name string) (afero.File, error) {
+	return nil, &os.PathError{Op: "create", Path: name, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) Mkdir(name string, _ os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) MkdirAll(name string, _ os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) RemoveAll(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) Rename(oldname, _ string) error {
+	return &os.PathError{Op: "rename", Path: oldname, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) Chmod(name string, _ os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) Chown(name string, _, _ int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: syscall.EPERM}
+}
+
+func (p *PackageFs) Chtimes(name string, _, _ time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+// packageFile is a file of a package, named by its path in the package.
+type packageFile struct {
+	afero.File
+	name string
+}
+
+func (f *packageFile) Name() string {
+	return f.name
+}
+
+type packageRootInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (i packageRootInfo) Name() string       { return i.name }
+func (i packageRootInfo) Size() int64        { return 0 }
+func (i packageRootInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (i packageRootInfo) ModTime() time.Time { return i.modTime }
+func (i packageRootInfo) IsDir() bool        { return true }
+func (i packageRootInfo) Sys() interface{}   { return nil }
+
+// packageRoot is the root directory of a package, listing its items in
+// order. Items missing in the base file system are left out.
+type packageRoot struct {
+	fs     *PackageFs
+	offset int
+}
+
+func (r *packageRoot) Readdir(count int) ([]os.FileInfo, error) {
+	infos := []os.FileInfo{}
+	for r.offset < len(r.fs.items) && (count <= 0 || len(infos) < count) {
+		item := r.fs.items[r.offset]
+		r.offset++
+
+		info, err := r.fs.base.Stat(item)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return infos, err
+		}
+		infos = append(infos, info)
+	}
+	if count > 0 && len(infos) == 0 {
+		return infos, io.EOF
+	}
+	return infos, nil
+}
+
+func (r *packageRoot) Readdirnames(count int) ([]string, error) {
+	infos, err := r.Readdir(count)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
+}
+
+func (r *packageRoot) Stat() (os.FileInfo, error) { return r.fs.rootInfo(), nil }
+func (r *packageRoot) Name() string               { return "/" }
+func (r *packageRoot) Close() error               { return nil }
+func (r *packageRoot) Sync() error                { return nil }
+
+func (r *packageRoot) Read([]byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: "/", Err: syscall.EISDIR}
+}
+
+func (r *packageRoot) ReadAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: "/", Err: syscall.EISDIR}
+}
+
+func (r *packageRoot) Seek(int64, int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: "/", Err: syscall.EISDIR}
+}
+
+func (r *packageRoot) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "/", Err: syscall.EPERM}
+}
+
+func (r *packageRoot) WriteAt([]byte, int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "/", Err: syscall.EPERM}
+}
+
+func (r *packageRoot) WriteString(string) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "/", Err: syscall.EPERM}
+}
+
+func (r *packageRoot) Truncate(int64) error {
+	return &os.PathError{Op: "truncate", Path: "/", Err: syscall.EPERM}
+}
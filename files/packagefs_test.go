@@ -0,0 +1,58 @@
+package files
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestPackageFs(t *testing.T) {
+	base := afero.NewMemMapFs()
+	writeZarrFixture(t, base, map[string]string{
+		"/raw/2023/a.tif":       "tiff",
+		"/docs/README.md":       "readme",
+		"/labels/x.json":        "{}",
+		"/labels/sub/y.json":    "{}",
+		"/private/secret.txt":   "secret",
+		"/raw/2023/unshared.md": "no",
+	})
+	pfs := NewPackageFs(afero.NewBasePathFs(base, "/"), "curated", []string{"raw/2023/a.tif", "/docs/README.md", "/labels/", "/missing.txt"})
+
+	root, err := NewFileInfo(&FileOptions{Fs: pfs, Path: "/", Expand: true, Checker: denyPrefix("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, item := range root.Items {
+		names = append(names, item.Name)
+	}
+	if root.Name != "curated" || !root.IsDir || strings.Join(names, " ") != "README.md a.tif labels" {
+		t.Errorf("unexpected root %s with items %v", root.Name, names)
+	}
+
+	content, err := afero.ReadFile(pfs, "/labels/sub/y.json")
+	if err != nil || string(content) != "{}" {
+		t.Errorf("unexpected content %q: %v", content, err)
+	}
+	if info, err := pfs.Stat("/a.tif"); err != nil || info.Size() != 4 {
+		t.Errorf("unexpected stat %v: %v", info, err)
+	}
+	if real, err := pfs.RealPath("/labels/sub"); err != nil || real != "/labels/sub" {
+		t.Errorf("unexpected real path %q: %v", real, err)
+	}
+
+	for _, name := range []string{"/unshared.md", "/private/secret.txt", "/raw/2023/a.tif", "/missing.txt", "/../private/secret.txt"} {
+		if _, err := pfs.Open(name); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to exist, got %v", name, err)
+		}
+	}
+
+	if err := afero.WriteFile(pfs, "/a.tif", []byte("changed"), PermFile); !os.IsPermission(err) {
+		t.Errorf("expected the package to be read-only, got %v", err)
+	}
+	if err := pfs.RemoveAll("/labels"); !os.IsPermission(err) {
+		t.Errorf("expected the package to be read-only, got %v", err)
+	}
+}
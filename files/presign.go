@@ -1,24 +1,94 @@
 package files
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
 )
 
+// DefaultPresignExpiry is the lifetime of presigned URLs when nothing else is
+// configured. It is also the longest lifetime S3 accepts for SigV4 signatures.
+const DefaultPresignExpiry = 7 * 24 * time.Hour
+
+// PresignOptions are the options when presigning a path.
+type PresignOptions struct {
+	Method string
+	Expiry time.Duration
+	// Cutoff is a unix timestamp. If set, the object version that was
+	// current at that time is presigned instead of the latest one.
+	Cutoff int64
+}
+
+// S3Connection presigns objects of S3 and S3-compatible stores.
 type S3Connection struct {
-	s3           *s3.S3
+	client       *s3.Client
 	bucketName   string
 	bucketPrefix string
 }
 
+func (conn *S3Connection) Presign(ctx context.Context, path, method string, cutoff int64, expiry time.Duration) (string, error) {
+	if conn == nil || conn.client == nil {
+		return "", fmt.Errorf("skip presign without valid S3 connection for '%s'", path)
+	}
+
+	bucket, key, err := conn.objectLocation(path)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("presigning (bucket: '%s', key: '%s')", bucket, key)
+
+	var versionID *string
+	if cutoff > 0 {
+		versionID, err = conn.versionAt(ctx, bucket, key, time.Unix(cutoff, 0))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if expiry <= 0 || expiry > DefaultPresignExpiry {
+		expiry = DefaultPresignExpiry
+	}
+
+	presigner := s3.NewPresignClient(conn.client, s3.WithPresignExpires(expiry))
+
+	var req *v4.PresignedHTTPRequest
+	if method == http.MethodHead {
+		req, err = presigner.PresignHeadObject(ctx, &s3.HeadObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: versionID,
+		})
+	} else {
+		req, err = presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: versionID,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return req.URL, nil
+}
+
+// objectLocation resolves the bucket and the key of path, taking the bucket
+// name override and the bucket prefix of the connection into account.
+func (conn *S3Connection) objectLocation(path string) (bucket, key string, err error) {
+	return objectLocation(conn.bucketName, conn.bucketPrefix, path)
+}
+
 /*
 Edge Case Coverage for Presign:
 
@@ -32,29 +102,25 @@ Edge Case Coverage for Presign:
 | ""                     | ""                 | -                | -                      | invalid     |
 */
 
-func (conn *S3Connection) Presign(path, method string, cutoff int64) (string, error) {
-	if conn == nil || conn.s3 == nil {
-		return "", fmt.Errorf("skip presign without valid S3 connection for '%s'", path)
-	}
-	var bucket, key string
-	bucketNameOverride := strings.TrimSpace(strings.Trim(conn.bucketName, "/"))
+// objectLocation resolves the bucket (or container) and the key of path. If
+// bucketName is empty, the first path segment is the bucket.
+func objectLocation(bucketName, bucketPrefix, path string) (bucket, key string, err error) {
+	bucketNameOverride := strings.TrimSpace(strings.Trim(bucketName, "/"))
 	trimmedPath := strings.TrimPrefix(path, "/")
 
 	if bucketNameOverride == "" {
 		if trimmedPath == "" {
-			return "", fmt.Errorf("skip presign without valid path for '%s'", path)
+			return "", "", fmt.Errorf("skip presign without valid path for '%s'", path)
 		}
 		segments := strings.Split(trimmedPath, "/")
 		if len(segments) == 0 || segments[0] == "" {
-			return "", fmt.Errorf("skip presign with invalid path for '%s'", path)
+			return "", "", fmt.Errorf("skip presign with invalid path for '%s'", path)
 		}
 		bucket = segments[0]
 		key = strings.Join(segments[1:], "/")
 	} else {
 		bucket = bucketNameOverride
-		if trimmedPath == "" {
-			key = ""
-		} else {
+		if trimmedPath != "" {
 			segments := strings.Split(trimmedPath, "/")
 			if segments[0] == bucketNameOverride {
 				key = strings.Join(segments[1:], "/")
@@ -66,54 +132,78 @@ func (conn *S3Connection) Presign(path, method string, cutoff int64) (string, er
 
 	key = strings.TrimPrefix(key, "/")
 	if key == "" {
-		return "", fmt.Errorf("skip presign with empty path for '%s'", path)
+		return "", "", fmt.Errorf("skip presign with empty path for '%s'", path)
 	}
 
-	if conn.bucketPrefix != "" {
-		key = strings.TrimSuffix(conn.bucketPrefix, "/") + "/" + key
+	if bucketPrefix != "" {
+		key = strings.TrimSuffix(bucketPrefix, "/") + "/" + key
 	}
 
-	log.Printf("presigning (bucket: '%s', key: '%s')", bucket, key)
-
-	var getObjectInput *s3.GetObjectInput
-
-	if cutoff > 0 {
-		cutoffTime := time.Unix(cutoff, 0)
+	return bucket, key, nil
+}
 
-		listObjectVersionsInput := &s3.ListObjectVersionsInput{
-			Bucket: aws.String(bucket),
-			Prefix: aws.String(key),
+// versionAt returns the id of the object version that was current at cutoff.
+// If the object did not exist at that time or was deleted, ErrNotExist is
+// returned.
+func (conn *S3Connection) versionAt(ctx context.Context, bucket, key string, cutoff time.Time) (*string, error) {
+	var (
+		current  *time.Time
+		id       *string
+		isMarker bool
+	)
+
+	consider := func(k, versionID *string, lastModified *time.Time, marker bool) {
+		if aws.ToString(k) != key || lastModified == nil || lastModified.After(cutoff) {
+			return
 		}
+		if current == nil || lastModified.After(*current) {
+			current, id, isMarker = lastModified, versionID, marker
+		}
+	}
 
-		listOutput, err := conn.s3.ListObjectVersions(listObjectVersionsInput)
+	paginator := s3.NewListObjectVersionsPaginator(conn.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return "", fmt.Errorf("failed to list object versions: %w", err)
+			return nil, fmt.Errorf("failed to list object versions: %w", err)
 		}
-
-		for _, version := range listOutput.Versions {
-			if version.LastModified != nil && version.LastModified.After(cutoffTime) {
-				continue
-			}
-			getObjectInput = &s3.GetObjectInput{
-				Bucket:    aws.String(bucket),
-				Key:       aws.String(key),
-				VersionId: version.VersionId,
-			}
-			break
+		for _, v := range page.Versions {
+			consider(v.Key, v.VersionId, v.LastModified, false)
+		}
+		for _, m := range page.DeleteMarkers {
+			consider(m.Key, m.VersionId, m.LastModified, true)
 		}
 	}
 
-	if getObjectInput == nil {
-		getObjectInput = &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+	if current == nil || isMarker {
+		return nil, fmt.Errorf("no version of '%s' at %s: %w", key, cutoff.UTC().Format(time.RFC3339), fbErrors.ErrNotExist)
+	}
+
+	return id, nil
+}
+
+// ParseAsOf parses a point in time given either as unix seconds or as an
+// RFC 3339 timestamp. An empty value results in 0.
+func ParseAsOf(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, fbErrors.ErrInvalidRequestParams
 		}
+		return seconds, nil
 	}
 
-	req, _ := conn.s3.GetObjectRequest(getObjectInput)
-	req.Operation.HTTPMethod = method
-	req.HTTPRequest.Method = method
-	return req.Presign(7 * 24 * time.Hour)
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid point in time %q: %w", value, fbErrors.ErrInvalidRequestParams)
+	}
+	return t.Unix(), nil
 }
 
 func getStringOrDefault(values map[string]string, key, defaultValue string) string {
@@ -123,28 +213,30 @@ func getStringOrDefault(values map[string]string, key, defaultValue string) stri
 	return defaultValue
 }
 
-func Presign(path, method string, envs map[string]string) (string, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Credentials: credentials.NewStaticCredentials(
-			getStringOrDefault(envs, "AWS_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
-			getStringOrDefault(envs, "AWS_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
-			"",
-		),
-		Endpoint:         aws.String(getStringOrDefault(envs, "AWS_ENDPOINT_URL", os.Getenv("AWS_ENDPOINT_URL"))),
-		Region:           aws.String(getStringOrDefault(envs, "AWS_REGION", os.Getenv("AWS_REGION"))),
-		S3ForcePathStyle: aws.Bool(true),
-	})
-	if err != nil {
-		return "", fmt.Errorf("could not create S3 session: %w", err)
+// PresignExpiry returns the lifetime of presigned URLs configured through the
+// PRESIGN_EXPIRY entry of envs or the fallback if it is missing or invalid.
+func PresignExpiry(envs map[string]string, fallback time.Duration) time.Duration {
+	value := getStringOrDefault(envs, "PRESIGN_EXPIRY", "")
+	if value == "" {
+		return fallback
 	}
 
-	conn := S3Connection{
-		s3:           s3.New(sess),
-		bucketName:   getStringOrDefault(envs, "BUCKET_NAME", ""),
-		bucketPrefix: getStringOrDefault(envs, "BUCKET_PREFIX", ""),
+	expiry, err := time.ParseDuration(value)
+	if err != nil || expiry <= 0 {
+		log.Printf("[WARN] Failed to parse PRESIGN_EXPIRY %q: %v", value, err)
+		return fallback
+	}
+	return expiry
+}
+
+// Presign presigns path with the presigner selected by envs.
+func Presign(ctx context.Context, path string, envs map[string]string, opts PresignOptions) (string, error) {
+	presigner, err := NewPresigner(ctx, envs)
+	if err != nil {
+		return "", err
 	}
 
-	url, err := conn.Presign(path, method, 0)
+	url, err := presigner.Presign(ctx, path, opts.Method, opts.Cutoff, opts.Expiry)
 	if err != nil {
 		return "", fmt.Errorf("could not presign object %q: %w", path, err)
 	}
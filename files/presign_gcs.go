@@ -0,0 +1,228 @@
+package files
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/lru"
+)
+
+const defaultGCSEndpoint = "https://storage.googleapis.com"
+
+// GCSPresigner creates V4 signed URLs for Google Cloud Storage objects with
+// the key of a service account.
+type GCSPresigner struct {
+	endpoint     string
+	clientEmail  string
+	privateKey   *rsa.PrivateKey
+	bucketName   string
+	bucketPrefix string
+	now          func() time.Time
+}
+
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// gcsPresignerKey identifies a cached GCS presigner by the envs it is built
+// from and the version of the credentials file, so that rotated credentials
+// are read again.
+type gcsPresignerKey struct {
+	credentialsFile string
+	modTime         int64
+	size            int64
+	endpoint        string
+	bucketName      string
+	bucketPrefix    string
+}
+
+var gcsPresigners = lru.New[gcsPresignerKey, *GCSPresigner](64)
+
+// newGCSPresigner returns the cached GCS presigner for envs and builds it
+// on first use, so that the key of the service account isn't read and
+// parsed per request.
+func newGCSPresigner(envs map[string]string) (*GCSPresigner, error) {
+	credentialsFile := getStringOrDefault(envs, "GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	if credentialsFile == "" {
+		return nil, fmt.Errorf("gcs presigning needs GOOGLE_APPLICATION_CREDENTIALS: %w", fbErrors.ErrInvalidOption)
+	}
+
+	info, err := os.Stat(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read gcs credentials: %w", err)
+	}
+	key := gcsPresignerKey{
+		credentialsFile: credentialsFile,
+		modTime:         info.ModTime().UnixNano(),
+		size:            info.Size(),
+		endpoint:        strings.TrimSuffix(getStringOrDefault(envs, "GCS_ENDPOINT_URL", defaultGCSEndpoint), "/"),
+		bucketName:      getStringOrDefault(envs, "BUCKET_NAME", ""),
+		bucketPrefix:    getStringOrDefault(envs, "BUCKET_PREFIX", ""),
+	}
+	if presigner, ok := gcsPresigners.Get(key); ok {
+		return presigner, nil
+	}
+
+	raw, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read gcs credentials: %w", err)
+	}
+
+	var account gcsServiceAccount
+	if err := json.Unmarshal(raw, &account); err != nil {
+		return nil, fmt.Errorf("could not parse gcs credentials: %w", err)
+	}
+
+	privateKey, err := parseRSAPrivateKey(account.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	presigner := &GCSPresigner{
+		endpoint:     key.endpoint,
+		clientEmail:  account.ClientEmail,
+		privateKey:   privateKey,
+		bucketName:   key.bucketName,
+		bucketPrefix: key.bucketPrefix,
+		now:          time.Now,
+	}
+	gcsPresigners.Add(key, presigner)
+	return presigner, nil
+}
+
+func parseRSAPrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("gcs credentials contain no PEM private key: %w", fbErrors.ErrInvalidOption)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse gcs private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("gcs private key is not an RSA key: %w", fbErrors.ErrInvalidOption)
+	}
+	return key, nil
+}
+
+// Presign implements Presigner. Object versions are not resolved for GCS, so
+// a cutoff is rejected.
+func (p *GCSPresigner) Presign(_ context.Context, path, method string, cutoff int64, expiry time.Duration) (string, error) {
+	if cutoff > 0 {
+		return "", fmt.Errorf("gcs presigning does not support points in time: %w", fbErrors.ErrInvalidOption)
+	}
+
+	bucket, key, err := objectLocation(p.bucketName, p.bucketPrefix, path)
+	if err != nil {
+		return "", err
+	}
+
+	if method == "" {
+		method = http.MethodGet
+	}
+	if expiry <= 0 || expiry > DefaultPresignExpiry {
+		expiry = DefaultPresignExpiry
+	}
+
+	endpoint, err := url.Parse(p.endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid gcs endpoint %q: %w", p.endpoint, err)
+	}
+
+	now := p.now().UTC()
+	datestamp := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	scope := datestamp + "/auto/storage/goog4_request"
+	resource := "/" + bucket + "/" + uriEncode(key, false)
+
+	query := map[string]string{
+		"X-Goog-Algorithm":     "GOOG4-RSA-SHA256",
+		"X-Goog-Credential":    p.clientEmail + "/" + scope,
+		"X-Goog-Date":          timestamp,
+		"X-Goog-Expires":       strconv.FormatInt(int64(expiry/time.Second), 10),
+		"X-Goog-SignedHeaders": "host",
+	}
+	canonicalQuery := canonicalQueryString(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		resource,
+		canonicalQuery,
+		"host:" + endpoint.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		timestamp,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign gcs url: %w", err)
+	}
+
+	return p.endpoint + resource + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
+
+// canonicalQueryString encodes query sorted by key as required by V4 signing.
+func canonicalQueryString(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(query[k], true))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but RFC 3986 unreserved characters.
+// Slashes are kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
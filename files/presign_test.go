@@ -0,0 +1,410 @@
+package files
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+func testEnvs() map[string]string {
+	return map[string]string{
+		"AWS_ACCESS_KEY_ID":     "key",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_ENDPOINT_URL":      "http://localhost:9000",
+		"AWS_REGION":            "us-east-1",
+		"BUCKET_NAME":           "bucket",
+	}
+}
+
+func TestPresign(t *testing.T) {
+	testCases := map[string]struct {
+		path    string
+		expiry  time.Duration
+		wantKey string
+		wantTTL string
+	}{
+		"key without bucket":      {path: "/key1.txt", expiry: time.Hour, wantKey: "/bucket/key1.txt", wantTTL: "3600"},
+		"key with bucket":         {path: "/bucket/key1.txt", expiry: time.Hour, wantKey: "/bucket/key1.txt", wantTTL: "3600"},
+		"default expiry":          {path: "/key1.txt", wantKey: "/bucket/key1.txt", wantTTL: "604800"},
+		"expiry above s3 maximum": {path: "/key1.txt", expiry: 30 * 24 * time.Hour, wantKey: "/bucket/key1.txt", wantTTL: "604800"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			raw, err := Presign(context.Background(), tc.path, testEnvs(), PresignOptions{Method: "GET", Expiry: tc.expiry})
+			if err != nil {
+				t.Fatalf("presign failed: %v", err)
+			}
+
+			u, err := url.Parse(raw)
+			if err != nil {
+				t.Fatalf("invalid presigned url %q: %v", raw, err)
+			}
+			if u.Path != tc.wantKey {
+				t.Errorf("expected path %q, got %q", tc.wantKey, u.Path)
+			}
+			if got := u.Query().Get("X-Amz-Expires"); got != tc.wantTTL {
+				t.Errorf("expected expiry %s, got %s", tc.wantTTL, got)
+			}
+		})
+	}
+}
+
+func TestPresignInvalidPath(t *testing.T) {
+	envs := testEnvs()
+	delete(envs, "BUCKET_NAME")
+
+	for _, p := range []string{"", "/", "/bucket/"} {
+		if _, err := Presign(context.Background(), p, envs, PresignOptions{Method: "GET"}); err == nil {
+			t.Errorf("expected error presigning %q", p)
+		}
+	}
+}
+
+func TestS3ConnectionCache(t *testing.T) {
+	envs := testEnvs()
+
+	first, err := getS3Connection(context.Background(), envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := getS3Connection(context.Background(), envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("expected the connection to be reused")
+	}
+
+	envs["AWS_SECRET_ACCESS_KEY"] = "rotated"
+	rotated, err := getS3Connection(context.Background(), envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == first {
+		t.Error("expected a new connection for changed envs")
+	}
+
+	InvalidateS3Connection(envs)
+	fresh, err := getS3Connection(context.Background(), envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == rotated {
+		t.Error("expected a new connection after invalidation")
+	}
+}
+
+func TestCredentialsProvider(t *testing.T) {
+	testCases := map[string]struct {
+		envs    map[string]string
+		wantErr bool
+	}{
+		"static":                     {envs: map[string]string{"AWS_CREDENTIALS_PROVIDER": CredentialsStatic}},
+		"default":                    {envs: map[string]string{"AWS_CREDENTIALS_PROVIDER": CredentialsDefault}},
+		"unknown":                    {envs: map[string]string{"AWS_CREDENTIALS_PROVIDER": "magic"}, wantErr: true},
+		"assume role without arn":    {envs: map[string]string{"AWS_CREDENTIALS_PROVIDER": CredentialsAssumeRole}, wantErr: true},
+		"web identity without token": {envs: map[string]string{"AWS_CREDENTIALS_PROVIDER": CredentialsWebIdentity, "AWS_ROLE_ARN": "arn:aws:iam::1:role/r"}, wantErr: true},
+		"assume role with tags": {envs: map[string]string{
+			"AWS_CREDENTIALS_PROVIDER": CredentialsAssumeRole,
+			"AWS_ROLE_ARN":             "arn:aws:iam::1:role/r",
+			"AWS_ROLE_SESSION_TAGS":    "project=a;team=b",
+		}},
+		"assume role with invalid tags": {envs: map[string]string{
+			"AWS_CREDENTIALS_PROVIDER": CredentialsAssumeRole,
+			"AWS_ROLE_ARN":             "arn:aws:iam::1:role/r",
+			"AWS_ROLE_SESSION_TAGS":    "=a",
+		}, wantErr: true},
+		"static without keys": {envs: map[string]string{
+			"AWS_CREDENTIALS_PROVIDER": CredentialsStatic,
+			"AWS_ACCESS_KEY_ID":        "",
+			"AWS_SECRET_ACCESS_KEY":    "",
+		}, wantErr: true},
+		"static without secret": {envs: map[string]string{
+			"AWS_CREDENTIALS_PROVIDER": CredentialsStatic,
+			"AWS_SECRET_ACCESS_KEY":    "",
+		}, wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			envs := testEnvs()
+			for k, v := range tc.envs {
+				envs[k] = v
+			}
+			_, err := getS3Connection(context.Background(), envs)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseAsOf(t *testing.T) {
+	testCases := map[string]struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		"empty":        {value: "", want: 0},
+		"unix seconds": {value: "1700000000", want: 1700000000},
+		"rfc3339":      {value: "2023-11-14T22:13:20Z", want: 1700000000},
+		"negative":     {value: "-1", wantErr: true},
+		"garbage":      {value: "yesterday", wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseAsOf(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionAt(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := gofakes3.FixedTimeSource(start)
+	backend := s3mem.New(s3mem.WithTimeSource(clock))
+	if err := backend.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.SetVersioningConfiguration("bucket", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(key, content string) string {
+		result, err := backend.PutObject("bucket", key, nil, strings.NewReader(content), int64(len(content)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(result.VersionID)
+	}
+	first := put("data/a.txt", "first")
+	clock.Advance(30 * time.Minute)
+	put("data/a.txt.bak", "backup")
+	clock.Advance(30 * time.Minute)
+	second := put("data/a.txt", "second")
+	clock.Advance(time.Hour)
+	if _, err := backend.DeleteObject("bucket", "data/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(gofakes3.New(backend, gofakes3.WithTimeSkewLimit(0)).Server())
+	t.Cleanup(server.Close)
+	envs := testEnvs()
+	envs["AWS_ENDPOINT_URL"] = server.URL
+	t.Cleanup(func() { InvalidateS3Connection(envs) })
+	conn, err := getS3Connection(context.Background(), envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		at   time.Duration
+		want string
+	}{
+		"before the first version": {at: -time.Minute},
+		"first version":            {at: 45 * time.Minute, want: first},
+		"second version":           {at: 90 * time.Minute, want: second},
+		"deleted":                  {at: 3 * time.Hour},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			id, err := conn.versionAt(context.Background(), "bucket", "data/a.txt", start.Add(tc.at))
+			if tc.want == "" {
+				if !errors.Is(err, fbErrors.ErrNotExist) {
+					t.Errorf("expected no version, got %v: %v", aws.ToString(id), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aws.ToString(id) != tc.want {
+				t.Errorf("expected version %s, got %s", tc.want, aws.ToString(id))
+			}
+		})
+	}
+}
+
+func TestPresignExpiry(t *testing.T) {
+	fallback := time.Hour
+	if got := PresignExpiry(map[string]string{}, fallback); got != fallback {
+		t.Errorf("expected fallback, got %s", got)
+	}
+	if got := PresignExpiry(map[string]string{"PRESIGN_EXPIRY": "15m"}, fallback); got != 15*time.Minute {
+		t.Errorf("expected 15m, got %s", got)
+	}
+	if got := PresignExpiry(map[string]string{"PRESIGN_EXPIRY": "soon"}, fallback); got != fallback {
+		t.Errorf("expected fallback for invalid value, got %s", got)
+	}
+}
+
+func TestGCSPresigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	account, err := json.Marshal(gcsServiceAccount{ClientEmail: "sa@project.iam.gserviceaccount.com", PrivateKey: string(pemKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsFile := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(credentialsFile, account, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	presigner, err := NewPresigner(context.Background(), map[string]string{
+		"STORAGE_PROVIDER":               ProviderGCS,
+		"GOOGLE_APPLICATION_CREDENTIALS": credentialsFile,
+		"BUCKET_NAME":                    "bucket",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcs := presigner.(*GCSPresigner)
+	gcs.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	raw, err := gcs.Presign(context.Background(), "/dir/a b.tif", "GET", 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "storage.googleapis.com" || u.EscapedPath() != "/bucket/dir/a%20b.tif" {
+		t.Errorf("unexpected url %s", raw)
+	}
+	q := u.Query()
+	if q.Get("X-Goog-Date") != "20240102T030405Z" || q.Get("X-Goog-Expires") != "3600" {
+		t.Errorf("unexpected query %v", q)
+	}
+	if q.Get("X-Goog-Credential") != "sa@project.iam.gserviceaccount.com/20240102/auto/storage/goog4_request" {
+		t.Errorf("unexpected credential %s", q.Get("X-Goog-Credential"))
+	}
+
+	signature, err := hex.DecodeString(q.Get("X-Goog-Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonicalRequest := "GET\n/bucket/dir/a%20b.tif\n" + strings.Split(u.RawQuery, "&X-Goog-Signature=")[0] +
+		"\nhost:storage.googleapis.com\n\nhost\nUNSIGNED-PAYLOAD"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "GOOG4-RSA-SHA256\n20240102T030405Z\n20240102/auto/storage/goog4_request\n" + hex.EncodeToString(requestHash[:])
+	digest := sha256.Sum256([]byte(stringToSign))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("invalid signature: %v", err)
+	}
+
+	if _, err := gcs.Presign(context.Background(), "/a.tif", "GET", 1, time.Hour); err == nil {
+		t.Error("expected points in time to be rejected")
+	}
+
+	envs := map[string]string{
+		"STORAGE_PROVIDER":               ProviderGCS,
+		"GOOGLE_APPLICATION_CREDENTIALS": credentialsFile,
+		"BUCKET_NAME":                    "bucket",
+	}
+	if cached, err := NewPresigner(context.Background(), envs); err != nil || cached != presigner {
+		t.Errorf("expected the cached presigner, got %v: %v", cached, err)
+	}
+	// rotated credentials are read again
+	if err := os.Chtimes(credentialsFile, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if rotated, err := NewPresigner(context.Background(), envs); err != nil || rotated == presigner {
+		t.Errorf("expected a new presigner, got %v: %v", rotated, err)
+	}
+}
+
+func TestAzurePresigner(t *testing.T) {
+	accountKey := base64.StdEncoding.EncodeToString([]byte("secret"))
+	presigner, err := NewPresigner(context.Background(), map[string]string{
+		"STORAGE_PROVIDER":      ProviderAzure,
+		"AZURE_STORAGE_ACCOUNT": "account",
+		"AZURE_STORAGE_KEY":     accountKey,
+		"BUCKET_NAME":           "container",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	azure := presigner.(*AzurePresigner)
+	azure.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	raw, err := azure.Presign(context.Background(), "/dir/a.tif", "GET", 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "account.blob.core.windows.net" || u.Path != "/container/dir/a.tif" {
+		t.Errorf("unexpected url %s", raw)
+	}
+	q := u.Query()
+	if q.Get("se") != "2024-01-02T04:04:05Z" || q.Get("sp") != "r" || q.Get("sr") != "b" || q.Get("sv") != azureSASVersion {
+		t.Errorf("unexpected query %v", q)
+	}
+
+	stringToSign := "r\n\n2024-01-02T04:04:05Z\n/blob/account/container/dir/a.tif\n\n\nhttps,http\n" + azureSASVersion + "\nb\n\n\n\n\n\n\n"
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(stringToSign))
+	if q.Get("sig") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Error("unexpected signature")
+	}
+}
+
+func TestUnknownStorageProvider(t *testing.T) {
+	if _, err := NewPresigner(context.Background(), map[string]string{"STORAGE_PROVIDER": "ftp"}); err == nil {
+		t.Error("expected unknown provider to be rejected")
+	}
+}
+
+func TestUploadPartSize(t *testing.T) {
+	testCases := map[string]struct {
+		size, preferred, want int64
+	}{
+		"small upload":             {size: 1024, preferred: 0, want: MinUploadPartSize},
+		"preferred part size":      {size: 1 << 30, preferred: 64 << 20, want: 64 << 20},
+		"too many preferred parts": {size: 100 << 30, preferred: MinUploadPartSize, want: (100<<30 + MaxUploadParts - 1) / MaxUploadParts},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := UploadPartSize(tc.size, tc.preferred); got != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
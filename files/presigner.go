@@ -0,0 +1,44 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+// Storage providers selectable through STORAGE_PROVIDER.
+const (
+	ProviderS3    = "s3"
+	ProviderGCS   = "gcs"
+	ProviderAzure = "azure"
+)
+
+// Presigner creates presigned URLs granting temporary access to the object
+// behind a path without routing the data through packageR. If cutoff is set,
+// the object version that was current at that time is presigned.
+type Presigner interface {
+	Presign(ctx context.Context, path, method string, cutoff int64, expiry time.Duration) (string, error)
+}
+
+var (
+	_ Presigner = &S3Connection{}
+	_ Presigner = &GCSPresigner{}
+	_ Presigner = &AzurePresigner{}
+)
+
+// NewPresigner returns the presigner for the STORAGE_PROVIDER of envs, which
+// defaults to S3.
+func NewPresigner(ctx context.Context, envs map[string]string) (Presigner, error) {
+	switch provider := getStringOrDefault(envs, "STORAGE_PROVIDER", ProviderS3); provider {
+	case ProviderS3:
+		return getS3Connection(ctx, envs)
+	case ProviderGCS:
+		return newGCSPresigner(envs)
+	case ProviderAzure:
+		return newAzurePresigner(envs)
+	default:
+		return nil, fmt.Errorf("unknown storage provider %q: %w", provider, fbErrors.ErrInvalidOption)
+	}
+}
@@ -0,0 +1,636 @@
+package files
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// RasterInfo describes a TIFF or GeoTIFF from its header.
+type RasterInfo struct {
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	Bands        int            `json:"bands"`
+	DataTypes    []string       `json:"dataTypes"`
+	Compression  string         `json:"compression"`
+	Interleave   string         `json:"interleave"`
+	Tiled        bool           `json:"tiled"`
+	TileWidth    int            `json:"tileWidth,omitempty"`
+	TileHeight   int            `json:"tileHeight,omitempty"`
+	Overviews    []RasterSize   `json:"overviews,omitempty"`
+	CRS          string         `json:"crs,omitempty"`
+	GeoTransform []float64      `json:"geoTransform,omitempty"`
+	Bounds       []float64      `json:"bounds,omitempty"`
+	NoData       string         `json:"nodata,omitempty"`
+	BigTIFF      bool           `json:"bigTiff,omitempty"`
+	COG          *COGValidation `json:"cog"`
+}
+
+// RasterSize is the size of an overview of a raster.
+type RasterSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// COGValidation tells whether a TIFF is a Cloud-Optimized GeoTIFF, following
+// the checks of GDAL's validate_cloud_optimized_geotiff.
+type COGValidation struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+const (
+	tagNewSubfileType        = 254
+	tagImageWidth            = 256
+	tagImageLength           = 257
+	tagBitsPerSample         = 258
+	tagCompression           = 259
+	tagStripOffsets          = 273
+	tagSamplesPerPixel       = 277
+	tagPlanarConfiguration   = 284
+	tagTileWidth             = 322
+	tagTileLength            = 323
+	tagTileOffsets           = 324
+	tagSampleFormat          = 339
+	tagModelPixelScale       = 33550
+	tagModelTiepoint         = 33922
+	tagModelTransformation   = 34264
+	tagGeoKeyDirectory       = 34735
+	tagGDALNoData            = 42113
+	geoKeyRasterType         = 1025
+	geoKeyGeographicType     = 2048
+	geoKeyProjectedCSType    = 3072
+	geoKeyUserDefined        = 32767
+	rasterPixelIsPoint       = 2
+	subfileReducedResolution = 1
+	subfileMask              = 4
+
+	// rasterBlockSize is the size of the ranged reads of a header.
+	rasterBlockSize = 64 << 10
+	// maxRasterIFDs and maxRasterEntries bound malformed headers.
+	maxRasterIFDs    = 64
+	maxRasterEntries = 4096
+	// maxRasterBands bounds the samples per pixel of a header.
+	maxRasterBands = 65535
+	// cogMinOverviewSize is the size from which a COG needs overviews.
+	cogMinOverviewSize = 512
+)
+
+var tiffCompressions = map[uint64]string{
+	1:     "none",
+	2:     "ccittrle",
+	5:     "lzw",
+	6:     "ojpeg",
+	7:     "jpeg",
+	8:     "deflate",
+	32773: "packbits",
+	32946: "deflate",
+	34887: "lerc",
+	34925: "lzma",
+	50000: "zstd",
+	50001: "webp",
+	50002: "jxl",
+}
+
+// blockReader caches the blocks of r it read, so that parsing a header with
+// many small reads costs few ranged requests on remote file systems.
+type blockReader struct {
+	r      io.ReaderAt
+	size   int64
+	blocks map[int64][]byte
+}
+
+func (b *blockReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > b.size {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := 0
+	for n < len(p) {
+		index := (off + int64(n)) / rasterBlockSize
+		block, ok := b.blocks[index]
+		if !ok {
+			start := index * rasterBlockSize
+			block = make([]byte, min(rasterBlockSize, b.size-start))
+			if _, err := b.r.ReadAt(block, start); err != nil && !errors.Is(err, io.EOF) {
+				return n, err
+			}
+			b.blocks[index] = block
+		}
+		n += copy(p[n:], block[off+int64(n)-index*rasterBlockSize:])
+	}
+	return n, nil
+}
+
+type tiffEntry struct {
+	typ    uint16
+	count  uint64
+	offset int64 // of the values
+}
+
+type tiffIFD struct {
+	offset  int64
+	entries map[uint16]tiffEntry
+}
+
+type tiffReader struct {
+	r     *blockReader
+	order binary.ByteOrder
+	big   bool
+}
+
+var tiffTypeSizes = map[uint16]int64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 16: 8, 17: 8, 18: 8,
+}
+
+func (t *tiffReader) bytes(off, n int64) ([]byte, error) {
+	if n < 0 || n > t.r.size {
+		return nil, fmt.Errorf("invalid tiff value size %d", n)
+	}
+	buf := make([]byte, n)
+	_, err := t.r.ReadAt(buf, off)
+	return buf, err
+}
+
+func (t *tiffReader) readUint(off int64, size int) (uint64, error) {
+	buf, err := t.bytes(off, int64(size))
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(buf[0]), nil
+	case 2:
+		return uint64(t.order.Uint16(buf)), nil
+	case 4:
+		return uint64(t.order.Uint32(buf)), nil
+	default:
+		return t.order.Uint64(buf), nil
+	}
+}
+
+func (t *tiffReader) readIFD(off int64) (*tiffIFD, int64, error) {
+	countSize, entrySize, valueSize := 2, int64(12), int64(4)
+	if t.big {
+		countSize, entrySize, valueSize = 8, 20, 8
+	}
+
+	count, err := t.readUint(off, countSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	if count > maxRasterEntries {
+		return nil, 0, fmt.Errorf("too many tiff entries: %d", count)
+	}
+
+	ifd := &tiffIFD{offset: off, entries: map[uint16]tiffEntry{}}
+	for i := int64(0); i < int64(count); i++ {
+		pos := off + int64(countSize) + i*entrySize
+		buf, err := t.bytes(pos, entrySize)
+		if err != nil {
+			return nil, 0, err
+		}
+		entry := tiffEntry{typ: t.order.Uint16(buf[2:])}
+		valuePos := pos + 8
+		if t.big {
+			entry.count = t.order.Uint64(buf[4:])
+			valuePos = pos + 12
+		} else {
+			entry.count = uint64(t.order.Uint32(buf[4:]))
+		}
+
+		size, ok := tiffTypeSizes[entry.typ]
+		if !ok {
+			continue
+		}
+		if entry.count > uint64(t.r.size) {
+			return nil, 0, fmt.Errorf("invalid tiff value count %d", entry.count)
+		}
+		entry.offset = valuePos
+		if size*int64(entry.count) > valueSize {
+			o, err := t.readUint(valuePos, int(valueSize))
+			if err != nil {
+				return nil, 0, err
+			}
+			entry.offset = int64(o)
+		}
+		ifd.entries[t.order.Uint16(buf)] = entry
+	}
+
+	next, err := t.readUint(off+int64(countSize)+int64(count)*entrySize, int(valueSize))
+	return ifd, int64(next), err
+}
+
+// values returns up to limit values of an integer entry.
+func (t *tiffReader) values(ifd *tiffIFD, tag uint16, limit uint64) ([]uint64, error) {
+	entry, ok := ifd.entries[tag]
+	if !ok {
+		return nil, nil
+	}
+	size := int(tiffTypeSizes[entry.typ])
+	if size != 1 && size != 2 && size != 4 && size != 8 {
+		return nil, fmt.Errorf("unexpected type %d of tiff tag %d", entry.typ, tag)
+	}
+
+	values := make([]uint64, 0, min(entry.count, limit))
+	for i := uint64(0); i < entry.count && i < limit; i++ {
+		v, err := t.readUint(entry.offset+int64(i)*int64(size), size)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (t *tiffReader) value(ifd *tiffIFD, tag uint16, fallback uint64) (uint64, error) {
+	values, err := t.values(ifd, tag, 1)
+	if err != nil || len(values) == 0 {
+		return fallback, err
+	}
+	return values[0], nil
+}
+
+func (t *tiffReader) doubles(ifd *tiffIFD, tag uint16) ([]float64, error) {
+	entry, ok := ifd.entries[tag]
+	if !ok || entry.typ != 12 {
+		return nil, nil
+	}
+	buf, err := t.bytes(entry.offset, int64(entry.count)*8)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, entry.count)
+	for i := range values {
+		values[i] = math.Float64frombits(t.order.Uint64(buf[i*8:]))
+	}
+	return values, nil
+}
+
+func (t *tiffReader) ascii(ifd *tiffIFD, tag uint16) (string, error) {
+	entry, ok := ifd.entries[tag]
+	if !ok || entry.typ != 2 {
+		return "", nil
+	}
+	buf, err := t.bytes(entry.offset, int64(entry.count))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\x00 "), nil
+}
+
+// ReadRaster parses the header of the TIFF at filePath with ranged reads.
+func ReadRaster(fSys afero.Fs, filePath string) (*RasterInfo, error) {
+	file, err := fSys.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return readRaster(file, stat.Size())
+}
+
+func readRaster(r io.ReaderAt, size int64) (*RasterInfo, error) {
+	t, ifds, err := readTIFF(r, size)
+	if err != nil {
+		return nil, err
+	}
+	info, _, err := readRasterInfo(t, ifds)
+	return info, err
+}
+
+// readTIFF reads the byte order and the IFDs of a TIFF.
+func readTIFF(r io.ReaderAt, size int64) (*tiffReader, []*tiffIFD, error) {
+	t := &tiffReader{r: &blockReader{r: r, size: size, blocks: map[int64][]byte{}}}
+
+	header, err := t.bytes(0, 8)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a tiff: %w", err)
+	}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, nil, errors.New("not a tiff")
+	}
+
+	var offset uint64
+	switch t.order.Uint16(header[2:]) {
+	case 42:
+		offset = uint64(t.order.Uint32(header[4:]))
+	case 43:
+		t.big = true
+		if offset, err = t.readUint(8, 8); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, errors.New("not a tiff")
+	}
+
+	ifds := []*tiffIFD{}
+	seen := map[int64]bool{}
+	for offset != 0 && len(ifds) < maxRasterIFDs && !seen[int64(offset)] {
+		seen[int64(offset)] = true
+		ifd, next, err := t.readIFD(int64(offset))
+		if err != nil {
+			return nil, nil, err
+		}
+		ifds = append(ifds, ifd)
+		offset = uint64(next)
+	}
+	if len(ifds) == 0 {
+		return nil, nil, errors.New("tiff without images")
+	}
+
+	return t, ifds, nil
+}
+
+// readRasterInfo describes the image of the first IFD and returns the IFDs
+// of its overviews.
+//
+//nolint:gocyclo
+func readRasterInfo(t *tiffReader, ifds []*tiffIFD) (*RasterInfo, []*tiffIFD, error) {
+	main := ifds[0]
+	info := &RasterInfo{BigTIFF: t.big, DataTypes: []string{}}
+
+	width, err := t.value(main, tagImageWidth, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	height, err := t.value(main, tagImageLength, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	bands, err := t.value(main, tagSamplesPerPixel, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if width == 0 || height == 0 || width > math.MaxInt32 || height > math.MaxInt32 {
+		return nil, nil, fmt.Errorf("invalid raster size %dx%d", width, height)
+	}
+	if bands == 0 || bands > maxRasterBands {
+		return nil, nil, fmt.Errorf("invalid number of bands %d", bands)
+	}
+	info.Width, info.Height, info.Bands = int(width), int(height), int(bands)
+
+	bits, err := t.values(main, tagBitsPerSample, bands)
+	if err != nil {
+		return nil, nil, err
+	}
+	formats, err := t.values(main, tagSampleFormat, bands)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i < info.Bands; i++ {
+		info.DataTypes = append(info.DataTypes, sampleType(valueAt(bits, i, 1), valueAt(formats, i, 1)))
+	}
+
+	compression, err := t.value(main, tagCompression, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.Compression = tiffCompressions[compression]
+	if info.Compression == "" {
+		info.Compression = strconv.FormatUint(compression, 10)
+	}
+
+	planar, err := t.value(main, tagPlanarConfiguration, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.Interleave = "pixel"
+	if planar == 2 {
+		info.Interleave = "band"
+	}
+
+	_, info.Tiled = main.entries[tagTileWidth]
+	if info.Tiled {
+		tileWidth, err := t.value(main, tagTileWidth, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		tileHeight, err := t.value(main, tagTileLength, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		info.TileWidth, info.TileHeight = int(tileWidth), int(tileHeight)
+	}
+
+	if err := readGeoKeys(t, main, info); err != nil {
+		return nil, nil, err
+	}
+
+	if info.NoData, err = t.ascii(main, tagGDALNoData); err != nil {
+		return nil, nil, err
+	}
+
+	overviews := []*tiffIFD{}
+	for _, ifd := range ifds[1:] {
+		subfile, err := t.value(ifd, tagNewSubfileType, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if subfile&subfileMask != 0 || subfile&subfileReducedResolution == 0 {
+			continue
+		}
+		w, err := t.value(ifd, tagImageWidth, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		h, err := t.value(ifd, tagImageLength, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		info.Overviews = append(info.Overviews, RasterSize{Width: int(w), Height: int(h)})
+		overviews = append(overviews, ifd)
+	}
+
+	info.COG, err = validateCOG(t, info, main, overviews, ifds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return info, overviews, nil
+}
+
+func valueAt(values []uint64, i int, fallback uint64) uint64 {
+	switch {
+	case i < len(values):
+		return values[i]
+	case len(values) > 0:
+		return values[len(values)-1]
+	default:
+		return fallback
+	}
+}
+
+func sampleType(bits, format uint64) string {
+	switch format {
+	case 2:
+		return "int" + strconv.FormatUint(bits, 10)
+	case 3:
+		return "float" + strconv.FormatUint(bits, 10)
+	case 5:
+		return "cint" + strconv.FormatUint(bits/2, 10)
+	case 6:
+		return "cfloat" + strconv.FormatUint(bits/2, 10)
+	default:
+		return "uint" + strconv.FormatUint(bits, 10)
+	}
+}
+
+// readGeoKeys reads the CRS and geotransform of a GeoTIFF into info. The
+// geotransform is in GDAL order, the origin being the corner of the first
+// pixel.
+//
+//nolint:gocyclo
+func readGeoKeys(t *tiffReader, ifd *tiffIFD, info *RasterInfo) error {
+	keys, err := t.values(ifd, tagGeoKeyDirectory, maxRasterEntries)
+	if err != nil {
+		return err
+	}
+
+	pixelIsPoint := false
+	if len(keys) >= 4 {
+		for i := 4; i+3 < len(keys) && i < 4+int(keys[3])*4; i += 4 {
+			id, location, value := keys[i], keys[i+1], keys[i+3]
+			if location != 0 {
+				// values stored in other tags are not needed
+				continue
+			}
+			switch id {
+			case geoKeyRasterType:
+				pixelIsPoint = value == rasterPixelIsPoint
+			case geoKeyProjectedCSType:
+				if value != geoKeyUserDefined && value != 0 {
+					info.CRS = "EPSG:" + strconv.FormatUint(value, 10)
+				}
+			case geoKeyGeographicType:
+				if info.CRS == "" && value != geoKeyUserDefined && value != 0 {
+					info.CRS = "EPSG:" + strconv.FormatUint(value, 10)
+				}
+			}
+		}
+	}
+
+	transform, err := t.doubles(ifd, tagModelTransformation)
+	if err != nil {
+		return err
+	}
+	scale, err := t.doubles(ifd, tagModelPixelScale)
+	if err != nil {
+		return err
+	}
+	tiepoint, err := t.doubles(ifd, tagModelTiepoint)
+	if err != nil {
+		return err
+	}
+
+	var gt []float64
+	switch {
+	case len(transform) >= 16:
+		gt = []float64{transform[3], transform[0], transform[1], transform[7], transform[4], transform[5]}
+	case len(scale) >= 2 && len(tiepoint) >= 6:
+		gt = []float64{
+			tiepoint[3] - tiepoint[0]*scale[0], scale[0], 0,
+			tiepoint[4] + tiepoint[1]*scale[1], 0, -scale[1],
+		}
+	default:
+		return nil
+	}
+
+	if pixelIsPoint {
+		gt[0] -= 0.5*gt[1] + 0.5*gt[2]
+		gt[3] -= 0.5*gt[4] + 0.5*gt[5]
+	}
+	info.GeoTransform = gt
+
+	if gt[2] == 0 && gt[4] == 0 {
+		x0, x1 := gt[0], gt[0]+float64(info.Width)*gt[1]
+		y0, y1 := gt[3], gt[3]+float64(info.Height)*gt[5]
+		info.Bounds = []float64{math.Min(x0, x1), math.Min(y0, y1), math.Max(x0, x1), math.Max(y0, y1)}
+	}
+
+	return nil
+}
+
+// firstBlockOffset returns the offset of the first tile or strip of ifd.
+func firstBlockOffset(t *tiffReader, ifd *tiffIFD) (uint64, error) {
+	tag := uint16(tagTileOffsets)
+	if _, ok := ifd.entries[tag]; !ok {
+		tag = tagStripOffsets
+	}
+	return t.value(ifd, tag, 0)
+}
+
+func validateCOG(t *tiffReader, info *RasterInfo, main *tiffIFD, overviews, ifds []*tiffIFD) (*COGValidation, error) {
+	v := &COGValidation{Errors: []string{}, Warnings: []string{}}
+
+	if !info.Tiled && (info.Width > cogMinOverviewSize || info.Height > cogMinOverviewSize) {
+		v.Errors = append(v.Errors, "the file is larger than 512 pixels and not tiled")
+	}
+	if len(overviews) == 0 && (info.Width > cogMinOverviewSize || info.Height > cogMinOverviewSize) {
+		v.Warnings = append(v.Warnings, "the file is larger than 512 pixels and has no overviews")
+	}
+
+	for i, ov := range info.Overviews {
+		prev := RasterSize{Width: info.Width, Height: info.Height}
+		if i > 0 {
+			prev = info.Overviews[i-1]
+		}
+		if ov.Width >= prev.Width && ov.Height >= prev.Height {
+			v.Errors = append(v.Errors, "overviews are not sorted by decreasing size")
+			break
+		}
+		if _, ok := overviews[i].entries[tagTileWidth]; !ok && (ov.Width > cogMinOverviewSize || ov.Height > cogMinOverviewSize) {
+			v.Errors = append(v.Errors, fmt.Sprintf("overview %d is larger than 512 pixels and not tiled", i))
+		}
+	}
+
+	// all IFDs come before the image data
+	var lastIFD int64
+	for _, ifd := range ifds {
+		lastIFD = max(lastIFD, ifd.offset)
+	}
+
+	mainOffset, err := firstBlockOffset(t, main)
+	if err != nil {
+		return nil, err
+	}
+	if mainOffset != 0 && int64(mainOffset) < lastIFD {
+		v.Errors = append(v.Errors, "the image data comes before the last IFD")
+	}
+
+	// the data of the smallest overview comes first, the main image last
+	prev := mainOffset
+	for i, ov := range overviews {
+		offset, err := firstBlockOffset(t, ov)
+		if err != nil {
+			return nil, err
+		}
+		if offset != 0 && int64(offset) < lastIFD {
+			v.Errors = append(v.Errors, fmt.Sprintf("the data of overview %d comes before the last IFD", i))
+		}
+		if offset > prev {
+			v.Errors = append(v.Errors, fmt.Sprintf("the data of overview %d comes after that of a larger image", i))
+		}
+		prev = offset
+	}
+
+	v.Valid = len(v.Errors) == 0
+	return v, nil
+}
@@ -0,0 +1,240 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+type tiffTag struct {
+	tag   uint16
+	value interface{} // []uint16, []uint32, []float64 or string
+}
+
+// encodeTIFF writes a little-endian TIFF of ifds, all of them before the
+// image data. Each IFD gets blocks[i] tiles, or a single strip if it has
+// no tile size, of 16 bytes written in dataOrder.
+func encodeTIFF(t *testing.T, ifds [][]tiffTag, blocks []int, dataOrder []int) []byte {
+	t.Helper()
+
+	tiled := func(tags []tiffTag) bool {
+		return slices.ContainsFunc(tags, func(tag tiffTag) bool { return tag.tag == tagTileWidth })
+	}
+	for i := range ifds {
+		offsetsTag, countsTag := uint16(tagTileOffsets), uint16(325)
+		if !tiled(ifds[i]) {
+			offsetsTag, countsTag = tagStripOffsets, 279
+		}
+		counts := make([]uint32, blocks[i])
+		for j := range counts {
+			counts[j] = 16
+		}
+		ifds[i] = append(ifds[i], tiffTag{offsetsTag, make([]uint32, blocks[i])}, tiffTag{countsTag, counts})
+		sort.Slice(ifds[i], func(a, b int) bool { return ifds[i][a].tag < ifds[i][b].tag })
+	}
+
+	encode := func(value interface{}) (uint16, uint32, []byte) {
+		var buf bytes.Buffer
+		switch v := value.(type) {
+		case []uint16:
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+			return 3, uint32(len(v)), buf.Bytes()
+		case []uint32:
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+			return 4, uint32(len(v)), buf.Bytes()
+		case []float64:
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+			return 12, uint32(len(v)), buf.Bytes()
+		default:
+			s := value.(string) + "\x00"
+			return 2, uint32(len(s)), []byte(s)
+		}
+	}
+
+	// lay out the IFDs with their out of line values, then the data
+	ifdOffsets := make([]uint32, len(ifds))
+	offset := uint32(8)
+	for i, tags := range ifds {
+		ifdOffsets[i] = offset
+		offset += 2 + 12*uint32(len(tags)) + 4
+		for _, tag := range tags {
+			if _, _, data := encode(tag.value); len(data) > 4 {
+				offset += uint32(len(data)+1) &^ 1
+			}
+		}
+	}
+	for _, i := range dataOrder {
+		for _, tag := range ifds[i] {
+			if tag.tag == tagTileOffsets || tag.tag == tagStripOffsets {
+				offsets := tag.value.([]uint32)
+				for j := range offsets {
+					offsets[j] = offset
+					offset += 16
+				}
+			}
+		}
+	}
+
+	out := make([]byte, offset)
+	copy(out, "II")
+	binary.LittleEndian.PutUint16(out[2:], 42)
+	binary.LittleEndian.PutUint32(out[4:], ifdOffsets[0])
+	for i, tags := range ifds {
+		pos := ifdOffsets[i]
+		extra := pos + 2 + 12*uint32(len(tags)) + 4
+		binary.LittleEndian.PutUint16(out[pos:], uint16(len(tags)))
+		for j, tag := range tags {
+			entry := out[pos+2+12*uint32(j):]
+			typ, count, data := encode(tag.value)
+			binary.LittleEndian.PutUint16(entry, tag.tag)
+			binary.LittleEndian.PutUint16(entry[2:], typ)
+			binary.LittleEndian.PutUint32(entry[4:], count)
+			if len(data) <= 4 {
+				copy(entry[8:], data)
+				continue
+			}
+			binary.LittleEndian.PutUint32(entry[8:], extra)
+			copy(out[extra:], data)
+			extra += uint32(len(data)+1) &^ 1
+		}
+		next := uint32(0)
+		if i+1 < len(ifds) {
+			next = ifdOffsets[i+1]
+		}
+		binary.LittleEndian.PutUint32(out[pos+2+12*uint32(len(tags)):], next)
+	}
+	return out
+}
+
+func cogIFDs() [][]tiffTag {
+	return [][]tiffTag{
+		{
+			{tagImageWidth, []uint16{1024}},
+			{tagImageLength, []uint16{1024}},
+			{tagBitsPerSample, []uint16{16}},
+			{tagCompression, []uint16{8}},
+			{tagSamplesPerPixel, []uint16{1}},
+			{tagTileWidth, []uint16{512}},
+			{tagTileLength, []uint16{512}},
+			{tagSampleFormat, []uint16{2}},
+			{tagModelPixelScale, []float64{10, 10, 0}},
+			{tagModelTiepoint, []float64{0, 0, 0, 500000, 5000000, 0}},
+			{tagGeoKeyDirectory, []uint16{1, 1, 0, 2, 1024, 0, 1, 1, 3072, 0, 1, 32633}},
+			{tagGDALNoData, "-9999"},
+		},
+		{
+			{tagNewSubfileType, []uint32{subfileReducedResolution}},
+			{tagImageWidth, []uint16{512}},
+			{tagImageLength, []uint16{512}},
+			{tagBitsPerSample, []uint16{16}},
+			{tagTileWidth, []uint16{512}},
+			{tagTileLength, []uint16{512}},
+		},
+	}
+}
+
+func TestReadRaster(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/cog.tif", encodeTIFF(t, cogIFDs(), []int{4, 1}, []int{1, 0}), PermFile); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ReadRaster(fs, "/cog.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Width != 1024 || info.Height != 1024 || info.Bands != 1 || !slices.Equal(info.DataTypes, []string{"int16"}) {
+		t.Errorf("unexpected size or bands %+v", info)
+	}
+	if info.Compression != "deflate" || info.Interleave != "pixel" || !info.Tiled || info.TileWidth != 512 || info.TileHeight != 512 {
+		t.Errorf("unexpected layout %+v", info)
+	}
+	if !slices.Equal(info.Overviews, []RasterSize{{512, 512}}) {
+		t.Errorf("unexpected overviews %v", info.Overviews)
+	}
+	if info.CRS != "EPSG:32633" || info.NoData != "-9999" {
+		t.Errorf("unexpected crs %q or nodata %q", info.CRS, info.NoData)
+	}
+	if !slices.Equal(info.GeoTransform, []float64{500000, 10, 0, 5000000, 0, -10}) ||
+		!slices.Equal(info.Bounds, []float64{500000, 5000000 - 10240, 510240, 5000000}) {
+		t.Errorf("unexpected georeferencing %v %v", info.GeoTransform, info.Bounds)
+	}
+	if !info.COG.Valid || len(info.COG.Errors) != 0 || len(info.COG.Warnings) != 0 {
+		t.Errorf("expected a valid COG, got %+v", info.COG)
+	}
+}
+
+func TestReadRasterNotCOG(t *testing.T) {
+	testCases := map[string]struct {
+		tiff []byte
+		want string
+	}{
+		"main image data first": {
+			tiff: encodeTIFF(t, cogIFDs(), []int{4, 1}, []int{0, 1}),
+			want: "the data of overview 0 comes after that of a larger image",
+		},
+		"striped": {
+			tiff: encodeTIFF(t, [][]tiffTag{{
+				{tagImageWidth, []uint32{2048}},
+				{tagImageLength, []uint32{1024}},
+				{tagBitsPerSample, []uint16{8, 8, 8}},
+				{tagSamplesPerPixel, []uint16{3}},
+				{tagPlanarConfiguration, []uint16{2}},
+			}}, []int{1}, []int{0}),
+			want: "the file is larger than 512 pixels and not tiled",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			info, err := readRaster(bytes.NewReader(tc.tiff), int64(len(tc.tiff)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.COG.Valid || !slices.Contains(info.COG.Errors, tc.want) {
+				t.Errorf("expected error %q, got %+v", tc.want, info.COG)
+			}
+		})
+	}
+
+	striped := testCases["striped"].tiff
+	info, _ := readRaster(bytes.NewReader(striped), int64(len(striped)))
+	if info.Bands != 3 || info.Interleave != "band" || info.Compression != "none" || info.GeoTransform != nil {
+		t.Errorf("unexpected striped raster %+v", info)
+	}
+
+	if _, err := readRaster(bytes.NewReader([]byte("not a tiff")), 10); err == nil {
+		t.Error("expected an error for a file that is not a tiff")
+	}
+}
+
+func TestReadRasterInvalidHeader(t *testing.T) {
+	testCases := map[string][]tiffTag{
+		"too many bands": {
+			{tagImageWidth, []uint16{16}},
+			{tagImageLength, []uint16{16}},
+			{tagSamplesPerPixel, []uint32{200_000_000}},
+		},
+		"no width": {
+			{tagImageLength, []uint16{16}},
+		},
+		"no height": {
+			{tagImageWidth, []uint16{16}},
+			{tagImageLength, []uint16{0}},
+		},
+	}
+
+	for name, tags := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tiff := encodeTIFF(t, [][]tiffTag{tags}, []int{1}, []int{0})
+			if _, err := readRaster(bytes.NewReader(tiff), int64(len(tiff))); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,731 @@
+package files
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+	"golang.org/x/image/tiff/lzw"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+// ErrUnsupportedRaster means a raster cannot be rendered, because of its
+// compression, data type or CRS.
+var ErrUnsupportedRaster = errors.New("unsupported raster")
+
+// RenderOptions select and stretch the bands of a rendered raster.
+type RenderOptions struct {
+	// Bands are the 1-based bands rendered as gray, or as red, green and
+	// blue; by default the first three bands, or the first one.
+	Bands []int
+	// Stretch is "minmax" or "percentile" (2nd to 98th), computed from the
+	// smallest overview. By default 8-bit rasters are not stretched and
+	// others are stretched from their minimum to their maximum.
+	Stretch string
+	// Rescale is the minimum and maximum of the stretch, overriding Stretch.
+	Rescale []float64
+}
+
+const (
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPredictor       = 317
+	tagTileByteCounts  = 325
+	tagJPEGTables      = 347
+
+	// TileSize is the size of the rendered XYZ tiles.
+	TileSize = 256
+	// maxRasterBlocks bounds the decoded blocks kept while rendering.
+	maxRasterBlocks = 64
+	// maxRasterBlockSamples bounds the size of a decoded block.
+	maxRasterBlockSamples = 1 << 26
+	// rasterStatsSize is the size of the grid sampled for statistics.
+	rasterStatsSize = 256
+	// webMercatorExtent is half the width of the web mercator plane.
+	webMercatorExtent = 20037508.342789244
+	maxTileZoom       = 30
+)
+
+type rasterLevel struct {
+	width, height   int
+	blockWidth      int
+	blockHeight     int
+	blocksPerRow    int
+	offsets, counts []uint64
+}
+
+type rasterBlockKey struct {
+	level, index int
+}
+
+type rasterImage struct {
+	t           *tiffReader
+	info        *RasterInfo
+	bands       int
+	sampleSize  int
+	format      uint64
+	compression uint64
+	predictor   uint64
+	planar      bool
+	jpegTables  []byte
+	nodata      float64
+	hasNodata   bool
+	levels      []*rasterLevel // the image, then its overviews
+	blocks      map[rasterBlockKey][]float64
+}
+
+// openRaster reads the header of a TIFF to render it.
+//
+//nolint:gocyclo
+func openRaster(r io.ReaderAt, size int64) (*rasterImage, error) {
+	t, ifds, err := readTIFF(r, size)
+	if err != nil {
+		return nil, err
+	}
+	info, overviews, err := readRasterInfo(t, ifds)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &rasterImage{t: t, info: info, bands: info.Bands, blocks: map[rasterBlockKey][]float64{}}
+	main := ifds[0]
+
+	bits, err := t.values(main, tagBitsPerSample, uint64(img.bands))
+	if err != nil {
+		return nil, err
+	}
+	formats, err := t.values(main, tagSampleFormat, uint64(img.bands))
+	if err != nil {
+		return nil, err
+	}
+	img.sampleSize = int(valueAt(bits, 0, 1)) / 8
+	img.format = valueAt(formats, 0, 1)
+	for i := 1; i < img.bands; i++ {
+		if valueAt(bits, i, 1) != valueAt(bits, 0, 1) || valueAt(formats, i, 1) != img.format {
+			return nil, fmt.Errorf("%w: bands of different data types", ErrUnsupportedRaster)
+		}
+	}
+	switch {
+	case img.bands < 1 || img.info.Width < 1 || img.info.Height < 1:
+		return nil, errors.New("empty tiff")
+	case img.format > 3 || !slices.Contains([]int{1, 2, 4, 8}, img.sampleSize) ||
+		valueAt(bits, 0, 1)%8 != 0 || (img.format == 3 && img.sampleSize < 4):
+		return nil, fmt.Errorf("%w: data type %s", ErrUnsupportedRaster, info.DataTypes[0])
+	}
+
+	if img.compression, err = t.value(main, tagCompression, 1); err != nil {
+		return nil, err
+	}
+	switch img.compression {
+	case 1, 5, 8, 32773, 32946, 50000:
+	case 7:
+		if img.sampleSize != 1 {
+			return nil, fmt.Errorf("%w: jpeg of %s", ErrUnsupportedRaster, info.DataTypes[0])
+		}
+		if entry, ok := main.entries[tagJPEGTables]; ok {
+			if img.jpegTables, err = t.bytes(entry.offset, int64(entry.count)); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s compression", ErrUnsupportedRaster, info.Compression)
+	}
+	if img.predictor, err = t.value(main, tagPredictor, 1); err != nil {
+		return nil, err
+	}
+	img.planar = info.Interleave == "band" && img.bands > 1
+
+	if info.NoData != "" {
+		if nodata, err := strconv.ParseFloat(info.NoData, 64); err == nil {
+			img.nodata, img.hasNodata = nodata, true
+		}
+	}
+
+	for _, ifd := range append([]*tiffIFD{main}, overviews...) {
+		level, err := img.readLevel(ifd)
+		if err != nil {
+			return nil, err
+		}
+		img.levels = append(img.levels, level)
+	}
+
+	return img, nil
+}
+
+func (img *rasterImage) readLevel(ifd *tiffIFD) (*rasterLevel, error) {
+	t := img.t
+	width, err := t.value(ifd, tagImageWidth, 0)
+	if err != nil {
+		return nil, err
+	}
+	height, err := t.value(ifd, tagImageLength, 0)
+	if err != nil {
+		return nil, err
+	}
+	level := &rasterLevel{width: int(width), height: int(height)}
+
+	offsetsTag, countsTag := uint16(tagTileOffsets), uint16(tagTileByteCounts)
+	if _, ok := ifd.entries[tagTileWidth]; ok {
+		tileWidth, err := t.value(ifd, tagTileWidth, 0)
+		if err != nil {
+			return nil, err
+		}
+		tileHeight, err := t.value(ifd, tagTileLength, 0)
+		if err != nil {
+			return nil, err
+		}
+		level.blockWidth, level.blockHeight = int(tileWidth), int(tileHeight)
+	} else {
+		rows, err := t.value(ifd, tagRowsPerStrip, height)
+		if err != nil {
+			return nil, err
+		}
+		level.blockWidth, level.blockHeight = int(width), int(min(rows, height))
+		offsetsTag, countsTag = tagStripOffsets, tagStripByteCounts
+	}
+	if level.width < 1 || level.height < 1 || level.blockWidth < 1 || level.blockHeight < 1 ||
+		level.blockWidth > maxRasterBlockSamples || level.blockHeight > maxRasterBlockSamples ||
+		level.blockWidth*level.blockHeight*img.bands > maxRasterBlockSamples {
+		return nil, fmt.Errorf("%w: blocks of %dx%d pixels", ErrUnsupportedRaster, level.blockWidth, level.blockHeight)
+	}
+	level.blocksPerRow = (level.width + level.blockWidth - 1) / level.blockWidth
+
+	blocks := level.blocksPerRow * ((level.height + level.blockHeight - 1) / level.blockHeight)
+	if img.planar {
+		blocks *= img.bands
+	}
+	if level.offsets, err = t.values(ifd, offsetsTag, uint64(blocks)); err != nil {
+		return nil, err
+	}
+	if level.counts, err = t.values(ifd, countsTag, uint64(blocks)); err != nil {
+		return nil, err
+	}
+	if len(level.offsets) != blocks || len(level.counts) != blocks {
+		return nil, errors.New("tiff without block offsets")
+	}
+	return level, nil
+}
+
+// bestLevel returns the smallest level with at least width by height pixels,
+// or the image itself.
+func (img *rasterImage) bestLevel(width, height float64) int {
+	for i := len(img.levels) - 1; i > 0; i-- {
+		if float64(img.levels[i].width) >= width && float64(img.levels[i].height) >= height {
+			return i
+		}
+	}
+	return 0
+}
+
+// pixel reads the samples of the pixel x, y of a level into out, and tells
+// whether it has data.
+func (img *rasterImage) pixel(l, x, y int, out []float64) (bool, error) {
+	level := img.levels[l]
+	index := (y/level.blockHeight)*level.blocksPerRow + x/level.blockWidth
+
+	key := rasterBlockKey{l, index}
+	block, ok := img.blocks[key]
+	if !ok {
+		var err error
+		if block, err = img.block(level, index); err != nil {
+			return false, err
+		}
+		if len(img.blocks) >= maxRasterBlocks {
+			clear(img.blocks)
+		}
+		img.blocks[key] = block
+	}
+
+	pos := ((y%level.blockHeight)*level.blockWidth + x%level.blockWidth) * img.bands
+	copy(out, block[pos:pos+img.bands])
+	for _, v := range out {
+		if math.IsNaN(v) || (img.hasNodata && v == img.nodata) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// block decodes the samples of a block of a level, interleaved by pixel.
+func (img *rasterImage) block(level *rasterLevel, index int) ([]float64, error) {
+	pixels := level.blockWidth * level.blockHeight
+	if !img.planar {
+		return img.decode(level, index, img.bands)
+	}
+
+	// with planar configuration, each band has its own blocks
+	out := make([]float64, pixels*img.bands)
+	perBand := len(level.offsets) / img.bands
+	for b := 0; b < img.bands; b++ {
+		samples, err := img.decode(level, b*perBand+index, 1)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range samples {
+			out[i*img.bands+b] = v
+		}
+	}
+	return out, nil
+}
+
+// decode reads, decompresses and converts the block at index, of samples
+// samples per pixel. Missing data, as in the last strip, is NaN.
+func (img *rasterImage) decode(level *rasterLevel, index, samples int) ([]float64, error) {
+	n := level.blockWidth * level.blockHeight * samples
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+
+	offset, count := int64(level.offsets[index]), int64(level.counts[index])
+	if offset == 0 || count == 0 {
+		// sparse block
+		return out, nil
+	}
+	if offset < 0 || count < 0 || offset+count > img.t.r.size {
+		return nil, fmt.Errorf("invalid tiff block at %d", offset)
+	}
+	// block data is read as is, without caching it as headers are
+	compressed := make([]byte, count)
+	if _, err := img.t.r.r.ReadAt(compressed, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if img.compression == 7 {
+		return img.decodeJPEG(compressed, level, out, samples)
+	}
+
+	raw, err := decompressBlock(img.compression, compressed, n*img.sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	rowSize := level.blockWidth * samples * img.sampleSize
+	for row := 0; row+rowSize <= len(raw); row += rowSize {
+		if err := undoPredictor(img.predictor, raw[row:row+rowSize], samples, img.sampleSize, img.t.order); err != nil {
+			return nil, err
+		}
+	}
+
+	order := img.t.order
+	for i := 0; i < n && (i+1)*img.sampleSize <= len(raw); i++ {
+		b := raw[i*img.sampleSize:]
+		switch {
+		case img.format == 3 && img.sampleSize == 4:
+			out[i] = float64(math.Float32frombits(order.Uint32(b)))
+		case img.format == 3:
+			out[i] = math.Float64frombits(order.Uint64(b))
+		case img.format == 2 && img.sampleSize == 1:
+			out[i] = float64(int8(b[0]))
+		case img.format == 2 && img.sampleSize == 2:
+			out[i] = float64(int16(order.Uint16(b)))
+		case img.format == 2 && img.sampleSize == 4:
+			out[i] = float64(int32(order.Uint32(b)))
+		case img.format == 2:
+			out[i] = float64(int64(order.Uint64(b)))
+		case img.sampleSize == 1:
+			out[i] = float64(b[0])
+		case img.sampleSize == 2:
+			out[i] = float64(order.Uint16(b))
+		case img.sampleSize == 4:
+			out[i] = float64(order.Uint32(b))
+		default:
+			out[i] = float64(order.Uint64(b))
+		}
+	}
+	return out, nil
+}
+
+// decodeJPEG decodes a JPEG block, prefixed by the tables shared by all
+// blocks if any.
+func (img *rasterImage) decodeJPEG(data []byte, level *rasterLevel, out []float64, samples int) ([]float64, error) {
+	if len(img.jpegTables) > 4 && len(data) > 2 {
+		// the tables end with EOI and the block starts with SOI
+		data = slices.Concat(img.jpegTables[:len(img.jpegTables)-2], data[2:])
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := decoded.Bounds()
+	for y := 0; y < min(level.blockHeight, bounds.Dy()); y++ {
+		for x := 0; x < min(level.blockWidth, bounds.Dx()); x++ {
+			pos := (y*level.blockWidth + x) * samples
+			c := decoded.At(bounds.Min.X+x, bounds.Min.Y+y)
+			if samples < 3 {
+				out[pos] = float64(color.GrayModel.Convert(c).(color.Gray).Y)
+				continue
+			}
+			rgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+			out[pos], out[pos+1], out[pos+2] = float64(rgba.R), float64(rgba.G), float64(rgba.B)
+		}
+	}
+	return out, nil
+}
+
+// decompressBlock decompresses up to size bytes of a block.
+func decompressBlock(compression uint64, data []byte, size int) ([]byte, error) {
+	var r io.Reader
+	switch compression {
+	case 1:
+		return data, nil
+	case 5:
+		r = lzw.NewReader(bytes.NewReader(data), lzw.MSB, 8)
+	case 8, 32946:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case 32773:
+		return unpackBits(data, size), nil
+	case 50000:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, ErrUnsupportedRaster
+	}
+
+	out := make([]byte, size)
+	n, err := io.ReadFull(r, out)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return out[:n], nil
+}
+
+// unpackBits decodes PackBits run-length encoded data.
+func unpackBits(data []byte, size int) []byte {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(data) && len(out) < size; {
+		n := int(int8(data[i]))
+		i++
+		switch {
+		case n >= 0:
+			end := min(i+n+1, len(data))
+			out = append(out, data[i:end]...)
+			i = end
+		case n != -128 && i < len(data):
+			for j := 0; j < 1-n; j++ {
+				out = append(out, data[i])
+			}
+			i++
+		}
+	}
+	return out[:min(len(out), size)]
+}
+
+// undoPredictor reverses the horizontal differencing of a row of samples
+// samples per pixel.
+func undoPredictor(predictor uint64, row []byte, samples, size int, order binary.ByteOrder) error {
+	switch predictor {
+	case 1:
+		return nil
+	case 2:
+		for i := samples * size; i+size <= len(row); i += size {
+			prev := row[i-samples*size:]
+			switch size {
+			case 1:
+				row[i] += prev[0]
+			case 2:
+				order.PutUint16(row[i:], order.Uint16(row[i:])+order.Uint16(prev))
+			case 4:
+				order.PutUint32(row[i:], order.Uint32(row[i:])+order.Uint32(prev))
+			default:
+				order.PutUint64(row[i:], order.Uint64(row[i:])+order.Uint64(prev))
+			}
+		}
+		return nil
+	case 3:
+		// the bytes are differenced, then grouped from the most significant
+		for i := samples; i < len(row); i++ {
+			row[i] += row[i-samples]
+		}
+		shuffled := slices.Clone(row)
+		count := len(row) / size
+		little := order == binary.LittleEndian
+		for j := 0; j < count; j++ {
+			for k := 0; k < size; k++ {
+				plane := k
+				if little {
+					plane = size - k - 1
+				}
+				row[j*size+k] = shuffled[plane*count+j]
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: predictor %d", ErrUnsupportedRaster, predictor)
+	}
+}
+
+// selectBands returns the 0-based bands to render.
+func (img *rasterImage) selectBands(opts RenderOptions) ([]int, error) {
+	if len(opts.Bands) == 0 {
+		if img.bands >= 3 {
+			return []int{0, 1, 2}, nil
+		}
+		return []int{0}, nil
+	}
+	if len(opts.Bands) != 1 && len(opts.Bands) != 3 {
+		return nil, fbErrors.ErrInvalidOption
+	}
+	bands := make([]int, len(opts.Bands))
+	for i, b := range opts.Bands {
+		if b < 1 || b > img.bands {
+			return nil, fbErrors.ErrInvalidOption
+		}
+		bands[i] = b - 1
+	}
+	return bands, nil
+}
+
+// stretches returns the minimum and maximum of each band to render.
+func (img *rasterImage) stretches(bands []int, opts RenderOptions) ([][2]float64, error) {
+	stretches := make([][2]float64, len(bands))
+
+	switch {
+	case len(opts.Rescale) > 0:
+		if len(opts.Rescale) != 2 || !(opts.Rescale[0] < opts.Rescale[1]) {
+			return nil, fbErrors.ErrInvalidOption
+		}
+		for i := range stretches {
+			stretches[i] = [2]float64{opts.Rescale[0], opts.Rescale[1]}
+		}
+		return stretches, nil
+	case opts.Stretch == "" && img.sampleSize == 1 && img.format != 2:
+		for i := range stretches {
+			stretches[i] = [2]float64{0, 255}
+		}
+		return stretches, nil
+	case opts.Stretch != "" && opts.Stretch != "minmax" && opts.Stretch != "percentile":
+		return nil, fbErrors.ErrInvalidOption
+	}
+
+	// sample a grid of the smallest overview
+	l := len(img.levels) - 1
+	level := img.levels[l]
+	values := make([][]float64, len(bands))
+	pixel := make([]float64, img.bands)
+	rows, cols := min(level.height, rasterStatsSize), min(level.width, rasterStatsSize)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			ok, err := img.pixel(l, (2*j+1)*level.width/(2*cols), (2*i+1)*level.height/(2*rows), pixel)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			for b, band := range bands {
+				values[b] = append(values[b], pixel[band])
+			}
+		}
+	}
+
+	for b := range bands {
+		v := values[b]
+		if len(v) == 0 {
+			stretches[b] = [2]float64{0, 1}
+			continue
+		}
+		slices.Sort(v)
+		lo, hi := v[0], v[len(v)-1]
+		if opts.Stretch == "percentile" {
+			lo, hi = v[(len(v)-1)*2/100], v[(len(v)-1)*98/100]
+		}
+		if hi <= lo {
+			hi = lo + 1
+		}
+		stretches[b] = [2]float64{lo, hi}
+	}
+	return stretches, nil
+}
+
+// renderer paints pixels of a raster, stretched to 8 bits.
+type renderer struct {
+	img       *rasterImage
+	bands     []int
+	stretches [][2]float64
+	pixel     []float64
+	out       *image.NRGBA
+	painted   bool
+}
+
+func (img *rasterImage) renderer(width, height int, opts RenderOptions) (*renderer, error) {
+	bands, err := img.selectBands(opts)
+	if err != nil {
+		return nil, err
+	}
+	stretches, err := img.stretches(bands, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &renderer{
+		img:       img,
+		bands:     bands,
+		stretches: stretches,
+		pixel:     make([]float64, img.bands),
+		out:       image.NewNRGBA(image.Rect(0, 0, width, height)),
+	}, nil
+}
+
+// paint sets the output pixel ox, oy from the pixel x, y of a level, and
+// leaves it transparent if it has no data.
+func (r *renderer) paint(ox, oy, l, x, y int) error {
+	ok, err := r.img.pixel(l, x, y, r.pixel)
+	if err != nil || !ok {
+		return err
+	}
+
+	c := color.NRGBA{A: 255}
+	channels := []*uint8{&c.R, &c.G, &c.B}
+	for i, band := range r.bands {
+		s := r.stretches[i]
+		v := math.Round((r.pixel[band] - s[0]) / (s[1] - s[0]) * 255)
+		*channels[i] = uint8(max(0, min(255, v)))
+	}
+	if len(r.bands) == 1 {
+		c.G, c.B = c.R, c.R
+	}
+	r.out.SetNRGBA(ox, oy, c)
+	r.painted = true
+	return nil
+}
+
+func openRasterFile(fSys afero.Fs, filePath string) (*rasterImage, afero.File, error) {
+	file, err := fSys.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	img, err := openRaster(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return img, file, nil
+}
+
+// RenderRaster renders the TIFF at filePath to fit in size by size pixels,
+// from its smallest overview that is large enough.
+func RenderRaster(fSys afero.Fs, filePath string, size int, opts RenderOptions) (image.Image, error) {
+	img, file, err := openRasterFile(fSys, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// rasters smaller than size are not enlarged
+	scale := max(1, float64(max(img.info.Width, img.info.Height))/float64(size))
+	width := max(1, int(math.Round(float64(img.info.Width)/scale)))
+	height := max(1, int(math.Round(float64(img.info.Height)/scale)))
+
+	r, err := img.renderer(width, height, opts)
+	if err != nil {
+		return nil, err
+	}
+	l := img.bestLevel(float64(width), float64(height))
+	level := img.levels[l]
+	for oy := 0; oy < height; oy++ {
+		for ox := 0; ox < width; ox++ {
+			if err := r.paint(ox, oy, l, (2*ox+1)*level.width/(2*width), (2*oy+1)*level.height/(2*height)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r.out, nil
+}
+
+// RenderTile renders the XYZ tile z/x/y, in web mercator, of the TIFF at
+// filePath, which must be georeferenced in web mercator or in geographic
+// coordinates. Tiles outside of the raster do not exist.
+func RenderTile(fSys afero.Fs, filePath string, z, x, y int, opts RenderOptions) (image.Image, error) {
+	if z < 0 || z > maxTileZoom || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return nil, fbErrors.ErrInvalidOption
+	}
+
+	img, file, err := openRasterFile(fSys, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gt := img.info.GeoTransform
+	var project func(mx, my float64) (float64, float64)
+	switch img.info.CRS {
+	case "EPSG:3857":
+		project = func(mx, my float64) (float64, float64) { return mx, my }
+	case "EPSG:4326":
+		project = func(mx, my float64) (float64, float64) {
+			lon := mx / webMercatorExtent * 180
+			lat := math.Atan(math.Sinh(my/webMercatorExtent*math.Pi)) * 180 / math.Pi
+			return lon, lat
+		}
+	default:
+		return nil, fmt.Errorf("%w: crs %q", ErrUnsupportedRaster, img.info.CRS)
+	}
+	if gt == nil || gt[2] != 0 || gt[4] != 0 || gt[1] == 0 || gt[5] == 0 {
+		return nil, fmt.Errorf("%w: not georeferenced or rotated", ErrUnsupportedRaster)
+	}
+
+	resolution := 2 * webMercatorExtent / float64(TileSize) / float64(int64(1)<<z)
+	// toPixel returns the position in pixels of the image of the center of
+	// the pixel px, py of the tile
+	toPixel := func(px, py int) (float64, float64) {
+		mx := -webMercatorExtent + (float64(x*TileSize+px)+0.5)*resolution
+		my := webMercatorExtent - (float64(y*TileSize+py)+0.5)*resolution
+		cx, cy := project(mx, my)
+		return (cx - gt[0]) / gt[1], (cy - gt[3]) / gt[5]
+	}
+
+	// pick the level from the number of image pixels per tile pixel at the
+	// center of the tile
+	x0, y0 := toPixel(TileSize/2, TileSize/2)
+	x1, y1 := toPixel(TileSize/2+1, TileSize/2+1)
+	scale := max(math.Abs(x1-x0), math.Abs(y1-y0), math.SmallestNonzeroFloat64)
+	l := img.bestLevel(float64(img.info.Width)/scale, float64(img.info.Height)/scale)
+	level := img.levels[l]
+
+	r, err := img.renderer(TileSize, TileSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	for py := 0; py < TileSize; py++ {
+		for px := 0; px < TileSize; px++ {
+			cx, cy := toPixel(px, py)
+			if cx < 0 || cy < 0 || cx >= float64(img.info.Width) || cy >= float64(img.info.Height) {
+				continue
+			}
+			lx := int(cx * float64(level.width) / float64(img.info.Width))
+			ly := int(cy * float64(level.height) / float64(img.info.Height))
+			if err := r.paint(px, py, l, lx, ly); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !r.painted {
+		return nil, fbErrors.ErrNotExist
+	}
+	return r.out, nil
+}
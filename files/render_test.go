@@ -0,0 +1,191 @@
+package files
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+// writeRenderFixture writes an 8x8 uint8 raster covering the web mercator
+// plane in 4x4 tiles, valued 10*y+x+1, with a 4x4 overview valued 200.
+func writeRenderFixture(t *testing.T, afs afero.Fs, name string, extra ...tiffTag) {
+	t.Helper()
+
+	pixel := 2 * webMercatorExtent / 8
+	main := []tiffTag{
+		{tagImageWidth, []uint16{8}},
+		{tagImageLength, []uint16{8}},
+		{tagBitsPerSample, []uint16{8}},
+		{tagSamplesPerPixel, []uint16{1}},
+		{tagTileWidth, []uint16{4}},
+		{tagTileLength, []uint16{4}},
+		{tagModelPixelScale, []float64{pixel, pixel, 0}},
+		{tagModelTiepoint, []float64{0, 0, 0, -webMercatorExtent, webMercatorExtent, 0}},
+		{tagGeoKeyDirectory, []uint16{1, 1, 0, 1, 3072, 0, 1, 3857}},
+	}
+	for _, tag := range extra {
+		main = slices.DeleteFunc(main, func(m tiffTag) bool { return m.tag == tag.tag })
+		main = append(main, tag)
+	}
+	ifds := [][]tiffTag{
+		main,
+		{
+			{tagNewSubfileType, []uint32{subfileReducedResolution}},
+			{tagImageWidth, []uint16{4}},
+			{tagImageLength, []uint16{4}},
+			{tagBitsPerSample, []uint16{8}},
+			{tagTileWidth, []uint16{4}},
+			{tagTileLength, []uint16{4}},
+		},
+	}
+	tiff := encodeTIFF(t, ifds, []int{4, 1}, []int{0, 1})
+
+	// the data of the 4 tiles of the image, then of the overview
+	data := tiff[len(tiff)-80:]
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			tile := y/4*2 + x/4
+			data[tile*16+y%4*4+x%4] = byte(10*y + x + 1)
+		}
+	}
+	for i := 64; i < 80; i++ {
+		data[i] = 200
+	}
+
+	if err := afero.WriteFile(afs, name, tiff, PermFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func grayAt(img image.Image, x, y int) (uint8, uint8) {
+	c := img.(*image.NRGBA).NRGBAAt(x, y)
+	return c.R, c.A
+}
+
+func TestRenderRaster(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	writeRenderFixture(t, afs, "/world.tif")
+
+	thumb, err := RenderRaster(afs, "/world.tif", 4, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumb.Bounds().Dx() != 4 || thumb.Bounds().Dy() != 4 {
+		t.Fatalf("unexpected thumbnail size %v", thumb.Bounds())
+	}
+	if v, a := grayAt(thumb, 1, 2); v != 200 || a != 255 {
+		t.Errorf("expected the thumbnail to be read from the overview, got %d %d", v, a)
+	}
+
+	// rasters are not enlarged
+	full, err := RenderRaster(afs, "/world.tif", 1080, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Bounds().Dx() != 8 {
+		t.Fatalf("unexpected size %v", full.Bounds())
+	}
+	if v, _ := grayAt(full, 5, 6); v != 66 {
+		t.Errorf("unexpected pixel %d", v)
+	}
+
+	stretched, err := RenderRaster(afs, "/world.tif", 8, RenderOptions{Rescale: []float64{1, 78}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := grayAt(stretched, 0, 0); v != 0 {
+		t.Errorf("unexpected stretched minimum %d", v)
+	}
+	if v, _ := grayAt(stretched, 7, 7); v != 255 {
+		t.Errorf("unexpected stretched maximum %d", v)
+	}
+
+	for _, opts := range []RenderOptions{{Bands: []int{2}}, {Bands: []int{1, 1}}, {Stretch: "gamma"}, {Rescale: []float64{3, 1}}} {
+		if _, err := RenderRaster(afs, "/world.tif", 8, opts); !errors.Is(err, fbErrors.ErrInvalidOption) {
+			t.Errorf("expected an invalid option for %+v, got %v", opts, err)
+		}
+	}
+}
+
+func TestRenderTile(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	writeRenderFixture(t, afs, "/world.tif", tiffTag{tagGDALNoData, "1"})
+
+	testCases := map[string]struct {
+		z, x, y     int
+		px, py      int
+		value       uint8
+		transparent bool
+	}{
+		"world":            {z: 0, x: 0, y: 0, px: 255, py: 255, value: 78},
+		"top left":         {z: 1, x: 0, y: 0, px: 255, py: 255, value: 34},
+		"bottom right":     {z: 1, x: 1, y: 1, px: 0, py: 0, value: 45},
+		"nodata":           {z: 1, x: 0, y: 0, px: 0, py: 0, transparent: true},
+		"deep in a pixel":  {z: 4, x: 15, y: 0, px: 0, py: 0, value: 8},
+		"overview ignored": {z: 0, x: 0, y: 0, px: 100, py: 40, value: 14},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tile, err := RenderTile(afs, "/world.tif", tc.z, tc.x, tc.y, RenderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tile.Bounds().Dx() != TileSize || tile.Bounds().Dy() != TileSize {
+				t.Fatalf("unexpected tile size %v", tile.Bounds())
+			}
+			v, a := grayAt(tile, tc.px, tc.py)
+			if tc.transparent && a != 0 || !tc.transparent && (a != 255 || v != tc.value) {
+				t.Errorf("got %d with alpha %d", v, a)
+			}
+		})
+	}
+
+	if _, err := RenderTile(afs, "/world.tif", 1, 2, 0, RenderOptions{}); !errors.Is(err, fbErrors.ErrInvalidOption) {
+		t.Errorf("expected an invalid tile, got %v", err)
+	}
+
+	writeRenderFixture(t, afs, "/utm.tif", tiffTag{tagGeoKeyDirectory, []uint16{1, 1, 0, 1, 3072, 0, 1, 32633}})
+	if _, err := RenderTile(afs, "/utm.tif", 0, 0, 0, RenderOptions{}); !errors.Is(err, ErrUnsupportedRaster) {
+		t.Errorf("expected an unsupported crs, got %v", err)
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	// a row of 4 uint16 pixels of 2 samples, differenced and deflated
+	want := []uint16{100, 7, 110, 5, 90, 9, 95, 1}
+	diff := slices.Clone(want)
+	for i := len(diff) - 1; i >= 2; i-- {
+		diff[i] -= diff[i-2]
+	}
+	var raw, compressed bytes.Buffer
+	_ = binary.Write(&raw, binary.LittleEndian, diff)
+	zw := zlib.NewWriter(&compressed)
+	_, _ = zw.Write(raw.Bytes())
+	_ = zw.Close()
+
+	row, err := decompressBlock(8, compressed.Bytes(), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := undoPredictor(2, row, 2, 2, binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]uint16, len(want))
+	_ = binary.Read(bytes.NewReader(row), binary.LittleEndian, got)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := unpackBits([]byte{0xfe, 0xaa, 0x02, 0x80, 0x00, 0x2a}, 6); !slices.Equal(got, []byte{0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a}) {
+		t.Errorf("unexpected unpacked bits %x", got)
+	}
+}
@@ -0,0 +1,95 @@
+package files
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/versioneer-tech/package-r/lru"
+)
+
+// s3ConnectionKey identifies a cached S3 connection. It holds every value a
+// connection is built from, so changed envs never hit a stale connection.
+type s3ConnectionKey struct {
+	endpoint             string
+	region               string
+	provider             string
+	accessKeyID          string
+	secretAccessKey      string
+	roleARN              string
+	roleSessionName      string
+	webIdentityTokenFile string
+	externalID           string
+	sessionTags          string
+	stsEndpoint          string
+	bucketName           string
+	bucketPrefix         string
+}
+
+func newS3ConnectionKey(envs map[string]string) s3ConnectionKey {
+	env := func(name string) string {
+		return getStringOrDefault(envs, name, os.Getenv(name))
+	}
+
+	return s3ConnectionKey{
+		endpoint:             env("AWS_ENDPOINT_URL"),
+		region:               env("AWS_REGION"),
+		provider:             getStringOrDefault(envs, "AWS_CREDENTIALS_PROVIDER", ""),
+		accessKeyID:          env("AWS_ACCESS_KEY_ID"),
+		secretAccessKey:      env("AWS_SECRET_ACCESS_KEY"),
+		roleARN:              env("AWS_ROLE_ARN"),
+		roleSessionName:      env("AWS_ROLE_SESSION_NAME"),
+		webIdentityTokenFile: env("AWS_WEB_IDENTITY_TOKEN_FILE"),
+		externalID:           getStringOrDefault(envs, "AWS_EXTERNAL_ID", ""),
+		sessionTags:          getStringOrDefault(envs, "AWS_ROLE_SESSION_TAGS", ""),
+		stsEndpoint:          env("AWS_STS_ENDPOINT_URL"),
+		bucketName:           getStringOrDefault(envs, "BUCKET_NAME", ""),
+		bucketPrefix:         getStringOrDefault(envs, "BUCKET_PREFIX", ""),
+	}
+}
+
+// maxS3Connections bounds the cached connections, of which the least
+// recently used are dropped, e.g. those of envs that were changed since.
+const maxS3Connections = 256
+
+var s3Connections = lru.New[s3ConnectionKey, *S3Connection](maxS3Connections)
+
+// getS3Connection returns the cached S3 connection for envs and builds it on
+// first use. S3 clients are safe for concurrent use, so a single connection is
+// shared by all requests with the same envs.
+func getS3Connection(ctx context.Context, envs map[string]string) (*S3Connection, error) {
+	key := newS3ConnectionKey(envs)
+
+	if conn, ok := s3Connections.Get(key); ok {
+		return conn, nil
+	}
+
+	cfg, err := loadAWSConfig(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &S3Connection{
+		client: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = true
+			if key.endpoint != "" {
+				o.BaseEndpoint = aws.String(key.endpoint)
+			}
+		}),
+		bucketName:   key.bucketName,
+		bucketPrefix: key.bucketPrefix,
+	}
+
+	s3Connections.Add(key, conn)
+	return conn, nil
+}
+
+// InvalidateS3Connection drops the S3 connection built from envs from the
+// cache of this process. Other processes, like a server while the command
+// line changes a user, never use the connection of changed envs as the
+// cache is keyed by them, and drop it once it is the least recently used.
+func InvalidateS3Connection(envs map[string]string) {
+	s3Connections.Remove(newS3ConnectionKey(envs))
+}
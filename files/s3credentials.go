@@ -0,0 +1,150 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+// Credential providers selectable through AWS_CREDENTIALS_PROVIDER.
+const (
+	// CredentialsStatic signs with AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	CredentialsStatic = "static"
+	// CredentialsDefault uses the default AWS credential chain of the server
+	// (environment, shared config, IRSA, container and instance roles).
+	CredentialsDefault = "default"
+	// CredentialsWebIdentity exchanges the token in AWS_WEB_IDENTITY_TOKEN_FILE
+	// for credentials of AWS_ROLE_ARN.
+	CredentialsWebIdentity = "web-identity"
+	// CredentialsAssumeRole assumes AWS_ROLE_ARN with the static keys if given
+	// or the default chain otherwise, tagging the session with
+	// AWS_ROLE_SESSION_TAGS.
+	CredentialsAssumeRole = "assume-role"
+)
+
+const defaultRoleSessionName = "package-r"
+
+// loadAWSConfig builds the AWS config for a connection, resolving the
+// credentials with the provider selected in key.
+func loadAWSConfig(ctx context.Context, key s3ConnectionKey) (aws.Config, error) {
+	region := key.region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+
+	provider := key.provider
+	if provider == "" {
+		provider = CredentialsDefault
+		if key.accessKeyID != "" {
+			provider = CredentialsStatic
+		}
+	}
+
+	if provider == CredentialsStatic && (key.accessKeyID == "" || key.secretAccessKey == "") {
+		return aws.Config{}, fmt.Errorf("static credentials need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY: %w", fbErrors.ErrInvalidOption)
+	}
+
+	if key.accessKeyID != "" && (provider == CredentialsStatic || provider == CredentialsAssumeRole) {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(key.accessKeyID, key.secretAccessKey, ""),
+		))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("could not load AWS config: %w", err)
+	}
+
+	switch provider {
+	case CredentialsStatic, CredentialsDefault:
+		return cfg, nil
+	case CredentialsWebIdentity:
+		if key.roleARN == "" || key.webIdentityTokenFile == "" {
+			return aws.Config{}, fmt.Errorf("web identity credentials need AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE: %w", fbErrors.ErrInvalidOption)
+		}
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
+			newSTSClient(cfg, key),
+			key.roleARN,
+			stscreds.IdentityTokenFile(key.webIdentityTokenFile),
+			func(o *stscreds.WebIdentityRoleOptions) {
+				o.RoleSessionName = roleSessionName(key)
+			},
+		))
+		return cfg, nil
+	case CredentialsAssumeRole:
+		if key.roleARN == "" {
+			return aws.Config{}, fmt.Errorf("assume role credentials need AWS_ROLE_ARN: %w", fbErrors.ErrInvalidOption)
+		}
+		tags, err := parseSessionTags(key.sessionTags)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(
+			newSTSClient(cfg, key),
+			key.roleARN,
+			func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = roleSessionName(key)
+				o.Tags = tags
+				if key.externalID != "" {
+					o.ExternalID = aws.String(key.externalID)
+				}
+			},
+		))
+		return cfg, nil
+	default:
+		return aws.Config{}, fmt.Errorf("unknown credentials provider %q: %w", provider, fbErrors.ErrInvalidOption)
+	}
+}
+
+// newSTSClient returns a STS client talking to AWS_STS_ENDPOINT_URL or, for
+// S3-compatible stores such as MinIO, to the storage endpoint itself.
+func newSTSClient(cfg aws.Config, key s3ConnectionKey) *sts.Client {
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		switch {
+		case key.stsEndpoint != "":
+			o.BaseEndpoint = aws.String(key.stsEndpoint)
+		case key.endpoint != "":
+			o.BaseEndpoint = aws.String(key.endpoint)
+		}
+	})
+}
+
+func roleSessionName(key s3ConnectionKey) string {
+	if key.roleSessionName != "" {
+		return key.roleSessionName
+	}
+	return defaultRoleSessionName
+}
+
+// parseSessionTags parses session tags given as "key=value;key=value". A
+// semicolon is used as separator since envs are passed as a comma-separated
+// list on the command line.
+func parseSessionTags(value string) ([]ststypes.Tag, error) {
+	var tags []ststypes.Tag
+	for _, pair := range strings.Split(value, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid session tag %q: %w", pair, fbErrors.ErrInvalidOption)
+		}
+		tags = append(tags, ststypes.Tag{
+			Key:   aws.String(strings.TrimSpace(k)),
+			Value: aws.String(strings.TrimSpace(v)),
+		})
+	}
+	return tags, nil
+}
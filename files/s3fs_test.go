@@ -0,0 +1,106 @@
+package files
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/spf13/afero"
+)
+
+func newTestS3Fs(t *testing.T) *S3Fs {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	envs := testEnvs()
+	envs["AWS_ENDPOINT_URL"] = server.URL
+	envs["BUCKET_PREFIX"] = "prefix"
+	t.Cleanup(func() { InvalidateS3Connection(envs) })
+
+	fs, err := NewS3Fs(context.Background(), envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestS3Fs(t *testing.T) {
+	fs := newTestS3Fs(t)
+
+	if err := afero.WriteFile(fs, "/data/a.txt", []byte("hello world"), PermFile); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := fs.Mkdir("/data/empty", PermDir); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	info, err := fs.Stat("/data")
+	if err != nil || !info.IsDir() {
+		t.Fatalf("stat dir: %v %v", info, err)
+	}
+	info, err = fs.Stat("/data/a.txt")
+	if err != nil || info.IsDir() || info.Size() != 11 {
+		t.Fatalf("stat file: %v %v", info, err)
+	}
+	if _, err := fs.Stat("/data/missing"); !os.IsNotExist(err) {
+		t.Fatalf("stat missing: %v", err)
+	}
+
+	entries, err := afero.ReadDir(fs, "/data")
+	if err != nil {
+		t.Fatalf("readdir: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name() != "a.txt" || entries[1].Name() != "empty" || !entries[1].IsDir() {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if entries, _ := afero.ReadDir(fs, "/data/empty"); len(entries) != 0 {
+		t.Fatalf("unexpected entries in empty dir: %v", entries)
+	}
+
+	f, err := fs.Open("/data/a.txt")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	if n, err := f.ReadAt(buf, 6); err != nil || string(buf[:n]) != "world" {
+		t.Fatalf("read at: %q %v", buf[:n], err)
+	}
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	if rest, err := io.ReadAll(f); err != nil || string(rest) != "world" {
+		t.Fatalf("read after seek: %q %v", rest, err)
+	}
+
+	if err := fs.Rename("/data", "/moved"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if content, err := afero.ReadFile(fs, "/moved/a.txt"); err != nil || string(content) != "hello world" {
+		t.Fatalf("read renamed: %q %v", content, err)
+	}
+	if _, err := fs.Stat("/data"); !os.IsNotExist(err) {
+		t.Fatalf("stat renamed source: %v", err)
+	}
+
+	if err := fs.Remove("/moved"); err == nil {
+		t.Fatal("expected remove of non-empty directory to fail")
+	}
+	if err := fs.RemoveAll("/moved"); err != nil {
+		t.Fatalf("remove all: %v", err)
+	}
+	if _, err := fs.Stat("/moved"); !os.IsNotExist(err) {
+		t.Fatalf("stat removed: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const (
+	// MinUploadPartSize is the smallest part size S3 accepts for all but the
+	// last part of a multipart upload.
+	MinUploadPartSize = 5 * 1024 * 1024
+	// MaxUploadParts is the largest number of parts of a multipart upload.
+	MaxUploadParts = 10000
+)
+
+// MultipartUpload is an initiated multipart upload whose parts are uploaded
+// by the client directly to object storage through presigned URLs.
+type MultipartUpload struct {
+	UploadID string          `json:"uploadId"`
+	PartSize int64           `json:"partSize"`
+	Parts    []PresignedPart `json:"parts"`
+}
+
+// PresignedPart is the presigned URL to PUT a single part to.
+type PresignedPart struct {
+	PartNumber int32  `json:"partNumber"`
+	URL        string `json:"url"`
+}
+
+// CompletedPart is a part the client uploaded, identified by the ETag object
+// storage returned for it.
+type CompletedPart struct {
+	PartNumber int32  `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// NewUploader returns the S3 connection for envs to run multipart uploads
+// with. Multipart uploads are only supported for S3.
+func NewUploader(ctx context.Context, envs map[string]string) (*S3Connection, error) {
+	if provider := getStringOrDefault(envs, "STORAGE_PROVIDER", ProviderS3); provider != ProviderS3 {
+		return nil, fmt.Errorf("presigned uploads are not supported for %q: %w", provider, fbErrors.ErrInvalidOption)
+	}
+	return getS3Connection(ctx, envs)
+}
+
+// UploadPartSize returns the part size to split an upload of size bytes with,
+// keeping the number of parts within MaxUploadParts.
+func UploadPartSize(size, preferred int64) int64 {
+	partSize := max(preferred, MinUploadPartSize)
+	if minimum := (size + MaxUploadParts - 1) / MaxUploadParts; partSize < minimum {
+		partSize = minimum
+	}
+	return partSize
+}
+
+// CreateMultipartUpload initiates a multipart upload of size bytes to path and
+// presigns an UploadPart request for every part.
+func (conn *S3Connection) CreateMultipartUpload(ctx context.Context, path string, size, partSize int64, expiry time.Duration) (*MultipartUpload, error) {
+	bucket, key, err := conn.objectLocation(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 0 || partSize <= 0 {
+		return nil, fbErrors.ErrInvalidRequestParams
+	}
+
+	parts := max((size+partSize-1)/partSize, 1)
+	if parts > MaxUploadParts {
+		return nil, fmt.Errorf("upload of %d bytes needs %d parts: %w", size, parts, fbErrors.ErrInvalidRequestParams)
+	}
+
+	if expiry <= 0 || expiry > DefaultPresignExpiry {
+		expiry = DefaultPresignExpiry
+	}
+
+	out, err := conn.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create multipart upload: %w", err)
+	}
+
+	upload := &MultipartUpload{
+		UploadID: aws.ToString(out.UploadId),
+		PartSize: partSize,
+		Parts:    make([]PresignedPart, 0, parts),
+	}
+
+	presigner := s3.NewPresignClient(conn.client, s3.WithPresignExpires(expiry))
+	for n := int32(1); n <= int32(parts); n++ {
+		req, err := presigner.PresignUploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(bucket),
+			Key:        aws.String(key),
+			UploadId:   out.UploadId,
+			PartNumber: aws.Int32(n),
+		})
+		if err != nil {
+			_ = conn.AbortMultipartUpload(ctx, path, upload.UploadID)
+			return nil, fmt.Errorf("could not presign part %d: %w", n, err)
+		}
+		upload.Parts = append(upload.Parts, PresignedPart{PartNumber: n, URL: req.URL})
+	}
+
+	return upload, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object.
+func (conn *S3Connection) CompleteMultipartUpload(ctx context.Context, path, uploadID string, parts []CompletedPart) error {
+	bucket, key, err := conn.objectLocation(path)
+	if err != nil {
+		return err
+	}
+
+	if uploadID == "" || len(parts) == 0 {
+		return fbErrors.ErrInvalidRequestParams
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	completed := make([]s3types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		completed = append(completed, s3types.CompletedPart{
+			PartNumber: aws.Int32(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		})
+	}
+
+	_, err = conn.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("could not complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards an upload and all parts uploaded so far.
+func (conn *S3Connection) AbortMultipartUpload(ctx context.Context, path, uploadID string) error {
+	bucket, key, err := conn.objectLocation(path)
+	if err != nil {
+		return err
+	}
+
+	if uploadID == "" {
+		return fbErrors.ErrInvalidRequestParams
+	}
+
+	_, err = conn.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("could not abort multipart upload: %w", err)
+	}
+	return nil
+}
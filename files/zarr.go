@@ -0,0 +1,417 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/versioneer-tech/package-r/rules"
+)
+
+// Metadata documents of Zarr stores.
+const (
+	zarrGroupV2        = ".zgroup"
+	zarrArrayV2        = ".zarray"
+	zarrAttrsV2        = ".zattrs"
+	zarrConsolidatedV2 = ".zmetadata"
+	zarrJSONV3         = "zarr.json"
+)
+
+// ZarrInfo summarizes the groups and arrays of a Zarr store.
+type ZarrInfo struct {
+	Version      int                    `json:"version"`
+	Consolidated bool                   `json:"consolidated"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Groups       []string               `json:"groups,omitempty"`
+	Arrays       []ZarrArray            `json:"arrays"`
+}
+
+// ZarrArray describes an array of a Zarr store, by its path within the store.
+type ZarrArray struct {
+	Path       string                 `json:"path"`
+	Shape      []int64                `json:"shape"`
+	Chunks     []int64                `json:"chunks"`
+	DataType   string                 `json:"dataType"`
+	Codecs     []string               `json:"codecs,omitempty"`
+	FillValue  interface{}            `json:"fillValue,omitempty"`
+	Dimensions []string               `json:"dimensions,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// zarrArrayV2Doc is a .zarray document.
+type zarrArrayV2Doc struct {
+	Shape      []int64           `json:"shape"`
+	Chunks     []int64           `json:"chunks"`
+	DType      json.RawMessage   `json:"dtype"`
+	Compressor *zarrCodecV2      `json:"compressor"`
+	Filters    []json.RawMessage `json:"filters"`
+	FillValue  interface{}       `json:"fill_value"`
+}
+
+type zarrCodecV2 struct {
+	ID string `json:"id"`
+}
+
+// zarrNodeV3 is a zarr.json document.
+type zarrNodeV3 struct {
+	NodeType  string          `json:"node_type"`
+	Shape     []int64         `json:"shape"`
+	DataType  json.RawMessage `json:"data_type"`
+	ChunkGrid struct {
+		Configuration struct {
+			ChunkShape []int64 `json:"chunk_shape"`
+		} `json:"configuration"`
+	} `json:"chunk_grid"`
+	Codecs []struct {
+		Name string `json:"name"`
+	} `json:"codecs"`
+	FillValue      interface{}            `json:"fill_value"`
+	DimensionNames []*string              `json:"dimension_names"`
+	Attributes     map[string]interface{} `json:"attributes"`
+	Consolidated   *struct {
+		Metadata map[string]zarrNodeV3 `json:"metadata"`
+	} `json:"consolidated_metadata"`
+}
+
+// ZarrVersion returns the Zarr format of the store at dir, or 0 if dir is
+// not the root of a Zarr store.
+func ZarrVersion(afs afero.Fs, dir string) int {
+	for _, name := range []string{zarrConsolidatedV2, zarrGroupV2, zarrArrayV2} {
+		if _, err := afs.Stat(path.Join(dir, name)); err == nil {
+			return 2
+		}
+	}
+	if _, err := afs.Stat(path.Join(dir, zarrJSONV3)); err == nil {
+		return 3
+	}
+	return 0
+}
+
+// InZarrStore tells whether the file at p is a metadata document or chunk
+// of a Zarr store. Only the directories a chunk can be nested in are looked
+// at, not every parent of p.
+func InZarrStore(afs afero.Fs, p string) bool {
+	name := path.Base(p)
+	if !isZarrKey(name) {
+		return false
+	}
+	dir := path.Dir(p)
+	if isZarrMetadata(name) {
+		return ZarrVersion(afs, dir) != 0
+	}
+
+	// chunks are in their array, or nested below it in directories named
+	// by their indices, which version 3 puts into a "c" directory
+	for {
+		if isZarrArray(afs, dir) {
+			return true
+		}
+		base := path.Base(dir)
+		if base != "c" && strings.Trim(base, "0123456789") != "" || dir == "/" || dir == "." {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+
+	// arrays of consolidated stores may only be described at their root
+	for {
+		if _, err := afs.Stat(path.Join(dir, zarrConsolidatedV2)); err == nil {
+			return true
+		}
+		if dir == "/" || dir == "." {
+			return false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// isZarrArray tells whether dir holds the metadata document of an array.
+func isZarrArray(afs afero.Fs, dir string) bool {
+	for _, name := range []string{zarrArrayV2, zarrJSONV3} {
+		if _, err := afs.Stat(path.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeZarrStore tells whether the directory name follows the convention
+// of naming Zarr stores, which listings check before looking into it.
+func looksLikeZarrStore(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".zarr")
+}
+
+// isZarrKey tells whether name is that of a metadata document or of a
+// chunk, which is named by its dot or slash separated grid indices.
+func isZarrKey(name string) bool {
+	return isZarrMetadata(name) || strings.Trim(name, "0123456789.") == "" && strings.Trim(name, ".") != ""
+}
+
+// isZarrMetadata tells whether name is that of a metadata document.
+func isZarrMetadata(name string) bool {
+	switch name {
+	case zarrGroupV2, zarrArrayV2, zarrAttrsV2, zarrConsolidatedV2, zarrJSONV3:
+		return true
+	}
+	return false
+}
+
+// ReadZarr summarizes the Zarr store at dir from its consolidated metadata
+// if available, or otherwise from the metadata documents of its groups and
+// arrays, leaving out those the checker denies. Chunks are never listed.
+func ReadZarr(afs afero.Fs, dir string, checker rules.Checker) (*ZarrInfo, error) {
+	var (
+		info *ZarrInfo
+		err  error
+	)
+	switch ZarrVersion(afs, dir) {
+	case 2:
+		info, err = readZarrV2(afs, dir, checker)
+	case 3:
+		info, err = readZarrV3(afs, dir, checker)
+	default:
+		return nil, fmt.Errorf("%s is not a zarr store", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(info.Groups)
+	sort.Slice(info.Arrays, func(i, j int) bool { return info.Arrays[i].Path < info.Arrays[j].Path })
+	return info, nil
+}
+
+func readZarrV2(afs afero.Fs, dir string, checker rules.Checker) (*ZarrInfo, error) {
+	info := &ZarrInfo{Version: 2, Arrays: []ZarrArray{}}
+
+	var consolidated struct {
+		Metadata map[string]json.RawMessage `json:"metadata"`
+	}
+	err := readZarrJSON(afs, path.Join(dir, zarrConsolidatedV2), &consolidated)
+	switch {
+	case err == nil:
+		info.Consolidated = true
+		for key, doc := range consolidated.Metadata {
+			node, name := path.Split(key)
+			node = strings.TrimSuffix(node, "/")
+			if !checker.Check(path.Join(dir, node)) {
+				continue
+			}
+			switch name {
+			case zarrGroupV2:
+				if node != "" {
+					info.Groups = append(info.Groups, node)
+				}
+			case zarrArrayV2:
+				var attrs map[string]interface{}
+				_ = json.Unmarshal(consolidated.Metadata[path.Join(node, zarrAttrsV2)], &attrs)
+				array, err := zarrArrayFromV2(node, doc, attrs)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", key, err)
+				}
+				info.Arrays = append(info.Arrays, array)
+			}
+		}
+		_ = json.Unmarshal(consolidated.Metadata[zarrAttrsV2], &info.Attributes)
+		return info, nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	if err := readZarrJSON(afs, path.Join(dir, zarrAttrsV2), &info.Attributes); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return info, walkZarr(afs, dir, "", checker, func(node string) (bool, error) {
+		full := path.Join(dir, node)
+		doc, err := afero.ReadFile(afs, path.Join(full, zarrArrayV2))
+		if errors.Is(err, fs.ErrNotExist) {
+			if _, err := afs.Stat(path.Join(full, zarrGroupV2)); err != nil {
+				return false, nil
+			}
+			if node != "" {
+				info.Groups = append(info.Groups, node)
+			}
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		var attrs map[string]interface{}
+		if err := readZarrJSON(afs, path.Join(full, zarrAttrsV2), &attrs); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+		array, err := zarrArrayFromV2(node, doc, attrs)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path.Join(full, zarrArrayV2), err)
+		}
+		info.Arrays = append(info.Arrays, array)
+		return false, nil
+	})
+}
+
+func zarrArrayFromV2(node string, doc []byte, attrs map[string]interface{}) (ZarrArray, error) {
+	var meta zarrArrayV2Doc
+	if err := json.Unmarshal(doc, &meta); err != nil {
+		return ZarrArray{}, err
+	}
+
+	array := ZarrArray{
+		Path:       node,
+		Shape:      meta.Shape,
+		Chunks:     meta.Chunks,
+		DataType:   zarrDataType(meta.DType),
+		FillValue:  meta.FillValue,
+		Attributes: attrs,
+	}
+	for _, filter := range meta.Filters {
+		var codec zarrCodecV2
+		if json.Unmarshal(filter, &codec) == nil && codec.ID != "" {
+			array.Codecs = append(array.Codecs, codec.ID)
+		}
+	}
+	if meta.Compressor != nil {
+		array.Codecs = append(array.Codecs, meta.Compressor.ID)
+	}
+
+	// xarray records the dimensions of an array in its attributes
+	if dims, ok := attrs["_ARRAY_DIMENSIONS"].([]interface{}); ok {
+		for _, dim := range dims {
+			name, _ := dim.(string)
+			array.Dimensions = append(array.Dimensions, name)
+		}
+		delete(attrs, "_ARRAY_DIMENSIONS")
+	}
+	if len(array.Attributes) == 0 {
+		array.Attributes = nil
+	}
+
+	return array, nil
+}
+
+func readZarrV3(afs afero.Fs, dir string, checker rules.Checker) (*ZarrInfo, error) {
+	info := &ZarrInfo{Version: 3, Arrays: []ZarrArray{}}
+
+	var root zarrNodeV3
+	if err := readZarrJSON(afs, path.Join(dir, zarrJSONV3), &root); err != nil {
+		return nil, err
+	}
+	info.Attributes = root.Attributes
+
+	add := func(node string, meta *zarrNodeV3) {
+		switch meta.NodeType {
+		case "group":
+			if node != "" {
+				info.Groups = append(info.Groups, node)
+			}
+		case "array":
+			info.Arrays = append(info.Arrays, zarrArrayFromV3(node, meta))
+		}
+	}
+
+	if root.Consolidated != nil {
+		info.Consolidated = true
+		add("", &root)
+		for node, meta := range root.Consolidated.Metadata {
+			if checker.Check(path.Join(dir, node)) {
+				add(node, &meta)
+			}
+		}
+		return info, nil
+	}
+
+	return info, walkZarr(afs, dir, "", checker, func(node string) (bool, error) {
+		var meta zarrNodeV3
+		err := readZarrJSON(afs, path.Join(dir, node, zarrJSONV3), &meta)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		add(node, &meta)
+		return meta.NodeType == "group", nil
+	})
+}
+
+func zarrArrayFromV3(node string, meta *zarrNodeV3) ZarrArray {
+	array := ZarrArray{
+		Path:       node,
+		Shape:      meta.Shape,
+		Chunks:     meta.ChunkGrid.Configuration.ChunkShape,
+		DataType:   zarrDataType(meta.DataType),
+		FillValue:  meta.FillValue,
+		Attributes: meta.Attributes,
+	}
+	for _, codec := range meta.Codecs {
+		array.Codecs = append(array.Codecs, codec.Name)
+	}
+	for _, name := range meta.DimensionNames {
+		if name == nil {
+			array.Dimensions = append(array.Dimensions, "")
+		} else {
+			array.Dimensions = append(array.Dimensions, *name)
+		}
+	}
+	if len(array.Attributes) == 0 {
+		array.Attributes = nil
+	}
+	return array
+}
+
+// walkZarr calls visit for node and, as long as visit reports a group, for
+// the directories below it. Arrays are not descended into, so their chunks
+// are never listed.
+func walkZarr(afs afero.Fs, dir, node string, checker rules.Checker, visit func(node string) (bool, error)) error {
+	if !checker.Check(path.Join(dir, node)) {
+		return nil
+	}
+	group, err := visit(node)
+	if err != nil || !group {
+		return err
+	}
+
+	entries, err := afero.ReadDir(afs, path.Join(dir, node))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := walkZarr(afs, dir, path.Join(node, entry.Name()), checker, visit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// zarrDataType renders a data type, which is a string in the common case
+// but may be a structured type or, since version 3, an extension object.
+func zarrDataType(raw json.RawMessage) string {
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		return name
+	}
+	var extension struct {
+		Name string `json:"name"`
+	}
+	if json.Unmarshal(raw, &extension) == nil && extension.Name != "" {
+		return extension.Name
+	}
+	return string(raw)
+}
+
+func readZarrJSON(afs afero.Fs, name string, v interface{}) error {
+	data, err := afero.ReadFile(afs, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package files
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+type denyPrefix string
+
+func (p denyPrefix) Check(name string) bool {
+	return p == "" || !strings.HasPrefix(name, string(p))
+}
+
+func writeZarrFixture(t *testing.T, afs afero.Fs, docs map[string]string) {
+	t.Helper()
+	for name, doc := range docs {
+		if err := afs.MkdirAll(path.Dir(name), PermDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := afero.WriteFile(afs, name, []byte(doc), PermFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const zarrTempV2 = `{"zarr_format": 2, "shape": [365, 180, 360], "chunks": [30, 90, 90], "dtype": "<f4",
+	"compressor": {"id": "blosc", "cname": "lz4"}, "filters": [{"id": "delta", "dtype": "<f4"}], "fill_value": "NaN", "order": "C"}`
+
+func TestReadZarr(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	writeZarrFixture(t, afs, map[string]string{
+		// version 2, consolidated
+		"/v2c.zarr/.zgroup":    `{"zarr_format": 2}`,
+		"/v2c.zarr/.zmetadata": `{"zarr_consolidated_format": 1, "metadata": {".zgroup": {"zarr_format": 2}, ".zattrs": {"title": "era5"}, "temp/.zarray": ` + zarrTempV2 + `, "temp/.zattrs": {"_ARRAY_DIMENSIONS": ["time", "lat", "lon"], "units": "K"}}}`,
+		"/v2c.zarr/temp/0.0.0": "chunk",
+		// version 2, nested groups
+		"/v2.zarr/.zgroup":               `{"zarr_format": 2}`,
+		"/v2.zarr/.zattrs":               `{"title": "nested"}`,
+		"/v2.zarr/obs/.zgroup":           `{"zarr_format": 2}`,
+		"/v2.zarr/obs/temp/.zarray":      zarrTempV2,
+		"/v2.zarr/obs/temp/0/0/0":        "chunk",
+		"/v2.zarr/secret/.zgroup":        `{"zarr_format": 2}`,
+		"/v2.zarr/secret/values/.zarray": zarrTempV2,
+		// version 3
+		"/v3.zarr/zarr.json":      `{"zarr_format": 3, "node_type": "group", "attributes": {"title": "v3"}}`,
+		"/v3.zarr/grid/zarr.json": `{"zarr_format": 3, "node_type": "group"}`,
+		"/v3.zarr/grid/elevation/zarr.json": `{"zarr_format": 3, "node_type": "array", "shape": [1000, 2000], "data_type": "int16",
+			"chunk_grid": {"name": "regular", "configuration": {"chunk_shape": [256, 256]}}, "chunk_key_encoding": {"name": "default"},
+			"codecs": [{"name": "bytes", "configuration": {"endian": "little"}}, {"name": "zstd"}], "fill_value": -9999, "dimension_names": ["y", null]}`,
+		"/v3.zarr/grid/elevation/c/0/0": "chunk",
+	})
+
+	testCases := map[string]struct {
+		dir     string
+		checker denyPrefix
+		want    string
+	}{
+		"consolidated v2": {
+			dir:  "/v2c.zarr",
+			want: "2 true map[title:era5] [] [{temp [365 180 360] [30 90 90] <f4 [delta blosc] NaN [time lat lon] map[units:K]}]",
+		},
+		"nested v2": {
+			dir:     "/v2.zarr",
+			checker: "/v2.zarr/secret",
+			want:    "2 false map[title:nested] [obs] [{obs/temp [365 180 360] [30 90 90] <f4 [delta blosc] NaN [] map[]}]",
+		},
+		"v3": {
+			dir:  "/v3.zarr",
+			want: "3 false map[title:v3] [grid] [{grid/elevation [1000 2000] [256 256] int16 [bytes zstd] -9999 [y ] map[]}]",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			info, err := ReadZarr(afs, tc.dir, tc.checker)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := fmt.Sprint(info.Version, " ", info.Consolidated, " ", info.Attributes, " ", info.Groups, " ", info.Arrays)
+			got = strings.ReplaceAll(got, "<nil>", "")
+			if got != tc.want {
+				t.Errorf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+
+	if ZarrVersion(afs, "/v2.zarr/obs/temp/0") != 0 {
+		t.Error("expected a chunk directory not to be a zarr store")
+	}
+	for name, want := range map[string]bool{
+		"/v2c.zarr/temp/0.0.0":           true,
+		"/v2.zarr/obs/temp/0/0/0":        true,
+		"/v3.zarr/grid/elevation/c/0/0":  true,
+		"/v3.zarr/zarr.json":             true,
+		"/v2c.zarr/temp/notes.txt":       false,
+		"/v3.zarr/grid/elevation/c/0/..": false,
+	} {
+		if got := InZarrStore(afs, name); got != want {
+			t.Errorf("InZarrStore(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestNewFileInfoZarr(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	writeZarrFixture(t, afs, map[string]string{
+		"/data/store.zarr/.zgroup":      `{"zarr_format": 2}`,
+		"/data/store.zarr/.zattrs":      `{}`,
+		"/data/store.zarr/temp/.zarray": zarrTempV2,
+		"/data/store.zarr/temp/0.0.0":   "chunk",
+		"/data/plain/readme.txt":        "text",
+		"/data/unnamed/.zgroup":         `{"zarr_format": 2}`,
+		"/data/unnamed/2020/.zarray":    zarrTempV2,
+		"/data/unnamed/2020/0.0.0":      "chunk",
+	})
+
+	dir, err := NewFileInfo(&FileOptions{Fs: afs, Path: "/data", Expand: true, Checker: denyPrefix("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for _, item := range dir.Items {
+		types[item.Name] = item.Type
+	}
+	if types["store.zarr"] != "zarr" || types["plain"] != "" || types["unnamed"] != "" {
+		t.Errorf("unexpected item types %v", types)
+	}
+	if unnamed, err := NewFileInfo(&FileOptions{Fs: afs, Path: "/data/unnamed", Expand: true, Checker: denyPrefix("")}); err != nil || unnamed.Type != "zarr" {
+		t.Errorf("expected a store not named *.zarr to be recognized when opened, got %+v: %v", unnamed, err)
+	}
+	if !InZarrStore(afs, "/data/unnamed/2020/0.0.0") {
+		t.Error("expected the chunk of an array named by digits to be in the store")
+	}
+
+	store, err := NewFileInfo(&FileOptions{Fs: afs, Path: "/data/store.zarr", Expand: true, Checker: denyPrefix("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Type != "zarr" || store.Zarr == nil || len(store.Zarr.Arrays) != 1 || store.Zarr.Arrays[0].Path != "temp" {
+		t.Fatalf("unexpected store %+v", store)
+	}
+	names := []string{}
+	for _, item := range store.Items {
+		names = append(names, item.Name)
+	}
+	if strings.Join(names, " ") != ".zgroup .zattrs" || store.NumFiles != 2 || store.NumDirs != 0 {
+		t.Errorf("unexpected store listing %v", names)
+	}
+}
@@ -7,7 +7,10 @@ export async function fetch(url: string, password: string = "") {
   const res = await fetchURL(
     `/api/public/share${url}`,
     {
-      headers: { "X-SHARE-PASSWORD": encodeURIComponent(password) },
+      headers: {
+        Accept: "application/json",
+        "X-SHARE-PASSWORD": encodeURIComponent(password),
+      },
     },
     false
   );
@@ -9,12 +9,26 @@ export async function get(url: string) {
   return fetchJSON<Share>(`/api/share${url}`);
 }
 
+export async function stats(hash: string) {
+  return fetchJSON(`/api/share/${hash}/stats`);
+}
+
 export async function remove(hash: string) {
   await fetchURL(`/api/share/${hash}`, {
     method: "DELETE",
   });
 }
 
+export async function update(
+  hash: string,
+  changes: { [key: string]: string | boolean }
+) {
+  return fetchJSON<Share>(`/api/share/${hash}`, {
+    method: "PATCH",
+    body: JSON.stringify(changes),
+  });
+}
+
 export async function create(
   url: string,
   password = "",
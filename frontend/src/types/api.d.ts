@@ -24,6 +24,7 @@ interface Share {
   path: string;
   expire?: any;
   description?: string;
+  license?: string;
   userID?: number;
   token?: string;
   username?: string;
@@ -38,6 +38,8 @@ type ResourceType =
   | "text"
   | "tiff"
   | "parquet"
+  | "zarr"
+  | "netcdf"
   | "blob"
   | "textImmutable";
 
@@ -0,0 +1,94 @@
+package http
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/tomasen/realip"
+
+	"github.com/versioneer-tech/package-r/analytics"
+)
+
+// countingWriter counts the bytes written of a response.
+type countingWriter struct {
+	http.ResponseWriter
+	bytes int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streamed responses, e.g. of the catalog, flowing.
+func (w *countingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// asksPresign reports whether a request asks for presigned URLs instead of
+// the data.
+func asksPresign(r *http.Request) bool {
+	if isManifestAlgorithm(r) {
+		return true
+	}
+	presign, ok := r.URL.Query()["presign"]
+	return ok && !strings.EqualFold(presign[0], "false")
+}
+
+// withAccessEvent records an access event of kind, or of presigning, for the
+// share of the request once fn answered it successfully. Events are written
+// in the background, so recording doesn't hold up the response.
+func withAccessEvent(kind string, fn handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		if d.settings.Analytics.Disabled {
+			return fn(w, r, d)
+		}
+
+		hash, _, _ := strings.Cut(r.URL.Path, "/")
+		presigned := asksPresign(r)
+
+		cw := &countingWriter{ResponseWriter: w}
+		status, err := fn(cw, r, d)
+		if err != nil || status >= http.StatusBadRequest {
+			return status, err
+		}
+
+		if presigned || status == http.StatusTemporaryRedirect {
+			kind = analytics.EventPresign
+		}
+		event := analytics.NewEvent(hash, kind, cw.bytes, realip.FromRequest(r), r.UserAgent())
+		if err := d.store.Analytics.Record(event, d.settings.Analytics.GetRetention()); err != nil {
+			log.Printf("failed to record access of share %s: %v", hash, err)
+		}
+
+		return status, err
+	}
+}
+
+// shareStatsHandler returns the access statistics of a share to its owner
+// and admins.
+var shareStatsHandler = withPermShare(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	link, err := d.store.Share.GetByHash(mux.Vars(r)["hash"])
+	if err != nil {
+		return errToStatus(err), err
+	}
+	if link.UserID != d.user.ID && !d.user.Perm.Admin {
+		return http.StatusForbidden, nil
+	}
+
+	stats, err := d.store.Analytics.Stats(link.Hash)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return renderJSON(w, r, stats)
+})
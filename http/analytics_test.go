@@ -0,0 +1,61 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/versioneer-tech/package-r/analytics"
+	"github.com/versioneer-tech/package-r/settings"
+	"github.com/versioneer-tech/package-r/share"
+)
+
+func TestWithAccessEvent(t *testing.T) {
+	storage, _ := newTestStorage(t, map[string]string{"/data/plots.csv": "id,x\n1,2\n"},
+		&share.Link{Hash: "h", Path: "/data/", UserID: 1})
+
+	serve := func(handler handleFunc, target string) int {
+		req, err := http.NewRequest(http.MethodGet, target, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "203.0.113.77:4242"
+		req.Header.Set("User-Agent", "curl/8.0")
+		recorder := httptest.NewRecorder()
+		handle(handler, "", storage, &settings.Server{}).ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for _, target := range []string{"h/plots.csv", "h/missing.csv"} {
+		serve(publicShareHandler, target)
+	}
+	if code := serve(publicDlHandler, "h/plots.csv"); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	serve(publicDlHandler, "unknown/plots.csv")
+
+	storage.Analytics.Flush()
+	stats, err := storage.Analytics.Stats("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Totals.Views != 1 || stats.Totals.Downloads != 1 || len(stats.Events) != 2 {
+		t.Fatalf("expected a view and a download, got %+v", stats)
+	}
+	download := stats.Events[0]
+	if download.Kind != analytics.EventDownload || download.Bytes != 9 || download.IP != "203.0.113.0" || download.UserAgent != "curl/8.0" {
+		t.Errorf("unexpected event %+v", download)
+	}
+
+	if err := storage.Analytics.Compact(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	compacted, err := storage.Analytics.Stats("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.Totals != stats.Totals || len(compacted.Days) != 1 || len(compacted.Events) != 0 {
+		t.Errorf("expected the events to be folded into a day, got %+v", compacted)
+	}
+}
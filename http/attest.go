@@ -0,0 +1,153 @@
+package http
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/versioneer-tech/package-r/attest"
+	"github.com/versioneer-tech/package-r/files"
+	"github.com/versioneer-tech/package-r/lru"
+)
+
+// manifests caches the manifests of shares by their version, which changes
+// along with the name, source or any file of a share, so that a manifest
+// and its signature are built from the same pass over the files.
+var manifests = lru.New[string, []byte](1000)
+
+// publicAttestationHandler publishes the manifest of a share with the SHA-256
+// checksums of its files, its Ed25519 signature and the public key to
+// verify it. Without a signing key only the manifest is published. A shared
+// folder that holds its own manifest and signature serves its files instead.
+var publicAttestationHandler = func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	name := mux.Vars(r)["attestation"]
+	hash, _, _ := strings.Cut(r.URL.Path, "/")
+	r.URL.Path = hash
+
+	return withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		if !d.user.Perm.Download {
+			return http.StatusForbidden, nil
+		}
+		file := d.raw.(*catalogedFile).File
+
+		if file.IsDir {
+			if own, ok := ownAttestation(d, file, name); ok {
+				if own == nil {
+					return http.StatusNotFound, nil
+				}
+				return rawFileHandler(w, r, own)
+			}
+		}
+
+		key, err := d.settings.Signing.PrivateKey()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if key == nil && name != attest.ManifestName {
+			return http.StatusNotFound, nil
+		}
+
+		var (
+			out         []byte
+			contentType string
+		)
+		if name == attest.PublicKeyName {
+			out, err = attest.MarshalPublicKey(key.Public().(ed25519.PublicKey))
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			contentType = "application/x-pem-file"
+		} else {
+			out, err = shareManifest(r, d, file, hash)
+			if err != nil {
+				return errToStatus(err), err
+			}
+			contentType = "application/json; charset=utf-8"
+
+			if name == attest.SignatureName {
+				out = attest.Sign(key, out)
+				contentType = "text/plain; charset=utf-8"
+			}
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if _, err := w.Write(out); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return 0, nil
+	})(w, r, d)
+}
+
+// ownAttestation returns the file name of the shared folder dir, if the
+// folder holds its own manifest and signature, which are only served as a
+// set so that a signature is never paired with a manifest it doesn't sign.
+// The file is nil if the folder lacks it.
+func ownAttestation(d *data, dir *files.FileInfo, name string) (*files.FileInfo, bool) {
+	stat := func(name string) *files.FileInfo {
+		p := path.Join(dir.Path, name)
+		if !d.Check(p) {
+			return nil
+		}
+		fi, err := files.NewFileInfo(&files.FileOptions{
+			Fs:      d.user.Fs,
+			Path:    p,
+			Checker: d,
+		})
+		if err != nil || fi.IsDir {
+			return nil
+		}
+		return fi
+	}
+
+	manifest, signature := stat(attest.ManifestName), stat(attest.SignatureName)
+	if manifest == nil || signature == nil {
+		return nil, false
+	}
+	switch name {
+	case attest.ManifestName:
+		return manifest, true
+	case attest.SignatureName:
+		return signature, true
+	default:
+		return stat(name), true
+	}
+}
+
+// shareManifest returns the marshaled manifest of the share hash of file,
+// built once per version of the share.
+func shareManifest(r *http.Request, d *data, file *files.FileInfo, hash string) ([]byte, error) {
+	described, err := describeFiles(r, d, file, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &attest.Manifest{
+		Version: attest.ManifestVersion,
+		Name:    file.Name,
+		Source:  publicURL(r, "share", hash),
+		Files:   make([]*attest.File, 0, len(described)),
+	}
+	version := sha256.New()
+	fmt.Fprintf(version, "%s\x00%s\x00%s\n", hash, m.Name, m.Source)
+	for _, f := range described {
+		m.Files = append(m.Files, &attest.File{Path: f.Path, Size: f.Size, SHA256: f.Checksum})
+		fmt.Fprintf(version, "%s\x00%d\x00%d\n", f.Path, f.Size, f.ModTime.UnixNano())
+	}
+
+	key := hex.EncodeToString(version.Sum(nil))
+	if out, ok := manifests.Get(key); ok {
+		return out, nil
+	}
+	out, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	manifests.Add(key, out)
+	return out, nil
+}
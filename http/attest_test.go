@@ -0,0 +1,112 @@
+package http
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+
+	"github.com/versioneer-tech/package-r/attest"
+	"github.com/versioneer-tech/package-r/settings"
+	"github.com/versioneer-tech/package-r/share"
+)
+
+func TestPublicAttestationHandler(t *testing.T) {
+	storage, fs := newTestStorage(t, map[string]string{
+		"/data/plots.csv":        "id,x\n1,2\n",
+		"/data/scans/a.tif":      "tiff",
+		"/signed/manifest.json":  `{"version":1}`,
+		"/signed/manifest.sig":   "c2ln",
+		"/partial/manifest.json": `{"version":1}`,
+		"/partial/a.txt":         "a",
+	},
+		&share.Link{Hash: "h", Path: "/data/", UserID: 1},
+		&share.Link{Hash: "own", Path: "/signed/", UserID: 1},
+		&share.Link{Hash: "partial", Path: "/partial/", UserID: 1},
+	)
+	seed := make([]byte, ed25519.SeedSize)
+	if err := storage.Settings.Save(&settings.Settings{Key: []byte("key"), Signing: settings.Signing{Key: seed}}); err != nil {
+		t.Fatal(err)
+	}
+
+	serveStatus := func(hash, name string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, hash+"/"+name, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"attestation": name})
+		recorder := httptest.NewRecorder()
+		handle(publicAttestationHandler, "", storage, &settings.Server{}).ServeHTTP(recorder, req)
+		return recorder
+	}
+	serveShare := func(hash, name string) []byte {
+		recorder := serveStatus(hash, name)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", name, recorder.Code)
+		}
+		return recorder.Body.Bytes()
+	}
+	serve := func(name string) []byte {
+		return serveShare("h", name)
+	}
+
+	manifest := serve(attest.ManifestName)
+	pub, err := attest.ParsePublicKey(serve(attest.PublicKeyName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(ed25519.NewKeyFromSeed(seed).Public()) {
+		t.Errorf("unexpected public key %v", pub)
+	}
+	if err := attest.CheckSignature(pub, manifest, serve(attest.SignatureName)); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &attest.Manifest{}
+	if err := json.Unmarshal(manifest, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 2 || m.Files[0].Path != "plots.csv" || m.Files[1].Path != "scans/a.tif" {
+		t.Errorf("unexpected manifest %s", manifest)
+	}
+
+	problems, err := attest.Verify(afero.NewBasePathFs(fs, "/data"), "/", m)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("unexpected problems %v: %v", problems, err)
+	}
+
+	// the signature covers the manifest of the changed share
+	if err := afero.WriteFile(fs, "/data/notes.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := serve(attest.ManifestName)
+	if bytes.Equal(changed, manifest) {
+		t.Error("expected the manifest to change along with the share")
+	}
+	if err := attest.CheckSignature(pub, changed, serve(attest.SignatureName)); err != nil {
+		t.Error(err)
+	}
+
+	// a folder's own manifest files are served only as a set
+	if got := serveShare("own", attest.ManifestName); string(got) != `{"version":1}` {
+		t.Errorf("expected the shared manifest, got %q", got)
+	}
+	if got := serveShare("own", attest.SignatureName); string(got) != "c2ln" {
+		t.Errorf("expected the shared signature, got %q", got)
+	}
+	if code := serveStatus("own", attest.PublicKeyName).Code; code != http.StatusNotFound {
+		t.Errorf("got status %d for the missing public key of a shared manifest", code)
+	}
+	partial := serveShare("partial", attest.ManifestName)
+	if string(partial) == `{"version":1}` {
+		t.Error("expected the generated manifest for a folder without signature")
+	}
+	if err := attest.CheckSignature(pub, partial, serveShare("partial", attest.SignatureName)); err != nil {
+		t.Error(err)
+	}
+}
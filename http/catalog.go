@@ -2,34 +2,144 @@ package http
 
 import (
 	"net/http"
+	"path"
 	"strings"
 
+	"github.com/versioneer-tech/package-r/analytics"
 	"github.com/versioneer-tech/package-r/catalog"
 )
 
-var catalogHandler = withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+var catalogHandler = withAccessEvent(analytics.EventCatalog, func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	hash, route, ok := stacRoute(r.URL.Path)
+	if !ok {
+		if r.Method == http.MethodPost {
+			return http.StatusMethodNotAllowed, nil
+		}
+		return catalogFileHandler(w, r, d)
+	}
+
+	r.URL.Path = hash
+	return withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		return stacHandler(w, r, d, hash, route)
+	})(w, r, d)
+})
+
+var catalogFileHandler = withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 	cf := d.raw.(*catalogedFile)
 
 	if cf.CatalogURL == "" {
 		return http.StatusNotFound, nil
 	}
 
-	parts := strings.Split(r.URL.Path, "/")
+	hash, p, _ := strings.Cut(r.URL.Path, "/")
+	if p != "" {
+		p = "/" + p
+	}
+
+	src := catalogSource(r, cf, hash)
+	urls := newStacURLs(r, hash)
 
-	if len(parts) < 1 {
-		return http.StatusBadRequest, nil
+	search, err := stacSearchFromQuery(r.URL.Query())
+	if err != nil {
+		return errToStatus(err), err
 	}
 
+	if contentType := stacStreamType(r); contentType != "" {
+		return stacStream(w, r, src, urls, search, contentType)
+	}
+
+	result, err := catalog.SearchItems(r.Context(), src, search)
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	// a single item, e.g. the one of a previewed file, is returned as is
+	if result.Matched == 1 && search.Token == "" {
+		return renderJSON(w, r, stacItem(urls, result.Items[0]))
+	}
+
+	return renderItemCollection(w, r, urls, search, p, result)
+})
+
+// catalogSource returns the part of the catalog of a share visible at the
+// requested path.
+func catalogSource(r *http.Request, cf *catalogedFile, hash string) catalog.Source {
+	src := catalog.Source{
+		Path:        cf.CatalogURL,
+		FilterField: cf.FilterField,
+		BaseURL:     cf.AssetsBaseURL,
+		RequestPath: cf.File.Path,
+		AssetsURL:   publicURL(r, "share", hash),
+		Collection:  hash,
+	}
+
+	// the assets of a package are below AssetsBaseURL at the paths of its
+	// items in the user's scope
+	if cf.Package != nil {
+		src.Mounts = []catalog.Mount{}
+		for _, p := range cf.Package.Paths {
+			src.Mounts = append(src.Mounts, catalog.Mount{Path: p, Name: path.Base(p)})
+		}
+	}
+
+	return src
+}
+
+// publicURL returns the absolute URL of a public endpoint of the share hash.
+func publicURL(r *http.Request, endpoint, hash string) string {
 	scheme := "https"
 	if strings.HasPrefix(r.Host, "localhost") {
 		scheme = "http"
 	}
-	assetsURL := scheme + "://" + r.Host + "/api/public/share/" + parts[0] // TBD consider configurable base path
+	return scheme + "://" + r.Host + "/api/public/" + endpoint + "/" + hash // TBD consider configurable base path
+}
+
+type catalogBuildResponse struct {
+	Path  string `json:"path"`
+	Items int    `json:"items"`
+}
+
+// catalogBuildHandler builds a stac-geoparquet catalog of the directory at
+// the request path, by default stored inside it under the catalog default
+// name.
+var catalogBuildHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if !d.user.Perm.Create {
+		return http.StatusForbidden, nil
+	}
+
+	query := r.URL.Query()
+	output := query.Get("output")
+	if output == "" {
+		name := d.settings.Catalog.DefaultName
+		if name == "" {
+			name = catalog.DefaultCatalogName
+		}
+		output = path.Join(r.URL.Path, name)
+	}
+	output = path.Clean("/" + output)
+
+	if !d.Check(r.URL.Path) || !d.Check(output) {
+		return http.StatusForbidden, nil
+	}
+	if _, err := d.user.Fs.Stat(output); err == nil && !d.user.Perm.Modify {
+		return http.StatusForbidden, nil
+	}
 
-	result, err := catalog.QueryCatalogParquet(r.Context(), cf.CatalogURL, cf.FilterField, cf.AssetsBaseURL, cf.File.Path, assetsURL)
+	var count int
+	err := d.RunHook(func() error {
+		var err error
+		count, err = catalog.Build(r.Context(), d.user.Fs, r.URL.Path, catalog.BuildOptions{
+			Output:        output,
+			Collection:    query.Get("collection"),
+			AssetKey:      query.Get("assetKey"),
+			AssetsBaseURL: query.Get("assetsBaseURL"),
+			Checker:       d,
+		})
+		return err
+	}, "catalog", r.URL.Path, output, d.user)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return errToStatus(err), err
 	}
 
-	return renderJSON(w, r, result)
+	return renderJSON(w, r, catalogBuildResponse{Path: output, Items: count})
 })
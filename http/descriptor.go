@@ -0,0 +1,333 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/versioneer-tech/package-r/files"
+	"github.com/versioneer-tech/package-r/lru"
+)
+
+const roCrateName = "ro-crate-metadata.json"
+
+// checksumKey identifies the SHA-256 checksum of a file of a share. Files
+// that are changed get a different size or modification time and are hashed
+// again.
+type checksumKey struct {
+	hash    string
+	path    string
+	size    int64
+	modTime int64
+}
+
+var (
+	// checksums caches the checksums of shared files, so that the
+	// descriptors of a share don't read all of its data on every request.
+	checksums = lru.New[checksumKey, string](100000)
+	// hashing makes concurrent requests for a share wait for the checksum
+	// of a file another one is computing, rather than reading it again.
+	hashing singleflight.Group
+)
+
+// describedFile is a file of a share as listed by its package descriptors.
+type describedFile struct {
+	Path      string
+	URL       string
+	Size      int64
+	MediaType string
+	ModTime   time.Time
+	// Checksum is the SHA-256 checksum of the file.
+	Checksum string
+}
+
+// dataPackage is a Frictionless Data Package, see
+// https://specs.frictionlessdata.io/data-package/.
+type dataPackage struct {
+	Profile     string                `json:"profile"`
+	ID          string                `json:"id"`
+	Name        string                `json:"name"`
+	Title       string                `json:"title"`
+	Description string                `json:"description,omitempty"`
+	Licenses    []dataPackageLicense  `json:"licenses,omitempty"`
+	Resources   []dataPackageResource `json:"resources"`
+}
+
+type dataPackageLicense struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+}
+
+type dataPackageResource struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Title     string `json:"title"`
+	Format    string `json:"format,omitempty"`
+	MediaType string `json:"mediatype"`
+	Bytes     int64  `json:"bytes"`
+	Hash      string `json:"hash,omitempty"`
+}
+
+var resourceNameInvalid = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// resourceName turns a path into a Data Package name, which may only
+// contain lowercase alphanumerics, ".", "_" and "-".
+func resourceName(p string) string {
+	name := strings.Trim(resourceNameInvalid.ReplaceAllString(strings.ToLower(p), "-"), "-")
+	if name == "" {
+		return "resource"
+	}
+	return name
+}
+
+// licenseURL returns the URL of a license given either by URL or by SPDX
+// identifier.
+func licenseURL(license string) string {
+	if u, err := url.Parse(license); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return license
+	}
+	return "https://spdx.org/licenses/" + url.PathEscape(license)
+}
+
+// publicDescriptorHandler serves a Frictionless datapackage.json or an
+// RO-Crate ro-crate-metadata.json of a share, generated from its files
+// unless the shared folder comes with its own. Either way it takes the
+// download permission, as the checksums disclose the data.
+var publicDescriptorHandler = func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	name := mux.Vars(r)["descriptor"]
+	hash, _, _ := strings.Cut(r.URL.Path, "/")
+	r.URL.Path = hash
+
+	return withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		if !d.user.Perm.Download {
+			return http.StatusForbidden, nil
+		}
+		cf := d.raw.(*catalogedFile)
+		file := cf.File
+
+		if file.IsDir && d.Check(path.Join(file.Path, name)) {
+			own, err := files.NewFileInfo(&files.FileOptions{
+				Fs:      d.user.Fs,
+				Path:    path.Join(file.Path, name),
+				Checker: d,
+			})
+			if err == nil && !own.IsDir {
+				return rawFileHandler(w, r, own)
+			}
+		}
+
+		described, err := describeFiles(r, d, file, hash)
+		if err != nil {
+			return errToStatus(err), err
+		}
+
+		if cf.Description == "" && cf.Package != nil {
+			cf.Description = cf.Package.Description
+		}
+
+		if name == roCrateName {
+			out, err := json.Marshal(roCrate(cf, described))
+			if err != nil {
+				return http.StatusInternalServerError, err
+			}
+			w.Header().Set("Content-Type", "application/ld+json; charset=utf-8")
+			if _, err := w.Write(out); err != nil {
+				return http.StatusInternalServerError, err
+			}
+			return 0, nil
+		}
+		return renderJSON(w, r, newDataPackage(publicURL(r, "share", hash), cf, described))
+	})(w, r, d)
+}
+
+// describeFiles lists the files of a share below file, which must be allowed
+// by the rules of the share's owner, with their SHA-256 checksums. Checksums
+// are only computed for files that changed since they were last described,
+// and once for concurrent requests.
+func describeFiles(r *http.Request, d *data, file *files.FileInfo, hash string) ([]*describedFile, error) {
+	base := publicURL(r, "dl", hash)
+	described := []*describedFile{}
+
+	err := afero.Walk(d.user.Fs, file.Path, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Check(p) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, file.Path), "/")
+		if !file.IsDir {
+			rel = file.Name
+		}
+
+		target := base + "/" + (&url.URL{Path: rel}).EscapedPath()
+		if file.Token != "" {
+			target += "?token=" + url.QueryEscape(file.Token)
+		}
+
+		mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(p)))
+		if err != nil {
+			mediaType = files.ContentBinaryHeaderValue
+		}
+
+		checksum, err := fileChecksum(d, hash, p, info)
+		if err != nil {
+			return err
+		}
+
+		described = append(described, &describedFile{
+			Path:      rel,
+			URL:       target,
+			Size:      info.Size(),
+			MediaType: mediaType,
+			ModTime:   info.ModTime(),
+			Checksum:  checksum,
+		})
+		return nil
+	})
+
+	return described, err
+}
+
+// fileChecksum returns the cached SHA-256 checksum of the file p of the share
+// hash, or computes it.
+func fileChecksum(d *data, hash, p string, info fs.FileInfo) (string, error) {
+	key := checksumKey{hash: hash, path: p, size: info.Size(), modTime: info.ModTime().UnixNano()}
+	if checksum, ok := checksums.Get(key); ok {
+		return checksum, nil
+	}
+
+	flight := fmt.Sprintf("%s\x00%s\x00%d\x00%d", key.hash, key.path, key.size, key.modTime)
+	checksum, err, _ := hashing.Do(flight, func() (interface{}, error) {
+		if checksum, ok := checksums.Get(key); ok {
+			return checksum, nil
+		}
+		fi := &files.FileInfo{Fs: d.user.Fs, Path: p}
+		if err := fi.Checksum("sha256"); err != nil {
+			return "", err
+		}
+		checksums.Add(key, fi.Checksums["sha256"])
+		return fi.Checksums["sha256"], nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return checksum.(string), nil
+}
+
+func newDataPackage(id string, cf *catalogedFile, described []*describedFile) *dataPackage {
+	dp := &dataPackage{
+		Profile:     "data-package",
+		ID:          id,
+		Name:        resourceName(cf.File.Name),
+		Title:       cf.File.Name,
+		Description: cf.Description,
+		Resources:   make([]dataPackageResource, 0, len(described)),
+	}
+	if cf.License != "" {
+		license := dataPackageLicense{Path: licenseURL(cf.License)}
+		if license.Path != cf.License {
+			license.Name = cf.License
+		}
+		dp.Licenses = []dataPackageLicense{license}
+	}
+
+	names := map[string]int{}
+	for _, f := range described {
+		// names must be unique, so clashes of paths that only differ in
+		// invalid characters are numbered
+		name := resourceName(f.Path)
+		if n := names[name]; n > 0 {
+			names[name]++
+			name += "-" + strconv.Itoa(n)
+		} else {
+			names[name] = 1
+		}
+
+		dp.Resources = append(dp.Resources, dataPackageResource{
+			Name:      name,
+			Path:      f.URL,
+			Title:     f.Path,
+			Format:    strings.ToLower(strings.TrimPrefix(filepath.Ext(f.Path), ".")),
+			MediaType: f.MediaType,
+			Bytes:     f.Size,
+			Hash:      "sha256:" + f.Checksum,
+		})
+	}
+
+	return dp
+}
+
+// roCrate builds an RO-Crate 1.1 metadata document, see
+// https://www.researchobject.org/ro-crate/1.1/, whose files are web-based
+// data entities identified by their download URLs.
+func roCrate(cf *catalogedFile, described []*describedFile) map[string]interface{} {
+	parts := make([]map[string]string, 0, len(described))
+	graph := []map[string]interface{}{
+		{
+			"@id":        roCrateName,
+			"@type":      "CreativeWork",
+			"conformsTo": map[string]string{"@id": "https://w3id.org/ro/crate/1.1"},
+			"about":      map[string]string{"@id": "./"},
+		},
+	}
+
+	root := map[string]interface{}{
+		"@id":           "./",
+		"@type":         "Dataset",
+		"name":          cf.File.Name,
+		"datePublished": cf.File.ModTime.UTC().Format(time.RFC3339),
+	}
+	if cf.Description != "" {
+		root["description"] = cf.Description
+	}
+	graph = append(graph, root)
+
+	if cf.License != "" {
+		root["license"] = map[string]string{"@id": licenseURL(cf.License)}
+		graph = append(graph, map[string]interface{}{
+			"@id":   licenseURL(cf.License),
+			"@type": "CreativeWork",
+			"name":  cf.License,
+		})
+	}
+
+	for _, f := range described {
+		parts = append(parts, map[string]string{"@id": f.URL})
+		graph = append(graph, map[string]interface{}{
+			"@id":            f.URL,
+			"@type":          "File",
+			"name":           f.Path,
+			"contentSize":    strconv.FormatInt(f.Size, 10),
+			"encodingFormat": f.MediaType,
+			"dateModified":   f.ModTime.UTC().Format(time.RFC3339),
+			"sha256":         f.Checksum,
+		})
+	}
+	root["hasPart"] = parts
+
+	return map[string]interface{}{
+		"@context": "https://w3id.org/ro/crate/1.1/context",
+		"@graph":   graph,
+	}
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+
+	"github.com/versioneer-tech/package-r/settings"
+	"github.com/versioneer-tech/package-r/share"
+	"github.com/versioneer-tech/package-r/users"
+)
+
+func TestPublicDescriptorHandler(t *testing.T) {
+	storage, fs := newTestStorage(t, map[string]string{
+		"/data/plots.csv":           "id,x\n1,2\n",
+		"/data/Raw Scans/a.tif":     "tiff",
+		"/curated/datapackage.json": `{"name":"curated"}`,
+	},
+		&share.Link{Hash: "h", Path: "/data/", UserID: 1, Description: "Field survey", License: "CC-BY-4.0"},
+		&share.Link{Hash: "own", Path: "/curated/", UserID: 1},
+		&share.Link{Hash: "nodl", Path: "/data/", UserID: 2},
+	)
+	if err := storage.Users.Save(&users.User{Username: "viewer", Password: "pw"}); err != nil {
+		t.Fatal(err)
+	}
+
+	serveStatus := func(target, descriptor string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, target, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "localhost"
+		req = mux.SetURLVars(req, map[string]string{"descriptor": descriptor})
+		recorder := httptest.NewRecorder()
+		handle(publicDescriptorHandler, "", storage, &settings.Server{}).ServeHTTP(recorder, req)
+		return recorder
+	}
+	serve := func(target, descriptor string) *httptest.ResponseRecorder {
+		recorder := serveStatus(target, descriptor)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", target, recorder.Code)
+		}
+		return recorder
+	}
+
+	var dp dataPackage
+	if err := json.Unmarshal(serve("h/datapackage.json", "datapackage.json").Body.Bytes(), &dp); err != nil {
+		t.Fatal(err)
+	}
+	if dp.Description != "Field survey" || len(dp.Licenses) != 1 || dp.Licenses[0].Path != "https://spdx.org/licenses/CC-BY-4.0" {
+		t.Errorf("unexpected package %+v", dp)
+	}
+	if len(dp.Resources) != 2 {
+		t.Fatalf("unexpected resources %+v", dp.Resources)
+	}
+	scan, plots := dp.Resources[0], dp.Resources[1]
+	if scan.Name != "raw-scans-a.tif" || scan.Path != "http://localhost/api/public/dl/h/Raw%20Scans/a.tif" || scan.MediaType != "image/tiff" || scan.Bytes != 4 {
+		t.Errorf("unexpected resource %+v", scan)
+	}
+	if sum := sha256.Sum256([]byte("id,x\n1,2\n")); plots.Hash != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected hash %q", plots.Hash)
+	}
+	if plots.MediaType != "text/csv" || plots.Format != "csv" {
+		t.Errorf("unexpected resource %+v", plots)
+	}
+
+	recorder := serve("h/ro-crate-metadata.json", "ro-crate-metadata.json")
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/ld+json; charset=utf-8" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	var crate struct {
+		Graph []map[string]interface{} `json:"@graph"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &crate); err != nil {
+		t.Fatal(err)
+	}
+	entities := map[string]map[string]interface{}{}
+	for _, entity := range crate.Graph {
+		entities[entity["@id"].(string)] = entity
+	}
+	root := entities["./"]
+	if root == nil || root["description"] != "Field survey" || len(root["hasPart"].([]interface{})) != 2 {
+		t.Errorf("unexpected root %v", root)
+	}
+	if entities["https://spdx.org/licenses/CC-BY-4.0"] == nil || entities[roCrateName] == nil {
+		t.Errorf("missing entities in %v", crate.Graph)
+	}
+	if file := entities[plots.Path]; file == nil || file["contentSize"] != "9" || file["sha256"] != plots.Hash[len("sha256:"):] {
+		t.Errorf("unexpected file %v", file)
+	}
+
+	if body := serve("own/datapackage.json", "datapackage.json").Body.String(); body != `{"name":"curated"}` {
+		t.Errorf("expected the shared descriptor, got %q", body)
+	}
+
+	if code := serveStatus("nodl/datapackage.json", "datapackage.json").Code; code != http.StatusForbidden {
+		t.Errorf("got status %d for a share without download permission", code)
+	}
+
+	// changed files are hashed again
+	if err := afero.WriteFile(fs, "/data/plots.csv", []byte("id,x\n3,4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chtimes("/data/plots.csv", time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	dp = dataPackage{}
+	if err := json.Unmarshal(serve("h/datapackage.json", "datapackage.json").Body.Bytes(), &dp); err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256([]byte("id,x\n3,4\n")); dp.Resources[1].Hash != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected hash %q of a changed file", dp.Resources[1].Hash)
+	}
+}
+
+// openCounter counts the files opened on a file system.
+type openCounter struct {
+	afero.Fs
+	opens atomic.Int32
+}
+
+func (c *openCounter) Open(name string) (afero.File, error) {
+	c.opens.Add(1)
+	return c.Fs.Open(name)
+}
+
+func TestFileChecksumOnce(t *testing.T) {
+	fs := &openCounter{Fs: afero.NewMemMapFs()}
+	if err := afero.WriteFile(fs.Fs, "/data/a.tif", []byte("tiff"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat("/data/a.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &data{user: &users.User{Fs: fs}}
+
+	var wg sync.WaitGroup
+	sums := make([]string, 8)
+	for i := range sums {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sums[i], _ = fileChecksum(d, "once", "/data/a.tif", info)
+		}()
+	}
+	wg.Wait()
+
+	sum := sha256.Sum256([]byte("tiff"))
+	for _, got := range sums {
+		if got != hex.EncodeToString(sum[:]) {
+			t.Errorf("unexpected checksum %q", got)
+		}
+	}
+	if opens := fs.opens.Load(); opens != 1 {
+		t.Errorf("expected the file to be read once, got %d", opens)
+	}
+}
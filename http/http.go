@@ -71,13 +71,30 @@ func NewHandler(
 	api.PathPrefix("/tus").Handler(monkey(tusPatchHandler(), "/api/tus")).Methods("PATCH")
 	api.PathPrefix("/tus").Handler(monkey(resourceDeleteHandler(fileCache), "/api/tus")).Methods("DELETE")
 
+	api.PathPrefix("/presign-upload").Handler(monkey(presignUploadPostHandler, "/api/presign-upload")).Methods("POST")
+	api.PathPrefix("/presign-upload").Handler(monkey(presignUploadPutHandler, "/api/presign-upload")).Methods("PUT")
+	api.PathPrefix("/presign-upload").Handler(monkey(presignUploadDeleteHandler, "/api/presign-upload")).Methods("DELETE")
+
 	api.PathPrefix("/usage").Handler(monkey(diskUsage, "/api/usage")).Methods("GET")
 
 	api.Path("/shares").Handler(monkey(shareListHandler, "/api/shares")).Methods("GET")
+	api.Handle("/share/{hash}/stats", monkey(shareStatsHandler, "")).Methods("GET")
 	api.PathPrefix("/share").Handler(monkey(shareGetsHandler, "/api/share")).Methods("GET")
 	api.PathPrefix("/share").Handler(monkey(sharePostHandler, "/api/share")).Methods("POST")
+	api.PathPrefix("/share").Handler(monkey(sharePutHandler, "/api/share")).Methods("PUT", "PATCH")
 	api.PathPrefix("/share").Handler(monkey(shareDeleteHandler, "/api/share")).Methods("DELETE")
 
+	pkgs := api.PathPrefix("/packages").Subrouter()
+	pkgs.Handle("", monkey(packageListHandler, "")).Methods("GET")
+	pkgs.Handle("", monkey(packagePostHandler, "")).Methods("POST")
+	pkgs.Handle("/{id:[0-9]+}", monkey(packageGetHandler, "")).Methods("GET")
+	pkgs.Handle("/{id:[0-9]+}", monkey(packagePutHandler, "")).Methods("PUT")
+	pkgs.Handle("/{id:[0-9]+}", monkey(packageDeleteHandler, "")).Methods("DELETE")
+
+	api.PathPrefix("/catalog").Handler(monkey(catalogBuildHandler, "/api/catalog")).Methods("POST")
+	api.PathPrefix("/inspect/parquet").Handler(monkey(inspectParquetHandler, "/api/inspect/parquet")).Methods("GET")
+	api.Handle("/query", monkey(queryHandler, "")).Methods("POST")
+
 	api.Handle("/settings", monkey(settingsGetHandler, "")).Methods("GET")
 	api.Handle("/settings", monkey(settingsPutHandler, "")).Methods("PUT")
 
@@ -90,8 +107,12 @@ func NewHandler(
 
 	public := api.PathPrefix("/public").Subrouter()
 	public.PathPrefix("/dl").Handler(monkey(publicDlHandler, "/api/public/dl/")).Methods("GET")
+	public.Handle(`/share/{hash}/{descriptor:datapackage\.json|ro-crate-metadata\.json}`, monkey(publicDescriptorHandler, "/api/public/share/")).Methods("GET")
+	public.Handle(`/share/{hash}/{attestation:manifest\.json|manifest\.sig|manifest\.pem}`, monkey(publicAttestationHandler, "/api/public/share/")).Methods("GET")
 	public.PathPrefix("/share").Handler(monkey(publicShareHandler, "/api/public/share/")).Methods("GET", "HEAD")
-	public.PathPrefix("/catalog").Handler(monkey(catalogHandler, "/api/public/catalog/")).Methods("GET", "HEAD")
+	public.PathPrefix("/catalog").Handler(monkey(catalogHandler, "/api/public/catalog/")).Methods("GET", "HEAD", "POST")
+	public.PathPrefix("/tiles").Handler(monkey(publicTileHandler(fileCache), "/api/public/tiles/")).Methods("GET")
+	public.PathPrefix("/inspect/parquet").Handler(monkey(publicInspectParquetHandler, "/api/public/inspect/parquet/")).Methods("GET")
 
 	return stripPrefix(server.BaseURL, r), nil
 }
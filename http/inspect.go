@@ -0,0 +1,93 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/versioneer-tech/package-r/catalog"
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
+)
+
+var inspectParquetHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if !d.user.Perm.Download {
+		return http.StatusForbidden, nil
+	}
+	if !d.Check(r.URL.Path) {
+		return http.StatusForbidden, nil
+	}
+
+	file, err := files.NewFileInfo(&files.FileOptions{
+		Fs:      d.user.Fs,
+		Path:    r.URL.Path,
+		Modify:  d.user.Perm.Modify,
+		Expand:  false,
+		Checker: d,
+	})
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	return inspectParquet(w, r, d, file, 0, 0)
+})
+
+var publicInspectParquetHandler = withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if !d.user.Perm.Download {
+		return http.StatusForbidden, nil
+	}
+
+	cf := d.raw.(*catalogedFile)
+	return inspectParquet(w, r, d, cf.File, cf.Expire, cf.Snapshot)
+})
+
+func inspectParquet(w http.ResponseWriter, r *http.Request, d *data, file *files.FileInfo, expire, snapshot int64) (int, error) {
+	if file.IsDir {
+		return http.StatusBadRequest, nil
+	}
+
+	sampleRows := catalog.DefaultSampleRows
+	if value := r.URL.Query().Get("rows"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		sampleRows = n
+	}
+
+	location, err := inspectLocation(r, d, file, expire, snapshot)
+	if errors.Is(err, fbErrors.ErrInvalidOption) {
+		return http.StatusBadRequest, err
+	} else if err != nil {
+		return errToStatus(err), err
+	}
+
+	info, err := catalog.InspectParquet(r.Context(), location, sampleRows)
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	return renderJSON(w, r, info)
+}
+
+// inspectLocation returns where DuckDB reads file from: the file on the
+// mounted file system or, with the S3 file system, a presigned URL that is
+// read with ranged requests so only the footer and sampled pages are
+// transferred.
+func inspectLocation(r *http.Request, d *data, file *files.FileInfo, expire, snapshot int64) (string, error) {
+	if d.user.Envs == nil || !files.IsS3FsBackend(*d.user.Envs) {
+		return file.RealPath(), nil
+	}
+
+	cutoff, err := presignCutoff(r, snapshot)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", err, fbErrors.ErrInvalidRequestParams)
+	}
+
+	return files.Presign(r.Context(), file.RealPath(), *d.user.Envs, files.PresignOptions{
+		Method: http.MethodGet,
+		Expiry: presignExpiry(d, expire),
+		Cutoff: cutoff,
+	})
+}
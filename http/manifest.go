@@ -0,0 +1,205 @@
+package http
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
+	"github.com/versioneer-tech/package-r/fileutils"
+)
+
+// manifestEntry is a single file of a manifest together with a presigned URL
+// to fetch it directly from object storage.
+type manifestEntry struct {
+	Path      string            `json:"path"`
+	Size      int64             `json:"size"`
+	URL       string            `json:"url"`
+	Checksums map[string]string `json:"checksums,omitempty"`
+}
+
+type manifest struct {
+	Name  string           `json:"name"`
+	Files []*manifestEntry `json:"files"`
+}
+
+type metalink struct {
+	XMLName xml.Name       `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Files   []metalinkFile `xml:"file"`
+}
+
+type metalinkFile struct {
+	Name   string         `xml:"name,attr"`
+	Size   int64          `xml:"size"`
+	Hashes []metalinkHash `xml:"hash,omitempty"`
+	URL    string         `xml:"url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// hashNames maps checksum algorithms to their IANA names used by Metalink
+// and aria2.
+var hashNames = map[string]string{
+	"md5":    "md5",
+	"sha1":   "sha-1",
+	"sha256": "sha-256",
+	"sha512": "sha-512",
+}
+
+func isManifestAlgorithm(r *http.Request) bool {
+	switch r.URL.Query().Get("algo") {
+	case "manifest", "metalink", "aria2":
+		return true
+	default:
+		return false
+	}
+}
+
+// rawManifestHandler answers with presigned URLs for every file of the
+// selection instead of streaming an archive through the server.
+func rawManifestHandler(w http.ResponseWriter, r *http.Request, d *data, file *files.FileInfo) (int, error) {
+	if d.user.Envs == nil {
+		return http.StatusBadRequest, nil
+	}
+
+	filenames, err := parseQueryFiles(r, file, d.user)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	realPath := func(name string) string { return name }
+	var expire, snapshot int64
+	if cf, ok := d.raw.(*catalogedFile); ok {
+		realPath = func(name string) string {
+			return (&files.FileInfo{Fs: d.user.Fs, Path: name}).RealPath()
+		}
+		expire, snapshot = cf.Expire, cf.Snapshot
+	}
+
+	cutoff, err := presignCutoff(r, snapshot)
+	if err != nil {
+		return http.StatusBadRequest, nil
+	}
+
+	presigner, err := files.NewPresigner(r.Context(), *d.user.Envs)
+	if errors.Is(err, fbErrors.ErrInvalidOption) {
+		return http.StatusBadRequest, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	expiry := presignExpiry(d, expire)
+	checksum := r.URL.Query().Get("checksum")
+
+	commonDir := fileutils.CommonPrefix(filepath.Separator, filenames...)
+	if len(filenames) == 1 && !file.IsDir {
+		commonDir = filepath.Dir(commonDir)
+	}
+
+	m := &manifest{Name: file.Name, Files: []*manifestEntry{}}
+	for _, fname := range filenames {
+		err := afero.Walk(d.user.Fs, fname, func(p string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Check(p) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			url, err := presigner.Presign(r.Context(), realPath(p), http.MethodGet, cutoff, expiry)
+			if err != nil {
+				return err
+			}
+
+			entry := &manifestEntry{
+				Path: strings.TrimPrefix(strings.TrimPrefix(p, commonDir), string(filepath.Separator)),
+				Size: info.Size(),
+				URL:  url,
+			}
+
+			if checksum != "" {
+				fi := &files.FileInfo{Fs: d.user.Fs, Path: p}
+				if err := fi.Checksum(checksum); err != nil {
+					return err
+				}
+				entry.Checksums = fi.Checksums
+			}
+
+			m.Files = append(m.Files, entry)
+			return nil
+		})
+		if errors.Is(err, fbErrors.ErrInvalidOption) {
+			return http.StatusBadRequest, err
+		} else if err != nil {
+			return errToStatus(err), err
+		}
+	}
+
+	switch r.URL.Query().Get("algo") {
+	case "metalink":
+		return renderMetalink(w, m)
+	case "aria2":
+		return renderAria2(w, m)
+	default:
+		return renderJSON(w, r, m)
+	}
+}
+
+func renderMetalink(w http.ResponseWriter, m *manifest) (int, error) {
+	ml := metalink{Files: make([]metalinkFile, 0, len(m.Files))}
+	for _, entry := range m.Files {
+		f := metalinkFile{Name: filepath.ToSlash(entry.Path), Size: entry.Size, URL: entry.URL}
+		for _, algo := range slices.Sorted(maps.Keys(entry.Checksums)) {
+			f.Hashes = append(f.Hashes, metalinkHash{Type: hashNames[algo], Value: entry.Checksums[algo]})
+		}
+		ml.Files = append(ml.Files, f)
+	}
+
+	out, err := xml.MarshalIndent(ml, "", "  ")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	w.Header().Set("Content-Type", "application/metalink4+xml")
+	w.Header().Set("Content-Disposition", "attachment; filename*=utf-8''"+url.PathEscape(m.Name+".meta4"))
+	if _, err := w.Write(append([]byte(xml.Header), out...)); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+// renderAria2 writes an aria2 input file, to be used with aria2c -i.
+func renderAria2(w http.ResponseWriter, m *manifest) (int, error) {
+	var b strings.Builder
+	for _, entry := range m.Files {
+		fmt.Fprintf(&b, "%s\n  out=%s\n", entry.URL, filepath.ToSlash(entry.Path))
+		for _, algo := range slices.Sorted(maps.Keys(entry.Checksums)) {
+			fmt.Fprintf(&b, "  checksum=%s=%s\n", hashNames[algo], entry.Checksums[algo])
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename*=utf-8''"+url.PathEscape(m.Name+".aria2"))
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
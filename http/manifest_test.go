@@ -0,0 +1,52 @@
+package http
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testManifest() *manifest {
+	return &manifest{
+		Name: "package",
+		Files: []*manifestEntry{
+			{Path: "raw/a.tif", Size: 42, URL: "https://s3/a?sig=1", Checksums: map[string]string{"sha256": "abc", "md5": "def"}},
+			{Path: "README.md", Size: 7, URL: "https://s3/readme?sig=2"},
+		},
+	}
+}
+
+func TestRenderMetalink(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	if status, err := renderMetalink(recorder, testManifest()); status != 0 || err != nil {
+		t.Fatalf("unexpected result %d: %v", status, err)
+	}
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`<metalink xmlns="urn:ietf:params:xml:ns:metalink">`,
+		`<file name="raw/a.tif">`,
+		`<size>42</size>`,
+		`<hash type="md5">def</hash>` + "\n    " + `<hash type="sha-256">abc</hash>`,
+		`<url>https://s3/readme?sig=2</url>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metalink to contain %q, got:\n%s", want, body)
+		}
+	}
+	if got := recorder.Header().Get("Content-Type"); got != "application/metalink4+xml" {
+		t.Errorf("unexpected content type %q", got)
+	}
+}
+
+func TestRenderAria2(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	if status, err := renderAria2(recorder, testManifest()); status != 0 || err != nil {
+		t.Fatalf("unexpected result %d: %v", status, err)
+	}
+
+	want := "https://s3/a?sig=1\n  out=raw/a.tif\n  checksum=md5=def\n  checksum=sha-256=abc\nhttps://s3/readme?sig=2\n  out=README.md\n"
+	if got := recorder.Body.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/packages"
+)
+
+// withPackage loads the package of the request id into d.raw, for its owner
+// or, unless ownerOnly, an admin.
+func withPackage(ownerOnly bool, fn handleFunc) handleFunc {
+	return withPermShare(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		id, err := getUserID(r)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		pkg, err := d.store.Packages.Get(id)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		if pkg.UserID != d.user.ID && (ownerOnly || !d.user.Perm.Admin) {
+			return http.StatusForbidden, nil
+		}
+
+		d.raw = pkg
+		return fn(w, r, d)
+	})
+}
+
+// decodePackage reads a package from the request body and checks that its
+// items exist in the user's scope and are allowed by the user's rules.
+func decodePackage(r *http.Request, d *data) (*packages.Package, int, error) {
+	if r.Body == nil {
+		return nil, http.StatusBadRequest, fbErrors.ErrEmptyRequest
+	}
+	defer r.Body.Close()
+
+	pkg := &packages.Package{}
+	if err := json.NewDecoder(r.Body).Decode(pkg); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to decode body: %w", err)
+	}
+	if err := pkg.Clean(); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	for _, p := range pkg.Paths {
+		if !d.Check(p) {
+			return nil, http.StatusForbidden, nil
+		}
+		if _, err := d.user.Fs.Stat(p); err != nil {
+			return nil, errToStatus(err), err
+		}
+	}
+
+	return pkg, 0, nil
+}
+
+var packageListHandler = withPermShare(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	var (
+		pkgs []*packages.Package
+		err  error
+	)
+	if d.user.Perm.Admin {
+		pkgs, err = d.store.Packages.All()
+	} else {
+		pkgs, err = d.store.Packages.FindByUserID(d.user.ID)
+	}
+	if errors.Is(err, fbErrors.ErrNotExist) {
+		return renderJSON(w, r, []*packages.Package{})
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].ID < pkgs[j].ID
+	})
+
+	return renderJSON(w, r, pkgs)
+})
+
+var packageGetHandler = withPackage(false, func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	return renderJSON(w, r, d.raw.(*packages.Package))
+})
+
+var packagePostHandler = withPermShare(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	pkg, status, err := decodePackage(r, d)
+	if status != 0 || err != nil {
+		return status, err
+	}
+
+	pkg.ID = 0
+	pkg.UserID = d.user.ID
+	if err := d.store.Packages.Save(pkg); err != nil {
+		return errToStatus(err), err
+	}
+
+	return renderJSON(w, r, pkg)
+})
+
+var packagePutHandler = withPackage(true, func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	pkg, status, err := decodePackage(r, d)
+	if status != 0 || err != nil {
+		return status, err
+	}
+
+	existing := d.raw.(*packages.Package)
+	pkg.ID = existing.ID
+	pkg.UserID = existing.UserID
+	if err := d.store.Packages.Save(pkg); err != nil {
+		return errToStatus(err), err
+	}
+
+	return renderJSON(w, r, pkg)
+})
+
+// packageDeleteHandler deletes a package unless it is still shared, so that
+// no share is left pointing at a missing package.
+var packageDeleteHandler = withPackage(false, func(_ http.ResponseWriter, _ *http.Request, d *data) (int, error) {
+	id := d.raw.(*packages.Package).ID
+	links, err := d.store.Share.FindByPackageID(id)
+	if err != nil && !errors.Is(err, fbErrors.ErrNotExist) {
+		return http.StatusInternalServerError, err
+	}
+	if len(links) > 0 {
+		return http.StatusConflict, nil
+	}
+
+	err = d.store.Packages.Delete(id)
+	return errToStatus(err), err
+})
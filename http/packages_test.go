@@ -0,0 +1,137 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/packages"
+	"github.com/versioneer-tech/package-r/settings"
+	"github.com/versioneer-tech/package-r/share"
+)
+
+func TestPackageShare(t *testing.T) {
+	storage, _ := newTestStorage(t, map[string]string{
+		"/raw/2023/a.tif": "tiff",
+		"/raw/2023/b.tif": "other",
+		"/labels/x.json":  "{}",
+	})
+	pkg := &packages.Package{Name: "curated", UserID: 1, Paths: []string{"/raw/2023/a.tif", "/labels"}}
+	if err := storage.Packages.Save(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Share.Save(&share.Link{Hash: "h", UserID: 1, PackageID: pkg.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(handler handleFunc, target string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, target, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		handle(handler, "", storage, &settings.Server{}).ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", target, recorder.Code)
+		}
+		return recorder
+	}
+
+	var listing struct {
+		Name  string `json:"name"`
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(serve(publicShareHandler, "h").Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Name != "curated" || len(listing.Items) != 2 {
+		t.Errorf("unexpected listing %+v", listing)
+	}
+
+	if body := serve(publicDlHandler, "h/labels/x.json").Body.String(); body != "{}" {
+		t.Errorf("unexpected download %q", body)
+	}
+
+	archive := serve(publicDlHandler, "h").Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name == "a.tif" {
+			rc, _ := f.Open()
+			content, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(content) != "tiff" {
+				t.Errorf("unexpected archived content %q", content)
+			}
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"a.tif", "labels/", "labels/x.json"}) {
+		t.Errorf("unexpected archive entries %v", names)
+	}
+}
+
+func TestPackageDeleteShared(t *testing.T) {
+	storage, _ := newTestStorage(t, nil)
+	pkg := &packages.Package{Name: "curated", UserID: 1, Paths: []string{"/a.tif"}}
+	if err := storage.Packages.Save(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Share.Save(&share.Link{Hash: "h", UserID: 1, PackageID: pkg.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &authToken{
+		User:             userInfo{ID: 1},
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove := func() int {
+		req, err := http.NewRequest(http.MethodDelete, "/", http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Auth", token)
+		req = mux.SetURLVars(req, map[string]string{"id": strconv.FormatUint(uint64(pkg.ID), 10)})
+		recorder := httptest.NewRecorder()
+		handle(packageDeleteHandler, "", storage, &settings.Server{}).ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if code := remove(); code != http.StatusConflict {
+		t.Errorf("deleting a shared package: unexpected status %d", code)
+	}
+	if _, err := storage.Packages.Get(pkg.ID); err != nil {
+		t.Fatalf("shared package was deleted: %v", err)
+	}
+
+	if err := storage.Share.Delete("h"); err != nil {
+		t.Fatal(err)
+	}
+	if code := remove(); code != http.StatusOK {
+		t.Errorf("deleting an unshared package: unexpected status %d", code)
+	}
+	if _, err := storage.Packages.Get(pkg.ID); !errors.Is(err, fbErrors.ErrNotExist) {
+		t.Errorf("package was not deleted: %v", err)
+	}
+}
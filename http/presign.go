@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/versioneer-tech/package-r/files"
+)
+
+// presignExpiry resolves the lifetime of presigned URLs from the settings and
+// the user's envs. If expire is set, the lifetime is clamped to the remaining
+// lifetime of the share so a presigned URL never outlives it.
+func presignExpiry(d *data, expire int64) time.Duration {
+	expiry := d.settings.Presign.GetExpiry(files.DefaultPresignExpiry)
+	if d.user.Envs != nil {
+		expiry = files.PresignExpiry(*d.user.Envs, expiry)
+	}
+
+	if expire != 0 {
+		remaining := time.Until(time.Unix(expire, 0)).Truncate(time.Second)
+		if remaining < time.Second {
+			remaining = time.Second
+		}
+		if remaining < expiry {
+			expiry = remaining
+		}
+	}
+
+	return expiry
+}
+
+// presignCutoff resolves the point in time objects are presigned at from the
+// asOf query parameter. A share snapshot can only be moved further back in
+// time, never past the moment it was frozen.
+func presignCutoff(r *http.Request, snapshot int64) (int64, error) {
+	cutoff, err := files.ParseAsOf(r.URL.Query().Get("asOf"))
+	if err != nil {
+		return 0, err
+	}
+
+	if snapshot != 0 && (cutoff == 0 || cutoff > snapshot) {
+		cutoff = snapshot
+	}
+
+	return cutoff, nil
+}
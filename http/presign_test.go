@@ -0,0 +1,49 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/versioneer-tech/package-r/settings"
+	"github.com/versioneer-tech/package-r/users"
+)
+
+func TestPresignExpiry(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		expire int64
+		envs   map[string]string
+		want   time.Duration
+	}{
+		"permanent share":               {want: time.Hour},
+		"share expiring after the URLs": {expire: now.Add(2 * time.Hour).Unix(), want: time.Hour},
+		"share expiring before the URLs": {
+			expire: now.Add(10 * time.Minute).Unix(),
+			want:   10 * time.Minute,
+		},
+		"share expiring before the user's URLs": {
+			expire: now.Add(10 * time.Minute).Unix(),
+			envs:   map[string]string{"PRESIGN_EXPIRY": "30m"},
+			want:   10 * time.Minute,
+		},
+		"expired share": {expire: now.Add(-time.Minute).Unix(), want: time.Second},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			d := &data{
+				settings: &settings.Settings{Presign: settings.Presign{Expiry: "1h"}},
+				user:     &users.User{},
+			}
+			if tc.envs != nil {
+				d.user.Envs = &tc.envs
+			}
+
+			// the remaining lifetime of a share is truncated to seconds
+			got := presignExpiry(d, tc.expire)
+			if got > tc.want || got < tc.want-time.Second {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
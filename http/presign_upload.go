@@ -0,0 +1,99 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
+)
+
+type presignUploadBody struct {
+	UploadID string                `json:"uploadId"`
+	Parts    []files.CompletedPart `json:"parts"`
+}
+
+func withPresignUpload(fn func(w http.ResponseWriter, r *http.Request, d *data, uploader *files.S3Connection) (int, error)) handleFunc {
+	return withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		if !d.user.Perm.Create || !d.Check(r.URL.Path) {
+			return http.StatusForbidden, nil
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/") || d.user.Envs == nil {
+			return http.StatusBadRequest, nil
+		}
+
+		uploader, err := files.NewUploader(r.Context(), *d.user.Envs)
+		if errors.Is(err, fbErrors.ErrInvalidOption) {
+			return http.StatusBadRequest, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+
+		return fn(w, r, d, uploader)
+	})
+}
+
+var presignUploadPostHandler = withPresignUpload(func(w http.ResponseWriter, r *http.Request, d *data, uploader *files.S3Connection) (int, error) {
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil || size < 0 {
+		return http.StatusBadRequest, fmt.Errorf("invalid size: %w", fbErrors.ErrInvalidRequestParams)
+	}
+
+	info, err := d.user.Fs.Stat(r.URL.Path)
+	if err == nil {
+		if info.IsDir() {
+			return http.StatusBadRequest, fmt.Errorf("cannot upload to a directory %s", r.URL.Path)
+		}
+
+		if r.URL.Query().Get("override") != "true" {
+			return http.StatusConflict, nil
+		}
+
+		// Permission for overwriting the file
+		if !d.user.Perm.Modify {
+			return http.StatusForbidden, nil
+		}
+	}
+
+	partSize := files.UploadPartSize(size, int64(d.settings.Tus.ChunkSize))
+	upload, err := uploader.CreateMultipartUpload(r.Context(), r.URL.Path, size, partSize, presignExpiry(d, 0))
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	return renderJSON(w, r, upload)
+})
+
+var presignUploadPutHandler = withPresignUpload(func(_ http.ResponseWriter, r *http.Request, d *data, uploader *files.S3Connection) (int, error) {
+	var body presignUploadBody
+	if r.Body == nil {
+		return http.StatusBadRequest, fbErrors.ErrEmptyRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("failed to decode body: %w", err)
+	}
+	defer r.Body.Close()
+
+	err := d.RunHook(func() error {
+		return uploader.CompleteMultipartUpload(r.Context(), r.URL.Path, body.UploadID, body.Parts)
+	}, "upload", r.URL.Path, "", d.user)
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	return http.StatusOK, nil
+})
+
+var presignUploadDeleteHandler = withPresignUpload(func(_ http.ResponseWriter, r *http.Request, _ *data, uploader *files.S3Connection) (int, error) {
+	err := uploader.AbortMultipartUpload(r.Context(), r.URL.Path, r.URL.Query().Get("uploadId"))
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	return http.StatusNoContent, nil
+})
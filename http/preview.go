@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"net/http"
 
@@ -63,6 +64,8 @@ func previewHandler(imgSvc ImgService, fileCache FileCache, enableThumbnails, re
 		switch file.Type {
 		case "image":
 			return handleImagePreview(w, r, imgSvc, fileCache, file, previewSize, enableThumbnails, resizePreview)
+		case "tiff":
+			return handleRasterPreview(w, r, fileCache, file, previewSize, enableThumbnails)
 		default:
 			return http.StatusNotImplemented, fmt.Errorf("can't create preview for %s type", file.Type)
 		}
@@ -152,6 +155,35 @@ func createPreview(imgSvc ImgService, fileCache FileCache,
 	return buf.Bytes(), nil
 }
 
+// handleRasterPreview renders a TIFF to PNG from its best-fitting overview,
+// as browsers can't display TIFFs.
+func handleRasterPreview(
+	w http.ResponseWriter,
+	r *http.Request,
+	fileCache FileCache,
+	file *files.FileInfo,
+	previewSize PreviewSize,
+	enableThumbnails bool,
+) (int, error) {
+	if previewSize == PreviewSizeThumb && !enableThumbnails {
+		return http.StatusNotImplemented, errors.New("thumbnails are disabled")
+	}
+
+	opts, err := parseRenderOptions(r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	size := 1080
+	if previewSize == PreviewSizeThumb {
+		size = 256
+	}
+
+	return renderRaster(w, r, fileCache, file, previewSize.String(), opts, func() (image.Image, error) {
+		return files.RenderRaster(file.Fs, file.Path, size, opts)
+	})
+}
+
 func previewCacheKey(f *files.FileInfo, previewSize PreviewSize) string {
 	return fmt.Sprintf("%x%x%x", f.RealPath(), f.ModTime.Unix(), previewSize)
 }
@@ -11,8 +11,10 @@ import (
 	"github.com/spf13/afero"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/versioneer-tech/package-r/analytics"
 	fbErrors "github.com/versioneer-tech/package-r/errors"
 	"github.com/versioneer-tech/package-r/files"
+	"github.com/versioneer-tech/package-r/packages"
 	"github.com/versioneer-tech/package-r/share"
 )
 
@@ -21,6 +23,11 @@ type catalogedFile struct {
 	CatalogURL    string
 	FilterField   string
 	AssetsBaseURL string
+	Expire        int64
+	Snapshot      int64
+	Package       *packages.Package
+	Description   string
+	License       string
 }
 
 var withHashFile = func(fn handleFunc) handleFunc {
@@ -43,34 +50,46 @@ var withHashFile = func(fn handleFunc) handleFunc {
 
 		d.user = user
 
-		file, err := files.NewFileInfo(&files.FileOptions{
-			Fs:         d.user.Fs,
-			Path:       link.Path,
-			Modify:     d.user.Perm.Modify,
-			Expand:     false,
-			ReadHeader: d.server.TypeDetectionByHeader,
-			Checker:    d,
-			Token:      link.Token,
-		})
-		if err != nil {
-			return errToStatus(err), err
-		}
-
-		// share base path
-		basePath := link.Path
-
 		// file relative path
 		filePath := ""
 
-		if file.IsDir {
-			basePath = filepath.Dir(basePath)
+		var pkg *packages.Package
+		if link.PackageID != 0 {
+			pkg, err = d.store.Packages.Get(link.PackageID)
+			if err != nil {
+				return errToStatus(err), err
+			}
+
+			// the items of a package are the entries of a virtual root
+			d.user.Fs = files.NewPackageFs(d.user.Fs, pkg.Name, pkg.Paths)
 			filePath = ifPath
-		}
+		} else {
+			file, err := files.NewFileInfo(&files.FileOptions{
+				Fs:         d.user.Fs,
+				Path:       link.Path,
+				Modify:     d.user.Perm.Modify,
+				Expand:     false,
+				ReadHeader: d.server.TypeDetectionByHeader,
+				Checker:    d,
+				Token:      link.Token,
+			})
+			if err != nil {
+				return errToStatus(err), err
+			}
+
+			// share base path
+			basePath := link.Path
 
-		// set fs root to the shared file/folder
-		d.user.Fs = afero.NewBasePathFs(d.user.Fs, basePath)
+			if file.IsDir {
+				basePath = filepath.Dir(basePath)
+				filePath = ifPath
+			}
+
+			// set fs root to the shared file/folder
+			d.user.Fs = afero.NewBasePathFs(d.user.Fs, basePath)
+		}
 
-		file, err = files.NewFileInfo(&files.FileOptions{
+		file, err := files.NewFileInfo(&files.FileOptions{
 			Fs:      d.user.Fs,
 			Path:    filePath,
 			Modify:  d.user.Perm.Modify,
@@ -87,6 +106,11 @@ var withHashFile = func(fn handleFunc) handleFunc {
 			CatalogURL:    link.CatalogURL,
 			FilterField:   link.FiltersField,
 			AssetsBaseURL: link.AssetsBaseURL,
+			Expire:        link.Expire,
+			Snapshot:      link.Snapshot,
+			Package:       pkg,
+			Description:   link.Description,
+			License:       link.License,
 		}
 
 		return fn(w, r, d)
@@ -108,7 +132,7 @@ func ifPathWithName(r *http.Request) (id, filePath string) {
 	}
 }
 
-var publicShareHandler = withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+var publicShareHandler = withAccessEvent(analytics.EventView, withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 	cf := d.raw.(*catalogedFile)
 	file := cf.File
 
@@ -118,6 +142,16 @@ var publicShareHandler = withHashFile(func(w http.ResponseWriter, r *http.Reques
 		return renderJSON(w, r, file)
 	}
 
+	// the metadata documents and chunks of Zarr stores are served as they
+	// are, with range requests, so that xarray and fsspec can open shared
+	// stores; the file info is only returned to clients asking for JSON
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") && files.InZarrStore(d.user.Fs, file.Path) {
+		if !d.user.Perm.Download {
+			return http.StatusForbidden, nil
+		}
+		return rawFileHandler(w, r, file)
+	}
+
 	if checksum := r.URL.Query().Get("checksum"); checksum != "" {
 		err := file.Checksum(checksum)
 		if errors.Is(err, fbErrors.ErrInvalidOption) {
@@ -132,11 +166,20 @@ var publicShareHandler = withHashFile(func(w http.ResponseWriter, r *http.Reques
 
 	presign, ok := r.URL.Query()["presign"]
 	if ok && !strings.EqualFold(presign[0], "false") {
-		url, err := files.Presign(file.RealPath(), r.Method, *d.user.Envs)
+		cutoff, err := presignCutoff(r, cf.Snapshot)
+		if err != nil {
+			return http.StatusBadRequest, nil
+		}
+
+		url, err := files.Presign(r.Context(), file.RealPath(), *d.user.Envs, files.PresignOptions{
+			Method: r.Method,
+			Expiry: presignExpiry(d, cf.Expire),
+			Cutoff: cutoff,
+		})
 		if errors.Is(err, fbErrors.ErrInvalidOption) {
 			return http.StatusBadRequest, nil
 		} else if err != nil {
-			return http.StatusInternalServerError, err
+			return errToStatus(err), err
 		}
 		file.PresignedURL = url
 	}
@@ -148,6 +191,27 @@ var publicShareHandler = withHashFile(func(w http.ResponseWriter, r *http.Reques
 		return status, nil
 	}
 
+	if _, ok := r.URL.Query()["references"]; ok {
+		if !d.user.Perm.Download {
+			return http.StatusForbidden, nil
+		}
+
+		// the references point at the download endpoint (or the presigned
+		// url) so that the file can be opened lazily with ranged requests
+		target := file.PresignedURL
+		if target == "" {
+			target = publicURL(r, "dl", r.URL.Path)
+			if file.Token != "" {
+				target += "?token=" + url.QueryEscape(file.Token)
+			}
+		}
+		refs, err := files.NetCDFReferences(d.user.Fs, file.Path, target)
+		if err != nil {
+			return http.StatusUnprocessableEntity, err
+		}
+		return renderJSON(w, r, refs)
+	}
+
 	if d.settings.Catalog.PreviewURL != "" {
 		preview, ok := r.URL.Query()["preview"]
 		if ok && !strings.EqualFold(preview[0], "false") {
@@ -167,9 +231,9 @@ var publicShareHandler = withHashFile(func(w http.ResponseWriter, r *http.Reques
 	}
 
 	return renderJSON(w, r, file)
-})
+}))
 
-var publicDlHandler = withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+var publicDlHandler = withAccessEvent(analytics.EventDownload, withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
 	if !d.user.Perm.Download {
 		return http.StatusForbidden, nil
 	}
@@ -177,12 +241,16 @@ var publicDlHandler = withHashFile(func(w http.ResponseWriter, r *http.Request,
 	cf := d.raw.(*catalogedFile)
 	file := cf.File
 
+	if isManifestAlgorithm(r) {
+		return rawManifestHandler(w, r, d, file)
+	}
+
 	if !file.IsDir {
 		return rawFileHandler(w, r, file)
 	}
 
 	return rawDirHandler(w, r, d, file)
-})
+}))
 
 func authenticateShareRequest(r *http.Request, l *share.Link) (int, error) {
 	if l.PasswordHash == "" {
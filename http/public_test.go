@@ -12,6 +12,7 @@ import (
 
 	"github.com/versioneer-tech/package-r/settings"
 	"github.com/versioneer-tech/package-r/share"
+	"github.com/versioneer-tech/package-r/storage"
 	"github.com/versioneer-tech/package-r/storage/bolt"
 	"github.com/versioneer-tech/package-r/users"
 )
@@ -121,6 +122,48 @@ func newHTTPRequest(t *testing.T, requestModifiers ...func(*http.Request)) *http
 	return r
 }
 
+// newTestStorage opens a storage in a temporary directory with the user
+// "username", allowed to download and share, the files in a memory file
+// system as their scope and the share links. It returns the storage and the
+// file system.
+func newTestStorage(t *testing.T, files map[string]string, links ...*share.Link) (*storage.Storage, afero.Fs) {
+	t.Helper()
+	db, err := storm.Open(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+
+	store, err := bolt.NewStorage(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Analytics.Flush()
+		_ = db.Close()
+	})
+
+	if err := store.Users.Save(&users.User{Username: "username", Password: "pw", Perm: users.Permissions{Download: true, Share: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Settings.Save(&settings.Settings{Key: []byte("key")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range links {
+		if err := store.Share.Save(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := afero.NewMemMapFs()
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Users = &customFSUser{Store: store.Users, fs: fs}
+	return store, fs
+}
+
 type customFSUser struct {
 	users.Store
 	fs afero.Fs
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/versioneer-tech/package-r/catalog"
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
+)
+
+type queryBody struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+	// Format exports the result as csv, json or parquet, into Output if set
+	// or as a download otherwise.
+	Format string `json:"format"`
+	Output string `json:"output"`
+}
+
+type queryExportResponse struct {
+	Path string `json:"path"`
+}
+
+var queryContentTypes = map[string]string{
+	catalog.FormatCSV:     "text/csv",
+	catalog.FormatJSON:    "application/x-ndjson",
+	catalog.FormatParquet: "application/vnd.apache.parquet",
+}
+
+// queryHandler runs read-only SQL over the files of the user. Files are
+// referenced relative to the scope of the user, either directly or through
+// the read_* table functions.
+var queryHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	if !d.user.Perm.Download {
+		return http.StatusForbidden, nil
+	}
+	if d.user.Envs != nil && files.IsS3FsBackend(*d.user.Envs) {
+		return http.StatusBadRequest, fmt.Errorf("queries are not supported on the %s file system", files.FsBackendS3)
+	}
+
+	var body queryBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if strings.TrimSpace(body.Query) == "" {
+		return http.StatusBadRequest, nil
+	}
+
+	opts := catalog.QueryOptions{
+		Root:    (&files.FileInfo{Fs: d.user.Fs, Path: "/"}).RealPath(),
+		Resolve: queryResolver(d),
+		Limit:   body.Limit,
+	}
+
+	if body.Format == "" {
+		result, err := catalog.RunQuery(r.Context(), body.Query, opts)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		return renderJSON(w, r, result)
+	}
+
+	contentType, ok := queryContentTypes[body.Format]
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("unsupported format %q", body.Format)
+	}
+
+	if body.Output == "" {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "query." + body.Format}))
+		if err := catalog.ExportQuery(r.Context(), body.Query, body.Format, opts, w); err != nil {
+			return errToStatus(err), err
+		}
+		return 0, nil
+	}
+
+	if !d.user.Perm.Create {
+		return http.StatusForbidden, nil
+	}
+	output := path.Clean("/" + body.Output)
+	if !d.Check(output) {
+		return http.StatusForbidden, nil
+	}
+	if _, err := d.user.Fs.Stat(output); err == nil && !d.user.Perm.Modify {
+		return http.StatusForbidden, nil
+	}
+
+	if err := d.user.Fs.MkdirAll(path.Dir(output), files.PermDir); err != nil {
+		return errToStatus(err), err
+	}
+	f, err := d.user.Fs.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, files.PermFile)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	err = catalog.ExportQuery(r.Context(), body.Query, body.Format, opts, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = d.user.Fs.Remove(output)
+		return errToStatus(err), err
+	}
+
+	return renderJSON(w, r, queryExportResponse{Path: output})
+})
+
+// queryResolver maps the file references of a query to local paths within
+// the scope of the user, checking the rules for every file a glob matches.
+func queryResolver(d *data) func(ref string) (string, error) {
+	return func(ref string) (string, error) {
+		if strings.Contains(ref, "://") {
+			return "", fmt.Errorf("remote file %q: %w", ref, fbErrors.ErrInvalidRequestParams)
+		}
+		if strings.Contains(ref, "**") {
+			return "", fmt.Errorf("recursive glob %q: %w", ref, fbErrors.ErrInvalidRequestParams)
+		}
+
+		name := path.Clean("/" + ref)
+		if !d.Check(name) {
+			return "", fbErrors.ErrPermissionDenied
+		}
+
+		matches, err := afero.Glob(d.user.Fs, name)
+		if err != nil {
+			return "", fmt.Errorf("invalid glob %q: %w", ref, fbErrors.ErrInvalidRequestParams)
+		}
+		for _, match := range matches {
+			if !d.Check(match) {
+				return "", fbErrors.ErrPermissionDenied
+			}
+		}
+
+		return (&files.FileInfo{Fs: d.user.Fs, Path: name}).RealPath(), nil
+	}
+}
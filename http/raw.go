@@ -96,6 +96,10 @@ var rawHandler = withUser(func(w http.ResponseWriter, r *http.Request, d *data)
 		return 0, nil
 	}
 
+	if isManifestAlgorithm(r) {
+		return rawManifestHandler(w, r, d, file)
+	}
+
 	if !file.IsDir {
 		return rawFileHandler(w, r, file)
 	}
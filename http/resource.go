@@ -54,11 +54,20 @@ var resourceGetHandler = withUser(func(w http.ResponseWriter, r *http.Request, d
 
 	presign, ok := r.URL.Query()["presign"]
 	if ok && !strings.EqualFold(presign[0], "false") {
-		url, err := files.Presign(file.Path, r.Method, *d.user.Envs)
+		cutoff, err := presignCutoff(r, 0)
+		if err != nil {
+			return http.StatusBadRequest, nil
+		}
+
+		url, err := files.Presign(r.Context(), file.Path, *d.user.Envs, files.PresignOptions{
+			Method: r.Method,
+			Expiry: presignExpiry(d, 0),
+			Cutoff: cutoff,
+		})
 		if errors.Is(err, fbErrors.ErrInvalidOption) {
 			return http.StatusBadRequest, nil
 		} else if err != nil {
-			return http.StatusInternalServerError, err
+			return errToStatus(err), err
 		}
 		file.PresignedURL = url
 	}
@@ -84,6 +84,47 @@ var shareDeleteHandler = withPermShare(func(_ http.ResponseWriter, r *http.Reque
 	return errToStatus(err), err
 })
 
+// sharePutHandler updates a share of the user, or of anyone for admins. PUT
+// replaces every field of the share, PATCH only those in the body.
+var sharePutHandler = withPermShare(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+	hash := strings.Trim(r.URL.Path, "/")
+	if hash == "" {
+		return http.StatusBadRequest, nil
+	}
+
+	link, err := d.store.Share.GetByHash(hash)
+	if err != nil {
+		return errToStatus(err), err
+	}
+	if link.UserID != d.user.ID && !d.user.Perm.Admin {
+		return http.StatusForbidden, nil
+	}
+
+	if r.Body == nil {
+		return http.StatusBadRequest, fbErrors.ErrEmptyRequest
+	}
+	defer r.Body.Close()
+
+	var body share.UpdateBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("failed to decode body: %w", err)
+	}
+	if r.Method == http.MethodPut {
+		body.Complete()
+	}
+
+	updated, err := link.Update(body, share.LinkOptions{CatalogBaseURL: d.settings.Catalog.BaseURL}, d.user.ID)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := d.store.Share.Save(updated); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	return renderJSON(w, r, updated)
+})
+
 // allowed characters: a-z, 0-9, ., -, min length: 1 character, max length: 20 characters
 var hashRegex = regexp.MustCompile(`^[a-z0-9.-]{1,20}$`)
 
@@ -125,6 +166,19 @@ var sharePostHandler = withPermShare(func(w http.ResponseWriter, r *http.Request
 		return http.StatusBadRequest, fmt.Errorf("invalid hash: %s", hash)
 	}
 
+	sharePath := r.URL.Path
+	if body.Package != 0 {
+		pkg, err := d.store.Packages.Get(body.Package)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		if pkg.UserID != d.user.ID {
+			return http.StatusForbidden, nil
+		}
+		// shares of a package have no path of their own
+		sharePath = ""
+	}
+
 	catalogURL := ""
 	if d.settings.Catalog.BaseURL != "" && body.CatalogName != "" {
 		catalogURL = path.Join(d.settings.Catalog.BaseURL, r.URL.Path, body.CatalogName)
@@ -168,16 +222,19 @@ var sharePostHandler = withPermShare(func(w http.ResponseWriter, r *http.Request
 	}
 
 	s = &share.Link{
-		Path:          r.URL.Path,
+		Path:          sharePath,
 		Hash:          hash,
 		Expire:        expire,
 		Description:   body.Description,
+		License:       body.License,
 		CatalogURL:    catalogURL,
 		FiltersField:  body.FiltersField,
 		AssetsBaseURL: body.AssetsBaseURL,
 		UserID:        d.user.ID,
 		PasswordHash:  string(passwordHash),
 		Token:         token,
+		Snapshot:      share.NewSnapshot(body.Snapshot),
+		PackageID:     body.Package,
 	}
 
 	if err := d.store.Share.Save(s); err != nil {
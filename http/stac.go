@@ -0,0 +1,436 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/versioneer-tech/package-r/catalog"
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+const stacVersion = "1.0.0"
+
+var stacConformance = []string{
+	"https://api.stacspec.org/v1.0.0/core",
+	"https://api.stacspec.org/v1.0.0/collections",
+	"https://api.stacspec.org/v1.0.0/ogcapi-features",
+	"https://api.stacspec.org/v1.0.0/item-search",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"https://api.stacspec.org/v1.0.0-rc.2/item-search#filter",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/filter",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-json",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2",
+	"http://www.opengis.net/spec/cql2/1.0/conf/advanced-comparison-operators",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-spatial-functions",
+}
+
+// stacRoute splits a catalog request path into the share hash and the STAC API
+// route below it. Paths that are no STAC API route address files of the share.
+func stacRoute(p string) (hash string, route []string, ok bool) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	hash, route = parts[0], parts[1:]
+
+	if len(route) == 0 {
+		return hash, route, true
+	}
+
+	switch route[0] {
+	case "conformance", "collections", "search":
+		return hash, route, true
+	default:
+		return hash, nil, false
+	}
+}
+
+type stacLink struct {
+	Rel    string      `json:"rel"`
+	Href   string      `json:"href"`
+	Type   string      `json:"type,omitempty"`
+	Title  string      `json:"title,omitempty"`
+	Method string      `json:"method,omitempty"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+// stacURLs builds the links of the STAC API of a share. The token of password
+// protected shares is kept so clients can follow them.
+type stacURLs struct {
+	root  string
+	token string
+}
+
+func newStacURLs(r *http.Request, hash string) stacURLs {
+	return stacURLs{root: publicURL(r, "catalog", hash), token: r.URL.Query().Get("token")}
+}
+
+func (u stacURLs) href(p string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	if u.token != "" {
+		query.Set("token", u.token)
+	}
+
+	href := u.root + p
+	if len(query) > 0 {
+		href += "?" + query.Encode()
+	}
+	return href
+}
+
+func (u stacURLs) collection(id string) string {
+	return "/collections/" + url.PathEscape(id)
+}
+
+//nolint:gocyclo
+func stacHandler(w http.ResponseWriter, r *http.Request, d *data, hash string, route []string) (int, error) {
+	cf := d.raw.(*catalogedFile)
+
+	if cf.CatalogURL == "" {
+		return http.StatusNotFound, nil
+	}
+
+	// the root of a shared file keeps resolving to the item of the file
+	if len(route) == 0 && !cf.File.IsDir {
+		return catalogFileHandler(w, r, d)
+	}
+
+	isSearch := len(route) == 1 && route[0] == "search"
+	if r.Method == http.MethodPost && !isSearch {
+		return http.StatusMethodNotAllowed, nil
+	}
+
+	src := catalogSource(r, cf, hash)
+	urls := newStacURLs(r, hash)
+
+	switch {
+	case len(route) == 0:
+		collections, err := catalog.Collections(r.Context(), src)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		return renderJSON(w, r, stacLandingPage(urls, hash, collections))
+
+	case len(route) == 1 && route[0] == "conformance":
+		return renderJSON(w, r, map[string]interface{}{"conformsTo": stacConformance})
+
+	case len(route) == 1 && route[0] == "collections":
+		collections, err := catalog.Collections(r.Context(), src)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		result := []interface{}{}
+		for _, c := range collections {
+			result = append(result, stacCollection(urls, c))
+		}
+		return renderJSON(w, r, map[string]interface{}{
+			"collections": result,
+			"links": []stacLink{
+				{Rel: "self", Href: urls.href("/collections", nil), Type: "application/json"},
+				{Rel: "root", Href: urls.href("", nil), Type: "application/json"},
+				{Rel: "parent", Href: urls.href("", nil), Type: "application/json"},
+			},
+		})
+
+	case len(route) == 2 && route[0] == "collections":
+		collections, err := catalog.Collections(r.Context(), src)
+		if err != nil {
+			return errToStatus(err), err
+		}
+		for _, c := range collections {
+			if c.ID == route[1] {
+				return renderJSON(w, r, stacCollection(urls, c))
+			}
+		}
+		return http.StatusNotFound, nil
+
+	case len(route) == 3 && route[0] == "collections" && route[2] == "items":
+		search, err := stacSearchFromQuery(r.URL.Query())
+		if err != nil {
+			return errToStatus(err), err
+		}
+		search.Collections = []string{route[1]}
+		return stacItemCollection(w, r, src, urls, search, urls.collection(route[1])+"/items")
+
+	case len(route) == 4 && route[0] == "collections" && route[2] == "items":
+		result, err := catalog.SearchItems(r.Context(), src, catalog.Search{
+			Collections: []string{route[1]},
+			IDs:         []string{route[3]},
+			Limit:       1,
+		})
+		if err != nil {
+			return errToStatus(err), err
+		}
+		if len(result.Items) == 0 {
+			return http.StatusNotFound, nil
+		}
+		return renderJSON(w, r, stacItem(urls, result.Items[0]))
+
+	case isSearch:
+		var search catalog.Search
+		var err error
+		if r.Method == http.MethodPost {
+			if r.Body == nil {
+				return http.StatusBadRequest, fbErrors.ErrEmptyRequest
+			}
+			if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+				return http.StatusBadRequest, err
+			}
+		} else if search, err = stacSearchFromQuery(r.URL.Query()); err != nil {
+			return errToStatus(err), err
+		}
+		return stacItemCollection(w, r, src, urls, search, "/search")
+
+	default:
+		return http.StatusNotFound, nil
+	}
+}
+
+// stacSearchFromQuery parses the query parameters of a GET item search.
+func stacSearchFromQuery(query url.Values) (catalog.Search, error) {
+	// "token" authenticates password protected shares, so the paging token
+	// is passed as "page"
+	search := catalog.Search{
+		Datetime:   query.Get("datetime"),
+		Token:      query.Get("page"),
+		FilterLang: query.Get("filter-lang"),
+	}
+	if filter := query.Get("filter"); filter != "" {
+		search.Filter = filter
+	}
+
+	if ids := query.Get("ids"); ids != "" {
+		search.IDs = strings.Split(ids, ",")
+	}
+	if collections := query.Get("collections"); collections != "" {
+		search.Collections = strings.Split(collections, ",")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return search, fmt.Errorf("invalid limit %q: %w", limit, fbErrors.ErrInvalidRequestParams)
+		}
+		search.Limit = n
+	}
+
+	if bbox := query.Get("bbox"); bbox != "" {
+		values := strings.Split(bbox, ",")
+		if len(values) != 4 && len(values) != 6 {
+			return search, fmt.Errorf("bbox needs 4 or 6 values: %w", fbErrors.ErrInvalidRequestParams)
+		}
+		coords := make([]float64, len(values))
+		for i, v := range values {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return search, fmt.Errorf("invalid bbox %q: %w", bbox, fbErrors.ErrInvalidRequestParams)
+			}
+			coords[i] = f
+		}
+		if len(coords) == 6 {
+			coords = []float64{coords[0], coords[1], coords[3], coords[4]}
+		}
+		search.BBox = coords
+	}
+
+	return search, nil
+}
+
+func stacItemCollection(w http.ResponseWriter, r *http.Request, src catalog.Source, urls stacURLs, search catalog.Search, p string) (int, error) {
+	if contentType := stacStreamType(r); contentType != "" {
+		return stacStream(w, r, src, urls, search, contentType)
+	}
+
+	result, err := catalog.SearchItems(r.Context(), src, search)
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	return renderItemCollection(w, r, urls, search, p, result)
+}
+
+func renderItemCollection(w http.ResponseWriter, r *http.Request, urls stacURLs, search catalog.Search, p string, result *catalog.SearchResult) (int, error) {
+	features := make([]interface{}, 0, len(result.Items))
+	for _, item := range result.Items {
+		features = append(features, stacItem(urls, item))
+	}
+
+	links := []stacLink{
+		{Rel: "self", Href: urls.href(p, stacPageQuery(r.URL.Query(), search.Token)), Type: "application/geo+json"},
+		{Rel: "root", Href: urls.href("", nil), Type: "application/json"},
+	}
+	if result.Next != "" {
+		if r.Method == http.MethodPost {
+			next := search
+			next.Token = result.Next
+			links = append(links, stacLink{
+				Rel: "next", Href: urls.href(p, nil), Type: "application/geo+json",
+				Method: http.MethodPost, Body: next,
+			})
+		} else {
+			links = append(links, stacLink{
+				Rel: "next", Href: urls.href(p, stacPageQuery(r.URL.Query(), result.Next)), Type: "application/geo+json",
+			})
+		}
+	}
+
+	return renderJSON(w, r, map[string]interface{}{
+		"type":           "FeatureCollection",
+		"features":       features,
+		"numberMatched":  result.Matched,
+		"numberReturned": len(features),
+		"links":          links,
+	})
+}
+
+const (
+	geoJSONSeqType = "application/geo+json-seq"
+	ndjsonType     = "application/x-ndjson"
+)
+
+// stacStreamType returns the content type of the streamed item responses
+// accepted by r, if any.
+func stacStreamType(r *http.Request) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		switch strings.TrimSpace(mediaType) {
+		case geoJSONSeqType:
+			return geoJSONSeqType
+		case ndjsonType, "application/ndjson":
+			return ndjsonType
+		}
+	}
+	return ""
+}
+
+// stacStream writes the items matching search one per line as DuckDB yields
+// them. GeoJSON text sequences (RFC 8142) prefix each item with a record
+// separator. Without a limit, all matching items are written.
+func stacStream(w http.ResponseWriter, r *http.Request, src catalog.Source, urls stacURLs, search catalog.Search, contentType string) (int, error) {
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	err := catalog.StreamItems(r.Context(), src, search, func(item map[string]interface{}) error {
+		start()
+		if contentType == geoJSONSeqType {
+			if _, err := w.Write([]byte{0x1e}); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(stacItem(urls, item)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		return errToStatus(err), err
+	}
+
+	start()
+	return 0, err
+}
+
+// stacPageQuery returns the search parameters of query pointing to the page
+// of token.
+func stacPageQuery(query url.Values, token string) url.Values {
+	page := url.Values{}
+	for k, v := range query {
+		if k != "token" && k != "page" {
+			page[k] = v
+		}
+	}
+	if token != "" {
+		page.Set("page", token)
+	}
+	return page
+}
+
+func stacItem(urls stacURLs, item map[string]interface{}) map[string]interface{} {
+	if _, ok := item["stac_version"]; !ok {
+		item["stac_version"] = stacVersion
+	}
+
+	id, _ := item["id"].(string)
+	collection, _ := item["collection"].(string)
+	item["links"] = []stacLink{
+		{Rel: "self", Href: urls.href(urls.collection(collection)+"/items/"+url.PathEscape(id), nil), Type: "application/geo+json"},
+		{Rel: "parent", Href: urls.href(urls.collection(collection), nil), Type: "application/json"},
+		{Rel: "collection", Href: urls.href(urls.collection(collection), nil), Type: "application/json"},
+		{Rel: "root", Href: urls.href("", nil), Type: "application/json"},
+	}
+	return item
+}
+
+func stacCollection(urls stacURLs, c catalog.CollectionInfo) map[string]interface{} {
+	bbox := c.BBox
+	if bbox == nil {
+		bbox = []float64{-180, -90, 180, 90}
+	}
+
+	interval := []*string{nil, nil}
+	for i, t := range []*time.Time{c.Start, c.End} {
+		if t != nil {
+			v := t.UTC().Format(time.RFC3339)
+			interval[i] = &v
+		}
+	}
+
+	return map[string]interface{}{
+		"type":         "Collection",
+		"stac_version": stacVersion,
+		"id":           c.ID,
+		"description":  fmt.Sprintf("%d items of collection %s", c.Count, c.ID),
+		"license":      "other",
+		"extent": map[string]interface{}{
+			"spatial":  map[string]interface{}{"bbox": [][]float64{bbox}},
+			"temporal": map[string]interface{}{"interval": [][]*string{interval}},
+		},
+		"links": []stacLink{
+			{Rel: "self", Href: urls.href(urls.collection(c.ID), nil), Type: "application/json"},
+			{Rel: "root", Href: urls.href("", nil), Type: "application/json"},
+			{Rel: "parent", Href: urls.href("", nil), Type: "application/json"},
+			{Rel: "items", Href: urls.href(urls.collection(c.ID)+"/items", nil), Type: "application/geo+json"},
+		},
+	}
+}
+
+func stacLandingPage(urls stacURLs, hash string, collections []catalog.CollectionInfo) map[string]interface{} {
+	links := []stacLink{
+		{Rel: "self", Href: urls.href("", nil), Type: "application/json"},
+		{Rel: "root", Href: urls.href("", nil), Type: "application/json"},
+		{Rel: "conformance", Href: urls.href("/conformance", nil), Type: "application/json"},
+		{Rel: "data", Href: urls.href("/collections", nil), Type: "application/json"},
+		{Rel: "search", Href: urls.href("/search", nil), Type: "application/geo+json", Method: http.MethodGet},
+		{Rel: "search", Href: urls.href("/search", nil), Type: "application/geo+json", Method: http.MethodPost},
+	}
+	for _, c := range collections {
+		links = append(links, stacLink{Rel: "child", Href: urls.href(urls.collection(c.ID), nil), Type: "application/json", Title: c.ID})
+	}
+
+	return map[string]interface{}{
+		"type":         "Catalog",
+		"stac_version": stacVersion,
+		"id":           hash,
+		"description":  "STAC API of share " + hash,
+		"conformsTo":   stacConformance,
+		"links":        links,
+	}
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
+)
+
+// parseRenderOptions reads the bands (e.g. "3,2,1"), stretch ("minmax" or
+// "percentile") and rescale (e.g. "0,3000") of a raster rendering.
+func parseRenderOptions(r *http.Request) (files.RenderOptions, error) {
+	query := r.URL.Query()
+	opts := files.RenderOptions{Stretch: query.Get("stretch")}
+
+	if bands := query.Get("bands"); bands != "" {
+		for _, s := range strings.Split(bands, ",") {
+			band, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return opts, fmt.Errorf("invalid bands %q", bands)
+			}
+			opts.Bands = append(opts.Bands, band)
+		}
+	}
+
+	if rescale := query.Get("rescale"); rescale != "" {
+		for _, s := range strings.Split(rescale, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return opts, fmt.Errorf("invalid rescale %q", rescale)
+			}
+			opts.Rescale = append(opts.Rescale, v)
+		}
+	}
+
+	return opts, nil
+}
+
+// renderRaster serves a raster rendered to PNG, from the cache if the same
+// rendering of the same version of the file was cached.
+func renderRaster(
+	w http.ResponseWriter,
+	r *http.Request,
+	fileCache FileCache,
+	file *files.FileInfo,
+	kind string,
+	opts files.RenderOptions,
+	render func() (image.Image, error),
+) (int, error) {
+	cacheKey := fmt.Sprintf("%x%x%x", file.RealPath(), file.ModTime.Unix(), fmt.Sprintf("%s%v", kind, opts))
+	rendered, ok, err := fileCache.Load(r.Context(), cacheKey)
+	if err != nil {
+		return errToStatus(err), err
+	}
+
+	if !ok {
+		img, err := render()
+		switch {
+		case errors.Is(err, files.ErrUnsupportedRaster):
+			return http.StatusNotImplemented, err
+		case errors.Is(err, fbErrors.ErrInvalidOption):
+			return http.StatusBadRequest, err
+		case err != nil:
+			return errToStatus(err), err
+		}
+
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		rendered = buf.Bytes()
+
+		go func() {
+			if err := fileCache.Store(context.Background(), cacheKey, rendered); err != nil {
+				fmt.Printf("failed to cache rendered raster: %v", err)
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private")
+	http.ServeContent(w, r, file.Name, file.ModTime, bytes.NewReader(rendered))
+
+	return 0, nil
+}
+
+// publicTileHandler serves the XYZ tiles of shared GeoTIFFs, preferably
+// COGs, at /api/public/tiles/<hash>/<path>/{z}/{x}/{y}.png.
+func publicTileHandler(fileCache FileCache) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+		elements := strings.Split(strings.TrimSuffix(r.URL.Path, ".png"), "/")
+		if len(elements) < 4 {
+			return http.StatusNotFound, nil
+		}
+		tile := make([]int, 3)
+		for i, s := range elements[len(elements)-3:] {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				return http.StatusNotFound, nil
+			}
+			tile[i] = v
+		}
+		r.URL.Path = strings.Join(elements[:len(elements)-3], "/")
+
+		return withHashFile(func(w http.ResponseWriter, r *http.Request, d *data) (int, error) {
+			if !d.user.Perm.Download {
+				return http.StatusForbidden, nil
+			}
+
+			file := d.raw.(*catalogedFile).File
+			if file.IsDir || file.Type != "tiff" {
+				return http.StatusNotImplemented, fmt.Errorf("can't create tiles for %s type", file.Type)
+			}
+
+			opts, err := parseRenderOptions(r)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+
+			kind := fmt.Sprintf("tile/%d/%d/%d", tile[0], tile[1], tile[2])
+			return renderRaster(w, r, fileCache, file, kind, opts, func() (image.Image, error) {
+				return files.RenderTile(file.Fs, file.Path, tile[0], tile[1], tile[2], opts)
+			})
+		})(w, r, d)
+	}
+}
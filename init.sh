@@ -45,6 +45,7 @@ if ./filebrowser config set \
   --catalog.baseurl ${FB_CATALOG_BASE_URL:-""} \
   --catalog.defaultName ${FB_CATALOG_DEFAULT_NAME:-"catalog.v1.parquet"} \
   --catalog.previewURL ${FB_CATALOG_PREVIEW_URL:-""} \
+  ${FB_PRESIGN_EXPIRY:+--presign.expiry "$FB_PRESIGN_EXPIRY"} \
   --commands "" > /dev/null; then
   log "Filebrowser configuration applied"
 else
@@ -57,7 +58,8 @@ envs=\
 "AWS_ENDPOINT_URL=${AWS_ENDPOINT_URL},"\
 "AWS_REGION=${AWS_REGION},"\
 "BUCKET_NAME=${BUCKET_NAME},"\
-"BUCKET_PREFIX=${BUCKET_PREFIX}"
+"BUCKET_PREFIX=${BUCKET_PREFIX},"\
+"PRESIGN_EXPIRY=${PRESIGN_EXPIRY}"
 
 password=$(head /dev/urandom | tr -dc 'A-Za-z0-9' | head -c 16)
 
@@ -0,0 +1,30 @@
+package lru
+
+import "testing"
+
+func TestCache(t *testing.T) {
+	c := New[string, int](2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("got %d %v for a", v, ok)
+	}
+
+	// b is the least recently used now
+	c.Add("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 || c.Len() != 2 {
+		t.Errorf("got %d %v for c with %d entries", v, ok, c.Len())
+	}
+
+	c.Add("a", 4)
+	if v, _ := c.Get("a"); v != 4 {
+		t.Errorf("got %d for replaced a", v)
+	}
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok || c.Len() != 1 {
+		t.Errorf("expected a to be removed, %d entries left", c.Len())
+	}
+}
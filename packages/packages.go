@@ -0,0 +1,49 @@
+package packages
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/versioneer-tech/package-r/errors"
+)
+
+// Package is a named, ordered set of paths of a user, files or directories
+// anywhere in the user's scope, that is shared as if it were one folder.
+type Package struct {
+	ID          uint     `json:"id" storm:"id,increment"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	UserID      uint     `json:"userID" storm:"index"`
+	Paths       []string `json:"paths"`
+}
+
+// Clean validates the name and paths of a package and cleans the paths.
+// Items are the entries of the root of a shared package, so their names,
+// the last elements of their paths, must be unique.
+func (p *Package) Clean() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || len(p.Name) > 255 || strings.ContainsAny(p.Name, `/\`) {
+		return fmt.Errorf("invalid package name %q: %w", p.Name, errors.ErrInvalidRequestParams)
+	}
+	if len(p.Paths) == 0 {
+		return fmt.Errorf("package without paths: %w", errors.ErrInvalidRequestParams)
+	}
+
+	names := map[string]string{}
+	for i, item := range p.Paths {
+		cleaned := path.Clean("/" + item)
+		if cleaned == "/" {
+			return fmt.Errorf("the root can't be a package item: %w", errors.ErrInvalidRequestParams)
+		}
+
+		name := path.Base(cleaned)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s have the same name %q: %w", other, cleaned, name, errors.ErrInvalidRequestParams)
+		}
+		names[name] = cleaned
+		p.Paths[i] = cleaned
+	}
+
+	return nil
+}
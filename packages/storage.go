@@ -0,0 +1,48 @@
+package packages
+
+// StorageBackend is the interface to implement for a package storage.
+type StorageBackend interface {
+	All() ([]*Package, error)
+	FindByUserID(id uint) ([]*Package, error)
+	Get(id uint) (*Package, error)
+	Save(p *Package) error
+	Delete(id uint) error
+}
+
+// Storage is a storage.
+type Storage struct {
+	back StorageBackend
+}
+
+// NewStorage creates a package storage from a backend.
+func NewStorage(back StorageBackend) *Storage {
+	return &Storage{back: back}
+}
+
+// All wraps a StorageBackend.All.
+func (s *Storage) All() ([]*Package, error) {
+	return s.back.All()
+}
+
+// FindByUserID wraps a StorageBackend.FindByUserID.
+func (s *Storage) FindByUserID(id uint) ([]*Package, error) {
+	return s.back.FindByUserID(id)
+}
+
+// Get wraps a StorageBackend.Get.
+func (s *Storage) Get(id uint) (*Package, error) {
+	return s.back.Get(id)
+}
+
+// Save cleans a package and saves it.
+func (s *Storage) Save(p *Package) error {
+	if err := p.Clean(); err != nil {
+		return err
+	}
+	return s.back.Save(p)
+}
+
+// Delete wraps a StorageBackend.Delete.
+func (s *Storage) Delete(id uint) error {
+	return s.back.Delete(id)
+}
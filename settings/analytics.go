@@ -0,0 +1,31 @@
+package settings
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultAnalyticsRetention is how long single access events are kept by
+// default before they are folded into daily aggregates.
+const DefaultAnalyticsRetention = 7 * 24 * time.Hour
+
+// Analytics contains the share access analytics settings of the app.
+type Analytics struct {
+	Disabled  bool   `json:"disabled"`
+	Retention string `json:"retention"`
+}
+
+// GetRetention returns the configured lifetime of single access events or
+// the default if none or an invalid one is configured.
+func (a Analytics) GetRetention() time.Duration {
+	if a.Retention == "" {
+		return DefaultAnalyticsRetention
+	}
+
+	duration, err := time.ParseDuration(a.Retention)
+	if err != nil || duration < 0 {
+		log.Printf("[WARN] Failed to parse analytics retention %q: %v", a.Retention, err)
+		return DefaultAnalyticsRetention
+	}
+	return duration
+}
@@ -0,0 +1,26 @@
+package settings
+
+import (
+	"log"
+	"time"
+)
+
+// Presign contains the presigned URL settings of the app.
+type Presign struct {
+	Expiry string `json:"expiry"`
+}
+
+// GetExpiry returns the configured lifetime of presigned URLs or the
+// fallback if none or an invalid one is configured.
+func (p Presign) GetExpiry(fallback time.Duration) time.Duration {
+	if p.Expiry == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(p.Expiry)
+	if err != nil || duration <= 0 {
+		log.Printf("[WARN] Failed to parse presign expiry %q: %v", p.Expiry, err)
+		return fallback
+	}
+	return duration
+}
@@ -25,6 +25,9 @@ type Settings struct {
 	Branding         Branding            `json:"branding"`
 	ShareLink        ShareLink           `json:"shareLink"`
 	Catalog          Catalog             `json:"catalog"`
+	Presign          Presign             `json:"presign"`
+	Signing          Signing             `json:"signing"`
+	Analytics        Analytics           `json:"analytics"`
 	Tus              Tus                 `json:"tus"`
 	Commands         map[string][]string `json:"commands"`
 	Shell            []string            `json:"shell"`
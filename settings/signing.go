@@ -0,0 +1,25 @@
+package settings
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/versioneer-tech/package-r/errors"
+)
+
+// Signing contains the key signing the manifests of shares.
+type Signing struct {
+	// Key is the seed of an Ed25519 private key.
+	Key []byte `json:"key,omitempty"`
+}
+
+// PrivateKey returns the signing key or nil if none is configured.
+func (s Signing) PrivateKey() (ed25519.PrivateKey, error) {
+	if len(s.Key) == 0 {
+		return nil, nil
+	}
+	if len(s.Key) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key of %d bytes: %w", len(s.Key), errors.ErrInvalidOption)
+	}
+	return ed25519.NewKeyFromSeed(s.Key), nil
+}
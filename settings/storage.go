@@ -48,6 +48,7 @@ var defaultEvents = []string{
 	"rename",
 	"upload",
 	"delete",
+	"catalog",
 }
 
 // Save saves the settings for the current instance.
@@ -54,15 +54,26 @@ func NewLink(body CreateBody, opts LinkOptions) (*Link, error) {
 		Hash:          hash,
 		Expire:        expire,
 		Description:   body.Description,
+		License:       body.License,
 		CatalogURL:    catalogURL,
 		FiltersField:  body.FiltersField,
 		AssetsBaseURL: body.AssetsBaseURL,
 		UserID:        opts.UserID,
 		PasswordHash:  passwordHash,
 		Token:         token,
+		Snapshot:      NewSnapshot(body.Snapshot),
 	}, nil
 }
 
+// NewSnapshot returns the time presigned URLs of a share created now are
+// pinned to, or 0 if snapshot is false.
+func NewSnapshot(snapshot bool) int64 {
+	if !snapshot {
+		return 0
+	}
+	return time.Now().Unix()
+}
+
 func resolveHash(hash string, defaultHash string) (string, error) {
 	if hash != "" {
 		return hash, nil
@@ -9,6 +9,12 @@ type CreateBody struct {
 	CatalogName   string `json:"catalogName"`
 	FiltersField  string `json:"filtersField"`
 	AssetsBaseURL string `json:"assetsBaseURL"`
+	Snapshot      bool   `json:"snapshot"`
+	// License is an SPDX identifier or the URL of the license of the shared
+	// data, published in the generated package descriptors.
+	License string `json:"license"`
+	// Package is the id of the package to share instead of a path.
+	Package uint `json:"package"`
 }
 
 // Link is the information needed to build a shareable link.
@@ -18,6 +24,7 @@ type Link struct {
 	UserID        uint   `json:"userID"`
 	Expire        int64  `json:"expire"`
 	Description   string `json:"description,omitempty"`
+	License       string `json:"license,omitempty"`
 	CatalogURL    string `json:"catalogURL,omitempty"`
 	FiltersField  string `json:"filtersField,omitempty"`
 	AssetsBaseURL string `json:"assetsBaseURL,omitempty"`
@@ -26,4 +33,12 @@ type Link struct {
 	// URL-Safe and is used to download links in password-protected shares via a
 	// query arg.
 	Token string `json:"token,omitempty"`
+	// Snapshot is a unix timestamp. If set, presigned URLs of the share always
+	// resolve to the object versions that were current at that time.
+	Snapshot int64 `json:"snapshot,omitempty"`
+	// PackageID is set for shares of a package, whose items are served as
+	// the entries of a virtual root instead of Path.
+	PackageID uint `json:"packageID,omitempty"`
+	// Changes is the history of updates of the share.
+	Changes []Change `json:"changes,omitempty"`
 }
@@ -10,6 +10,7 @@ import (
 type StorageBackend interface {
 	All() ([]*Link, error)
 	FindByUserID(id uint) ([]*Link, error)
+	FindByPackageID(id uint) ([]*Link, error)
 	GetByHash(hash string) (*Link, error)
 	GetPermanent(path string, id uint) (*Link, error)
 	Gets(path string, id uint) ([]*Link, error)
@@ -67,6 +68,28 @@ func (s *Storage) FindByUserID(id uint) ([]*Link, error) {
 	return links, nil
 }
 
+// FindByPackageID wraps a StorageBackend.FindByPackageID, dropping expired
+// links.
+func (s *Storage) FindByPackageID(id uint) ([]*Link, error) {
+	links, err := s.back.FindByPackageID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []*Link{}
+	for _, link := range links {
+		if link.Expire != 0 && link.Expire <= time.Now().Unix() {
+			if err := s.Delete(link.Hash); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		found = append(found, link)
+	}
+
+	return found, nil
+}
+
 // GetByHash wraps a StorageBackend.GetByHash.
 func (s *Storage) GetByHash(hash string) (*Link, error) {
 	link, err := s.back.GetByHash(hash)
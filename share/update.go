@@ -0,0 +1,123 @@
+package share
+
+import (
+	"slices"
+	"time"
+)
+
+// UpdateBody holds the changes to a share. Fields left nil are kept as they
+// are.
+type UpdateBody struct {
+	// Password replaces the password, or removes it if empty.
+	Password *string `json:"password"`
+	// Expires, in Unit from now, replaces the expiry, or makes the share
+	// permanent if empty.
+	Expires       *string `json:"expires"`
+	Unit          string  `json:"unit"`
+	Description   *string `json:"description"`
+	License       *string `json:"license"`
+	CatalogName   *string `json:"catalogName"`
+	FiltersField  *string `json:"filtersField"`
+	AssetsBaseURL *string `json:"assetsBaseURL"`
+	// Snapshot pins presigned URLs to the object versions current now, or
+	// unpins them if false.
+	Snapshot *bool `json:"snapshot"`
+}
+
+// Complete sets the fields left nil to their zero values, so that the update
+// replaces every field of a share.
+func (b *UpdateBody) Complete() {
+	for _, field := range []**string{&b.Password, &b.Expires, &b.Description, &b.License, &b.CatalogName, &b.FiltersField, &b.AssetsBaseURL} {
+		if *field == nil {
+			*field = new(string)
+		}
+	}
+	if b.Snapshot == nil {
+		b.Snapshot = new(bool)
+	}
+}
+
+// Change records who changed which fields of a share and when.
+type Change struct {
+	Time int64 `json:"time"`
+	// UserID is the user that made the change, or 0 for the command line.
+	UserID uint     `json:"userID"`
+	Fields []string `json:"fields"`
+}
+
+// Update returns a copy of the link with the changes of body, validated by
+// the rules of NewLink, and records them as made by userID. The hash, path,
+// owner and package of a share can't be changed.
+func (l *Link) Update(body UpdateBody, opts LinkOptions, userID uint) (*Link, error) {
+	value := func(s *string, fallback string) string {
+		if s == nil {
+			return fallback
+		}
+		return *s
+	}
+
+	create := CreateBody{
+		Hash:          l.Hash,
+		Description:   value(body.Description, l.Description),
+		License:       value(body.License, l.License),
+		FiltersField:  value(body.FiltersField, l.FiltersField),
+		AssetsBaseURL: value(body.AssetsBaseURL, l.AssetsBaseURL),
+		CatalogName:   value(body.CatalogName, ""),
+		Expires:       value(body.Expires, ""),
+		Unit:          body.Unit,
+		Password:      value(body.Password, ""),
+		Snapshot:      body.Snapshot != nil && *body.Snapshot,
+	}
+	updated, err := NewLink(create, LinkOptions{
+		Path:           l.Path,
+		UserID:         l.UserID,
+		CatalogBaseURL: opts.CatalogBaseURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	updated.PackageID = l.PackageID
+
+	if body.CatalogName == nil {
+		updated.CatalogURL = l.CatalogURL
+	}
+	if body.Expires == nil {
+		updated.Expire = l.Expire
+	}
+	if body.Password == nil {
+		updated.PasswordHash, updated.Token = l.PasswordHash, l.Token
+	}
+	if body.Snapshot == nil {
+		updated.Snapshot = l.Snapshot
+	}
+
+	fields := []string{}
+	for _, field := range []struct {
+		name    string
+		changed bool
+	}{
+		{"description", updated.Description != l.Description},
+		{"license", updated.License != l.License},
+		{"catalogURL", updated.CatalogURL != l.CatalogURL},
+		{"filtersField", updated.FiltersField != l.FiltersField},
+		{"assetsBaseURL", updated.AssetsBaseURL != l.AssetsBaseURL},
+		{"expire", updated.Expire != l.Expire},
+		{"password", body.Password != nil && (*body.Password != "" || l.PasswordHash != "")},
+		{"snapshot", updated.Snapshot != l.Snapshot},
+	} {
+		if field.changed {
+			fields = append(fields, field.name)
+		}
+	}
+
+	updated.Changes = slices.Clone(l.Changes)
+	if len(fields) > 0 {
+		updated.Changes = append(updated.Changes, Change{
+			Time:   time.Now().Unix(),
+			UserID: userID,
+			Fields: fields,
+		})
+	}
+
+	return updated, nil
+}
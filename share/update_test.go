@@ -0,0 +1,58 @@
+package share
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLinkUpdate(t *testing.T) {
+	link := &Link{
+		Hash:         "survey",
+		Path:         "/data/",
+		UserID:       1,
+		Expire:       time.Now().Add(time.Hour).Unix(),
+		Description:  "Field survey",
+		CatalogURL:   "https://catalog/data/items.parquet",
+		PasswordHash: "hash",
+		Token:        "token",
+		PackageID:    2,
+	}
+	description, expires := "Field survey 2023", "7"
+
+	updated, err := link.Update(UpdateBody{Description: &description, Expires: &expires, Unit: "days"}, LinkOptions{}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Description != description || updated.Expire < time.Now().Add(6*24*time.Hour).Unix() {
+		t.Errorf("unexpected update %+v", updated)
+	}
+	if updated.Hash != link.Hash || updated.Path != link.Path || updated.UserID != 1 || updated.PackageID != 2 ||
+		updated.PasswordHash != "hash" || updated.Token != "token" || updated.CatalogURL != link.CatalogURL {
+		t.Errorf("expected the other fields to be kept, got %+v", updated)
+	}
+	if len(updated.Changes) != 1 || updated.Changes[0].UserID != 3 || !slices.Equal(updated.Changes[0].Fields, []string{"description", "expire"}) {
+		t.Errorf("unexpected changes %+v", updated.Changes)
+	}
+	if link.Description != "Field survey" || link.Changes != nil {
+		t.Errorf("expected the link not to be modified, got %+v", link)
+	}
+
+	body := UpdateBody{Description: &description}
+	body.Complete()
+	replaced, err := updated.Update(body, LinkOptions{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.Expire != 0 || replaced.PasswordHash != "" || replaced.Token != "" || replaced.CatalogURL != "" {
+		t.Errorf("expected a complete update to replace every field, got %+v", replaced)
+	}
+	if len(replaced.Changes) != 2 || !slices.Equal(replaced.Changes[1].Fields, []string{"catalogURL", "expire", "password"}) {
+		t.Errorf("unexpected changes %+v", replaced.Changes)
+	}
+
+	invalid := "soon"
+	if _, err := link.Update(UpdateBody{Expires: &invalid}, LinkOptions{}, 0); err == nil {
+		t.Error("expected an invalid expiry to fail")
+	}
+}
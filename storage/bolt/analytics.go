@@ -0,0 +1,92 @@
+package bolt
+
+import (
+	"errors"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+
+	"github.com/versioneer-tech/package-r/analytics"
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+)
+
+type analyticsBackend struct {
+	db *storm.DB
+}
+
+func (s analyticsBackend) SaveEvents(events []*analytics.Event) error {
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, e := range events {
+		if err := tx.Save(e); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s analyticsBackend) FindEvents(hash string) ([]*analytics.Event, error) {
+	var v []*analytics.Event
+	err := s.db.Select(q.Eq("Hash", hash)).Find(&v)
+	if errors.Is(err, storm.ErrNotFound) {
+		return v, fbErrors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s analyticsBackend) EventsBefore(unix int64) ([]*analytics.Event, error) {
+	var v []*analytics.Event
+	err := s.db.Select(q.Lt("Time", unix)).Find(&v)
+	if errors.Is(err, storm.ErrNotFound) {
+		return v, fbErrors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s analyticsBackend) GetDay(id string) (*analytics.Day, error) {
+	var v analytics.Day
+	err := s.db.One("ID", id, &v)
+	if errors.Is(err, storm.ErrNotFound) {
+		return nil, fbErrors.ErrNotExist
+	}
+
+	return &v, err
+}
+
+func (s analyticsBackend) FindDays(hash string) ([]*analytics.Day, error) {
+	var v []*analytics.Day
+	err := s.db.Select(q.Eq("Hash", hash)).Find(&v)
+	if errors.Is(err, storm.ErrNotFound) {
+		return v, fbErrors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s analyticsBackend) Fold(days []*analytics.Day, events []*analytics.Event) error {
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, day := range days {
+		if err := tx.Save(day); err != nil {
+			return err
+		}
+	}
+	for _, e := range events {
+		if err := tx.DeleteStruct(e); err != nil && !errors.Is(err, storm.ErrNotFound) {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -3,7 +3,9 @@ package bolt
 import (
 	"github.com/asdine/storm/v3"
 
+	"github.com/versioneer-tech/package-r/analytics"
 	"github.com/versioneer-tech/package-r/auth"
+	"github.com/versioneer-tech/package-r/packages"
 	"github.com/versioneer-tech/package-r/settings"
 	"github.com/versioneer-tech/package-r/share"
 	"github.com/versioneer-tech/package-r/storage"
@@ -14,8 +16,10 @@ import (
 func NewStorage(db *storm.DB) (*storage.Storage, error) {
 	userStore := users.NewStorage(usersBackend{db: db})
 	shareStore := share.NewStorage(shareBackend{db: db})
+	packageStore := packages.NewStorage(packageBackend{db: db})
 	settingsStore := settings.NewStorage(settingsBackend{db: db})
 	authStore := auth.NewStorage(authBackend{db: db}, userStore)
+	analyticsStore := analytics.NewStorage(analyticsBackend{db: db})
 
 	err := save(db, "version", 2)
 	if err != nil {
@@ -23,9 +27,11 @@ func NewStorage(db *storm.DB) (*storage.Storage, error) {
 	}
 
 	return &storage.Storage{
-		Auth:     authStore,
-		Users:    userStore,
-		Share:    shareStore,
-		Settings: settingsStore,
+		Auth:      authStore,
+		Users:     userStore,
+		Share:     shareStore,
+		Packages:  packageStore,
+		Settings:  settingsStore,
+		Analytics: analyticsStore,
 	}, nil
 }
@@ -0,0 +1,57 @@
+package bolt
+
+import (
+	"errors"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+
+	fbErrors "github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/packages"
+)
+
+type packageBackend struct {
+	db *storm.DB
+}
+
+func (s packageBackend) All() ([]*packages.Package, error) {
+	var v []*packages.Package
+	err := s.db.All(&v)
+	if errors.Is(err, storm.ErrNotFound) {
+		return v, fbErrors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s packageBackend) FindByUserID(id uint) ([]*packages.Package, error) {
+	var v []*packages.Package
+	err := s.db.Select(q.Eq("UserID", id)).Find(&v)
+	if errors.Is(err, storm.ErrNotFound) {
+		return v, fbErrors.ErrNotExist
+	}
+
+	return v, err
+}
+
+func (s packageBackend) Get(id uint) (*packages.Package, error) {
+	var v packages.Package
+	err := s.db.One("ID", id, &v)
+	if errors.Is(err, storm.ErrNotFound) {
+		return nil, fbErrors.ErrNotExist
+	}
+
+	return &v, err
+}
+
+func (s packageBackend) Save(p *packages.Package) error {
+	return s.db.Save(p)
+}
+
+func (s packageBackend) Delete(id uint) error {
+	err := s.db.DeleteStruct(&packages.Package{ID: id})
+	if errors.Is(err, storm.ErrNotFound) {
+		return nil
+	}
+	return err
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"github.com/versioneer-tech/package-r/analytics"
 	"github.com/versioneer-tech/package-r/auth"
+	"github.com/versioneer-tech/package-r/packages"
 	"github.com/versioneer-tech/package-r/settings"
 	"github.com/versioneer-tech/package-r/share"
 	"github.com/versioneer-tech/package-r/users"
@@ -10,8 +12,10 @@ import (
 // Storage is a storage powered by a Backend which makes the necessary
 // verifications when fetching and saving data to ensure consistency.
 type Storage struct {
-	Users    users.Store
-	Share    *share.Storage
-	Auth     *auth.Storage
-	Settings *settings.Storage
+	Users     users.Store
+	Share     *share.Storage
+	Packages  *packages.Storage
+	Auth      *auth.Storage
+	Settings  *settings.Storage
+	Analytics *analytics.Storage
 }
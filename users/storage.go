@@ -1,10 +1,13 @@
 package users
 
 import (
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/versioneer-tech/package-r/errors"
+	"github.com/versioneer-tech/package-r/files"
 )
 
 // StorageBackend is the interface to implement for a users storage.
@@ -71,18 +74,29 @@ func (s *Storage) Gets(baseScope string) ([]*User, error) {
 	return users, err
 }
 
-// Update updates a user in the database.
+// Update updates a user in the database. If the user's envs change, the
+// S3 connection this process cached for the previous envs is dropped.
 func (s *Storage) Update(user *User, fields ...string) error {
 	err := user.Clean("", fields...)
 	if err != nil {
 		return err
 	}
 
+	var previous *User
+	if len(fields) == 0 || slices.Contains(fields, "Envs") {
+		previous, _ = s.back.GetBy(user.ID)
+	}
+
 	err = s.back.Update(user, fields...)
 	if err != nil {
 		return err
 	}
 
+	if previous != nil && previous.Envs != nil &&
+		(user.Envs == nil || !maps.Equal(*previous.Envs, *user.Envs)) {
+		files.InvalidateS3Connection(*previous.Envs)
+	}
+
 	s.mux.Lock()
 	s.updated[user.ID] = time.Now().Unix()
 	s.mux.Unlock()
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"context"
 	"path/filepath"
 	"regexp"
 
@@ -93,6 +94,15 @@ func (u *User) Clean(baseScope string, fields ...string) error {
 	}
 
 	if u.Fs == nil {
+		if u.Envs != nil && files.IsS3FsBackend(*u.Envs) {
+			s3Fs, err := files.NewS3Fs(context.Background(), *u.Envs)
+			if err != nil {
+				return err
+			}
+			u.Fs = afero.NewBasePathFs(s3Fs, filepath.Join("/", u.Scope))
+			return nil
+		}
+
 		scope := u.Scope
 		scope = filepath.Join(baseScope, filepath.Join("/", scope))
 		u.Fs = afero.NewBasePathFs(afero.NewOsFs(), scope)